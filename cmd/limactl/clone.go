@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/lima-vm/lima/pkg/clone"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newCloneCommand() *cobra.Command {
+	var cloneCmd = &cobra.Command{
+		Use:   "clone SRC DST",
+		Short: "Clone an instance",
+		Long: `Clone an instance.
+
+The disk of SRC is frozen into a read-only disk in $LIMA_HOME/_frozen, without copying it,
+and the diff disks of SRC and DST are created as qcow2 overlays of it. The frozen disk is
+shared by the clones, until SRC is started again. DST boots as a separate machine, with its
+own MAC addresses, SSH port, and cloud-init data. SRC must be stopped.
+
+The frozen disks are removed when no instance uses them, on "limactl delete" and "limactl prune".
+
+This command is experimental. Only vmType "qemu" is supported.`,
+		Example:           `  for i in 1 2 3; do limactl clone default test-$i; done`,
+		Args:              WrapArgsError(cobra.ExactArgs(2)),
+		RunE:              cloneAction,
+		ValidArgsFunction: cloneBashComplete,
+	}
+	return cloneCmd
+}
+
+func cloneAction(cmd *cobra.Command, args []string) error {
+	logrus.Warn("`limactl clone` is experimental")
+	src, err := store.Inspect(args[0])
+	if err != nil {
+		return err
+	}
+	dst, err := clone.Clone(cmd.Context(), src, args[1])
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Cloned the instance %q to %q. Run `limactl start %s` to start the instance.", src.Name, dst.Name, dst.Name)
+	return nil
+}
+
+func cloneBashComplete(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return bashCompleteInstanceNames(cmd)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/lima-vm/lima/pkg/clone"
 	networks "github.com/lima-vm/lima/pkg/networks/reconcile"
 	"github.com/lima-vm/lima/pkg/stop"
 	"github.com/lima-vm/lima/pkg/store"
@@ -45,11 +46,14 @@ func deleteAction(cmd *cobra.Command, args []string) error {
 		}
 		logrus.Infof("Deleted %q (%q)", instName, inst.Dir)
 	}
+	if err := clone.PruneFrozen(); err != nil {
+		logrus.WithError(err).Warn("Failed to remove the unused frozen disks (hint: run `limactl prune` later)")
+	}
 	return networks.Reconcile(cmd.Context(), "")
 }
 
 func deleteInstance(ctx context.Context, inst *store.Instance, force bool) error {
-	if !force && inst.Status != store.StatusStopped {
+	if !force && inst.Status != store.StatusStopped && inst.Status != store.StatusSuspended {
 		return fmt.Errorf("expected status %q, got %q", store.StatusStopped, inst.Status)
 	}
 
@@ -1,16 +1,26 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
-	"text/tabwriter"
+	"path/filepath"
 
 	"github.com/docker/go-units"
+	"github.com/lima-vm/lima/pkg/diskcompact"
+	"github.com/lima-vm/lima/pkg/downloader"
+	hostagentclient "github.com/lima-vm/lima/pkg/hostagent/api/client"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/nativeimgutil"
+	networks "github.com/lima-vm/lima/pkg/networks/reconcile"
 	"github.com/lima-vm/lima/pkg/qemu"
+	"github.com/lima-vm/lima/pkg/start"
+	"github.com/lima-vm/lima/pkg/stop"
 	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -26,7 +36,19 @@ func newDiskCommand() *cobra.Command {
   $ limactl disk ls
 
   Delete a disk:
-  $ limactl disk delete DISK`,
+  $ limactl disk delete DISK
+
+  Resize a disk:
+  $ limactl disk resize DISK --size SIZE
+
+  Reclaim the host disk space that is no longer used by an instance:
+  $ limactl disk compact INSTANCE
+
+  Import a disk image:
+  $ limactl disk import DISK --from PATH_OR_URL [--digest DIGEST]
+
+  Export a disk:
+  $ limactl disk export DISK -o FILE [--format qcow2]`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
 	}
@@ -35,6 +57,10 @@ func newDiskCommand() *cobra.Command {
 		newDiskListCommand(),
 		newDiskDeleteCommand(),
 		newDiskUnlockCommand(),
+		newDiskResizeCommand(),
+		newDiskCompactCommand(),
+		newDiskImportCommand(),
+		newDiskExportCommand(),
 	)
 	return diskCommand
 }
@@ -72,10 +98,8 @@ func diskCreateAction(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	switch format {
-	case "qcow2", "raw":
-	default:
-		return fmt.Errorf(`disk format %q not supported, use "qcow2" or "raw" instead`, format)
+	if err := validateDiskFormat(format); err != nil {
+		return err
 	}
 
 	// only exactly one arg is allowed
@@ -109,13 +133,17 @@ func newDiskListCommand() *cobra.Command {
 		Example: `
 To list existing disks:
 $ limactl disk list
+
+To list the disks with the bytes allocated on the host:
+$ limactl disk list --format '{{.Name}} {{.Usage}}'
 `,
 		Short:   "List existing Lima disks",
 		Aliases: []string{"ls"},
 		Args:    WrapArgsError(cobra.ArbitraryArgs),
 		RunE:    diskListAction,
 	}
-	diskListCommand.Flags().Bool("json", false, "JSONify output")
+	diskListCommand.Flags().StringP("format", "f", "table", "output format, one of: json, yaml, table, go-template")
+	diskListCommand.Flags().Bool("json", false, "JSONify output (equal to '--format json')")
 	return diskListCommand
 }
 
@@ -130,63 +158,54 @@ func diskMatches(diskName string, disks []string) []string {
 }
 
 func diskListAction(cmd *cobra.Command, args []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
 	jsonFormat, err := cmd.Flags().GetBool("json")
 	if err != nil {
 		return err
 	}
+	if jsonFormat {
+		if cmd.Flags().Changed("format") {
+			return errors.New("option --json conflicts with option --format")
+		}
+		format = "json"
+	}
 
 	allDisks, err := store.Disks()
 	if err != nil {
 		return err
 	}
 
-	disks := []string{}
+	diskNames := []string{}
 	if len(args) > 0 {
 		for _, arg := range args {
 			matches := diskMatches(arg, allDisks)
 			if len(matches) > 0 {
-				disks = append(disks, matches...)
+				diskNames = append(diskNames, matches...)
 			} else {
 				logrus.Warnf("No disk matching %v found.", arg)
 			}
 		}
 	} else {
-		disks = allDisks
+		diskNames = allDisks
 	}
 
-	if jsonFormat {
-		for _, diskName := range disks {
-			disk, err := store.InspectDisk(diskName)
-			if err != nil {
-				logrus.WithError(err).Errorf("disk %q does not exist?", diskName)
-				continue
-			}
-			j, err := json.Marshal(disk)
-			if err != nil {
-				return err
-			}
-			fmt.Fprintln(cmd.OutOrStdout(), string(j))
-		}
-		return nil
-	}
-
-	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
-	fmt.Fprintln(w, "NAME\tSIZE\tDIR\tIN-USE-BY")
-
-	if len(disks) == 0 {
+	if len(diskNames) == 0 && format == "table" {
 		logrus.Warn("No disk found. Run `limactl disk create DISK --size SIZE` to create a disk.")
 	}
 
-	for _, diskName := range disks {
+	var disks []*store.Disk
+	for _, diskName := range diskNames {
 		disk, err := store.InspectDisk(diskName)
 		if err != nil {
 			logrus.WithError(err).Errorf("disk %q does not exist?", diskName)
 			continue
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", disk.Name, units.BytesSize(float64(disk.Size)), disk.Dir, disk.Instance)
+		disks = append(disks, disk)
 	}
-
-	return w.Flush()
+	return store.PrintDisks(cmd.OutOrStdout(), disks, format)
 }
 
 func newDiskDeleteCommand() *cobra.Command {
@@ -241,6 +260,9 @@ func diskDeleteAction(cmd *cobra.Command, args []string) error {
 			if disk.Instance != "" {
 				return fmt.Errorf("cannot delete disk %q in use by instance %q", disk.Name, disk.Instance)
 			}
+			if len(disk.ReadOnlyInstances) > 0 {
+				return fmt.Errorf("cannot delete disk %q in use by instances %v", disk.Name, disk.ReadOnlyInstances)
+			}
 			var refInstances []string
 			for _, inst := range instances {
 				if len(inst.AdditionalDisks) > 0 {
@@ -307,27 +329,351 @@ func diskUnlockAction(_ *cobra.Command, args []string) error {
 			}
 			return err
 		}
-		if disk.Instance == "" {
+		if !disk.InUse() {
 			logrus.Warnf("Ignoring unlocked disk %q", diskName)
 			continue
 		}
-		// if store.Inspect throws an error, the instance does not exist, and it is safe to unlock
-		inst, err := store.Inspect(disk.Instance)
-		if err == nil {
-			if len(inst.Errors) > 0 {
-				logrus.Warnf("Cannot unlock disk %q, attached instance %q has errors: %+v",
-					diskName, disk.Instance, inst.Errors)
-				continue
+		if disk.Instance != "" && diskUnlockable(diskName, disk.Instance) {
+			if err := disk.Unlock(); err != nil {
+				return fmt.Errorf("failed to unlock disk %q: %w", diskName, err)
 			}
-			if inst.Status == store.StatusRunning {
-				logrus.Warnf("Cannot unlock disk %q used by running instance %q", diskName, disk.Instance)
+			logrus.Infof("Unlocked disk %q (%q)", diskName, disk.Dir)
+		}
+		for _, instName := range disk.ReadOnlyInstances {
+			if !diskUnlockable(diskName, instName) {
 				continue
 			}
+			if err := disk.UnlockReadOnly(instName); err != nil {
+				return fmt.Errorf("failed to unlock disk %q for instance %q: %w", diskName, instName, err)
+			}
+			logrus.Infof("Unlocked disk %q (%q) for instance %q", diskName, disk.Dir, instName)
+		}
+	}
+	return nil
+}
+
+func diskUnlockable(diskName, instName string) bool {
+	// if store.Inspect throws an error, the instance does not exist, and it is safe to unlock
+	inst, err := store.Inspect(instName)
+	if err == nil {
+		if len(inst.Errors) > 0 {
+			logrus.Warnf("Cannot unlock disk %q, attached instance %q has errors: %+v",
+				diskName, instName, inst.Errors)
+			return false
+		}
+		if inst.Status == store.StatusRunning {
+			logrus.Warnf("Cannot unlock disk %q used by running instance %q", diskName, instName)
+			return false
+		}
+	}
+	return true
+}
+
+func newDiskResizeCommand() *cobra.Command {
+	var diskResizeCommand = &cobra.Command{
+		Use: "resize DISK",
+		Example: `
+To resize a disk:
+$ limactl disk resize DISK --size SIZE
+
+A disk attached to a running instance can be grown, but cannot be shrunk.
+The partition and the filesystem are grown too, unless the disk was created with "format: false".
+`,
+		Short: "Resize a Lima disk",
+		Args:  WrapArgsError(cobra.ExactArgs(1)),
+		RunE:  diskResizeAction,
+	}
+	diskResizeCommand.Flags().String("size", "", "new disk size")
+	diskResizeCommand.MarkFlagRequired("size")
+	return diskResizeCommand
+}
+
+func diskResizeAction(cmd *cobra.Command, args []string) error {
+	size, err := cmd.Flags().GetString("size")
+	if err != nil {
+		return err
+	}
+	diskSize, err := units.RAMInBytes(size)
+	if err != nil {
+		return err
+	}
+
+	diskName := args[0]
+	disk, err := store.InspectDisk(diskName)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("disk %q does not exist", diskName)
 		}
-		if err := disk.Unlock(); err != nil {
-			return fmt.Errorf("failed to unlock disk %q: %w", diskName, err)
+		return err
+	}
+	if disk.Instance != "" {
+		inst, err := store.Inspect(disk.Instance)
+		if err == nil && inst.Status == store.StatusRunning {
+			haClient, err := hostagentclient.NewHostAgentClient(filepath.Join(inst.Dir, filenames.HostAgentSock))
+			if err != nil {
+				return err
+			}
+			logrus.Infof("Resizing disk %q attached to the running instance %q to %s", diskName, inst.Name, units.BytesSize(float64(diskSize)))
+			return haClient.ResizeDisk(cmd.Context(), diskName, diskSize)
 		}
-		logrus.Infof("Unlocked disk %q (%q)", diskName, disk.Dir)
+		return fmt.Errorf("cannot resize disk %q locked by non-running instance %q (hint: unlock it with `limactl disk unlock %s`)",
+			diskName, disk.Instance, diskName)
+	}
+	if len(disk.ReadOnlyInstances) > 0 {
+		return fmt.Errorf("cannot resize disk %q attached read-only to instances %v", diskName, disk.ReadOnlyInstances)
+	}
+
+	logrus.Infof("Resizing disk %q to %s", diskName, units.BytesSize(float64(diskSize)))
+	if err := qemu.ResizeDataDisk(disk.Dir, diskSize); err != nil {
+		return fmt.Errorf("failed to resize disk %q: %w", diskName, err)
 	}
 	return nil
 }
+
+func newDiskImportCommand() *cobra.Command {
+	var diskImportCommand = &cobra.Command{
+		Use: "import DISK",
+		Example: `
+To import a disk image from a local file or a URL:
+$ limactl disk import DISK --from PATH_OR_URL [--digest DIGEST] [--format qcow2]
+
+Compressed images (.gz, .bz2, .xz, .zst) are decompressed.
+`,
+		Short: "Import a disk image as a Lima disk",
+		Args:  WrapArgsError(cobra.ExactArgs(1)),
+		RunE:  diskImportAction,
+	}
+	diskImportCommand.Flags().String("from", "", "path or URL of the disk image")
+	diskImportCommand.MarkFlagRequired("from")
+	diskImportCommand.Flags().String("digest", "", "expected digest of the disk image, e.g., \"sha256:...\"")
+	diskImportCommand.Flags().String("format", "qcow2", "specify the disk format")
+	return diskImportCommand
+}
+
+func diskImportAction(cmd *cobra.Command, args []string) (retErr error) {
+	from, err := cmd.Flags().GetString("from")
+	if err != nil {
+		return err
+	}
+	expectedDigest, err := cmd.Flags().GetString("digest")
+	if err != nil {
+		return err
+	}
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if err := validateDiskFormat(format); err != nil {
+		return err
+	}
+
+	name := args[0]
+	diskDir, err := store.DiskDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(diskDir); !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("disk %q already exists (%q)", name, diskDir)
+	}
+	if err := os.MkdirAll(diskDir, 0700); err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			_ = os.RemoveAll(diskDir)
+		}
+	}()
+
+	logrus.Infof("Importing disk %q from %q", name, from)
+	dataDisk := filepath.Join(diskDir, filenames.DataDisk)
+	res, err := downloader.Download(dataDisk, from,
+		downloader.WithDecompress(true),
+		downloader.WithDescription(fmt.Sprintf("%s (disk %q)", from, name)),
+		downloader.WithExpectedDigest(digest.Digest(expectedDigest)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to download %q: %w", from, err)
+	}
+	logrus.Debugf("res.ValidatedDigest=%v", res.ValidatedDigest)
+
+	// Converting in place also rejects images with a backing file
+	if err := convertDisk(dataDisk, dataDisk, format); err != nil {
+		return err
+	}
+	logrus.Infof("Imported disk %q (%q)", name, diskDir)
+	return nil
+}
+
+func newDiskExportCommand() *cobra.Command {
+	var diskExportCommand = &cobra.Command{
+		Use: "export DISK",
+		Example: `
+To export a disk as a qcow2 image:
+$ limactl disk export DISK -o DISK.qcow2
+
+To export a disk as a raw image:
+$ limactl disk export DISK -o DISK.img --format raw
+`,
+		Short: "Export a Lima disk into a disk image",
+		Args:  WrapArgsError(cobra.ExactArgs(1)),
+		RunE:  diskExportAction,
+	}
+	diskExportCommand.Flags().StringP("output", "o", "", "output file")
+	diskExportCommand.MarkFlagRequired("output")
+	diskExportCommand.Flags().String("format", "qcow2", "specify the format of the output file")
+	return diskExportCommand
+}
+
+func diskExportAction(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if err := validateDiskFormat(format); err != nil {
+		return err
+	}
+
+	name := args[0]
+	disk, err := store.InspectDisk(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("disk %q does not exist", name)
+		}
+		return err
+	}
+	if disk.Instance != "" {
+		return fmt.Errorf("cannot export disk %q in use by instance %q", name, disk.Instance)
+	}
+	if _, err := os.Stat(output); !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("output %q already exists", output)
+	}
+
+	logrus.Infof("Exporting disk %q into %q", name, output)
+	return convertDisk(filepath.Join(disk.Dir, filenames.DataDisk), output, format)
+}
+
+func validateDiskFormat(format string) error {
+	switch format {
+	case "qcow2", "raw":
+		return nil
+	default:
+		return fmt.Errorf(`disk format %q not supported, use "qcow2" or "raw" instead`, format)
+	}
+}
+
+func convertDisk(source, dest, format string) error {
+	if format == "raw" {
+		return nativeimgutil.ConvertToRaw(source, dest, nil, false)
+	}
+	return nativeimgutil.ConvertToQcow2(source, dest, false)
+}
+
+func newDiskCompactCommand() *cobra.Command {
+	var diskCompactCommand = &cobra.Command{
+		Use: "compact INSTANCE",
+		Example: `
+To reclaim the host disk space that is no longer used by an instance:
+$ limactl disk compact INSTANCE
+
+To also rewrite the disk of a running QEMU instance, restarting the instance:
+$ limactl disk compact --restart INSTANCE
+
+For a running instance, the unused blocks of the filesystems are discarded with fstrim.
+With --restart, a QEMU instance is then stopped, the disk is rewritten without the zero blocks,
+and the instance is started again.
+For a stopped instance, the disk is only rewritten (QEMU only), as fstrim needs a running guest.
+`,
+		Short:             "Reclaim the host disk space that is no longer used by an instance",
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              diskCompactAction,
+		ValidArgsFunction: diskCompactBashComplete,
+	}
+	diskCompactCommand.Flags().Bool("restart", false, "stop a running QEMU instance to rewrite the disk, and start it again")
+	return diskCompactCommand
+}
+
+func diskCompactAction(cmd *cobra.Command, args []string) error {
+	instName := args[0]
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("instance %q does not exist", instName)
+		}
+		return err
+	}
+	before, err := diskcompact.Usage(inst)
+	if err != nil {
+		return err
+	}
+	restart, err := cmd.Flags().GetBool("restart")
+	if err != nil {
+		return err
+	}
+	if err := compactInstance(cmd.Context(), inst, restart); err != nil {
+		return err
+	}
+	after, err := diskcompact.Usage(inst)
+	if err != nil {
+		return err
+	}
+	var reclaimed int64
+	if before > after {
+		reclaimed = before - after
+	}
+	logrus.Infof("Reclaimed %s (%s -> %s)", units.BytesSize(float64(reclaimed)),
+		units.BytesSize(float64(before)), units.BytesSize(float64(after)))
+	return nil
+}
+
+// compactInstance trims the disks of a running instance, and rewrites the disk of a stopped QEMU instance.
+// The disk cannot be rewritten while QEMU has it open, so a running QEMU instance is stopped
+// for the rewrite and started again, only when restart is true.
+func compactInstance(ctx context.Context, inst *store.Instance, restart bool) error {
+	switch inst.Status {
+	case store.StatusRunning:
+		if err := diskcompact.Trim(ctx, inst); err != nil {
+			return err
+		}
+		if inst.VMType != limayaml.QEMU {
+			return nil
+		}
+		if !restart {
+			logrus.Infof("Not rewriting the disk of the running instance %q (hint: use --restart to stop and start the instance for rewriting)", inst.Name)
+			return nil
+		}
+		// Check before stopping, so that the instance is not left stopped for nothing
+		if err := diskcompact.CheckRewrite(inst); err != nil {
+			return err
+		}
+		logrus.Infof("Stopping %q to rewrite the disk", inst.Name)
+		if err := stop.Gracefully(ctx, inst); err != nil {
+			return err
+		}
+		inst, err := store.Inspect(inst.Name)
+		if err != nil {
+			return err
+		}
+		rewriteErr := diskcompact.Rewrite(ctx, inst)
+		logrus.Infof("Starting %q again", inst.Name)
+		startErr := networks.Reconcile(ctx, inst.Name)
+		if startErr == nil {
+			startErr = start.Start(ctx, inst)
+		}
+		if startErr != nil {
+			startErr = fmt.Errorf("failed to start %q again: %w", inst.Name, startErr)
+		}
+		return errors.Join(rewriteErr, startErr)
+	case store.StatusStopped:
+		return diskcompact.Rewrite(ctx, inst)
+	default:
+		return fmt.Errorf("expected status %q or %q, got %q", store.StatusRunning, store.StatusStopped, inst.Status)
+	}
+}
+
+func diskCompactBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteInstanceNames(cmd)
+}
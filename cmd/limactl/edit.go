@@ -2,14 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/lima-vm/lima/cmd/limactl/editflags"
 	"github.com/lima-vm/lima/pkg/editutil"
+	hostagentclient "github.com/lima-vm/lima/pkg/hostagent/api/client"
 	"github.com/lima-vm/lima/pkg/limayaml"
 	networks "github.com/lima-vm/lima/pkg/networks/reconcile"
 	"github.com/lima-vm/lima/pkg/start"
@@ -19,12 +22,17 @@ import (
 	"github.com/mattn/go-isatty"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
 )
 
 func newEditCommand() *cobra.Command {
 	var editCommand = &cobra.Command{
-		Use:               "edit INSTANCE",
-		Short:             "Edit an instance of Lima",
+		Use:   "edit INSTANCE",
+		Short: "Edit an instance of Lima",
+		Long: `Edit an instance of Lima.
+
+For running instances, only "cpus" and "memory" can be changed. The changes are applied live where the driver supports it
+(EXPERIMENTAL, see "maxCPUs" and "maxMemory"), otherwise they take effect after restarting the instance.`,
 		Args:              WrapArgsError(cobra.MaximumNArgs(1)),
 		RunE:              editAction,
 		ValidArgsFunction: editBashComplete,
@@ -49,8 +57,8 @@ func editAction(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if inst.Status == store.StatusRunning {
-		return errors.New("Cannot edit a running instance")
+	if inst.Status == store.StatusSuspended {
+		return errors.New("Cannot edit a suspended instance (hint: resume and stop the instance first)")
 	}
 
 	filePath := filepath.Join(inst.Dir, filenames.LimaYAML)
@@ -97,18 +105,26 @@ func editAction(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	if err := limayaml.Validate(*y, true); err != nil {
-		rejectedYAML := "lima.REJECTED.yaml"
-		if writeErr := os.WriteFile(rejectedYAML, yBytes, 0644); writeErr != nil {
-			return fmt.Errorf("the YAML is invalid, attempted to save the buffer as %q but failed: %v: %w", rejectedYAML, writeErr, err)
+		return rejectYAML(yBytes, fmt.Errorf("the YAML is invalid: %w", err))
+	}
+	if inst.Status == store.StatusRunning {
+		fields, err := limayaml.ChangedNonHotplugFieldsYAML(yContent, yBytes)
+		if err != nil {
+			return err
+		}
+		if len(fields) > 0 {
+			return rejectYAML(yBytes, fmt.Errorf("cannot change %s of a running instance, only %s can be changed (hint: stop the instance first)",
+				strings.Join(fields, ", "), strings.Join(limayaml.HotplugFields, ", ")))
 		}
-		// TODO: may need to support editing the rejected YAML
-		return fmt.Errorf("the YAML is invalid, saved the buffer as %q: %w", rejectedYAML, err)
 	}
 	if err := os.WriteFile(filePath, yBytes, 0644); err != nil {
 		return err
 	}
 	logrus.Infof("Instance %q configuration edited", instName)
 
+	if inst.Status == store.StatusRunning {
+		return reconfigureRunningInstance(cmd.Context(), inst, y)
+	}
 	if !tty {
 		// use "start" to start it
 		return nil
@@ -128,6 +144,46 @@ func editAction(cmd *cobra.Command, args []string) error {
 	return start.Start(ctx, inst)
 }
 
+// rejectYAML saves the rejected YAML as lima.REJECTED.yaml, and returns err with the file name.
+func rejectYAML(yBytes []byte, err error) error {
+	rejectedYAML := "lima.REJECTED.yaml"
+	if writeErr := os.WriteFile(rejectedYAML, yBytes, 0644); writeErr != nil {
+		return fmt.Errorf("attempted to save the buffer as %q but failed: %v: %w", rejectedYAML, writeErr, err)
+	}
+	// TODO: may need to support editing the rejected YAML
+	return fmt.Errorf("saved the buffer as %q: %w", rejectedYAML, err)
+}
+
+// reconfigureRunningInstance applies the changes to the running instance where possible,
+// and reports the changes that need a restart.
+func reconfigureRunningInstance(ctx context.Context, inst *store.Instance, y *limayaml.LimaYAML) error {
+	var applied []string
+	haClient, err := hostagentclient.NewHostAgentClient(filepath.Join(inst.Dir, filenames.HostAgentSock))
+	if err != nil {
+		return err
+	}
+	res, err := haClient.Reconfigure(ctx)
+	if err != nil {
+		logrus.WithError(err).Warnf("Failed to apply the changes to the running instance %q", inst.Name)
+	} else {
+		applied = res.Applied
+	}
+	if len(applied) > 0 {
+		logrus.Infof("Applied the changes of %s to the running instance %q", strings.Join(applied, ", "), inst.Name)
+	}
+	var pending []string
+	for _, f := range limayaml.ChangedFields(inst.Config, y) {
+		if !slices.Contains(applied, f) {
+			pending = append(pending, f)
+		}
+	}
+	if len(pending) > 0 {
+		logrus.Warnf("The changes of %s take effect after restarting the instance (`limactl stop %s && limactl start %s`)",
+			strings.Join(pending, ", "), inst.Name, inst.Name)
+	}
+	return nil
+}
+
 func askWhetherToStart() (bool, error) {
 	ans := true
 	prompt := &survey.Confirm{
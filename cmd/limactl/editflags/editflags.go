@@ -84,9 +84,9 @@ func RegisterCreate(cmd *cobra.Command, commentPrefix string) {
 		return []string{"10", "30", "50", "100", "200"}, cobra.ShellCompDirectiveNoFileComp
 	})
 
-	flags.String("vm-type", "", commentPrefix+"virtual machine type (qemu, vz)") // colima-compatible
+	flags.String("vm-type", "", commentPrefix+"virtual machine type (qemu, vz, cloud-hypervisor)") // colima-compatible
 	_ = cmd.RegisterFlagCompletionFunc("vm-type", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		return []string{"qemu", "vz"}, cobra.ShellCompDirectiveNoFileComp
+		return []string{"qemu", "vz", "cloud-hypervisor"}, cobra.ShellCompDirectiveNoFileComp
 	})
 }
 
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"github.com/lima-vm/lima/pkg/instarchive"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newExportCommand() *cobra.Command {
+	var exportCmd = &cobra.Command{
+		Use:   "export INSTANCE",
+		Short: "Export an instance as an archive",
+		Long: `Export an instance as an archive.
+
+The archive contains lima.yaml and the disks of the instance.
+Host-specific files such as sockets, PID files, and logs are not included.
+The instance must be stopped.
+
+The archive is compressed according to the file name extension:
+.tar, .tar.gz (.tgz), .tar.bz2, .tar.xz, or .tar.zst.
+The compression requires the corresponding program (e.g., "zstd") to be installed.`,
+		Example: `  limactl export default -o default.tar.zst
+  limactl export default -o default.tar.zst --snapshots --additional-disks`,
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              exportAction,
+		ValidArgsFunction: exportBashComplete,
+	}
+	exportCmd.Flags().StringP("output", "o", "", "path of the archive (default: INSTANCE.tar.zst)")
+	exportCmd.Flags().Bool("snapshots", false, "include the snapshots")
+	exportCmd.Flags().Bool("additional-disks", false, "include the additional disks")
+	return exportCmd
+}
+
+func exportAction(cmd *cobra.Command, args []string) error {
+	logrus.Warn("`limactl export` is experimental")
+	instName := args[0]
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		return err
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = instName + ".tar.zst"
+	}
+	var opts instarchive.ExportOptions
+	opts.Snapshots, err = cmd.Flags().GetBool("snapshots")
+	if err != nil {
+		return err
+	}
+	opts.AdditionalDisks, err = cmd.Flags().GetBool("additional-disks")
+	if err != nil {
+		return err
+	}
+	if err := instarchive.Export(cmd.Context(), inst, output, opts); err != nil {
+		return err
+	}
+	logrus.Infof("Exported the instance %q to %q", instName, output)
+	return nil
+}
+
+func exportBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteInstanceNames(cmd)
+}
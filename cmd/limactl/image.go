@@ -0,0 +1,503 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/lima-vm/lima/cmd/limactl/guessarg"
+	"github.com/lima-vm/lima/pkg/clone"
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/lima-vm/lima/pkg/fileutils"
+	"github.com/lima-vm/lima/pkg/imagebuild"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	networks "github.com/lima-vm/lima/pkg/networks/reconcile"
+	"github.com/lima-vm/lima/pkg/start"
+	"github.com/lima-vm/lima/pkg/stop"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/lima-vm/lima/pkg/templatestore"
+	"github.com/lima-vm/lima/pkg/textutil"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newImageCommand() *cobra.Command {
+	var imageCommand = &cobra.Command{
+		Use:   "image",
+		Short: "Lima image management",
+		Example: `  List the cached images:
+  $ limactl image list
+
+  Download the images of a template for offline use:
+  $ limactl image pull template://docker
+
+  Remove the cached images that are not used by any instance:
+  $ limactl image prune --unused
+
+  Build an image from a stopped instance:
+  $ limactl image build INSTANCE -o IMAGE.qcow2`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			logrus.Warn("`limactl image` is experimental")
+		},
+	}
+	imageCommand.AddCommand(
+		newImageListCommand(),
+		newImagePullCommand(),
+		newImagePruneCommand(),
+		newImageBuildCommand(),
+	)
+	return imageCommand
+}
+
+func newImageBuildCommand() *cobra.Command {
+	var imageBuildCommand = &cobra.Command{
+		Use:   "build INSTANCE -o IMAGE.qcow2",
+		Short: "Build a base image from a stopped instance",
+		Long: `Build a base image from a stopped instance.
+
+The instance is cloned into a temporary instance "INSTANCE-imagebuild", which is started and generalized:
+the cloud-init state, the machine-id, the SSH host keys, and the Lima user (including the home
+directory) are removed from the guest. Then the temporary instance is stopped, its disk is written
+into a standalone qcow2 image omitting the unused blocks, and the temporary instance is deleted.
+
+The data of the instance is not modified. The disk of the instance is not copied either, see "limactl clone".
+
+The instance must be stopped, as the disk of a running instance is not consistent: the guest may
+not have written its page cache and filesystem journal to the disk yet, and Lima does not install
+a guest agent that can freeze the filesystems (e.g., qemu-guest-agent). An image taken from such a
+disk may need a filesystem check on boot, or may lose the recent changes of the provisioning.
+
+The image can be used in the "images" field of other instances, e.g., "location: file:///path/to/IMAGE.qcow2".
+
+This command is experimental. Only vmType "qemu" is supported.`,
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              imageBuildAction,
+		ValidArgsFunction: imageBuildBashComplete,
+	}
+	imageBuildCommand.Flags().StringP("output", "o", "", "path of the image")
+	return imageBuildCommand
+}
+
+func imageBuildAction(cmd *cobra.Command, args []string) (retErr error) {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		return errors.New("expected --output")
+	}
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(output); !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("output %q already exists", output)
+	}
+	ctx := cmd.Context()
+	inst, err := store.Inspect(args[0])
+	if err != nil {
+		return err
+	}
+	if inst.Status != store.StatusStopped {
+		// The disk of a running instance is not consistent, see the help of the command
+		return fmt.Errorf("expected status %q, got %q (hint: stop the instance first, the instance itself is not modified)", store.StatusStopped, inst.Status)
+	}
+	// The guest is generalized in a clone, as generalizing destroys the Lima user and its home directory
+	tmpName := inst.Name + "-imagebuild"
+	tmp, err := clone.Clone(ctx, inst, tmpName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		logrus.Infof("Deleting the temporary instance %q", tmpName)
+		tmp, err := store.Inspect(tmpName)
+		if err == nil {
+			err = deleteInstance(ctx, tmp, true)
+		}
+		if err == nil {
+			err = networks.Reconcile(ctx, "")
+		}
+		if err != nil {
+			retErr = errors.Join(retErr, fmt.Errorf("failed to delete the temporary instance %q: %w", tmpName, err))
+		}
+	}()
+	if err := networks.Reconcile(ctx, tmp.Name); err != nil {
+		return err
+	}
+	if err := start.Start(ctx, tmp); err != nil {
+		return err
+	}
+	if tmp, err = store.Inspect(tmpName); err != nil {
+		return err
+	}
+	if err := imagebuild.Generalize(ctx, tmp); err != nil {
+		return err
+	}
+	if err := stop.Gracefully(ctx, tmp); err != nil {
+		return err
+	}
+	if tmp, err = store.Inspect(tmpName); err != nil {
+		return err
+	}
+	if err := imagebuild.Compact(tmp, output); err != nil {
+		return err
+	}
+	logrus.Infof("Built the image %q. Use \"location: file://%s\" in the \"images\" field to use the image.", output, output)
+	return nil
+}
+
+func imageBuildBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteInstanceNames(cmd)
+}
+
+func newImageListCommand() *cobra.Command {
+	var imageListCommand = &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the cached images",
+		Long: `List the images and other files cached by the downloader, with the instances using them.
+
+An image is considered to be used by an instance when its location appears in the "images" field
+(or in the "containerd.archives" field) of the lima.yaml of the instance.
+
+With --check-updates, an image is reported to have an update available when a template pins a digest
+that differs from the cached one. Otherwise the remote is checked with a HEAD request, comparing
+its Last-Modified header and its size with the cached image.`,
+		Example: `
+To list the cached images:
+$ limactl image list
+
+To list the URLs of the cached images that are not used by any instance:
+$ limactl image list --format '{{if not .Instances}}{{.URL}}{{end}}'
+
+To check whether the remote images have been updated since they were cached:
+$ limactl image list --check-updates
+`,
+		Args:              WrapArgsError(cobra.NoArgs),
+		RunE:              imageListAction,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	imageListCommand.Flags().StringP("format", "f", "table", "output format, one of: json, yaml, table, go-template")
+	imageListCommand.Flags().Bool("check-updates", false, "check whether the remote images have been updated")
+	return imageListCommand
+}
+
+type imageListEntry struct {
+	downloader.CacheEntry `yaml:",inline"`
+	Instances             []string                `json:"instances,omitempty"`
+	Update                downloader.UpdateStatus `json:"update,omitempty"`
+}
+
+func imageListAction(cmd *cobra.Command, _ []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	checkUpdates, err := cmd.Flags().GetBool("check-updates")
+	if err != nil {
+		return err
+	}
+	entries, err := imageListEntries()
+	if err != nil {
+		return err
+	}
+	if checkUpdates {
+		templateDigests, err := imageTemplateDigests()
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			e := &entries[i]
+			e.Update, err = downloader.CheckUpdate(cmd.Context(), e.CacheEntry, templateDigests[e.URL])
+			if err != nil {
+				logrus.WithError(err).Warnf("Failed to check the update of %q", e.URL)
+				e.Update = downloader.UpdateStatusUnknown
+			}
+		}
+	}
+	w := cmd.OutOrStdout()
+	switch format {
+	case "json":
+		format = "{{json .}}"
+	case "yaml":
+		format = "{{yaml .}}"
+	case "table":
+		w := tabwriter.NewWriter(w, 4, 8, 4, ' ', 0)
+		header := "URL\tSIZE\tDIGEST\tLAST-USED\tINSTANCES"
+		if checkUpdates {
+			header += "\tUPDATE"
+		}
+		fmt.Fprintln(w, header)
+		for _, e := range entries {
+			dgst := "-"
+			if len(e.Digests) > 0 {
+				dgst = e.Digests[0].String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s",
+				e.URL,
+				units.BytesSize(float64(e.Size)),
+				dgst,
+				units.HumanDuration(time.Since(e.LastUsed))+" ago",
+				strings.Join(e.Instances, ","),
+			)
+			if checkUpdates {
+				fmt.Fprintf(w, "\t%s", e.Update)
+			}
+			fmt.Fprintln(w)
+		}
+		return w.Flush()
+	}
+	tmpl, err := template.New("format").Funcs(textutil.TemplateFuncMap).Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid go template: %w", err)
+	}
+	for _, e := range entries {
+		if err := tmpl.Execute(w, e); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+func imageListEntries() ([]imageListEntry, error) {
+	cacheDir, err := downloader.DefaultCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	cacheEntries, err := downloader.CacheEntries(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	users, err := imageUsers()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]imageListEntry, len(cacheEntries))
+	for i, e := range cacheEntries {
+		entries[i] = imageListEntry{
+			CacheEntry: e,
+			Instances:  users[e.URL],
+		}
+	}
+	return entries, nil
+}
+
+// imageUsers returns the map from the image locations to the names of the instances using them.
+func imageUsers() (map[string][]string, error) {
+	instNames, err := store.Instances()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string][]string)
+	for _, instName := range instNames {
+		instDir, err := store.InstanceDir(instName)
+		if err != nil {
+			return nil, err
+		}
+		y, err := store.LoadYAMLByFilePath(filepath.Join(instDir, filenames.LimaYAML))
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to load the YAML of instance %q", instName)
+			continue
+		}
+		for _, location := range imageLocations(y) {
+			res[location] = append(res[location], instName)
+		}
+	}
+	return res, nil
+}
+
+// imageTemplateDigests returns the map from the image locations to the digests pinned by the templates.
+func imageTemplateDigests() (map[string]digest.Digest, error) {
+	templates, err := templatestore.Templates()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]digest.Digest)
+	for _, tmpl := range templates {
+		yBytes, err := os.ReadFile(tmpl.Location)
+		if err != nil {
+			return nil, err
+		}
+		y, err := limayaml.Load(yBytes, tmpl.Location)
+		if err != nil {
+			logrus.WithError(err).Debugf("Failed to load the template %q", tmpl.Name)
+			continue
+		}
+		add := func(f limayaml.File) {
+			if f.Digest != "" {
+				res[f.Location] = f.Digest
+			}
+		}
+		for _, f := range y.Images {
+			add(f.File)
+			if f.Kernel != nil {
+				add(f.Kernel.File)
+			}
+			if f.Initrd != nil {
+				add(*f.Initrd)
+			}
+		}
+		for _, f := range y.Containerd.Archives {
+			add(f)
+		}
+	}
+	return res, nil
+}
+
+// imageLocations returns the locations of the images, kernels, initrds, and nerdctl archives in y.
+func imageLocations(y *limayaml.LimaYAML) []string {
+	var res []string
+	seen := make(map[string]bool)
+	add := func(location string) {
+		if !seen[location] {
+			seen[location] = true
+			res = append(res, location)
+		}
+	}
+	for _, f := range y.Images {
+		add(f.Location)
+		if f.Kernel != nil {
+			add(f.Kernel.Location)
+		}
+		if f.Initrd != nil {
+			add(f.Initrd.Location)
+		}
+	}
+	if *y.Containerd.System || *y.Containerd.User {
+		for _, f := range y.Containerd.Archives {
+			add(f.Location)
+		}
+	}
+	return res
+}
+
+func newImagePullCommand() *cobra.Command {
+	var imagePullCommand = &cobra.Command{
+		Use:   "pull TEMPLATE",
+		Short: "Download the images of a template into the cache",
+		Long: `Download the images of a template into the cache, so that instances can be created from the template without network access.
+
+The image (and the kernel and the initrd, if specified) for the architecture of the template is downloaded.
+The nerdctl archive is downloaded too, unless containerd is disabled in the template.`,
+		Example: `
+$ limactl image pull template://docker
+`,
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              imagePullAction,
+		ValidArgsFunction: imagePullBashComplete,
+	}
+	return imagePullCommand
+}
+
+func imagePullAction(_ *cobra.Command, args []string) error {
+	templateName := args[0]
+	if ok, u := guessarg.SeemsTemplateURL(templateName); ok {
+		templateName = filepath.Join(u.Host, u.Path)
+	}
+	yBytes, err := templatestore.Read(templateName)
+	if err != nil {
+		return err
+	}
+	y, err := limayaml.Load(yBytes, templateName+".yaml")
+	if err != nil {
+		return err
+	}
+	if err := pullImage(y); err != nil {
+		return err
+	}
+	if *y.Containerd.System || *y.Containerd.User {
+		errs := make([]error, len(y.Containerd.Archives))
+		for i, f := range y.Containerd.Archives {
+			if _, errs[i] = fileutils.DownloadFile("", f, false, "the nerdctl archive", *y.Arch); errs[i] == nil {
+				return nil
+			}
+		}
+		return fileutils.Errors(errs)
+	}
+	return nil
+}
+
+// pullImage downloads the first available image for *y.Arch into the cache, along with its kernel and initrd.
+func pullImage(y *limayaml.LimaYAML) error {
+	errs := make([]error, len(y.Images))
+	for i, f := range y.Images {
+		if _, errs[i] = fileutils.DownloadFile("", f.File, false, "the image", *y.Arch); errs[i] != nil {
+			continue
+		}
+		if f.Kernel != nil {
+			if _, errs[i] = fileutils.DownloadFile("", f.Kernel.File, false, "the kernel", *y.Arch); errs[i] != nil {
+				continue
+			}
+		}
+		if f.Initrd != nil {
+			if _, errs[i] = fileutils.DownloadFile("", *f.Initrd, false, "the initrd", *y.Arch); errs[i] != nil {
+				continue
+			}
+		}
+		return nil
+	}
+	return fileutils.Errors(errs)
+}
+
+func imagePullBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteTemplateNames(cmd)
+}
+
+func newImagePruneCommand() *cobra.Command {
+	var imagePruneCommand = &cobra.Command{
+		Use:   "prune",
+		Short: "Remove the cached images",
+		Long: `Remove the cached images.
+
+With --unused, only the images that are not used by any instance are removed.
+See "limactl image list" for the images used by the instances.`,
+		Example: `
+$ limactl image prune --unused
+`,
+		Args:              WrapArgsError(cobra.NoArgs),
+		RunE:              imagePruneAction,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	imagePruneCommand.Flags().Bool("unused", false, "remove only the images that are not used by any instance")
+	return imagePruneCommand
+}
+
+func imagePruneAction(cmd *cobra.Command, _ []string) error {
+	unused, err := cmd.Flags().GetBool("unused")
+	if err != nil {
+		return err
+	}
+	entries, err := imageListEntries()
+	if err != nil {
+		return err
+	}
+	var (
+		removed   int
+		reclaimed int64
+	)
+	for _, e := range entries {
+		if unused && len(e.Instances) > 0 {
+			logrus.Debugf("Keeping %q, used by %v", e.URL, e.Instances)
+			continue
+		}
+		logrus.Infof("Removing %q", e.URL)
+		if err := os.RemoveAll(e.Dir); err != nil {
+			return err
+		}
+		removed++
+		reclaimed += e.Size
+	}
+	logrus.Infof("Removed %d image(s), reclaimed %s", removed, units.BytesSize(float64(reclaimed)))
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/lima-vm/lima/pkg/instarchive"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newImportCommand() *cobra.Command {
+	var importCmd = &cobra.Command{
+		Use:   "import ARCHIVE",
+		Short: "Import an instance from an archive",
+		Long: `Import an instance from an archive created by "limactl export".
+
+The instance name defaults to the file name of the archive without the extension.
+The host-specific files are regenerated on the next start.`,
+		Example: `  limactl import default.tar.zst --name foo`,
+		Args:    WrapArgsError(cobra.ExactArgs(1)),
+		RunE:    importAction,
+	}
+	importCmd.Flags().String("name", "", "name of the instance")
+	return importCmd
+}
+
+func importAction(cmd *cobra.Command, args []string) error {
+	logrus.Warn("`limactl import` is experimental")
+	archive := args[0]
+	instName, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return err
+	}
+	if instName == "" {
+		instName, err = instarchive.DefaultInstanceName(archive)
+		if err != nil {
+			return err
+		}
+	}
+	inst, err := instarchive.Import(cmd.Context(), archive, instName)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Imported the instance %q. Run `limactl start %s` to start the instance.", inst.Name, inst.Name)
+	return nil
+}
@@ -97,6 +97,8 @@ func newApp() *cobra.Command {
 		newCreateCommand(),
 		newStartCommand(),
 		newStopCommand(),
+		newSuspendCommand(),
+		newResumeCommand(),
 		newShellCommand(),
 		newCopyCommand(),
 		newListCommand(),
@@ -114,6 +116,11 @@ func newApp() *cobra.Command {
 		newUsernetCommand(),
 		newGenDocCommand(),
 		newSnapshotCommand(),
+		newPortForwardCommand(),
+		newExportCommand(),
+		newImportCommand(),
+		newCloneCommand(),
+		newImageCommand(),
 	)
 	return rootCmd
 }
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	hostagentapi "github.com/lima-vm/lima/pkg/hostagent/api"
+	hostagentclient "github.com/lima-vm/lima/pkg/hostagent/api/client"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/spf13/cobra"
+)
+
+func newPortForwardCommand() *cobra.Command {
+	var portForwardCommand = &cobra.Command{
+		Use:     "port-forward",
+		Aliases: []string{"pf"},
+		Short:   "Manage port forwarding rules of running instances",
+		Example: `  List the port forwarding rules:
+  $ limactl port-forward list INSTANCE
+
+  Forward the guest port 80 to the host port 8080:
+  $ limactl port-forward add INSTANCE 8080:80
+
+  Remove a port forwarding rule:
+  $ limactl port-forward remove INSTANCE ID`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	portForwardCommand.AddCommand(
+		newPortForwardListCommand(),
+		newPortForwardAddCommand(),
+		newPortForwardRemoveCommand(),
+	)
+	return portForwardCommand
+}
+
+func newPortForwardListCommand() *cobra.Command {
+	var listCommand = &cobra.Command{
+		Use:               "list INSTANCE",
+		Aliases:           []string{"ls"},
+		Short:             "List the port forwarding rules of a running instance, in the order of evaluation",
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              portForwardListAction,
+		ValidArgsFunction: portForwardBashComplete,
+	}
+	listCommand.Flags().Bool("json", false, "JSONify output")
+	return listCommand
+}
+
+func portForwardListAction(cmd *cobra.Command, args []string) error {
+	jsonFormat, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	client, err := newRunningHostAgentClient(args[0])
+	if err != nil {
+		return err
+	}
+	rules, err := client.PortForwards(cmd.Context())
+	if err != nil {
+		return err
+	}
+	if jsonFormat {
+		return printPortForwardsJSON(cmd, rules)
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "ID\tPROTO\tGUEST\tHOST\tTYPE")
+	for _, r := range rules {
+		guest, host := r.GuestSocket, r.HostSocket
+		if guest == "" {
+			guest = formatPortRange(r.GuestIP, r.GuestPortRange)
+		}
+		if host == "" {
+			host = formatPortRange(r.HostIP, r.HostPortRange)
+		}
+		typ := "static"
+		if r.Dynamic {
+			typ = "dynamic"
+		}
+		if r.Ignore {
+			host = "-"
+			typ += ",ignore"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.ID, r.Proto, guest, host, typ)
+	}
+	return w.Flush()
+}
+
+func printPortForwardsJSON(cmd *cobra.Command, rules []hostagentapi.PortForward) error {
+	for _, r := range rules {
+		j, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(j))
+	}
+	return nil
+}
+
+func formatPortRange(ip net.IP, portRange [2]int) string {
+	ports := strconv.Itoa(portRange[0])
+	if portRange[0] != portRange[1] {
+		ports = fmt.Sprintf("%d-%d", portRange[0], portRange[1])
+	}
+	return net.JoinHostPort(ip.String(), ports)
+}
+
+func newPortForwardAddCommand() *cobra.Command {
+	var addCommand = &cobra.Command{
+		Use:   "add INSTANCE [HOSTIP:][HOSTPORT:]GUESTPORT",
+		Short: "Add a port forwarding rule to a running instance",
+		Long: `Add a port forwarding rule to a running instance.
+
+The rule takes precedence over the rules in lima.yaml.
+The rule is discarded when the instance is stopped.`,
+		Example: `  Forward the guest port 8080 to the host port 8080:
+  $ limactl port-forward add INSTANCE 8080
+
+  Forward the guest port 80 to the host port 8080 on all the host interfaces:
+  $ limactl port-forward add INSTANCE 0.0.0.0:8080:80
+
+  Forward the guest port 80 to the host port 8080 on the IPv6 loopback address:
+  $ limactl port-forward add INSTANCE [::1]:8080:80 --guest-ip=::1
+
+  Forward the guest UDP port 53 to the host UDP port 5353:
+  $ limactl port-forward add INSTANCE 5353:53 --proto=udp
+
+  Forward the guest port 3000 to the next free host port, when the host port 3000 is already in use:
+  $ limactl port-forward add INSTANCE 3000 --host-port-fallback=next-free
+  $ limactl list INSTANCE --format '{{.PortForwards}}'`,
+		Args:              WrapArgsError(cobra.ExactArgs(2)),
+		RunE:              portForwardAddAction,
+		ValidArgsFunction: portForwardBashComplete,
+	}
+	addCommand.Flags().String("guest-ip", "", "guest IP address to forward from (default: 127.0.0.1)")
+	addCommand.Flags().Bool("ignore", false, "do not forward the port")
+	addCommand.Flags().String("proto", limayaml.TCP, "protocol, one of: tcp, udp")
+	addCommand.Flags().String("host-port-fallback", limayaml.HostPortFallbackNone, "what to do when the host port is already in use, one of: none, next-free")
+	return addCommand
+}
+
+// parsePortForwardSpec parses "[HOSTIP:][HOSTPORT:]GUESTPORT" into a rule.
+// An IPv6 HOSTIP is enclosed in square brackets, e.g., "[::1]:8080:80".
+func parsePortForwardSpec(spec string) (limayaml.PortForward, error) {
+	var rule limayaml.PortForward
+	var hostIP string
+	ports := spec
+	if strings.HasPrefix(spec, "[") {
+		end := strings.Index(spec, "]:")
+		if end < 0 {
+			return rule, fmt.Errorf("invalid port forwarding spec %q, expected [HOSTIP:][HOSTPORT:]GUESTPORT", spec)
+		}
+		hostIP, ports = spec[1:end], spec[end+2:]
+	}
+	fields := strings.Split(ports, ":")
+	if len(fields) > 3 || (hostIP != "" && len(fields) != 2) {
+		return rule, fmt.Errorf("invalid port forwarding spec %q, expected [HOSTIP:][HOSTPORT:]GUESTPORT", spec)
+	}
+	if len(fields) == 3 {
+		hostIP = fields[0]
+	}
+	guestPort, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return rule, fmt.Errorf("invalid guest port in %q: %w", spec, err)
+	}
+	rule.GuestPort = guestPort
+	if len(fields) >= 2 {
+		hostPort, err := strconv.Atoi(fields[len(fields)-2])
+		if err != nil {
+			return rule, fmt.Errorf("invalid host port in %q: %w", spec, err)
+		}
+		rule.HostPort = hostPort
+	}
+	if hostIP != "" {
+		rule.HostIP = net.ParseIP(hostIP)
+		if rule.HostIP == nil {
+			return rule, fmt.Errorf("invalid host IP in %q", spec)
+		}
+	}
+	return rule, nil
+}
+
+func portForwardAddAction(cmd *cobra.Command, args []string) error {
+	rule, err := parsePortForwardSpec(args[1])
+	if err != nil {
+		return err
+	}
+	guestIP, err := cmd.Flags().GetString("guest-ip")
+	if err != nil {
+		return err
+	}
+	if guestIP != "" {
+		rule.GuestIP = net.ParseIP(guestIP)
+		if rule.GuestIP == nil {
+			return fmt.Errorf("invalid guest IP %q", guestIP)
+		}
+	}
+	rule.Ignore, err = cmd.Flags().GetBool("ignore")
+	if err != nil {
+		return err
+	}
+	rule.Proto, err = cmd.Flags().GetString("proto")
+	if err != nil {
+		return err
+	}
+	rule.HostPortFallback, err = cmd.Flags().GetString("host-port-fallback")
+	if err != nil {
+		return err
+	}
+	client, err := newRunningHostAgentClient(args[0])
+	if err != nil {
+		return err
+	}
+	added, err := client.AddPortForward(cmd.Context(), rule)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), added.ID)
+	return nil
+}
+
+func newPortForwardRemoveCommand() *cobra.Command {
+	var removeCommand = &cobra.Command{
+		Use:               "remove INSTANCE ID [ID, ...]",
+		Aliases:           []string{"rm"},
+		Short:             "Remove port forwarding rules that were added with `limactl port-forward add`",
+		Args:              WrapArgsError(cobra.MinimumNArgs(2)),
+		RunE:              portForwardRemoveAction,
+		ValidArgsFunction: portForwardBashComplete,
+	}
+	return removeCommand
+}
+
+func portForwardRemoveAction(cmd *cobra.Command, args []string) error {
+	client, err := newRunningHostAgentClient(args[0])
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, arg := range args[1:] {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid rule ID %q: %w", arg, err))
+			continue
+		}
+		if err := client.RemovePortForward(cmd.Context(), id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func newRunningHostAgentClient(instName string) (hostagentclient.HostAgentClient, error) {
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("instance %q does not exist", instName)
+		}
+		return nil, err
+	}
+	if inst.Status != store.StatusRunning {
+		return nil, fmt.Errorf("expected status %q, got %q", store.StatusRunning, inst.Status)
+	}
+	return hostagentclient.NewHostAgentClient(filepath.Join(inst.Dir, filenames.HostAgentSock))
+}
+
+func portForwardBashComplete(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return bashCompleteInstanceNames(cmd)
+}
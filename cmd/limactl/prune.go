@@ -2,8 +2,9 @@ package main
 
 import (
 	"os"
-	"path/filepath"
 
+	"github.com/lima-vm/lima/pkg/clone"
+	"github.com/lima-vm/lima/pkg/downloader"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -20,11 +21,13 @@ func newPruneCommand() *cobra.Command {
 }
 
 func pruneAction(_ *cobra.Command, _ []string) error {
-	ucd, err := os.UserCacheDir()
+	cacheDir, err := downloader.DefaultCacheDir()
 	if err != nil {
 		return err
 	}
-	cacheDir := filepath.Join(ucd, "lima")
 	logrus.Infof("Pruning %q", cacheDir)
-	return os.RemoveAll(cacheDir)
+	if err := os.RemoveAll(cacheDir); err != nil {
+		return err
+	}
+	return clone.PruneFrozen()
 }
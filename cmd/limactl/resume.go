@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	networks "github.com/lima-vm/lima/pkg/networks/reconcile"
+	"github.com/lima-vm/lima/pkg/start"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newResumeCommand() *cobra.Command {
+	var resumeCmd = &cobra.Command{
+		Use:   "resume INSTANCE",
+		Short: "Resume a suspended instance",
+		Long: `Resume an instance that was suspended with "limactl suspend".
+
+"limactl start" resumes suspended instances as well.
+To discard the saved state, run "limactl stop -f INSTANCE".
+
+This command is experimental.`,
+		Args:              WrapArgsError(cobra.MaximumNArgs(1)),
+		RunE:              resumeAction,
+		ValidArgsFunction: resumeBashComplete,
+	}
+	resumeCmd.Flags().Duration("timeout", start.DefaultWatchHostAgentEventsTimeout, "duration to wait for the instance to be running before timing out")
+	return resumeCmd
+}
+
+func resumeAction(cmd *cobra.Command, args []string) error {
+	logrus.Warn("`limactl resume` is experimental")
+	instName := DefaultInstanceName
+	if len(args) > 0 {
+		instName = args[0]
+	}
+
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		return err
+	}
+	if len(inst.Errors) > 0 {
+		return fmt.Errorf("errors inspecting instance: %+v", inst.Errors)
+	}
+	if inst.Status != store.StatusSuspended {
+		return fmt.Errorf("expected status %q, got %q", store.StatusSuspended, inst.Status)
+	}
+	ctx := cmd.Context()
+	if err := networks.Reconcile(ctx, inst.Name); err != nil {
+		return err
+	}
+
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	if timeout > 0 {
+		ctx = start.WithWatchHostAgentTimeout(ctx, timeout)
+	}
+
+	return start.Start(ctx, inst)
+}
+
+func resumeBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteInstanceNames(cmd)
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/lima-vm/lima/pkg/snapshot"
 	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lithammer/dedent"
 	"github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
@@ -23,6 +24,7 @@ func newSnapshotCommand() *cobra.Command {
 	snapshotCmd.AddCommand(newSnapshotCreateCommand())
 	snapshotCmd.AddCommand(newSnapshotDeleteCommand())
 	snapshotCmd.AddCommand(newSnapshotListCommand())
+	snapshotCmd.AddCommand(newSnapshotTreeCommand())
 
 	return snapshotCmd
 }
@@ -37,6 +39,7 @@ func newSnapshotCreateCommand() *cobra.Command {
 		ValidArgsFunction: snapshotBashComplete,
 	}
 	createCmd.Flags().String("tag", "", "name of the snapshot")
+	createCmd.Flags().String("description", "", "description of the snapshot")
 
 	return createCmd
 }
@@ -58,8 +61,13 @@ func snapshotCreateAction(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("expected tag")
 	}
 
+	description, err := cmd.Flags().GetString("description")
+	if err != nil {
+		return err
+	}
+
 	ctx := cmd.Context()
-	return snapshot.Save(ctx, inst, tag)
+	return snapshot.Save(ctx, inst, tag, description)
 }
 
 func newSnapshotDeleteCommand() *cobra.Command {
@@ -107,6 +115,7 @@ func newSnapshotApplyCommand() *cobra.Command {
 		ValidArgsFunction: snapshotBashComplete,
 	}
 	applyCmd.Flags().String("tag", "", "name of the snapshot")
+	applyCmd.Flags().Bool("discard-newer", false, "delete the snapshots that descend from the applied snapshot")
 
 	return applyCmd
 }
@@ -128,19 +137,36 @@ func snapshotApplyAction(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("expected tag")
 	}
 
+	discardNewer, err := cmd.Flags().GetBool("discard-newer")
+	if err != nil {
+		return err
+	}
+
 	ctx := cmd.Context()
-	return snapshot.Load(ctx, inst, tag)
+	return snapshot.Load(ctx, inst, tag, discardNewer)
 }
 
 func newSnapshotListCommand() *cobra.Command {
 	var listCmd = &cobra.Command{
-		Use:               "list INSTANCE",
-		Aliases:           []string{"ls"},
-		Short:             "List existing snapshots",
+		Use:     "list INSTANCE",
+		Aliases: []string{"ls"},
+		Short:   "List existing snapshots",
+		Long: "List existing snapshots.\n" + dedent.Dedent(`
+		The output can be presented in one of several formats, using the --format <format> flag.
+
+		  --format json  - output in json format
+		  --format yaml  - output in yaml format
+		  --format table - output in table format
+		  --format '{{ <go template> }}' - if the format begins and ends with '{{ }}', then it is used as a go template.
+
+		The following fields are available for the go template:
+		  .Tag, .CreatedAt, .VMClock, .VMStateSize, .VMState
+		`),
 		Args:              cobra.MinimumNArgs(1),
 		RunE:              snapshotListAction,
 		ValidArgsFunction: snapshotBashComplete,
 	}
+	listCmd.Flags().StringP("format", "f", "table", "output format, one of: json, yaml, table, go-template")
 	listCmd.Flags().BoolP("quiet", "q", false, "Only show tags")
 
 	return listCmd
@@ -158,30 +184,59 @@ func snapshotListAction(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	if quiet && cmd.Flags().Changed("format") {
+		return errors.New("option --quiet conflicts with option --format")
+	}
 	ctx := cmd.Context()
-	out, err := snapshot.List(ctx, inst)
+	snapshots, err := snapshot.List(ctx, inst)
 	if err != nil {
 		return err
 	}
 	if quiet {
-		for i, line := range strings.Split(out, "\n") {
-			// "ID", "TAG", "VM SIZE", "DATE", "VM CLOCK", "ICOUNT"
-			fields := strings.Fields(line)
-			if i == 0 && len(fields) > 1 && fields[1] != "TAG" {
-				// make sure that output matches the expected
-				return fmt.Errorf("unknown header: %s", line)
-			}
-			if i == 0 || line == "" {
-				// skip header and empty line after using split
-				continue
-			}
-			tag := fields[1]
-			fmt.Printf("%s\n", tag)
-		}
-		return nil
-	}
-	fmt.Print(out)
-	return nil
+		format = "{{.Tag}}"
+	}
+	return snapshot.PrintSnapshots(cmd.OutOrStdout(), snapshots, format)
+}
+
+func newSnapshotTreeCommand() *cobra.Command {
+	var treeCmd = &cobra.Command{
+		Use:   "tree INSTANCE",
+		Short: "Show the tree of existing snapshots",
+		Long: "Show the tree of existing snapshots.\n" + dedent.Dedent(`
+		A snapshot is a child of the snapshot that was created or applied before it.
+		The snapshot that was created or applied most recently is marked as "current".
+		`),
+		Args:              cobra.MinimumNArgs(1),
+		RunE:              snapshotTreeAction,
+		ValidArgsFunction: snapshotBashComplete,
+	}
+	treeCmd.Flags().StringP("format", "f", "tree", "output format, one of: json, yaml, tree")
+
+	return treeCmd
+}
+
+func snapshotTreeAction(cmd *cobra.Command, args []string) error {
+	instName := args[0]
+
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		return err
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	roots, err := snapshot.Tree(ctx, inst)
+	if err != nil {
+		return err
+	}
+	return snapshot.PrintTree(cmd.OutOrStdout(), roots, format)
 }
 
 func snapshotBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
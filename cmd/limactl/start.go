@@ -499,6 +499,8 @@ func startAction(cmd *cobra.Command, args []string) error {
 		return nil
 	case store.StatusStopped:
 		// NOP
+	case store.StatusSuspended:
+		logrus.Infof("The instance %q is suspended, resuming the instance", inst.Name)
 	default:
 		logrus.Warnf("expected status %q, got %q", store.StatusStopped, inst.Status)
 	}
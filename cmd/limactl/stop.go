@@ -1,17 +1,13 @@
 package main
 
 import (
-	"context"
-	"errors"
-	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
-	hostagentevents "github.com/lima-vm/lima/pkg/hostagent/events"
 	networks "github.com/lima-vm/lima/pkg/networks/reconcile"
 	"github.com/lima-vm/lima/pkg/osutil"
+	"github.com/lima-vm/lima/pkg/stop"
 	"github.com/lima-vm/lima/pkg/store"
 	"github.com/lima-vm/lima/pkg/store/filenames"
 	"github.com/sirupsen/logrus"
@@ -49,7 +45,7 @@ func stopAction(cmd *cobra.Command, args []string) error {
 	if force {
 		stopInstanceForcibly(inst)
 	} else {
-		err = stopInstanceGracefully(inst)
+		err = stop.Gracefully(cmd.Context(), inst)
 	}
 	// TODO: should we also reconcile networks if graceful stop returned an error?
 	if err == nil {
@@ -58,51 +54,6 @@ func stopAction(cmd *cobra.Command, args []string) error {
 	return err
 }
 
-func stopInstanceGracefully(inst *store.Instance) error {
-	if inst.Status != store.StatusRunning {
-		return fmt.Errorf("expected status %q, got %q (maybe use `limactl stop -f`?)", store.StatusRunning, inst.Status)
-	}
-
-	begin := time.Now() // used for logrus propagation
-	logrus.Infof("Sending SIGINT to hostagent process %d", inst.HostAgentPID)
-	if err := osutil.SysKill(inst.HostAgentPID, osutil.SigInt); err != nil {
-		logrus.Error(err)
-	}
-
-	logrus.Info("Waiting for the host agent and the driver processes to shut down")
-	return waitForHostAgentTermination(context.TODO(), inst, begin)
-}
-
-func waitForHostAgentTermination(ctx context.Context, inst *store.Instance, begin time.Time) error {
-	ctx2, cancel := context.WithTimeout(ctx, 3*time.Minute)
-	defer cancel()
-
-	var receivedExitingEvent bool
-	onEvent := func(ev hostagentevents.Event) bool {
-		if len(ev.Status.Errors) > 0 {
-			logrus.Errorf("%+v", ev.Status.Errors)
-		}
-		if ev.Status.Exiting {
-			receivedExitingEvent = true
-			return true
-		}
-		return false
-	}
-
-	haStdoutPath := filepath.Join(inst.Dir, filenames.HostAgentStdoutLog)
-	haStderrPath := filepath.Join(inst.Dir, filenames.HostAgentStderrLog)
-
-	if err := hostagentevents.Watch(ctx2, haStdoutPath, haStderrPath, begin, onEvent); err != nil {
-		return err
-	}
-
-	if !receivedExitingEvent {
-		return errors.New("did not receive an event with the \"exiting\" status")
-	}
-
-	return nil
-}
-
 func stopInstanceForcibly(inst *store.Instance) {
 	if inst.DriverPID > 0 {
 		logrus.Infof("Sending SIGKILL to the %s driver process %d", inst.VMType, inst.DriverPID)
@@ -120,7 +71,11 @@ func stopInstanceForcibly(inst *store.Instance) {
 			logrus.Warnf("Disk %q does not exist", diskName)
 			continue
 		}
-		if err := disk.Unlock(); err != nil {
+		unlock := disk.Unlock
+		if d.ReadOnly != nil && *d.ReadOnly {
+			unlock = func() error { return disk.UnlockReadOnly(inst.Dir) }
+		}
+		if err := unlock(); err != nil {
 			logrus.Warnf("Failed to unlock disk %q. To use, run `limactl disk unlock %v`", diskName, diskName)
 		}
 	}
@@ -134,6 +89,15 @@ func stopInstanceForcibly(inst *store.Instance) {
 		logrus.Info("The host agent process seems already stopped")
 	}
 
+	if inst.Status == store.StatusSuspended {
+		logrus.Warnf("Discarding the saved state of the suspended instance %q", inst.Name)
+		for _, f := range []string{filenames.VMState, filenames.VMStateJSON} {
+			if err := os.RemoveAll(filepath.Join(inst.Dir, f)); err != nil {
+				logrus.Error(err)
+			}
+		}
+	}
+
 	suffixesToBeRemoved := []string{".pid", ".sock", ".tmp"}
 	logrus.Infof("Removing %s under %q", inst.Dir, strings.ReplaceAll(strings.Join(suffixesToBeRemoved, " "), ".", "*."))
 	fi, err := os.ReadDir(inst.Dir)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	hostagentclient "github.com/lima-vm/lima/pkg/hostagent/api/client"
+	"github.com/lima-vm/lima/pkg/stop"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newSuspendCommand() *cobra.Command {
+	var suspendCmd = &cobra.Command{
+		Use:   "suspend INSTANCE",
+		Short: "Suspend (pause to disk) an instance",
+		Long: `Suspend (pause to disk) an instance.
+
+The state of the VM is saved to the instance directory, and the VM is stopped.
+Run "limactl resume INSTANCE" to resume the instance from the saved state.
+
+This command is experimental. Only vmType "qemu" is supported.`,
+		Args:              WrapArgsError(cobra.MaximumNArgs(1)),
+		RunE:              suspendAction,
+		ValidArgsFunction: suspendBashComplete,
+	}
+	return suspendCmd
+}
+
+func suspendAction(cmd *cobra.Command, args []string) error {
+	logrus.Warn("`limactl suspend` is experimental")
+	instName := DefaultInstanceName
+	if len(args) > 0 {
+		instName = args[0]
+	}
+
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		return err
+	}
+	if inst.Status != store.StatusRunning {
+		return fmt.Errorf("expected status %q, got %q", store.StatusRunning, inst.Status)
+	}
+	haClient, err := hostagentclient.NewHostAgentClient(filepath.Join(inst.Dir, filenames.HostAgentSock))
+	if err != nil {
+		return err
+	}
+
+	begin := time.Now() // used for logrus propagation
+	logrus.Infof("Requesting the host agent to suspend the instance %q", inst.Name)
+	if err := haClient.Suspend(cmd.Context()); err != nil {
+		return err
+	}
+
+	logrus.Info("Waiting for the host agent to save the VM state and to shut down")
+	if err := stop.WaitForHostAgentTermination(cmd.Context(), inst, begin); err != nil {
+		return err
+	}
+
+	inst, err = store.Inspect(instName)
+	if err != nil {
+		return err
+	}
+	if inst.Status != store.StatusSuspended {
+		return fmt.Errorf("failed to suspend the instance %q, got status %q (hint: see %q)",
+			inst.Name, inst.Status, filepath.Join(inst.Dir, filenames.HostAgentStderrLog))
+	}
+	logrus.Infof("Suspended the instance %q. Run `limactl resume %s` to resume the instance.", inst.Name, inst.Name)
+	return nil
+}
+
+func suspendBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteInstanceNames(cmd)
+}
@@ -0,0 +1,16 @@
+#!/bin/sh
+# This script replaces the cloud-init functionality of creating a user and setting its SSH keys
+# when using the fake driver, which runs boot.sh as root in a chroot.
+[ "$LIMA_CIDATA_VMTYPE" = "fake" ] || exit 0
+
+# create user
+if ! id "${LIMA_CIDATA_USER}" >/dev/null 2>&1; then
+	useradd -u "${LIMA_CIDATA_UID}" "${LIMA_CIDATA_USER}" -d "${LIMA_CIDATA_HOME}" -s /bin/bash
+fi
+mkdir -p "${LIMA_CIDATA_HOME}"/.ssh/
+cp "${LIMA_CIDATA_MNT}"/ssh_authorized_keys "${LIMA_CIDATA_HOME}"/.ssh/authorized_keys
+chown -R "${LIMA_CIDATA_USER}" "${LIMA_CIDATA_HOME}"
+
+# add $LIMA_CIDATA_USER to sudoers
+mkdir -p /etc/sudoers.d
+echo "${LIMA_CIDATA_USER} ALL=(ALL) NOPASSWD:ALL" >/etc/sudoers.d/99_lima_sudoers
@@ -15,9 +15,15 @@ for i in $(seq 0 $((LIMA_CIDATA_DISKS - 1))); do
 	FORMAT_DISK="$(get_disk_var "$i" "FORMAT")"
 	FORMAT_FSTYPE="$(get_disk_var "$i" "FSTYPE")"
 	FORMAT_FSARGS="$(get_disk_var "$i" "FSARGS")"
+	READONLY="$(get_disk_var "$i" "READONLY")"
 
 	test -n "$FORMAT_DISK" || FORMAT_DISK=true
 	test -n "$FORMAT_FSTYPE" || FORMAT_FSTYPE=ext4
+	test -n "$READONLY" || READONLY=false
+	# read-only disks are shared with other instances, and never modified
+	if $READONLY; then
+		FORMAT_DISK=false
+	fi
 
 	# first time setup
 	if [[ ! -b "/dev/disk/by-label/lima-${DISK_NAME}" ]]; then
@@ -28,6 +34,35 @@ for i in $(seq 0 $((LIMA_CIDATA_DISKS - 1))); do
 		fi
 	fi
 
-	mkdir -p "/mnt/lima-${DISK_NAME}"
-	mount -t $FORMAT_FSTYPE "/dev/${DEVICE_NAME}1" "/mnt/lima-${DISK_NAME}"
+	MOUNT_POINT="/mnt/lima-${DISK_NAME}"
+	mkdir -p "${MOUNT_POINT}"
+	# the script is executed again by the host agent after resizing the disk
+	if ! mountpoint -q "${MOUNT_POINT}"; then
+		MOUNT_OPTS=rw
+		if $READONLY; then
+			MOUNT_OPTS=ro
+			# do not replay the journal on the read-only device
+			case "$FORMAT_FSTYPE" in
+			ext3 | ext4) MOUNT_OPTS="ro,noload" ;;
+			xfs) MOUNT_OPTS="ro,norecovery" ;;
+			esac
+		fi
+		mount -t "$FORMAT_FSTYPE" -o "$MOUNT_OPTS" "/dev/${DEVICE_NAME}1" "${MOUNT_POINT}"
+	fi
+
+	# grow the partition and the filesystem, if the disk has been resized
+	$FORMAT_DISK || continue
+	DISK_SIZE="$(lsblk -bdno SIZE "/dev/${DEVICE_NAME}")"
+	PART_SIZE="$(lsblk -bdno SIZE "/dev/${DEVICE_NAME}1")"
+	test $((DISK_SIZE - PART_SIZE)) -gt $((16 * 1024 * 1024)) || continue
+	# move the backup GPT header to the end of the disk
+	sfdisk --relocate gpt-bak-std "/dev/${DEVICE_NAME}"
+	echo ',+' | sfdisk --no-reread -N 1 "/dev/${DEVICE_NAME}"
+	partx -u "/dev/${DEVICE_NAME}"
+	case "$FORMAT_FSTYPE" in
+	ext*) resize2fs "/dev/${DEVICE_NAME}1" ;;
+	xfs) xfs_growfs "${MOUNT_POINT}" ;;
+	btrfs) btrfs filesystem resize max "${MOUNT_POINT}" ;;
+	*) echo "WARNING: growing the filesystem of type ${FORMAT_FSTYPE} is not supported" >&2 ;;
+	esac
 done
@@ -18,7 +18,11 @@ fi
 install -m 755 "${LIMA_CIDATA_MNT}"/lima-guestagent "${LIMA_CIDATA_GUEST_INSTALL_PREFIX}"/bin/lima-guestagent
 
 # Launch the guestagent service
-if [ -f /sbin/openrc-run ]; then
+if [ "$LIMA_CIDATA_VMTYPE" = "fake" ]; then
+	# The fake driver has no init system; the process is killed along with the chroot
+	pkill -x lima-guestagent || true
+	nohup "${LIMA_CIDATA_GUEST_INSTALL_PREFIX}"/bin/lima-guestagent daemon >/var/log/lima-guestagent.log 2>&1 &
+elif [ -f /sbin/openrc-run ]; then
 	# Install the openrc lima-guestagent service script
 	cat >/etc/init.d/lima-guestagent <<'EOF'
 #!/sbin/openrc-run
@@ -40,7 +44,7 @@ else
 	# Remove legacy systemd service
 	rm -f "${LIMA_CIDATA_HOME}/.config/systemd/user/lima-guestagent.service"
 
-	if [ "$LIMA_CIDATA_VMTYPE" = "wsl2" ]; then
+	if [ "${LIMA_CIDATA_VSOCK_PORT}" -ne 0 ]; then
 		sudo "${LIMA_CIDATA_GUEST_INSTALL_PREFIX}"/bin/lima-guestagent install-systemd --vsock-port "${LIMA_CIDATA_VSOCK_PORT}"
 	else
 		sudo "${LIMA_CIDATA_GUEST_INSTALL_PREFIX}"/bin/lima-guestagent install-systemd
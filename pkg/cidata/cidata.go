@@ -243,12 +243,17 @@ func GenerateISO9660(instDir, name string, y *limayaml.LimaYAML, udpDNSLocalPort
 		if d.FSType != nil {
 			fstype = *d.FSType
 		}
+		readOnly := false
+		if d.ReadOnly != nil {
+			readOnly = *d.ReadOnly
+		}
 		args.Disks = append(args.Disks, Disk{
-			Name:   d.Name,
-			Device: diskDeviceNameFromOrder(i),
-			Format: format,
-			FSType: fstype,
-			FSArgs: d.FSArgs,
+			Name:     d.Name,
+			Device:   diskDeviceNameFromOrder(i),
+			Format:   format,
+			FSType:   fstype,
+			FSArgs:   d.FSArgs,
+			ReadOnly: readOnly,
 		})
 	}
 
@@ -266,7 +271,8 @@ func GenerateISO9660(instDir, name string, y *limayaml.LimaYAML, udpDNSLocalPort
 	}
 	if firstUsernetIndex != -1 || *y.VMType == limayaml.VZ {
 		args.DNSAddresses = append(args.DNSAddresses, args.SlirpDNS)
-	} else if *y.HostResolver.Enabled {
+	} else if *y.HostResolver.Enabled && *y.VMType != limayaml.Fake {
+		// The fake driver shares the network namespace with the host, so the host resolver cannot be set up with iptables
 		args.UDPDNSLocalPort = udpDNSLocalPort
 		args.TCPDNSLocalPort = tcpDNSLocalPort
 		args.DNSAddresses = append(args.DNSAddresses, args.SlirpDNS)
@@ -357,7 +363,7 @@ func GenerateISO9660(instDir, name string, y *limayaml.LimaYAML, udpDNSLocalPort
 		})
 	}
 
-	if args.VMType == limayaml.WSL2 {
+	if args.VMType == limayaml.WSL2 || args.VMType == limayaml.Fake {
 		layout = append(layout, iso9660util.Entry{
 			Path:   "ssh_authorized_keys",
 			Reader: strings.NewReader(strings.Join(args.SSHPubKeys, "\n")),
@@ -46,11 +46,12 @@ type BootCmds struct {
 	Lines []string
 }
 type Disk struct {
-	Name   string
-	Device string
-	Format bool
-	FSType string
-	FSArgs []string
+	Name     string
+	Device   string
+	Format   bool
+	FSType   string
+	FSArgs   []string
+	ReadOnly bool
 }
 type TemplateArgs struct {
 	Name                            string // instance name
@@ -0,0 +1,327 @@
+// Package clone creates a copy of an instance that boots as a separate machine.
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/goccy/go-yaml"
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/driverutil"
+	"github.com/lima-vm/lima/pkg/iso9660util"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/qemu/imgutil"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/dirnames"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/lima-vm/lima/pkg/yqutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Clone creates the instance dstName from the stopped instance src.
+//
+// The disk of src is frozen into a read-only layer in $LIMA_HOME/_frozen (see freezeDisk), and the diff disk
+// of the clone is created as a qcow2 overlay of it, so the disk is not copied for each clone, and src can
+// continue to be used independently.
+// The host-specific configuration (MAC addresses, SSH port) is reset, and cidata.iso is
+// regenerated on the first start of the clone.
+func Clone(_ context.Context, src *store.Instance, dstName string) (_ *store.Instance, retErr error) {
+	if src.Status != store.StatusStopped {
+		return nil, fmt.Errorf("expected status %q, got %q (hint: stop the instance before cloning)", store.StatusStopped, src.Status)
+	}
+	if src.VMType != limayaml.QEMU {
+		return nil, fmt.Errorf("cloning is not supported for vmType %q", src.VMType)
+	}
+	if len(src.AdditionalDisks) > 0 {
+		return nil, fmt.Errorf("cloning an instance with additionalDisks is not supported (hint: detach them with `limactl edit --set 'del(.additionalDisks)' %s`)", src.Name)
+	}
+	dstDir, err := store.InstanceDir(dstName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dstDir); !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("instance %q already exists (%q)", dstName, dstDir)
+	}
+	srcYBytes, err := os.ReadFile(filepath.Join(src.Dir, filenames.LimaYAML))
+	if err != nil {
+		return nil, err
+	}
+	dstYBytes, err := ResetHostSpecificFields(srcYBytes, filepath.Join(dstDir, filenames.LimaYAML))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dstDir, 0o700); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			_ = os.RemoveAll(dstDir)
+		}
+	}()
+	if err := os.WriteFile(filepath.Join(dstDir, filenames.LimaYAML), dstYBytes, 0o644); err != nil {
+		return nil, err
+	}
+	for _, f := range []string{filenames.Kernel, filenames.KernelCmdline, filenames.Initrd} {
+		if _, err := os.Stat(filepath.Join(src.Dir, f)); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		// continuity attempts clonefile
+		if err := fs.CopyFile(filepath.Join(dstDir, f), filepath.Join(src.Dir, f)); err != nil {
+			return nil, err
+		}
+	}
+	frozen, err := freezeDisk(src)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(src.Dir, filenames.BaseDisk)); err == nil {
+		// The base disk of the clone is only read, as the base disk of src
+		if err := linkOrCopyFile(filepath.Join(dstDir, filenames.BaseDisk), filepath.Join(src.Dir, filenames.BaseDisk)); err != nil {
+			return nil, err
+		}
+	}
+
+	dst, err := store.Inspect(dstName)
+	if err != nil {
+		return nil, err
+	}
+	if frozen != "" {
+		if err := imgutil.CreateOverlay(filepath.Join(dstDir, filenames.DiffDisk), frozen, "qcow2"); err != nil {
+			return nil, err
+		}
+		return dst, nil
+	}
+	y, err := dst.LoadYAML()
+	if err != nil {
+		return nil, err
+	}
+	// src has not been started yet. Creates the diff disk backed by the base disk.
+	limaDriver := driverutil.CreateTargetDriverInstance(&driver.BaseDriver{
+		Instance: dst,
+		Yaml:     y,
+	})
+	if err := limaDriver.CreateDisk(); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// freezeDisk freezes the disk of the stopped instance src into a read-only layer in $LIMA_HOME/_frozen,
+// and returns the path of the layer. An empty string is returned when src has not been started yet.
+//
+// The diff disk of src is moved into the layer, and replaced with an empty overlay of the layer,
+// so the layer is shared by src and its clones without copying the disk. The layer is reused
+// until src is started again. When the diff disk has snapshots, the layer is written as a copy
+// of the diff disk instead, so the snapshots of src are kept.
+// The layers that are no longer used are removed by PruneFrozen.
+func freezeDisk(src *store.Instance) (string, error) {
+	srcBaseDisk := filepath.Join(src.Dir, filenames.BaseDisk)
+	srcDiffDisk := filepath.Join(src.Dir, filenames.DiffDisk)
+	if _, err := os.Stat(srcDiffDisk); errors.Is(err, os.ErrNotExist) {
+		// Not started yet
+		return "", nil
+	}
+	isBaseDiskISO, err := iso9660util.IsISO9660(srcBaseDisk)
+	if err != nil {
+		return "", err
+	}
+	if isBaseDiskISO {
+		return "", errors.New("cloning an instance booted from an ISO9660 image is not supported")
+	}
+	frozenDir, err := dirnames.LimaFrozenDir()
+	if err != nil {
+		return "", err
+	}
+	info, err := imgutil.GetInfo(srcDiffDisk)
+	if err != nil {
+		return "", err
+	}
+	backingFile := fullBackingFilename(info)
+	if backingFile == "" {
+		return "", fmt.Errorf("the diff disk %q has no backing file", srcDiffDisk)
+	}
+	if isFrozen(frozenDir, backingFile) && len(info.Snapshots) == 0 {
+		unchanged, err := isUnchangedOverlay(srcDiffDisk, info, backingFile)
+		if err != nil {
+			return "", err
+		}
+		if unchanged {
+			logrus.Infof("Reusing the frozen disk %q", backingFile)
+			return backingFile, nil
+		}
+	}
+
+	if err := os.MkdirAll(frozenDir, 0o700); err != nil {
+		return "", err
+	}
+	layerDir, err := os.MkdirTemp(frozenDir, src.Name+"-")
+	if err != nil {
+		return "", err
+	}
+	frozen := filepath.Join(layerDir, filenames.DiffDisk)
+	if err := freezeDiffDisk(srcDiffDisk, frozen, info, backingFile, !isFrozen(frozenDir, backingFile)); err != nil {
+		_ = os.RemoveAll(layerDir)
+		return "", err
+	}
+	return frozen, nil
+}
+
+// freezeDiffDisk writes the diff disk into frozen. When linkBackingFile is true, the backing file is linked
+// into the directory of frozen, so that frozen does not depend on the instance directory.
+func freezeDiffDisk(diffDisk, frozen string, info *imgutil.Info, backingFile string, linkBackingFile bool) error {
+	frozenBackingFile := backingFile
+	if linkBackingFile {
+		frozenBackingFile = filepath.Join(filepath.Dir(frozen), filenames.BaseDisk)
+		if err := linkOrCopyFile(frozenBackingFile, backingFile); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("Freezing the disk %q into %q", diffDisk, frozen)
+	if len(info.Snapshots) > 0 {
+		// Moving the diff disk would lose the snapshots of the instance
+		if err := imgutil.ConvertOverlay(diffDisk, frozen, frozenBackingFile, info.BackingFilenameFormat); err != nil {
+			return err
+		}
+		return os.Chmod(frozen, 0o444)
+	}
+	if err := imgutil.Rebase(diffDisk, frozenBackingFile, info.BackingFilenameFormat); err != nil {
+		return err
+	}
+	err := os.Rename(diffDisk, frozen)
+	if err == nil {
+		if err = imgutil.CreateOverlay(diffDisk, frozen, "qcow2"); err != nil {
+			_ = os.Rename(frozen, diffDisk)
+		}
+	}
+	if err != nil {
+		// Restore the backing file, as the directory of frozen is removed
+		if rebaseErr := imgutil.Rebase(diffDisk, backingFile, info.BackingFilenameFormat); rebaseErr != nil {
+			return errors.Join(err, rebaseErr)
+		}
+		return err
+	}
+	return os.Chmod(frozen, 0o444)
+}
+
+// isUnchangedOverlay returns true if the overlay f of the frozen layer has not been written since it was created.
+func isUnchangedOverlay(f string, info *imgutil.Info, frozen string) (bool, error) {
+	empty, err := imgutil.IsEmptyOverlay(f)
+	if err != nil || !empty {
+		return false, err
+	}
+	frozenInfo, err := imgutil.GetInfo(frozen)
+	if err != nil {
+		return false, err
+	}
+	// `limactl disk resize` only changes the virtual size of the overlay
+	return frozenInfo.VSize == info.VSize, nil
+}
+
+// isFrozen returns true if f is a frozen layer in frozenDir.
+func isFrozen(frozenDir, f string) bool {
+	return filepath.Dir(filepath.Dir(f)) == frozenDir
+}
+
+func fullBackingFilename(info *imgutil.Info) string {
+	if info.FullBackingFilename != "" {
+		return info.FullBackingFilename
+	}
+	return info.BackingFilename
+}
+
+// linkOrCopyFile creates the hard link dst of src, or copies src to dst when the hard link cannot be created,
+// e.g., across filesystems.
+func linkOrCopyFile(dst, src string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	// continuity attempts clonefile
+	return fs.CopyFile(dst, src)
+}
+
+// PruneFrozen removes the frozen layers in $LIMA_HOME/_frozen that are no longer used by any instance.
+func PruneFrozen() error {
+	frozenDir, err := dirnames.LimaFrozenDir()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(frozenDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	instNames, err := store.Instances()
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, instName := range instNames {
+		instDir, err := store.InstanceDir(instName)
+		if err != nil {
+			return err
+		}
+		f := filepath.Join(instDir, filenames.DiffDisk)
+		if _, err := os.Stat(f); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		// Walk the backing chain while it is in frozenDir
+		for {
+			info, err := imgutil.GetInfo(f)
+			if err != nil {
+				return err
+			}
+			f = fullBackingFilename(info)
+			if f == "" || !isFrozen(frozenDir, f) {
+				break
+			}
+			used[filepath.Base(filepath.Dir(f))] = true
+		}
+	}
+	var errs []error
+	for _, e := range entries {
+		if used[e.Name()] {
+			continue
+		}
+		logrus.Infof("Removing the unused frozen disk %q", filepath.Join(frozenDir, e.Name()))
+		if err := os.RemoveAll(filepath.Join(frozenDir, e.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ResetHostSpecificFields clears the fields of lima.yaml that must not be shared with the clone (or the imported instance).
+// Unspecified MAC addresses are derived from the path of lima.yaml, so only the explicit ones are replaced.
+func ResetHostSpecificFields(yBytes []byte, dstLimaYAML string) ([]byte, error) {
+	var raw struct {
+		SSH struct {
+			LocalPort int `yaml:"localPort"`
+		} `yaml:"ssh"`
+		Networks []struct {
+			MACAddress string `yaml:"macAddress"`
+		} `yaml:"networks"`
+	}
+	if err := yaml.Unmarshal(yBytes, &raw); err != nil {
+		return nil, err
+	}
+	var yqExprs []string
+	if raw.SSH.LocalPort != 0 {
+		yqExprs = append(yqExprs, ".ssh.localPort = 0")
+	}
+	for i, nw := range raw.Networks {
+		if nw.MACAddress != "" {
+			yqExprs = append(yqExprs, fmt.Sprintf(".networks[%d].macAddress = %q", i, limayaml.MACAddress(fmt.Sprintf("%s#%d", dstLimaYAML, i))))
+		}
+	}
+	if len(yqExprs) == 0 {
+		return yBytes, nil
+	}
+	return yqutil.EvaluateExpression(yqutil.Join(yqExprs), yBytes)
+}
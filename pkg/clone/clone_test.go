@@ -0,0 +1,100 @@
+package clone
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/qemu/imgutil"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/dirnames"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"gotest.tools/v3/assert"
+)
+
+func TestResetHostSpecificFields(t *testing.T) {
+	const dstLimaYAML = "/home/user/.lima/dst/lima.yaml"
+	t.Run("unspecified", func(t *testing.T) {
+		y := "cpus: 2\n"
+		out, err := ResetHostSpecificFields([]byte(y), dstLimaYAML)
+		assert.NilError(t, err)
+		assert.Equal(t, string(out), y)
+	})
+	t.Run("specified", func(t *testing.T) {
+		y := `# comment
+ssh:
+  localPort: 60022
+networks:
+- lima: shared
+  macAddress: "52:55:55:00:00:01"
+- lima: bridged
+`
+		out, err := ResetHostSpecificFields([]byte(y), dstLimaYAML)
+		assert.NilError(t, err)
+		assert.Equal(t, string(out), `# comment
+ssh:
+  localPort: 0
+networks:
+  - lima: shared
+    macAddress: "`+limayaml.MACAddress(dstLimaYAML+"#0")+`"
+  - lima: bridged
+`)
+	})
+}
+
+func TestFreezeDisk(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	instDir, err := store.InstanceDir("src")
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(instDir, 0o700))
+	src := &store.Instance{Name: "src", Dir: instDir}
+	frozen, err := freezeDisk(src)
+	assert.NilError(t, err)
+	assert.Equal(t, frozen, "", "not started yet")
+
+	for _, cmd := range []string{"qemu-img", "qemu-io"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("%s is not installed", cmd)
+		}
+	}
+	baseDisk := filepath.Join(instDir, filenames.BaseDisk)
+	diffDisk := filepath.Join(instDir, filenames.DiffDisk)
+	assert.NilError(t, exec.Command("qemu-img", "create", "-f", "qcow2", baseDisk, "64M").Run())
+	assert.NilError(t, exec.Command("qemu-img", "create", "-f", "qcow2", "-F", "qcow2", "-b", baseDisk, diffDisk).Run())
+
+	frozen, err = freezeDisk(src)
+	assert.NilError(t, err)
+	frozenDir, err := dirnames.LimaFrozenDir()
+	assert.NilError(t, err)
+	assert.Assert(t, isFrozen(frozenDir, frozen))
+	info, err := imgutil.GetInfo(diffDisk)
+	assert.NilError(t, err)
+	assert.Equal(t, fullBackingFilename(info), frozen)
+	// the frozen disk does not depend on the instance directory
+	frozenInfo, err := imgutil.GetInfo(frozen)
+	assert.NilError(t, err)
+	assert.Equal(t, fullBackingFilename(frozenInfo), filepath.Join(filepath.Dir(frozen), filenames.BaseDisk))
+
+	// reused until the diff disk is written
+	reused, err := freezeDisk(src)
+	assert.NilError(t, err)
+	assert.Equal(t, reused, frozen)
+	assert.NilError(t, exec.Command("qemu-io", "-c", "write 0 64k", diffDisk).Run())
+	frozen2, err := freezeDisk(src)
+	assert.NilError(t, err)
+	assert.Assert(t, frozen2 != frozen)
+	frozenInfo, err = imgutil.GetInfo(frozen2)
+	assert.NilError(t, err)
+	assert.Equal(t, fullBackingFilename(frozenInfo), frozen)
+
+	assert.NilError(t, PruneFrozen())
+	_, err = os.Stat(frozen)
+	assert.NilError(t, err)
+	assert.NilError(t, os.RemoveAll(instDir))
+	assert.NilError(t, PruneFrozen())
+	entries, err := os.ReadDir(frozenDir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+}
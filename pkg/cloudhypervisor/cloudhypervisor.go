@@ -0,0 +1,284 @@
+// Package cloudhypervisor implements the "cloud-hypervisor" vmType, for Linux hosts.
+//
+// The network is provided by passt running in the vhost-user mode, so no root privilege is required.
+// The guest agent is reached over the hybrid vsock device of cloud-hypervisor.
+package cloudhypervisor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+
+	"github.com/docker/go-units"
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/fileutils"
+	"github.com/lima-vm/lima/pkg/iso9660util"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/nativeimgutil"
+	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+)
+
+// guestCID is the context ID of the guest. Not shared with other VMs, as the vsock device is "hybrid".
+const guestCID = 3
+
+// EnsureDisk also ensures the kernel and the initrd.
+// Like VZ, the diff disk is a raw disk, so that qemu-img is not needed.
+func EnsureDisk(driver *driver.BaseDriver) error {
+	diffDisk := filepath.Join(driver.Instance.Dir, filenames.DiffDisk)
+	if _, err := os.Stat(diffDisk); err == nil || !errors.Is(err, os.ErrNotExist) {
+		// disk is already ensured
+		return err
+	}
+
+	y := driver.Yaml
+	baseDisk := filepath.Join(driver.Instance.Dir, filenames.BaseDisk)
+	kernel := filepath.Join(driver.Instance.Dir, filenames.Kernel)
+	kernelCmdline := filepath.Join(driver.Instance.Dir, filenames.KernelCmdline)
+	initrd := filepath.Join(driver.Instance.Dir, filenames.Initrd)
+	if _, err := os.Stat(baseDisk); errors.Is(err, os.ErrNotExist) {
+		var ensuredBaseDisk bool
+		errs := make([]error, len(y.Images))
+		for i, f := range y.Images {
+			if _, err := fileutils.DownloadFile(baseDisk, f.File, true, "the image", *y.Arch); err != nil {
+				errs[i] = err
+				continue
+			}
+			if f.Kernel != nil {
+				if _, err := fileutils.DownloadFile(kernel, f.Kernel.File, false, "the kernel", *y.Arch); err != nil {
+					errs[i] = err
+					continue
+				}
+				if f.Kernel.Cmdline != "" {
+					if err := os.WriteFile(kernelCmdline, []byte(f.Kernel.Cmdline), 0644); err != nil {
+						errs[i] = err
+						continue
+					}
+				}
+			}
+			if f.Initrd != nil {
+				if _, err := fileutils.DownloadFile(initrd, *f.Initrd, false, "the initrd", *y.Arch); err != nil {
+					errs[i] = err
+					continue
+				}
+			}
+			ensuredBaseDisk = true
+			break
+		}
+		if !ensuredBaseDisk {
+			return fileutils.Errors(errs)
+		}
+	}
+	diskSize, _ := units.RAMInBytes(*y.Disk)
+	if diskSize == 0 {
+		return nil
+	}
+	isBaseDiskISO, err := iso9660util.IsISO9660(baseDisk)
+	if err != nil {
+		return err
+	}
+	if isBaseDiskISO {
+		// Create an empty data volume (sparse)
+		diffDiskF, err := os.Create(diffDisk)
+		if err != nil {
+			return err
+		}
+		if err = nativeimgutil.MakeSparse(diffDiskF, diskSize); err != nil {
+			diffDiskF.Close()
+			return err
+		}
+		return diffDiskF.Close()
+	}
+	if err = nativeimgutil.ConvertToRaw(baseDisk, diffDisk, &diskSize, false); err != nil {
+		return fmt.Errorf("failed to convert %q to a raw disk %q: %w", baseDisk, diffDisk, err)
+	}
+	return nil
+}
+
+// Exe returns the path of the cloud-hypervisor binary.
+// The binary can be overridden with $CLOUD_HYPERVISOR.
+func Exe() (string, error) {
+	exe := "cloud-hypervisor"
+	if envV := os.Getenv("CLOUD_HYPERVISOR"); envV != "" {
+		exe = envV
+	}
+	return exec.LookPath(exe)
+}
+
+// PasstExe returns the path of the passt binary.
+// The binary can be overridden with $PASST.
+func PasstExe() (string, error) {
+	exe := "passt"
+	if envV := os.Getenv("PASST"); envV != "" {
+		exe = envV
+	}
+	return exec.LookPath(exe)
+}
+
+// Cmdline returns the arguments of cloud-hypervisor.
+// firmware is used only when the image does not have a kernel.
+// Additional disks are locked by Cmdline.
+func Cmdline(driver *driver.BaseDriver, firmware string) ([]string, error) {
+	y := driver.Yaml
+	instDir := driver.Instance.Dir
+
+	memBytes, err := units.RAMInBytes(*y.Memory)
+	if err != nil {
+		return nil, err
+	}
+	memory := fmt.Sprintf("size=%dM", memBytes>>20)
+	if *y.MountType == limayaml.VIRTIOFS && len(y.Mounts) > 0 {
+		// vhost-user devices require the guest memory to be shared
+		memory += ",shared=on"
+	}
+
+	args := []string{
+		"--api-socket", "path=" + filepath.Join(instDir, filenames.CHAPISock),
+		"--cpus", fmt.Sprintf("boot=%d", *y.CPUs),
+		"--memory", memory,
+		"--serial", "file=" + filepath.Join(instDir, filenames.SerialLog),
+		"--console", "off",
+		"--rng", "src=/dev/urandom",
+	}
+
+	// Kernel
+	kernel := filepath.Join(instDir, filenames.Kernel)
+	kernelCmdline := filepath.Join(instDir, filenames.KernelCmdline)
+	initrd := filepath.Join(instDir, filenames.Initrd)
+	if _, err := os.Stat(kernel); err == nil {
+		args = append(args, "--kernel", kernel)
+		if b, err := os.ReadFile(kernelCmdline); err == nil {
+			args = append(args, "--cmdline", string(b))
+		}
+		if _, err := os.Stat(initrd); err == nil {
+			args = append(args, "--initramfs", initrd)
+		}
+	} else {
+		if firmware == "" {
+			return nil, errors.New("the image does not have a kernel, and no firmware was found")
+		}
+		args = append(args, "--firmware", firmware)
+	}
+
+	// Disk
+	baseDisk := filepath.Join(instDir, filenames.BaseDisk)
+	diffDisk := filepath.Join(instDir, filenames.DiffDisk)
+	isBaseDiskISO, err := iso9660util.IsISO9660(baseDisk)
+	if err != nil {
+		return nil, err
+	}
+	var disks []string
+	if diskSize, _ := units.RAMInBytes(*y.Disk); diskSize > 0 {
+		disks = append(disks, "path="+diffDisk)
+	} else if !isBaseDiskISO {
+		disks = append(disks, "path="+baseDisk)
+	}
+	if isBaseDiskISO {
+		disks = append(disks, "path="+baseDisk+",readonly=on")
+	}
+	for _, d := range y.AdditionalDisks {
+		disk, err := store.InspectDisk(d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("could not load disk %q: %w", d.Name, err)
+		}
+		if disk.Instance != "" {
+			return nil, fmt.Errorf("could not attach disk %q, in use by instance %q", d.Name, disk.Instance)
+		}
+		logrus.Infof("Mounting disk %q on %q", d.Name, disk.MountPoint)
+		diskOpts := "path=" + filepath.Join(disk.Dir, filenames.DataDisk)
+		if d.ReadOnly != nil && *d.ReadOnly {
+			if err := disk.LockReadOnly(instDir); err != nil {
+				return nil, fmt.Errorf("could not lock disk %q: %w", d.Name, err)
+			}
+			diskOpts += ",readonly=on"
+		} else if err := disk.Lock(instDir); err != nil {
+			return nil, fmt.Errorf("could not lock disk %q: %w", d.Name, err)
+		}
+		disks = append(disks, diskOpts)
+	}
+	// cloud-init
+	disks = append(disks, "path="+filepath.Join(instDir, filenames.CIDataISO)+",readonly=on")
+	args = append(args, "--disk")
+	args = append(args, disks...)
+
+	// Network
+	args = append(args, "--net", fmt.Sprintf("vhost_user=true,socket=%s,mac=%s",
+		filepath.Join(instDir, filenames.PasstSock), limayaml.MACAddress(instDir)))
+
+	// Mounts
+	if *y.MountType == limayaml.VIRTIOFS && len(y.Mounts) > 0 {
+		args = append(args, "--fs")
+		for i := range y.Mounts {
+			vhostSock := filepath.Join(instDir, fmt.Sprintf(filenames.VhostSock, i))
+			args = append(args, fmt.Sprintf("tag=mount%d,socket=%s", i, vhostSock))
+		}
+	}
+
+	// vsock (for the guest agent)
+	args = append(args, "--vsock", fmt.Sprintf("cid=%d,socket=%s", guestCID, filepath.Join(instDir, filenames.CHVSockSock)))
+
+	return args, nil
+}
+
+// PasstCmdline returns the arguments of passt.
+// The guest gets the same addresses as the QEMU user-mode network, and the SSH port of the guest is
+// forwarded to sshLocalPort on the host.
+func PasstCmdline(instDir string, sshLocalPort int) []string {
+	return []string{
+		"--vhost-user",
+		"--socket", filepath.Join(instDir, filenames.PasstSock),
+		"--foreground",
+		"--address", networks.SlirpIPAddress,
+		"--netmask", "24",
+		"--gateway", networks.SlirpGateway,
+		"--tcp-ports", fmt.Sprintf("127.0.0.1/%d:22", sshLocalPort),
+		"--udp-ports", "none",
+	}
+}
+
+// Firmware returns the path of the firmware of cloud-hypervisor (rust-hypervisor-firmware, or CLOUDHV.fd of EDK2).
+// The firmware can be overridden with $CLOUD_HYPERVISOR_FIRMWARE.
+func Firmware(exe string, arch limayaml.Arch) (string, error) {
+	if envV := os.Getenv("CLOUD_HYPERVISOR_FIRMWARE"); envV != "" {
+		return envV, nil
+	}
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	binDir := filepath.Dir(exe)                                  // "/usr/local/bin"
+	localDir := filepath.Dir(binDir)                             // "/usr/local"
+	userLocalDir := filepath.Join(currentUser.HomeDir, ".local") // "$HOME/.local"
+
+	var names []string
+	switch arch {
+	case limayaml.X8664:
+		names = []string{"hypervisor-fw", "CLOUDHV.fd"}
+	case limayaml.AARCH64:
+		names = []string{"CLOUDHV_EFI.fd"}
+	default:
+		return "", fmt.Errorf("unexpected architecture: %q", arch)
+	}
+
+	var candidates []string
+	for _, dir := range []string{userLocalDir, localDir, "/usr"} {
+		for _, name := range names {
+			candidates = append(candidates, filepath.Join(dir, "share/cloud-hypervisor", name))
+		}
+	}
+	logrus.Debugf("firmware candidates = %v", candidates)
+
+	for _, f := range candidates {
+		if _, err := os.Stat(f); err == nil {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("could not find firmware for %q (hint: install rust-hypervisor-firmware to %q, or set $CLOUD_HYPERVISOR_FIRMWARE)",
+		arch, filepath.Join(userLocalDir, "share/cloud-hypervisor", names[0]))
+}
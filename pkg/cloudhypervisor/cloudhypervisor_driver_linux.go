@@ -0,0 +1,299 @@
+package cloudhypervisor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/httpclientutil"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/qemu"
+	"github.com/lima-vm/lima/pkg/reflectutil"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+)
+
+const Enabled = true
+
+type LimaCloudHypervisorDriver struct {
+	*driver.BaseDriver
+	chCmd    *exec.Cmd
+	chWaitCh chan error
+
+	helperCmds []*exec.Cmd
+}
+
+func New(driver *driver.BaseDriver) *LimaCloudHypervisorDriver {
+	return &LimaCloudHypervisorDriver{
+		BaseDriver: driver,
+	}
+}
+
+func (l *LimaCloudHypervisorDriver) Validate() error {
+	if *l.Yaml.MountType == limayaml.NINEP {
+		return fmt.Errorf("field `mountType` must be %q or %q for cloud-hypervisor driver, got %q", limayaml.REVSSHFS, limayaml.VIRTIOFS, *l.Yaml.MountType)
+	}
+	if *l.Yaml.Firmware.LegacyBIOS {
+		return fmt.Errorf("`firmware.legacyBIOS` configuration is not supported for cloud-hypervisor driver")
+	}
+	if !limayaml.IsNativeArch(*l.Yaml.Arch) {
+		return fmt.Errorf("unsupported arch: %q", *l.Yaml.Arch)
+	}
+	if unknown := reflectutil.UnknownNonEmptyFields(l.Yaml, "VMType",
+		"Arch",
+		"Images",
+		"CPUs",
+		"CPUType",
+		"Memory",
+		"Disk",
+		"Mounts",
+		"MountType",
+		"SSH",
+		"Firmware",
+		"Provision",
+		"Containerd",
+		"GuestInstallPrefix",
+		"Probes",
+		"PortForwards",
+		"Message",
+		"Env",
+		"DNS",
+		"HostResolver",
+		"PropagateProxyEnv",
+		"CACertificates",
+		"AdditionalDisks",
+		"Audio",
+		"Video",
+		"OS",
+	); len(unknown) > 0 {
+		logrus.Warnf("vmType %s: ignoring %+v", *l.Yaml.VMType, unknown)
+	}
+	for k, v := range l.Yaml.CPUType {
+		if v != "" {
+			logrus.Warnf("vmType %s: ignoring cpuType[%q]: %q", *l.Yaml.VMType, k, v)
+		}
+	}
+	if audioDevice := *l.Yaml.Audio.Device; audioDevice != "" {
+		logrus.Warnf("vmType %s: ignoring `audio.device`: %q", *l.Yaml.VMType, audioDevice)
+	}
+	switch videoDisplay := *l.Yaml.Video.Display; videoDisplay {
+	case "", "default", "none":
+	default:
+		logrus.Warnf("vmType %s: ignoring `video.display`: %q", *l.Yaml.VMType, videoDisplay)
+	}
+	if _, err := Exe(); err != nil {
+		return fmt.Errorf("cloud-hypervisor is not installed: %w", err)
+	}
+	if _, err := PasstExe(); err != nil {
+		return fmt.Errorf("passt is not installed: %w", err)
+	}
+	return nil
+}
+
+func (l *LimaCloudHypervisorDriver) CreateDisk() error {
+	return EnsureDisk(l.BaseDriver)
+}
+
+func (l *LimaCloudHypervisorDriver) Start(ctx context.Context) (chan error, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		if l.chCmd == nil {
+			cancel()
+			l.killHelpers()
+		}
+	}()
+
+	chExe, err := Exe()
+	if err != nil {
+		return nil, err
+	}
+	firmware := ""
+	if _, err := os.Stat(filepath.Join(l.Instance.Dir, filenames.Kernel)); errors.Is(err, os.ErrNotExist) {
+		firmware, err = Firmware(chExe, *l.Yaml.Arch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	chArgs, err := Cmdline(l.BaseDriver, firmware)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []string{filenames.CHAPISock, filenames.CHVSockSock, filenames.PasstSock} {
+		if err := os.RemoveAll(filepath.Join(l.Instance.Dir, f)); err != nil {
+			return nil, err
+		}
+	}
+
+	passtExe, err := PasstExe()
+	if err != nil {
+		return nil, err
+	}
+	passtCmd := exec.CommandContext(ctx, passtExe, PasstCmdline(l.Instance.Dir, l.SSHLocalPort)...)
+	if err := l.startHelper(passtCmd, "passt", filepath.Join(l.Instance.Dir, filenames.PasstSock)); err != nil {
+		return nil, err
+	}
+
+	if *l.Yaml.MountType == limayaml.VIRTIOFS {
+		vhostExe, err := qemu.FindVirtiofsd(chExe)
+		if err != nil {
+			return nil, err
+		}
+		qCfg := qemu.Config{
+			Name:        l.Instance.Name,
+			InstanceDir: l.Instance.Dir,
+			LimaYAML:    l.Yaml,
+		}
+		for i := range l.Yaml.Mounts {
+			args, err := qemu.VirtiofsdCmdline(qCfg, i)
+			if err != nil {
+				return nil, err
+			}
+			vhostCmd := exec.CommandContext(ctx, vhostExe, args...)
+			vhostSock := filepath.Join(l.Instance.Dir, fmt.Sprintf(filenames.VhostSock, i))
+			if err := l.startHelper(vhostCmd, fmt.Sprintf("virtiofsd-%d", i), vhostSock); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	chCmd := exec.CommandContext(ctx, chExe, chArgs...)
+	if err := pipeLogs(chCmd, "cloud-hypervisor"); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Starting cloud-hypervisor (hint: to watch the boot progress, see %q)", filepath.Join(l.Instance.Dir, filenames.SerialLog))
+	logrus.Debugf("chCmd.Args: %v", chCmd.Args)
+	if err := chCmd.Start(); err != nil {
+		return nil, err
+	}
+	pidFile := filepath.Join(l.Instance.Dir, filenames.PIDFile(*l.Yaml.VMType))
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(chCmd.Process.Pid)+"\n"), 0644); err != nil {
+		_ = chCmd.Process.Kill()
+		_ = chCmd.Wait()
+		return nil, err
+	}
+	l.chCmd = chCmd
+	l.chWaitCh = make(chan error, 1)
+	go func() {
+		err := chCmd.Wait()
+		_ = os.RemoveAll(pidFile)
+		l.killHelpers()
+		l.chWaitCh <- err
+	}()
+	return l.chWaitCh, nil
+}
+
+// startHelper starts a vhost-user backend, and waits for its socket to appear.
+func (l *LimaCloudHypervisorDriver) startHelper(cmd *exec.Cmd, name, sock string) error {
+	if err := pipeLogs(cmd, name); err != nil {
+		return err
+	}
+	logrus.Debugf("%s: Args: %v", name, cmd.Args)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	l.helperCmds = append(l.helperCmds, cmd)
+	waitCh := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			logrus.Errorf("Error from %s: %v", name, err)
+		}
+		waitCh <- err
+	}()
+	for attempt := 0; attempt < 25; attempt++ {
+		if _, err := os.Stat(sock); err == nil {
+			return nil
+		}
+		select {
+		case err := <-waitCh:
+			return fmt.Errorf("%s never created the socket %q: %w", name, sock, err)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("socket %q of %s never appeared", sock, name)
+}
+
+func (l *LimaCloudHypervisorDriver) killHelpers() {
+	for _, cmd := range l.helperCmds {
+		if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			logrus.WithError(err).Warnf("failed to kill %v", cmd.Args)
+		}
+	}
+}
+
+func (l *LimaCloudHypervisorDriver) Stop(ctx context.Context) error {
+	if l.chCmd == nil {
+		return nil
+	}
+	logrus.Info("Shutting down cloud-hypervisor with ACPI")
+	const timeout = 3 * time.Minute
+	if err := l.powerButton(ctx); err != nil {
+		logrus.WithError(err).Warn("failed to press the power button, forcibly killing cloud-hypervisor")
+		return l.kill()
+	}
+	select {
+	case err := <-l.chWaitCh:
+		logrus.WithError(err).Info("cloud-hypervisor has exited")
+		return err
+	case <-time.After(timeout):
+	}
+	logrus.Warnf("cloud-hypervisor did not exit in %v, forcibly killing cloud-hypervisor", timeout)
+	return l.kill()
+}
+
+// powerButton calls the "vm.power-button" API.
+func (l *LimaCloudHypervisorDriver) powerButton(ctx context.Context) error {
+	hc, err := httpclientutil.NewHTTPClientWithSocketPath(filepath.Join(l.Instance.Dir, filenames.CHAPISock))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", "http://localhost/api/v1/vm.power-button", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return httpclientutil.Successful(resp)
+}
+
+func (l *LimaCloudHypervisorDriver) kill() error {
+	if err := l.chCmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		logrus.WithError(err).Warn("failed to kill cloud-hypervisor")
+	}
+	err := <-l.chWaitCh
+	logrus.WithError(err).Info("cloud-hypervisor has exited, after killing forcibly")
+	return err
+}
+
+func pipeLogs(cmd *exec.Cmd, name string) error {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	go logPipeRoutine(stdout, name+"[stdout]")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	go logPipeRoutine(stderr, name+"[stderr]")
+	return nil
+}
+
+func logPipeRoutine(r io.Reader, header string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logrus.Debugf("%s: %s", header, scanner.Text())
+	}
+}
@@ -0,0 +1,41 @@
+//go:build !linux
+// +build !linux
+
+package cloudhypervisor
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lima-vm/lima/pkg/driver"
+)
+
+var ErrUnsupported = errors.New("vm driver 'cloud-hypervisor' needs a Linux host")
+
+const Enabled = false
+
+type LimaCloudHypervisorDriver struct {
+	*driver.BaseDriver
+}
+
+func New(driver *driver.BaseDriver) *LimaCloudHypervisorDriver {
+	return &LimaCloudHypervisorDriver{
+		BaseDriver: driver,
+	}
+}
+
+func (l *LimaCloudHypervisorDriver) Validate() error {
+	return ErrUnsupported
+}
+
+func (l *LimaCloudHypervisorDriver) CreateDisk() error {
+	return ErrUnsupported
+}
+
+func (l *LimaCloudHypervisorDriver) Start(_ context.Context) (chan error, error) {
+	return nil, ErrUnsupported
+}
+
+func (l *LimaCloudHypervisorDriver) Stop(_ context.Context) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,63 @@
+package cloudhypervisor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/xorcare/pointer"
+	"gotest.tools/v3/assert"
+)
+
+func TestCmdline(t *testing.T) {
+	instDir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.BaseDisk), []byte("not an iso"), 0o644))
+	y := &limayaml.LimaYAML{
+		CPUs:      pointer.Int(2),
+		Memory:    pointer.String("4GiB"),
+		Disk:      pointer.String("100GiB"),
+		MountType: pointer.String(limayaml.VIRTIOFS),
+		Mounts:    []limayaml.Mount{{Location: "/tmp/lima"}},
+	}
+	d := &driver.BaseDriver{
+		Instance: &store.Instance{Name: "foo", Dir: instDir},
+		Yaml:     y,
+	}
+
+	_, err := Cmdline(d, "")
+	assert.ErrorContains(t, err, "no firmware")
+
+	args, err := Cmdline(d, "/usr/share/cloud-hypervisor/hypervisor-fw")
+	assert.NilError(t, err)
+	cmdline := strings.Join(args, " ")
+	assert.Assert(t, strings.Contains(cmdline, "--cpus boot=2 --memory size=4096M,shared=on"), cmdline)
+	assert.Assert(t, strings.Contains(cmdline, "--firmware /usr/share/cloud-hypervisor/hypervisor-fw"), cmdline)
+	assert.Assert(t, strings.Contains(cmdline, "--disk path="+filepath.Join(instDir, filenames.DiffDisk)+" path="+filepath.Join(instDir, filenames.CIDataISO)+",readonly=on"), cmdline)
+	assert.Assert(t, strings.Contains(cmdline, "--fs tag=mount0,socket="+filepath.Join(instDir, "virtiofsd-0.sock")), cmdline)
+	assert.Assert(t, strings.Contains(cmdline, "--vsock cid=3,socket="+filepath.Join(instDir, filenames.CHVSockSock)), cmdline)
+
+	// The kernel takes precedence over the firmware
+	assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.Kernel), nil, 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.KernelCmdline), []byte("console=ttyS0"), 0o644))
+	y.MountType = pointer.String(limayaml.REVSSHFS)
+	args, err = Cmdline(d, "/usr/share/cloud-hypervisor/hypervisor-fw")
+	assert.NilError(t, err)
+	cmdline = strings.Join(args, " ")
+	assert.Assert(t, strings.Contains(cmdline, "--kernel "+filepath.Join(instDir, filenames.Kernel)+" --cmdline console=ttyS0"), cmdline)
+	assert.Assert(t, !strings.Contains(cmdline, "--firmware"), cmdline)
+	assert.Assert(t, !strings.Contains(cmdline, "--fs"), cmdline)
+	assert.Assert(t, strings.Contains(cmdline, "--memory size=4096M "), cmdline)
+}
+
+func TestPasstCmdline(t *testing.T) {
+	args := PasstCmdline("/lima/foo", 60022)
+	cmdline := strings.Join(args, " ")
+	assert.Assert(t, strings.Contains(cmdline, "--socket /lima/foo/passt.sock"), cmdline)
+	assert.Assert(t, strings.Contains(cmdline, "--address 192.168.5.15"), cmdline)
+	assert.Assert(t, strings.Contains(cmdline, "--tcp-ports 127.0.0.1/60022:22"), cmdline)
+}
@@ -0,0 +1,127 @@
+// Package diskcompact reclaims the host disk space that is no longer used by the guest.
+package diskcompact
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/osutil"
+	"github.com/lima-vm/lima/pkg/qemu/imgutil"
+	"github.com/lima-vm/lima/pkg/sshutil"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/lima-vm/sshocker/pkg/ssh"
+	"github.com/sirupsen/logrus"
+)
+
+// Trim discards the unused blocks of the filesystems of the running instance with fstrim(8).
+// The discarded blocks are deallocated from the disk image on the host, as the disks are attached with "discard=on".
+func Trim(_ context.Context, inst *store.Instance) error {
+	if inst.Status != store.StatusRunning {
+		return fmt.Errorf("expected status %q, got %q", store.StatusRunning, inst.Status)
+	}
+	y, err := inst.LoadYAML()
+	if err != nil {
+		return err
+	}
+	sshOpts, err := sshutil.SSHOpts(inst.Dir, *y.SSH.LoadDotSSHPubKeys, false, false, false)
+	if err != nil {
+		return err
+	}
+	sshConfig := &ssh.SSHConfig{
+		AdditionalArgs: sshutil.SSHArgsFromOpts(sshOpts),
+	}
+	const (
+		script = "#!/bin/sh\nset -eux\nsync\nsudo fstrim -av\n"
+		desc   = "discarding the unused blocks"
+	)
+	logrus.Infof("Running fstrim in %q", inst.Name)
+	stdout, stderr, err := ssh.ExecuteScript(inst.SSHAddress, inst.SSHLocalPort, sshConfig, script, desc)
+	logrus.Debugf("stdout=%q, stderr=%q, err=%v", stdout, stderr, err)
+	if err != nil {
+		return fmt.Errorf("failed to run the script for %s: stdout=%q, stderr=%q: %w", desc, stdout, stderr, err)
+	}
+	return nil
+}
+
+// CheckRewrite checks whether the diff disk of the instance can be rewritten by Rewrite, regardless of the status.
+// CheckRewrite can be used for checking a running instance before stopping it.
+func CheckRewrite(inst *store.Instance) error {
+	_, err := checkRewrite(inst)
+	return err
+}
+
+func checkRewrite(inst *store.Instance) (*imgutil.Info, error) {
+	if inst.VMType != limayaml.QEMU {
+		// The raw diff disks of the other drivers are sparse, and are compacted by Trim
+		return nil, fmt.Errorf("rewriting the disk is not supported for vmType %q (hint: start the instance to trim the disk)", inst.VMType)
+	}
+	diffDisk := filepath.Join(inst.Dir, filenames.DiffDisk)
+	if _, err := os.Stat(diffDisk); err != nil {
+		return nil, err
+	}
+	info, err := imgutil.GetInfo(diffDisk)
+	if err != nil {
+		return nil, err
+	}
+	if info.Format != "qcow2" {
+		return nil, fmt.Errorf("expected the format of %q to be qcow2, got %q", diffDisk, info.Format)
+	}
+	if len(info.Snapshots) > 0 {
+		return nil, errors.New("rewriting a disk with snapshots is not supported, as the snapshots would be lost (hint: delete them with `limactl snapshot delete`)")
+	}
+	return info, nil
+}
+
+// Rewrite rewrites the qcow2 diff disk of the stopped instance, omitting the zero clusters.
+// The base disk is kept as the backing file.
+func Rewrite(_ context.Context, inst *store.Instance) error {
+	if inst.Status != store.StatusStopped {
+		return fmt.Errorf("expected status %q, got %q", store.StatusStopped, inst.Status)
+	}
+	info, err := checkRewrite(inst)
+	if err != nil {
+		return err
+	}
+	diffDisk := filepath.Join(inst.Dir, filenames.DiffDisk)
+	tmp := diffDisk + ".tmp"
+	logrus.Infof("Rewriting %q", diffDisk)
+	if info.BackingFilename == "" {
+		err = imgutil.Convert(diffDisk, tmp, "qcow2")
+	} else {
+		backingFile := info.FullBackingFilename
+		if backingFile == "" {
+			backingFile = info.BackingFilename
+		}
+		err = imgutil.ConvertOverlay(diffDisk, tmp, backingFile, info.BackingFilenameFormat)
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	return os.Rename(tmp, diffDisk)
+}
+
+// Usage returns the bytes allocated on the host for the diff disk and the additional disks of the instance.
+// The additional disks are counted only while they are attached to the instance writable.
+func Usage(inst *store.Instance) (int64, error) {
+	fi, err := os.Stat(filepath.Join(inst.Dir, filenames.DiffDisk))
+	if err != nil {
+		return 0, err
+	}
+	usage := osutil.AllocatedSize(fi)
+	for _, d := range inst.AdditionalDisks {
+		disk, err := store.InspectDisk(d.Name)
+		if err != nil {
+			return 0, err
+		}
+		if disk.Instance == inst.Name {
+			usage += disk.Usage
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,89 @@
+package diskcompact
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/osutil"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"gotest.tools/v3/assert"
+)
+
+func TestTrim(t *testing.T) {
+	inst := &store.Instance{Name: "foo", Status: store.StatusStopped}
+	assert.ErrorContains(t, Trim(context.Background(), inst), "expected status")
+}
+
+func TestRewrite(t *testing.T) {
+	ctx := context.Background()
+	instDir := t.TempDir()
+	inst := &store.Instance{Name: "foo", Dir: instDir, Status: store.StatusRunning, VMType: limayaml.QEMU}
+	assert.ErrorContains(t, Rewrite(ctx, inst), "expected status")
+
+	inst.Status = store.StatusStopped
+	inst.VMType = limayaml.VZ
+	assert.ErrorContains(t, Rewrite(ctx, inst), "not supported")
+	assert.ErrorContains(t, CheckRewrite(inst), "not supported")
+
+	inst.VMType = limayaml.QEMU
+	assert.Assert(t, errors.Is(Rewrite(ctx, inst), os.ErrNotExist))
+
+	if _, err := exec.LookPath("qemu-img"); err != nil {
+		t.Skip("qemu-img is not installed")
+	}
+	baseDisk := filepath.Join(instDir, filenames.BaseDisk)
+	diffDisk := filepath.Join(instDir, filenames.DiffDisk)
+	assert.NilError(t, exec.Command("qemu-img", "create", "-f", "qcow2", baseDisk, "64M").Run())
+	assert.NilError(t, exec.Command("qemu-img", "create", "-f", "qcow2", "-F", "qcow2", "-b", baseDisk, diffDisk).Run())
+	assert.NilError(t, Rewrite(ctx, inst))
+	_, err := os.Stat(diffDisk + ".tmp")
+	assert.Assert(t, os.IsNotExist(err))
+	out, err := exec.Command("qemu-img", "info", diffDisk).CombinedOutput()
+	assert.NilError(t, err, string(out))
+	assert.Assert(t, strings.Contains(string(out), "backing file: "+baseDisk), string(out))
+
+	assert.NilError(t, exec.Command("qemu-img", "snapshot", "-c", "snap1", diffDisk).Run())
+	assert.ErrorContains(t, Rewrite(ctx, inst), "snapshots")
+	// checked regardless of the status, before stopping a running instance
+	inst.Status = store.StatusRunning
+	assert.ErrorContains(t, CheckRewrite(inst), "snapshots")
+}
+
+func TestUsage(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	instDir := t.TempDir()
+	diffDisk := filepath.Join(instDir, filenames.DiffDisk)
+	assert.NilError(t, os.WriteFile(diffDisk, nil, 0o644))
+	assert.NilError(t, os.Truncate(diffDisk, 64<<20))
+
+	for _, name := range []string{"attached", "detached"} {
+		diskDir, err := store.DiskDir(name)
+		assert.NilError(t, err)
+		assert.NilError(t, os.MkdirAll(diskDir, 0o700))
+		assert.NilError(t, os.WriteFile(filepath.Join(diskDir, filenames.DataDisk), make([]byte, 1<<20), 0o644))
+	}
+	disk, err := store.InspectDisk("attached")
+	assert.NilError(t, err)
+	inst := &store.Instance{
+		Name:            filepath.Base(instDir),
+		Dir:             instDir,
+		AdditionalDisks: []limayaml.Disk{{Name: "attached"}, {Name: "detached"}},
+	}
+	assert.NilError(t, disk.Lock(instDir))
+	disk, err = store.InspectDisk("attached")
+	assert.NilError(t, err)
+
+	usage, err := Usage(inst)
+	assert.NilError(t, err)
+	fi, err := os.Stat(diffDisk)
+	assert.NilError(t, err)
+	// the sparse diff disk and the attached disk only
+	assert.Equal(t, usage, osutil.AllocatedSize(fi)+disk.Usage)
+}
@@ -0,0 +1,174 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// CacheEntry is a remote resource cached in "<cacheDir>/download/by-url-sha256/<SHA256_OF_URL>".
+type CacheEntry struct {
+	URL      string          `json:"url"`
+	Dir      string          `json:"dir"`
+	Size     int64           `json:"size"` // bytes of the data file
+	Digests  []digest.Digest `json:"digests,omitempty"`
+	LastUsed time.Time       `json:"lastUsed"`
+	// LastModified is the Last-Modified header of the download, if the server sent one.
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+const (
+	// lastUsedFile is touched whenever the cache entry is used.
+	lastUsedFile = "last-used"
+	// lastModifiedFile contains the Last-Modified header of the download.
+	lastModifiedFile = "last-modified"
+)
+
+// DefaultCacheDir returns filepath.Join(os.UserCacheDir(), "lima"), which is used by WithCache.
+func DefaultCacheDir() (string, error) {
+	ucd, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(ucd, "lima"), nil
+}
+
+func touchLastUsed(shad string) error {
+	f := filepath.Join(shad, lastUsedFile)
+	now := time.Now()
+	if err := os.Chtimes(f, now, now); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return os.WriteFile(f, nil, 0o644)
+	}
+	return nil
+}
+
+// CacheEntries returns the complete cache entries in cacheDir, sorted by URL.
+func CacheEntries(cacheDir string) ([]CacheEntry, error) {
+	byURLDir := filepath.Join(cacheDir, "download", "by-url-sha256")
+	dirEntries, err := os.ReadDir(byURLDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var res []CacheEntry
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		shad := filepath.Join(byURLDir, dirEntry.Name())
+		urlB, err := os.ReadFile(filepath.Join(shad, "url"))
+		if err != nil {
+			continue
+		}
+		dataSt, err := os.Stat(filepath.Join(shad, "data"))
+		if err != nil {
+			// incomplete download
+			continue
+		}
+		e := CacheEntry{
+			URL:      string(urlB),
+			Dir:      shad,
+			Size:     dataSt.Size(),
+			LastUsed: dataSt.ModTime(),
+		}
+		if st, err := os.Stat(filepath.Join(shad, lastUsedFile)); err == nil {
+			e.LastUsed = st.ModTime()
+		}
+		if b, err := os.ReadFile(filepath.Join(shad, lastModifiedFile)); err == nil {
+			e.LastModified = strings.TrimSpace(string(b))
+		}
+		digestFiles, err := filepath.Glob(filepath.Join(shad, "*.digest"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range digestFiles {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			e.Digests = append(e.Digests, digest.Digest(strings.TrimSpace(string(b))))
+		}
+		res = append(res, e)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].URL < res[j].URL })
+	return res, nil
+}
+
+// UpdateStatus is the result of CheckUpdate.
+type UpdateStatus string
+
+const (
+	UpdateStatusUpToDate  UpdateStatus = "up-to-date"
+	UpdateStatusAvailable UpdateStatus = "available"
+	// UpdateStatusUnknown is returned when the server does not provide Last-Modified,
+	// and the size of the remote is the same or unknown.
+	UpdateStatusUnknown UpdateStatus = "unknown"
+)
+
+// CheckUpdate checks whether the remote resource of the cache entry has been updated since it was downloaded.
+//
+// When expectedDigest is specified (e.g., by a template) and the entry has a digest of the same algorithm,
+// the digests are compared without accessing the network.
+// Otherwise the remote is checked with a HEAD request: its Last-Modified header is compared with the one
+// saved on the download (or with the download time, for the entries cached by older versions),
+// and its Content-Length is compared with the size of the entry.
+func CheckUpdate(ctx context.Context, e CacheEntry, expectedDigest digest.Digest) (UpdateStatus, error) {
+	if expectedDigest != "" {
+		for _, d := range e.Digests {
+			if d.Algorithm() == expectedDigest.Algorithm() {
+				if d == expectedDigest {
+					return UpdateStatusUpToDate, nil
+				}
+				return UpdateStatusAvailable, nil
+			}
+		}
+	}
+	if IsLocal(e.URL) {
+		return UpdateStatusUnknown, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, e.URL, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("expected HTTP status %d, got %s", http.StatusOK, resp.Status)
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength != e.Size {
+		return UpdateStatusAvailable, nil
+	}
+	remoteLastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		// the size may be the same, but there is no way to tell whether the content is the same
+		return UpdateStatusUnknown, nil
+	}
+	cachedLastModified, err := http.ParseTime(e.LastModified)
+	if err != nil {
+		st, err := os.Stat(filepath.Join(e.Dir, "data"))
+		if err != nil {
+			return "", err
+		}
+		cachedLastModified = st.ModTime()
+	}
+	if remoteLastModified.After(cachedLastModified) {
+		return UpdateStatusAvailable, nil
+	}
+	return UpdateStatusUpToDate, nil
+}
@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"gotest.tools/v3/assert"
+)
+
+func TestCacheEntries(t *testing.T) {
+	cacheDir := t.TempDir()
+	entries, err := CacheEntries(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 0)
+
+	byURLDir := filepath.Join(cacheDir, "download", "by-url-sha256")
+	writeEntry := func(name, url, data string) string {
+		shad := filepath.Join(byURLDir, name)
+		assert.NilError(t, os.MkdirAll(shad, 0o700))
+		assert.NilError(t, os.WriteFile(filepath.Join(shad, "url"), []byte(url), 0o644))
+		if data != "" {
+			assert.NilError(t, os.WriteFile(filepath.Join(shad, "data"), []byte(data), 0o644))
+		}
+		return shad
+	}
+	shadB := writeEntry("b", "https://example.com/b.img", "bb")
+	d := digest.FromString("bb")
+	assert.NilError(t, os.WriteFile(filepath.Join(shadB, "sha256.digest"), []byte(d), 0o644))
+	shadA := writeEntry("a", "https://example.com/a.img", "a")
+	writeEntry("c", "https://example.com/incomplete.img", "")
+
+	old := time.Now().Add(-time.Hour)
+	assert.NilError(t, os.Chtimes(filepath.Join(shadA, "data"), old, old))
+	entries, err = CacheEntries(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 2)
+	assert.Equal(t, entries[0].URL, "https://example.com/a.img")
+	assert.Equal(t, entries[0].Size, int64(1))
+	assert.Assert(t, entries[0].LastUsed.Equal(old))
+	assert.Equal(t, entries[1].URL, "https://example.com/b.img")
+	assert.DeepEqual(t, entries[1].Digests, []digest.Digest{d})
+
+	assert.NilError(t, touchLastUsed(shadA))
+	assert.NilError(t, touchLastUsed(shadA))
+	entries, err = CacheEntries(cacheDir)
+	assert.NilError(t, err)
+	assert.Assert(t, entries[0].LastUsed.After(old))
+}
+
+func TestCheckUpdate(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("image")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	lastModified := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !lastModified {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			_, _ = w.Write(content)
+			return
+		}
+		http.ServeContent(w, r, "image.img", modTime, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	cacheDir := t.TempDir()
+	_, err := Download(filepath.Join(t.TempDir(), "image.img"), ts.URL+"/image.img",
+		WithCacheDir(cacheDir), WithExpectedDigest(digest.FromBytes(content)))
+	assert.NilError(t, err)
+	entries, err := CacheEntries(cacheDir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	e := entries[0]
+	assert.Equal(t, e.LastModified, modTime.UTC().Format(http.TimeFormat))
+
+	check := func(expectedDigest digest.Digest) UpdateStatus {
+		status, err := CheckUpdate(ctx, e, expectedDigest)
+		assert.NilError(t, err)
+		return status
+	}
+	assert.Equal(t, check(""), UpdateStatusUpToDate)
+	assert.Equal(t, check(digest.FromBytes(content)), UpdateStatusUpToDate)
+
+	// the template pins another digest, no need to access the network
+	offline := e
+	offline.URL = "http://127.0.0.1:0/image.img"
+	status, err := CheckUpdate(ctx, offline, digest.FromString("new image"))
+	assert.NilError(t, err)
+	assert.Equal(t, status, UpdateStatusAvailable)
+
+	modTime = modTime.Add(time.Minute)
+	assert.Equal(t, check(""), UpdateStatusAvailable)
+
+	// the entries cached by older versions are compared with the download time
+	e.LastModified = ""
+	assert.Equal(t, check(""), UpdateStatusUpToDate)
+
+	content = []byte("new image")
+	assert.Equal(t, check(""), UpdateStatusAvailable)
+
+	lastModified = false
+	content = []byte("IMAGE")
+	assert.Equal(t, check(""), UpdateStatusUnknown)
+}
@@ -57,11 +57,10 @@ type Opt func(*options) error
 // WithCache enables caching using filepath.Join(os.UserCacheDir(), "lima") as the cache dir.
 func WithCache() Opt {
 	return func(o *options) error {
-		ucd, err := os.UserCacheDir()
+		cacheDir, err := DefaultCacheDir()
 		if err != nil {
 			return err
 		}
-		cacheDir := filepath.Join(ucd, "lima")
 		return WithCacheDir(cacheDir)(o)
 	}
 }
@@ -83,7 +82,7 @@ func WithDescription(description string) Opt {
 	}
 }
 
-// WithDecompress decompress the download from the cache.
+// WithDecompress decompresses the download, according to the file name extension of the remote.
 func WithDecompress(decompress bool) Opt {
 	return func(o *options) error {
 		o.decompress = decompress
@@ -173,7 +172,17 @@ func Download(local, remote string, opts ...Opt) (*Result, error) {
 	}
 
 	if o.cacheDir == "" {
-		if err := downloadHTTP(localPath, remote, o.description, o.expectedDigest); err != nil {
+		if _, ok := Decompressor(ext); ok && o.decompress {
+			// Download into a temporary file, and decompress it into the local path
+			localPathCompressed := localPath + ".compressed"
+			defer os.RemoveAll(localPathCompressed)
+			if err := downloadHTTP(localPathCompressed, "", remote, o.description, o.expectedDigest); err != nil {
+				return nil, err
+			}
+			if err := copyLocal(localPath, localPathCompressed, ext, o.decompress, o.description, ""); err != nil {
+				return nil, err
+			}
+		} else if err := downloadHTTP(localPath, "", remote, o.description, o.expectedDigest); err != nil {
 			return nil, err
 		}
 		res := &Result{
@@ -210,6 +219,9 @@ func Download(local, remote string, opts ...Opt) (*Result, error) {
 				return nil, err
 			}
 		}
+		if err := touchLastUsed(shad); err != nil {
+			logrus.WithError(err).Debugf("failed to update the last used time of %q", shad)
+		}
 		res := &Result{
 			Status:          StatusUsedCache,
 			CachePath:       shadData,
@@ -227,7 +239,7 @@ func Download(local, remote string, opts ...Opt) (*Result, error) {
 	if err := os.WriteFile(shadURL, []byte(remote), 0644); err != nil {
 		return nil, err
 	}
-	if err := downloadHTTP(shadData, remote, o.description, o.expectedDigest); err != nil {
+	if err := downloadHTTP(shadData, filepath.Join(shad, lastModifiedFile), remote, o.description, o.expectedDigest); err != nil {
 		return nil, err
 	}
 	// no need to pass the digest to copyLocal(), as we already verified the digest
@@ -239,6 +251,9 @@ func Download(local, remote string, opts ...Opt) (*Result, error) {
 			return nil, err
 		}
 	}
+	if err := touchLastUsed(shad); err != nil {
+		logrus.WithError(err).Debugf("failed to update the last used time of %q", shad)
+	}
 	res := &Result{
 		Status:          StatusDownloaded,
 		CachePath:       shadData,
@@ -393,7 +408,9 @@ func validateLocalFileDigest(localPath string, expectedDigest digest.Digest) err
 	return nil
 }
 
-func downloadHTTP(localPath, url string, description string, expectedDigest digest.Digest) error {
+// downloadHTTP downloads url into localPath.
+// The Last-Modified header of the response is saved in lastModified, unless lastModified is empty.
+func downloadHTTP(localPath, lastModified, url string, description string, expectedDigest digest.Digest) error {
 	if localPath == "" {
 		return fmt.Errorf("downloadHTTP: got empty localPath")
 	}
@@ -462,6 +479,13 @@ func downloadHTTP(localPath, url string, description string, expectedDigest dige
 	if err := fileWriter.Close(); err != nil {
 		return err
 	}
+	if lastModified != "" {
+		if lm := resp.Header.Get("Last-Modified"); lm != "" {
+			if err := os.WriteFile(lastModified, []byte(lm), 0644); err != nil {
+				return err
+			}
+		}
+	}
 	if err := os.RemoveAll(localPath); err != nil {
 		return err
 	}
@@ -1,6 +1,8 @@
 package downloader
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -155,4 +157,24 @@ func TestDownloadCompressed(t *testing.T) {
 		assert.NilError(t, err)
 		assert.Equal(t, string(got), string(testDownloadCompressedContents))
 	})
+
+	t.Run("gzip over HTTP without cache", func(t *testing.T) {
+		localPath := filepath.Join(t.TempDir(), t.Name())
+		localFile := filepath.Join(t.TempDir(), "test-file")
+		testDownloadCompressedContents := []byte("TestDownloadCompressed")
+		assert.NilError(t, os.WriteFile(localFile, testDownloadCompressedContents, 0644))
+		assert.NilError(t, exec.Command("gzip", localFile).Run())
+		ts := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(localFile))))
+		defer ts.Close()
+
+		r, err := Download(localPath, ts.URL+"/test-file.gz", WithDecompress(true))
+		assert.NilError(t, err)
+		assert.Equal(t, StatusDownloaded, r.Status)
+
+		got, err := os.ReadFile(localPath)
+		assert.NilError(t, err)
+		assert.Equal(t, string(got), string(testDownloadCompressedContents))
+		_, err = os.Stat(localPath + ".compressed")
+		assert.Assert(t, os.IsNotExist(err))
+	})
 }
@@ -3,6 +3,7 @@ package driver
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/lima-vm/lima/pkg/store"
@@ -35,6 +36,23 @@ type Driver interface {
 	// It returns error if there are any errors during Stop
 	Stop(_ context.Context) error
 
+	// Suspend saves the state of the running vm to filenames.VMState, and terminates the vm.
+	// The next Start resumes the vm from the saved state.
+	Suspend(_ context.Context) error
+
+	// Reconfigure applies the changes of the config (e.g., `cpus` and `memory`) to the running vm, where the driver supports it.
+	// It returns the YAML names of the applied fields. The other changes take effect on the next Start.
+	Reconfigure(_ context.Context, y *limayaml.LimaYAML) ([]string, error)
+
+	// MemoryBalloon returns the current memory size of the guest, as reduced by the memory balloon.
+	MemoryBalloon(_ context.Context) (int64, error)
+
+	// SetMemoryBalloon resizes the memory balloon, so that the memory size of the guest becomes target bytes.
+	SetMemoryBalloon(_ context.Context, target int64) error
+
+	// ResizeAdditionalDisk notifies the running vm that the additional disk name has been resized to size bytes.
+	ResizeAdditionalDisk(_ context.Context, name string, size int64) error
+
 	// Register will add an instance to a registry.
 	// It returns error if there are any errors during Register
 	Register(_ context.Context) error
@@ -53,7 +71,17 @@ type Driver interface {
 
 	DeleteSnapshot(_ context.Context, tag string) error
 
-	ListSnapshots(_ context.Context) (string, error)
+	// ListSnapshots returns the snapshots of the instance, sorted by the creation time.
+	ListSnapshots(_ context.Context) ([]Snapshot, error)
+}
+
+// Snapshot is a snapshot of the instance, as returned by Driver.ListSnapshots.
+type Snapshot struct {
+	Tag         string        `json:"tag"`
+	CreatedAt   time.Time     `json:"createdAt"`
+	VMClock     time.Duration `json:"vmClock"`               // guest run time at the creation of the snapshot
+	VMStateSize int64         `json:"vmStateSize,omitempty"` // bytes
+	VMState     bool          `json:"vmState"`               // whether the snapshot contains the VM state (RAM, devices), not only the disk
 }
 
 type BaseDriver struct {
@@ -89,6 +117,26 @@ func (d *BaseDriver) Stop(_ context.Context) error {
 	return nil
 }
 
+func (d *BaseDriver) Suspend(_ context.Context) error {
+	return fmt.Errorf("unimplemented")
+}
+
+func (d *BaseDriver) Reconfigure(_ context.Context, _ *limayaml.LimaYAML) ([]string, error) {
+	return nil, nil
+}
+
+func (d *BaseDriver) MemoryBalloon(_ context.Context) (int64, error) {
+	return 0, fmt.Errorf("unimplemented")
+}
+
+func (d *BaseDriver) SetMemoryBalloon(_ context.Context, _ int64) error {
+	return fmt.Errorf("unimplemented")
+}
+
+func (d *BaseDriver) ResizeAdditionalDisk(_ context.Context, _ string, _ int64) error {
+	return fmt.Errorf("unimplemented")
+}
+
 func (d *BaseDriver) Register(_ context.Context) error {
 	return nil
 }
@@ -117,6 +165,6 @@ func (d *BaseDriver) DeleteSnapshot(_ context.Context, _ string) error {
 	return fmt.Errorf("unimplemented")
 }
 
-func (d *BaseDriver) ListSnapshots(_ context.Context) (string, error) {
-	return "", fmt.Errorf("unimplemented")
+func (d *BaseDriver) ListSnapshots(_ context.Context) ([]Snapshot, error) {
+	return nil, fmt.Errorf("unimplemented")
 }
@@ -0,0 +1,244 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/sirupsen/logrus"
+)
+
+// Driver implements driver.Driver by calling an external driver binary.
+type Driver struct {
+	*driver.BaseDriver
+
+	// dial connects to the external driver.
+	// Replaced in tests.
+	dial func(ctx context.Context) (net.Conn, error)
+
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+var _ driver.Driver = (*Driver)(nil)
+
+func New(driver *driver.BaseDriver) *Driver {
+	d := &Driver{
+		BaseDriver: driver,
+	}
+	d.dial = d.launch
+	return d
+}
+
+// launch launches the external driver binary, and connects to its socket.
+func (d *Driver) launch(ctx context.Context) (net.Conn, error) {
+	bin, err := exec.LookPath(BinaryName(*d.Yaml.VMType))
+	if err != nil {
+		return nil, fmt.Errorf("external driver %q is not installed: %w", Name(*d.Yaml.VMType), err)
+	}
+	// The socket is not created in the instance directory, as the path may exceed the limit of UNIX sockets
+	tmpDir, err := os.MkdirTemp("", "lima-driver")
+	if err != nil {
+		return nil, err
+	}
+	socket := filepath.Join(tmpDir, "driver.sock")
+	// Not using exec.CommandContext, as the driver (and the VM) must keep running until the connection is closed
+	cmd := exec.Command(bin, "--socket", socket)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	logrus.Debugf("Launching external driver: %v", cmd.Args)
+	if err := cmd.Start(); err != nil {
+		_ = os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("failed to launch %q: %w", bin, err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	const (
+		retries       = 100
+		sleepDuration = 100 * time.Millisecond
+	)
+	var dialer net.Dialer
+	for i := 0; i < retries; i++ {
+		conn, err := dialer.DialContext(ctx, "unix", socket)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case <-ctx.Done():
+			_ = cmd.Process.Kill()
+			return nil, ctx.Err()
+		case err := <-exited:
+			return nil, fmt.Errorf("external driver %q exited before accepting connections: %v", bin, err)
+		case <-time.After(sleepDuration):
+		}
+	}
+	_ = cmd.Process.Kill()
+	return nil, fmt.Errorf("external driver %q did not create the socket %q", bin, socket)
+}
+
+// rpcClient returns the RPC client, launching the external driver on the first call.
+func (d *Driver) rpcClient(ctx context.Context) (*rpc.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client != nil {
+		return d.client, nil
+	}
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := jsonrpc.NewClient(conn)
+	args := &InitArgs{
+		InstanceName: d.Instance.Name,
+		SSHLocalPort: d.SSHLocalPort,
+	}
+	if err := client.Call(serviceName+".Init", args, &Empty{}); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to initialize external driver %q: %w", Name(*d.Yaml.VMType), err)
+	}
+	d.client = client
+	return client, nil
+}
+
+func (d *Driver) call(ctx context.Context, method string, args, reply any) error {
+	client, err := d.rpcClient(ctx)
+	if err != nil {
+		return err
+	}
+	call := client.Go(serviceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.Done:
+		return call.Error
+	}
+}
+
+// Close closes the connection to the external driver.
+// The external driver stops the VM and exits when the connection is closed.
+func (d *Driver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client == nil {
+		return nil
+	}
+	err := d.client.Close()
+	d.client = nil
+	return err
+}
+
+func (d *Driver) Validate() error {
+	return d.call(context.Background(), "Validate", &Empty{}, &Empty{})
+}
+
+func (d *Driver) CreateDisk() error {
+	return d.call(context.Background(), "CreateDisk", &Empty{}, &Empty{})
+}
+
+// Start starts the VM. The returned channel receives the result of "Driver.Wait".
+func (d *Driver) Start(ctx context.Context) (chan error, error) {
+	if err := d.call(ctx, "Start", &Empty{}, &Empty{}); err != nil {
+		return nil, err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		// Not using ctx, as Wait has to block until the VM stops
+		err := d.call(context.Background(), "Wait", &Empty{}, &Empty{})
+		if errors.Is(err, rpc.ErrShutdown) {
+			err = fmt.Errorf("lost connection to external driver %q: %w", Name(*d.Yaml.VMType), err)
+		}
+		errCh <- err
+	}()
+	return errCh, nil
+}
+
+func (d *Driver) CanRunGUI() bool {
+	var res BoolResult
+	if err := d.call(context.Background(), "CanRunGUI", &Empty{}, &res); err != nil {
+		logrus.WithError(err).Warn("failed to call CanRunGUI")
+		return false
+	}
+	return res.Value
+}
+
+func (d *Driver) Stop(ctx context.Context) error {
+	return d.call(ctx, "Stop", &Empty{}, &Empty{})
+}
+
+func (d *Driver) Suspend(ctx context.Context) error {
+	return d.call(ctx, "Suspend", &Empty{}, &Empty{})
+}
+
+func (d *Driver) Reconfigure(ctx context.Context, y *limayaml.LimaYAML) ([]string, error) {
+	var res StringsResult
+	if err := d.call(ctx, "Reconfigure", &ReconfigureArgs{Yaml: y}, &res); err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
+func (d *Driver) MemoryBalloon(ctx context.Context) (int64, error) {
+	var res Int64Result
+	if err := d.call(ctx, "MemoryBalloon", &Empty{}, &res); err != nil {
+		return 0, err
+	}
+	return res.Value, nil
+}
+
+func (d *Driver) SetMemoryBalloon(ctx context.Context, target int64) error {
+	return d.call(ctx, "SetMemoryBalloon", &Int64Args{Value: target}, &Empty{})
+}
+
+func (d *Driver) ResizeAdditionalDisk(ctx context.Context, name string, size int64) error {
+	return d.call(ctx, "ResizeAdditionalDisk", &ResizeDiskArgs{Name: name, Size: size}, &Empty{})
+}
+
+func (d *Driver) Register(ctx context.Context) error {
+	return d.call(ctx, "Register", &Empty{}, &Empty{})
+}
+
+func (d *Driver) Unregister(ctx context.Context) error {
+	return d.call(ctx, "Unregister", &Empty{}, &Empty{})
+}
+
+func (d *Driver) ChangeDisplayPassword(ctx context.Context, password string) error {
+	return d.call(ctx, "ChangeDisplayPassword", &StringArgs{Value: password}, &Empty{})
+}
+
+func (d *Driver) GetDisplayConnection(ctx context.Context) (string, error) {
+	var res StringResult
+	err := d.call(ctx, "GetDisplayConnection", &Empty{}, &res)
+	return res.Value, err
+}
+
+func (d *Driver) CreateSnapshot(ctx context.Context, tag string) error {
+	return d.call(ctx, "CreateSnapshot", &StringArgs{Value: tag}, &Empty{})
+}
+
+func (d *Driver) ApplySnapshot(ctx context.Context, tag string) error {
+	return d.call(ctx, "ApplySnapshot", &StringArgs{Value: tag}, &Empty{})
+}
+
+func (d *Driver) DeleteSnapshot(ctx context.Context, tag string) error {
+	return d.call(ctx, "DeleteSnapshot", &StringArgs{Value: tag}, &Empty{})
+}
+
+func (d *Driver) ListSnapshots(ctx context.Context) ([]driver.Snapshot, error) {
+	var res SnapshotsResult
+	err := d.call(ctx, "ListSnapshots", &Empty{}, &res)
+	return res.Snapshots, err
+}
@@ -0,0 +1,121 @@
+// Package external implements drivers that run as separate processes.
+//
+// An external driver is a binary named "limactl-driver-<NAME>" in $PATH, selected with `vmType: ext:<NAME>`.
+// The binary is launched with `--socket PATH`, and has to serve the methods of driver.Driver
+// as JSON-RPC 1.0 (net/rpc/jsonrpc) on the UNIX socket, using the service name "Driver".
+// Drivers written in Go can just call Main.
+package external
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/limayaml"
+)
+
+// BinaryPrefix is the prefix of the binaries of external drivers.
+const BinaryPrefix = "limactl-driver-"
+
+// serviceName is the name of the JSON-RPC service.
+const serviceName = "Driver"
+
+// Name returns the name of the external driver for vmType, e.g. "firecracker" for "ext:firecracker".
+// Name returns an empty string if vmType is not an external driver.
+func Name(vmType limayaml.VMType) string {
+	name, ok := strings.CutPrefix(vmType, limayaml.ExtVMTypePrefix)
+	if !ok {
+		return ""
+	}
+	return name
+}
+
+// BinaryName returns the name of the binary of the external driver for vmType.
+func BinaryName(vmType limayaml.VMType) string {
+	return BinaryPrefix + Name(vmType)
+}
+
+// Discover returns the vmTypes of the external drivers found in $PATH.
+func Discover() []limayaml.VMType {
+	seen := make(map[string]struct{})
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, err := filepath.Glob(filepath.Join(dir, BinaryPrefix+"*"))
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if _, err := exec.LookPath(m); err != nil {
+				continue
+			}
+			name := strings.TrimPrefix(filepath.Base(m), BinaryPrefix)
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+			if name != "" {
+				seen[name] = struct{}{}
+			}
+		}
+	}
+	var res []limayaml.VMType
+	for name := range seen {
+		res = append(res, limayaml.ExtVMTypePrefix+name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Empty is used for the JSON-RPC methods that have no arguments or no results.
+type Empty struct{}
+
+// InitArgs is the argument of the "Driver.Init" method, which is called before any other method.
+type InitArgs struct {
+	InstanceName string
+	SSHLocalPort int
+}
+
+// StringArgs is used for the JSON-RPC methods that take a string, such as a password or a snapshot tag.
+type StringArgs struct {
+	Value string
+}
+
+// StringResult is used for the JSON-RPC methods that return a string.
+type StringResult struct {
+	Value string
+}
+
+// BoolResult is used for the JSON-RPC methods that return a bool.
+type BoolResult struct {
+	Value bool
+}
+
+// StringsResult is used for the JSON-RPC methods that return a string slice.
+type StringsResult struct {
+	Value []string
+}
+
+// SnapshotsResult is the result of the "Driver.ListSnapshots" method.
+type SnapshotsResult struct {
+	Snapshots []driver.Snapshot
+}
+
+// ReconfigureArgs is the argument of the "Driver.Reconfigure" method.
+type ReconfigureArgs struct {
+	Yaml *limayaml.LimaYAML
+}
+
+// Int64Args is used for the JSON-RPC methods that take an int64, such as a memory size.
+type Int64Args struct {
+	Value int64
+}
+
+// Int64Result is used for the JSON-RPC methods that return an int64.
+type Int64Result struct {
+	Value int64
+}
+
+// ResizeDiskArgs is the argument of the "Driver.ResizeAdditionalDisk" method.
+type ResizeDiskArgs struct {
+	Name string
+	Size int64
+}
@@ -0,0 +1,126 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"gotest.tools/v3/assert"
+)
+
+// fakeDriver is an in-process driver that does not run any VM.
+type fakeDriver struct {
+	*driver.BaseDriver
+	stopCh    chan error
+	snapshots []string
+}
+
+func (d *fakeDriver) Start(_ context.Context) (chan error, error) {
+	d.stopCh = make(chan error, 1)
+	return d.stopCh, nil
+}
+
+func (d *fakeDriver) Stop(_ context.Context) error {
+	d.stopCh <- errors.New("stopped")
+	return nil
+}
+
+func (d *fakeDriver) GetDisplayConnection(_ context.Context) (string, error) {
+	return "fake:" + d.Instance.Name, nil
+}
+
+func (d *fakeDriver) CreateSnapshot(_ context.Context, tag string) error {
+	d.snapshots = append(d.snapshots, tag)
+	return nil
+}
+
+func (d *fakeDriver) ListSnapshots(_ context.Context) ([]driver.Snapshot, error) {
+	if len(d.snapshots) == 0 {
+		return nil, errors.New("no snapshots")
+	}
+	var res []driver.Snapshot
+	for _, tag := range d.snapshots {
+		res = append(res, driver.Snapshot{Tag: tag, CreatedAt: time.Unix(1700000000, 0).UTC(), VMClock: time.Minute})
+	}
+	return res, nil
+}
+
+func createInstance(t *testing.T, name string) *store.Instance {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	instDir, err := store.InstanceDir(name)
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(instDir, 0o700))
+	yaml := `vmType: "ext:fake"
+images:
+- location: "/dev/null"
+`
+	assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.LimaYAML), []byte(yaml), 0o644))
+	inst, err := store.Inspect(name)
+	assert.NilError(t, err)
+	assert.Equal(t, len(inst.Errors), 0)
+	return inst
+}
+
+func TestDriver(t *testing.T) {
+	ctx := context.Background()
+	inst := createInstance(t, "foo")
+	y, err := inst.LoadYAML()
+	assert.NilError(t, err)
+
+	d := New(&driver.BaseDriver{Instance: inst, Yaml: y, SSHLocalPort: 60022})
+	served := make(chan error, 1)
+	d.dial = func(_ context.Context) (net.Conn, error) {
+		c, s := net.Pipe()
+		go func() {
+			served <- ServeConn(ctx, s, func(base *driver.BaseDriver) driver.Driver {
+				assert.Equal(t, base.SSHLocalPort, 60022)
+				return &fakeDriver{BaseDriver: base}
+			})
+		}()
+		return c, nil
+	}
+
+	assert.NilError(t, d.Validate())
+	assert.NilError(t, d.CreateDisk())
+	assert.Equal(t, d.CanRunGUI(), false)
+
+	errCh, err := d.Start(ctx)
+	assert.NilError(t, err)
+	pid, err := store.ReadPIDFile(filepath.Join(inst.Dir, filenames.PIDFile(*y.VMType)))
+	assert.NilError(t, err)
+	assert.Equal(t, pid, os.Getpid())
+
+	conn, err := d.GetDisplayConnection(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, conn, "fake:foo")
+
+	_, err = d.ListSnapshots(ctx)
+	assert.ErrorContains(t, err, "no snapshots")
+	assert.NilError(t, d.CreateSnapshot(ctx, "snap1"))
+	snapshots, err := d.ListSnapshots(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, snapshots, []driver.Snapshot{{Tag: "snap1", CreatedAt: time.Unix(1700000000, 0).UTC(), VMClock: time.Minute}})
+	assert.ErrorContains(t, d.ApplySnapshot(ctx, "snap1"), "unimplemented")
+
+	assert.NilError(t, d.Stop(ctx))
+	assert.ErrorContains(t, <-errCh, "stopped")
+	_, err = os.Stat(filepath.Join(inst.Dir, filenames.PIDFile(*y.VMType)))
+	assert.Assert(t, errors.Is(err, os.ErrNotExist))
+
+	assert.NilError(t, d.Close())
+	assert.NilError(t, <-served)
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, Name("ext:firecracker"), "firecracker")
+	assert.Equal(t, Name("qemu"), "")
+	assert.Equal(t, BinaryName("ext:firecracker"), "limactl-driver-firecracker")
+	assert.Equal(t, filenames.PIDFile("ext:firecracker"), "ext-firecracker.pid")
+}
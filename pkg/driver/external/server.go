@@ -0,0 +1,295 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+)
+
+// NewDriverFunc creates a driver for an instance.
+type NewDriverFunc func(*driver.BaseDriver) driver.Driver
+
+// Main is the main function of an external driver binary.
+// Main parses the `--socket PATH` flag, serves the driver on the socket, and exits.
+func Main(newDriver NewDriverFunc) {
+	socket := flag.String("socket", "", "UNIX socket to serve the driver on")
+	flag.Parse()
+	if *socket == "" {
+		logrus.Fatal("--socket must be specified")
+	}
+	if err := Listen(context.Background(), *socket, newDriver); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// Listen accepts a single connection on the UNIX socket, and serves the driver until the connection is closed.
+func Listen(ctx context.Context, socket string, newDriver NewDriverFunc) error {
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	conn, err := l.Accept()
+	_ = l.Close()
+	_ = os.RemoveAll(socket)
+	if err != nil {
+		return err
+	}
+	return ServeConn(ctx, conn, newDriver)
+}
+
+// ServeConn serves the driver on conn until conn is closed.
+// The driver (and hence the VM) is stopped when ctx is cancelled or conn is closed.
+func ServeConn(ctx context.Context, conn io.ReadWriteCloser, newDriver NewDriverFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	srv := rpc.NewServer()
+	s := &server{
+		ctx:       ctx,
+		newDriver: newDriver,
+	}
+	if err := srv.RegisterName(serviceName, s); err != nil {
+		return err
+	}
+	srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+	s.removePIDFile()
+	return nil
+}
+
+// server is exported via net/rpc.
+// The methods must satisfy the net/rpc conventions, so the unused arguments cannot be omitted.
+type server struct {
+	ctx       context.Context
+	newDriver NewDriverFunc
+
+	mu      sync.Mutex
+	d       driver.Driver
+	pidFile string
+	errCh   chan error
+}
+
+func (s *server) driver() (driver.Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.d == nil {
+		return nil, errors.New("driver is not initialized (Driver.Init must be called first)")
+	}
+	return s.d, nil
+}
+
+func (s *server) Init(args *InitArgs, _ *Empty) error {
+	inst, err := store.Inspect(args.InstanceName)
+	if err != nil {
+		return err
+	}
+	y, err := inst.LoadYAML()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.d = s.newDriver(&driver.BaseDriver{
+		Instance:     inst,
+		Yaml:         y,
+		SSHLocalPort: args.SSHLocalPort,
+	})
+	s.pidFile = filepath.Join(inst.Dir, filenames.PIDFile(*y.VMType))
+	return nil
+}
+
+func (s *server) Validate(_ *Empty, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.Validate()
+}
+
+func (s *server) CreateDisk(_ *Empty, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.CreateDisk()
+}
+
+// Start starts the VM, and writes the PID of the driver process to the PID file of the instance.
+func (s *server) Start(_ *Empty, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	errCh, err := d.Start(s.ctx)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.errCh = errCh
+	s.mu.Unlock()
+	return nil
+}
+
+// Wait blocks until the VM stops.
+func (s *server) Wait(_ *Empty, _ *Empty) error {
+	s.mu.Lock()
+	errCh := s.errCh
+	s.mu.Unlock()
+	if errCh == nil {
+		return errors.New("driver is not started")
+	}
+	err := <-errCh
+	s.removePIDFile()
+	return err
+}
+
+func (s *server) removePIDFile() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.errCh == nil {
+		return
+	}
+	if err := os.RemoveAll(s.pidFile); err != nil {
+		logrus.WithError(err).Warnf("failed to remove %q", s.pidFile)
+	}
+}
+
+func (s *server) CanRunGUI(_ *Empty, res *BoolResult) error {
+	// The GUI cannot be run synchronously by the host agent, as the driver runs in a different process.
+	res.Value = false
+	return nil
+}
+
+func (s *server) Stop(_ *Empty, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.Stop(s.ctx)
+}
+
+func (s *server) Suspend(_ *Empty, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.Suspend(s.ctx)
+}
+
+func (s *server) Reconfigure(args *ReconfigureArgs, res *StringsResult) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	res.Value, err = d.Reconfigure(s.ctx, args.Yaml)
+	return err
+}
+
+func (s *server) MemoryBalloon(_ *Empty, res *Int64Result) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	res.Value, err = d.MemoryBalloon(s.ctx)
+	return err
+}
+
+func (s *server) SetMemoryBalloon(args *Int64Args, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.SetMemoryBalloon(s.ctx, args.Value)
+}
+
+func (s *server) ResizeAdditionalDisk(args *ResizeDiskArgs, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.ResizeAdditionalDisk(s.ctx, args.Name, args.Size)
+}
+
+func (s *server) Register(_ *Empty, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.Register(s.ctx)
+}
+
+func (s *server) Unregister(_ *Empty, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.Unregister(s.ctx)
+}
+
+func (s *server) ChangeDisplayPassword(args *StringArgs, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.ChangeDisplayPassword(s.ctx, args.Value)
+}
+
+func (s *server) GetDisplayConnection(_ *Empty, res *StringResult) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	res.Value, err = d.GetDisplayConnection(s.ctx)
+	return err
+}
+
+func (s *server) CreateSnapshot(args *StringArgs, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.CreateSnapshot(s.ctx, args.Value)
+}
+
+func (s *server) ApplySnapshot(args *StringArgs, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.ApplySnapshot(s.ctx, args.Value)
+}
+
+func (s *server) DeleteSnapshot(args *StringArgs, _ *Empty) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	return d.DeleteSnapshot(s.ctx, args.Value)
+}
+
+func (s *server) ListSnapshots(_ *Empty, res *SnapshotsResult) error {
+	d, err := s.driver()
+	if err != nil {
+		return err
+	}
+	res.Snapshots, err = d.ListSnapshots(s.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Package fake implements the "fake" vmType, which runs the guest without a hypervisor, for testing.
+//
+// The guest is a root filesystem extracted from the image tarball (same as WSL2).
+// The driver runs the cidata boot.sh, sshd, and lima-guestagent in a chroot, in new mount and PID namespaces.
+// The guest shares the network namespace with the host, so sshd listens on the SSH local port directly.
+//
+// The driver requires Linux, root, unshare(1) and chroot(8). The rootfs has to contain sshd.
+package fake
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/fileutils"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/lima-vm/lima/pkg/textutil"
+)
+
+//go:embed fake-init.TEMPLATE
+var initTemplate string
+
+// InitScript returns the script that is executed in the new namespaces.
+func InitScript(instDir string, sshLocalPort int) ([]byte, error) {
+	m := map[string]any{
+		"RootFS":       filepath.Join(instDir, filenames.FakeRootFS),
+		"CIDataDir":    filepath.Join(instDir, filenames.CIDataISODir),
+		"SSHLocalPort": sshLocalPort,
+	}
+	return textutil.ExecuteTemplate(initTemplate, m)
+}
+
+// EnsureRootFS downloads the image tarball, and extracts it to the rootfs directory.
+func EnsureRootFS(driver *driver.BaseDriver) error {
+	rootfs := filepath.Join(driver.Instance.Dir, filenames.FakeRootFS)
+	if _, err := os.Stat(rootfs); err == nil || !errors.Is(err, os.ErrNotExist) {
+		// rootfs is already ensured
+		return err
+	}
+	baseDisk := filepath.Join(driver.Instance.Dir, filenames.BaseDisk)
+	if _, err := os.Stat(baseDisk); errors.Is(err, os.ErrNotExist) {
+		var ensuredBaseDisk bool
+		errs := make([]error, len(driver.Yaml.Images))
+		for i, f := range driver.Yaml.Images {
+			if _, err := fileutils.DownloadFile(baseDisk, f.File, true, "the image", *driver.Yaml.Arch); err != nil {
+				errs[i] = err
+				continue
+			}
+			ensuredBaseDisk = true
+			break
+		}
+		if !ensuredBaseDisk {
+			return fileutils.Errors(errs)
+		}
+	}
+	tmp := rootfs + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	cmd := exec.Command("tar", "-xf", baseDisk, "-C", tmp)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		_ = os.RemoveAll(tmp)
+		return fmt.Errorf("failed to extract %q (the image for the fake driver must be a rootfs tarball): %v: %q: %w", baseDisk, cmd.Args, string(out), err)
+	}
+	return os.Rename(tmp, rootfs)
+}
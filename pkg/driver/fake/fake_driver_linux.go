@@ -0,0 +1,110 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+)
+
+const Enabled = true
+
+type LimaFakeDriver struct {
+	*driver.BaseDriver
+	cmd    *exec.Cmd
+	waitCh chan error
+}
+
+func New(driver *driver.BaseDriver) *LimaFakeDriver {
+	return &LimaFakeDriver{
+		BaseDriver: driver,
+	}
+}
+
+func (l *LimaFakeDriver) Validate() error {
+	if os.Geteuid() != 0 {
+		return errors.New("the fake driver requires root")
+	}
+	if *l.Yaml.MountType != limayaml.REVSSHFS {
+		return fmt.Errorf("field `mountType` must be %q for the fake driver, got %q", limayaml.REVSSHFS, *l.Yaml.MountType)
+	}
+	for _, bin := range []string{"unshare", "chroot", "tar"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return fmt.Errorf("the fake driver requires %q: %w", bin, err)
+		}
+	}
+	return nil
+}
+
+func (l *LimaFakeDriver) CreateDisk() error {
+	return EnsureRootFS(l.BaseDriver)
+}
+
+func (l *LimaFakeDriver) Start(ctx context.Context) (chan error, error) {
+	script, err := InitScript(l.Instance.Dir, l.SSHLocalPort)
+	if err != nil {
+		return nil, err
+	}
+	// --kill-child kills the PID 1 of the namespace (and hence all the processes in the guest) when unshare is killed
+	cmd := exec.CommandContext(ctx, "unshare", "--mount", "--pid", "--fork", "--kill-child", "--", "/bin/sh", "-c", string(script))
+	logFile, err := os.Create(filepath.Join(l.Instance.Dir, filenames.SerialLog))
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	logrus.Infof("Starting the fake guest (hint: to watch the boot progress, see %q)", logFile.Name())
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	pidFile := filepath.Join(l.Instance.Dir, filenames.PIDFile(*l.Yaml.VMType))
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		logFile.Close()
+		return nil, err
+	}
+	l.cmd = cmd
+	l.waitCh = make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		logFile.Close()
+		_ = os.RemoveAll(pidFile)
+		l.waitCh <- err
+	}()
+	return l.waitCh, nil
+}
+
+func (l *LimaFakeDriver) Stop(_ context.Context) error {
+	if l.cmd == nil {
+		return nil
+	}
+	logrus.Info("Shutting down the fake guest")
+	if err := l.cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	const timeout = 30 * time.Second
+	select {
+	case err := <-l.waitCh:
+		logrus.WithError(err).Info("The fake guest has exited")
+		return nil
+	case <-time.After(timeout):
+	}
+	logrus.Warnf("The fake guest did not exit in %v, forcibly killing", timeout)
+	if err := l.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-l.waitCh
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build !linux
+// +build !linux
+
+package fake
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lima-vm/lima/pkg/driver"
+)
+
+var ErrUnsupported = errors.New("vm driver 'fake' needs a Linux host")
+
+const Enabled = false
+
+type LimaFakeDriver struct {
+	*driver.BaseDriver
+}
+
+func New(driver *driver.BaseDriver) *LimaFakeDriver {
+	return &LimaFakeDriver{
+		BaseDriver: driver,
+	}
+}
+
+func (l *LimaFakeDriver) Validate() error {
+	return ErrUnsupported
+}
+
+func (l *LimaFakeDriver) CreateDisk() error {
+	return ErrUnsupported
+}
+
+func (l *LimaFakeDriver) Start(_ context.Context) (chan error, error) {
+	return nil, ErrUnsupported
+}
+
+func (l *LimaFakeDriver) Stop(_ context.Context) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,95 @@
+package fake
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/xorcare/pointer"
+	"gotest.tools/v3/assert"
+)
+
+func TestInitScript(t *testing.T) {
+	b, err := InitScript("/lima/foo", 60022)
+	assert.NilError(t, err)
+	script := string(b)
+	assert.Assert(t, strings.Contains(script, `ROOTFS="/lima/foo/fake-rootfs"`), script)
+	assert.Assert(t, strings.Contains(script, `mount --bind "/lima/foo/cidata" "$ROOTFS/mnt/lima-cidata"`), script)
+	assert.Assert(t, strings.Contains(script, "-o Port=60022"), script)
+}
+
+// TestLifecycle starts and stops the fake guest.
+// The test needs root, and $LIMA_TEST_FAKE_ROOTFS to be set to a rootfs tarball that contains sshd.
+// As the guest shares /dev and /sys with the host, the test is skipped outside containers,
+// unless $LIMA_TEST_FAKE_ALLOW_HOST is set to "1".
+func TestLifecycle(t *testing.T) {
+	rootfs := os.Getenv("LIMA_TEST_FAKE_ROOTFS")
+	if rootfs == "" || runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("needs root on Linux, and $LIMA_TEST_FAKE_ROOTFS")
+	}
+	if !inContainer() && os.Getenv("LIMA_TEST_FAKE_ALLOW_HOST") != "1" {
+		t.Skip("needs to run in a container (set $LIMA_TEST_FAKE_ALLOW_HOST=1 to run on the host)")
+	}
+	instDir := t.TempDir()
+	cidataDir := filepath.Join(instDir, filenames.CIDataISODir)
+	assert.NilError(t, os.MkdirAll(cidataDir, 0o700))
+	bootScript := "#!/bin/sh\ntouch /run/lima-boot-done\n"
+	assert.NilError(t, os.WriteFile(filepath.Join(cidataDir, "boot.sh"), []byte(bootScript), 0o700))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	sshLocalPort := l.Addr().(*net.TCPAddr).Port
+	assert.NilError(t, l.Close())
+
+	y := &limayaml.LimaYAML{
+		VMType:    pointer.String(limayaml.Fake),
+		Arch:      pointer.String(limayaml.NewArch(runtime.GOARCH)),
+		MountType: pointer.String(limayaml.REVSSHFS),
+		Images:    []limayaml.Image{{File: limayaml.File{Location: rootfs}}},
+	}
+	d := New(&driver.BaseDriver{
+		Instance:     &store.Instance{Name: "fake", Dir: instDir},
+		Yaml:         y,
+		SSHLocalPort: sshLocalPort,
+	})
+	assert.NilError(t, d.Validate())
+	assert.NilError(t, d.CreateDisk())
+
+	ctx := context.Background()
+	_, err = d.Start(ctx)
+	assert.NilError(t, err)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(sshLocalPort))
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NilError(t, err, "sshd did not start")
+	conn.Close()
+
+	assert.NilError(t, d.Stop(ctx))
+	_, err = os.Stat(filepath.Join(instDir, filenames.PIDFile(limayaml.Fake)))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+// inContainer returns true if the process seems running in a Docker or Podman container.
+func inContainer() bool {
+	for _, f := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(f); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,15 @@
 package driverutil
 
 import (
+	"github.com/lima-vm/lima/pkg/cloudhypervisor"
+	"github.com/lima-vm/lima/pkg/driver/external"
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/lima-vm/lima/pkg/vz"
 	"github.com/lima-vm/lima/pkg/wsl2"
 )
 
 // Drivers returns the available drivers.
+// The fake driver is not listed, as it is only for testing.
 func Drivers() []string {
 	drivers := []string{limayaml.QEMU}
 	if vz.Enabled {
@@ -15,5 +18,9 @@ func Drivers() []string {
 	if wsl2.Enabled {
 		drivers = append(drivers, limayaml.WSL2)
 	}
+	if cloudhypervisor.Enabled {
+		drivers = append(drivers, limayaml.CloudHypervisor)
+	}
+	drivers = append(drivers, external.Discover()...)
 	return drivers
 }
@@ -1,7 +1,12 @@
 package driverutil
 
 import (
+	"strings"
+
+	"github.com/lima-vm/lima/pkg/cloudhypervisor"
 	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/driver/external"
+	"github.com/lima-vm/lima/pkg/driver/fake"
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/lima-vm/lima/pkg/qemu"
 	"github.com/lima-vm/lima/pkg/vz"
@@ -10,11 +15,20 @@ import (
 
 func CreateTargetDriverInstance(base *driver.BaseDriver) driver.Driver {
 	limaDriver := base.Yaml.VMType
+	if strings.HasPrefix(*limaDriver, limayaml.ExtVMTypePrefix) {
+		return external.New(base)
+	}
 	if *limaDriver == limayaml.VZ {
 		return vz.New(base)
 	}
 	if *limaDriver == limayaml.WSL2 {
 		return wsl2.New(base)
 	}
+	if *limaDriver == limayaml.CloudHypervisor {
+		return cloudhypervisor.New(base)
+	}
+	if *limaDriver == limayaml.Fake {
+		return fake.New(base)
+	}
 	return qemu.New(base)
 }
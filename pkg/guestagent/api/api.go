@@ -10,23 +10,49 @@ var (
 	IPv4loopback1 = net.IPv4(127, 0, 0, 1)
 )
 
+const (
+	TCP = "tcp"
+	UDP = "udp"
+)
+
 type IPPort struct {
-	IP   net.IP `json:"ip"`
-	Port int    `json:"port"`
+	// Protocol is "tcp" or "udp".
+	// Empty means "tcp", as the older guest agents do not set Protocol.
+	Protocol string `json:"protocol,omitempty"`
+	IP       net.IP `json:"ip"`
+	Port     int    `json:"port"`
 }
 
 func (x *IPPort) String() string {
 	return net.JoinHostPort(x.IP.String(), strconv.Itoa(x.Port))
 }
 
+// Proto returns x.Protocol, or "tcp" if x.Protocol is empty.
+func (x *IPPort) Proto() string {
+	if x.Protocol == "" {
+		return TCP
+	}
+	return x.Protocol
+}
+
+// Key returns a string that identifies x including the protocol, e.g., "udp/127.0.0.1:53".
+func (x *IPPort) Key() string {
+	return x.Proto() + "/" + x.String()
+}
+
 type Info struct {
-	// LocalPorts contain 127.0.0.1 and 0.0.0.0.
+	// LocalPorts contain 127.0.0.1, 0.0.0.0, ::1, and ::.
+	// LocalPorts contain both the listening TCP ports and the unconnected UDP ports.
 	// LocalPorts do NOT contain addresses such as 127.0.0.53 and 192.168.5.15.
-	//
-	// In future, LocalPorts will contain IPv6 addresses (::1 and ::) as well.
 	LocalPorts []IPPort `json:"localPorts"`
 }
 
+// MemInfo is the memory statistics of the guest, in bytes.
+type MemInfo struct {
+	Total     int64 `json:"total"`
+	Available int64 `json:"available"`
+}
+
 type Event struct {
 	Time time.Time `json:"time,omitempty"`
 	// The first event contains the full ports as LocalPortsAdded
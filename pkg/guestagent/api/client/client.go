@@ -7,8 +7,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/lima-vm/lima/pkg/guestagent/api"
@@ -19,6 +21,10 @@ type GuestAgentClient interface {
 	HTTPClient() *http.Client
 	Info(context.Context) (*api.Info, error)
 	Events(context.Context, func(api.Event)) error
+	MemInfo(context.Context) (*api.MemInfo, error)
+	// UDPTunnel returns a tunnel of the UDP datagrams sent to and received from addr in the guest.
+	// The datagrams are read and written with api.ReadDatagram and api.WriteDatagram.
+	UDPTunnel(ctx context.Context, addr string) (io.ReadWriteCloser, error)
 }
 
 type Proto = string
@@ -26,10 +32,13 @@ type Proto = string
 const (
 	UNIX  Proto = "unix"
 	VSOCK Proto = "vsock"
+	// HybridVSOCK connects to the vsock port via the host-side UNIX socket of cloud-hypervisor.
+	HybridVSOCK Proto = "hybrid-vsock"
 )
 
 // NewGuestAgentClient creates a client.
 // remote is a path to the UNIX socket, without unix:// prefix or a remote hostname/IP address.
+// For HybridVSOCK, remote is "<PATH>:<PORT>", where PATH is the host-side UNIX socket and PORT is the vsock port.
 func NewGuestAgentClient(remote string, proto Proto, instanceName string) (GuestAgentClient, error) {
 	var hc *http.Client
 	switch proto {
@@ -52,6 +61,16 @@ func NewGuestAgentClient(remote string, proto Proto, instanceName string) (Guest
 		if err != nil {
 			return nil, err
 		}
+	case HybridVSOCK:
+		socketPath, p, err := net.SplitHostPort(remote)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		hc = httpclientutil.NewHTTPClientWithHybridVSock(socketPath, port)
 	}
 
 	return NewGuestAgentClientWithHTTPClient(hc), nil
@@ -108,3 +127,45 @@ func (c *client) Events(ctx context.Context, onEvent func(api.Event)) error {
 		onEvent(ev)
 	}
 }
+
+func (c *client) MemInfo(ctx context.Context) (*api.MemInfo, error) {
+	u := fmt.Sprintf("http://%s/%s/meminfo", c.dummyHost, c.version)
+	resp, err := httpclientutil.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var memInfo api.MemInfo
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&memInfo); err != nil {
+		return nil, err
+	}
+	return &memInfo, nil
+}
+
+func (c *client) UDPTunnel(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+	u := fmt.Sprintf("http://%s/%s/udp?addr=%s", c.dummyHost, c.version, url.QueryEscape(addr))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", api.UDPTunnelUpgrade)
+	resp, err := c.HTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		if err := httpclientutil.Successful(resp); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("expected status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected body type %T", resp.Body)
+	}
+	return rwc, nil
+}
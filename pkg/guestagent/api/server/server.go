@@ -3,7 +3,11 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"syscall"
 
 	"github.com/gorilla/mux"
 	"github.com/lima-vm/lima/pkg/guestagent"
@@ -76,8 +80,112 @@ func (b *Backend) GetEvents(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetMemInfo is the handler for GET /v{N}/meminfo
+func (b *Backend) GetMemInfo(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	memInfo, err := b.Agent.MemInfo(ctx)
+	if err != nil {
+		b.onError(w, err, http.StatusInternalServerError)
+		return
+	}
+	m, err := json.Marshal(memInfo)
+	if err != nil {
+		b.onError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(m)
+}
+
+// GetUDP is the handler for GET /v{N}/udp?addr=IP:PORT .
+// The connection is upgraded into a tunnel of the UDP datagrams sent to and received from addr.
+// The unspecified IP addresses (0.0.0.0 and ::) are dialed as the loopback addresses.
+func (b *Backend) GetUDP(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.URL.Query().Get("addr"))
+	if err != nil {
+		b.onError(w, err, http.StatusBadRequest)
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		b.onError(w, fmt.Errorf("invalid IP address %q", host), http.StatusBadRequest)
+		return
+	}
+	if ip.IsUnspecified() {
+		if ip.To4() != nil {
+			ip = api.IPv4loopback1
+		} else {
+			ip = net.IPv6loopback
+		}
+	}
+	if r.Header.Get("Upgrade") != api.UDPTunnelUpgrade {
+		b.onError(w, fmt.Errorf("expected header \"Upgrade: %s\"", api.UDPTunnelUpgrade), http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		panic("http.ResponseWriter has to implement http.Hijacker")
+	}
+	udpConn, err := net.Dial("udp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		b.onError(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer udpConn.Close()
+	conn, bufrw, err := hijacker.Hijack()
+	if err != nil {
+		b.onError(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintf(bufrw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", api.UDPTunnelUpgrade); err != nil {
+		logrus.Warn(err)
+		return
+	}
+	if err := bufrw.Flush(); err != nil {
+		logrus.Warn(err)
+		return
+	}
+
+	go func() {
+		// Closing conn terminates the loop below
+		defer conn.Close()
+		buf := make([]byte, api.MaxDatagramSize)
+		for {
+			n, err := udpConn.Read(buf)
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				// An ICMP error of a datagram sent previously
+				continue
+			}
+			if err != nil {
+				logrus.WithError(err).Debugf("failed to read from %s", udpConn.RemoteAddr())
+				return
+			}
+			if err := api.WriteDatagram(conn, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, api.MaxDatagramSize)
+	for {
+		n, err := api.ReadDatagram(bufrw, buf)
+		if err != nil {
+			return
+		}
+		if _, err := udpConn.Write(buf[:n]); err != nil {
+			logrus.WithError(err).Debugf("failed to write to %s", udpConn.RemoteAddr())
+		}
+	}
+}
+
 func AddRoutes(r *mux.Router, b *Backend) {
 	v1 := r.PathPrefix("/v1").Subrouter()
 	v1.Path("/info").Methods("GET").HandlerFunc(b.GetInfo)
 	v1.Path("/events").Methods("GET").HandlerFunc(b.GetEvents)
+	v1.Path("/meminfo").Methods("GET").HandlerFunc(b.GetMemInfo)
+	v1.Path("/udp").Methods("GET").HandlerFunc(b.GetUDP)
 }
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lima-vm/lima/pkg/guestagent/api"
+	"github.com/lima-vm/lima/pkg/guestagent/api/client"
+	"gotest.tools/v3/assert"
+)
+
+func TestGetUDP(t *testing.T) {
+	// UDP echo server
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: api.IPv4loopback1})
+	assert.NilError(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, api.MaxDatagramSize)
+		for {
+			n, src, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteToUDP(buf[:n], src)
+		}
+	}()
+
+	r := mux.NewRouter()
+	AddRoutes(r, &Backend{})
+	sock := filepath.Join(t.TempDir(), "ga.sock")
+	l, err := net.Listen("unix", sock)
+	assert.NilError(t, err)
+	srv := &http.Server{Handler: r}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	c, err := client.NewGuestAgentClient(sock, client.UNIX, "")
+	assert.NilError(t, err)
+	// 0.0.0.0 is dialed as 127.0.0.1
+	addr := net.JoinHostPort("0.0.0.0", strconv.Itoa(echo.LocalAddr().(*net.UDPAddr).Port))
+	tunnel, err := c.UDPTunnel(context.Background(), addr)
+	assert.NilError(t, err)
+	defer tunnel.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, api.MaxDatagramSize)
+		for _, msg := range []string{"hello", "world"} {
+			assert.Check(t, api.WriteDatagram(tunnel, []byte(msg)))
+			n, err := api.ReadDatagram(tunnel, buf)
+			assert.Check(t, err)
+			assert.Check(t, string(buf[:n]) == msg)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out")
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// UDPTunnelUpgrade is the value of the "Upgrade" header of GET /v{N}/udp.
+//
+// After the upgrade, the connection carries the UDP datagrams in both directions,
+// each prefixed with its length as a big endian uint16.
+const UDPTunnelUpgrade = "lima-udp"
+
+// MaxDatagramSize is the maximum size of a UDP datagram.
+const MaxDatagramSize = 65535
+
+// WriteDatagram writes a length-prefixed datagram to w.
+func WriteDatagram(w io.Writer, b []byte) error {
+	if len(b) > MaxDatagramSize {
+		return fmt.Errorf("datagram too large: %d bytes", len(b))
+	}
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadDatagram reads a length-prefixed datagram from r into buf, and returns the size of the datagram.
+// buf must be at least MaxDatagramSize bytes.
+func ReadDatagram(r io.Reader, buf []byte) (int, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(r, buf[:n]); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
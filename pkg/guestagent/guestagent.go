@@ -10,4 +10,5 @@ type Agent interface {
 	Info(ctx context.Context) (*api.Info, error)
 	Events(ctx context.Context, ch chan api.Event)
 	LocalPorts(ctx context.Context) ([]api.IPPort, error)
+	MemInfo(ctx context.Context) (*api.MemInfo, error)
 }
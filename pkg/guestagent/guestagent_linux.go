@@ -3,7 +3,9 @@ package guestagent
 import (
 	"context"
 	"errors"
+	"net"
 	"reflect"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -13,6 +15,7 @@ import (
 	"github.com/lima-vm/lima/pkg/guestagent/api"
 	"github.com/lima-vm/lima/pkg/guestagent/iptables"
 	"github.com/lima-vm/lima/pkg/guestagent/kubernetesservice"
+	"github.com/lima-vm/lima/pkg/guestagent/meminfo"
 	"github.com/lima-vm/lima/pkg/guestagent/procnettcp"
 	"github.com/lima-vm/lima/pkg/guestagent/timesync"
 	"github.com/sirupsen/logrus"
@@ -141,12 +144,12 @@ func comparePorts(old, neww []api.IPPort) (added, removed []api.IPPort) {
 	mStillExist := make(map[string]bool, len(old))
 
 	for _, f := range old {
-		k := f.String()
+		k := f.Key()
 		mRaw[k] = f
 		mStillExist[k] = false
 	}
 	for _, f := range neww {
-		k := f.String()
+		k := f.Key()
 		if _, ok := mRaw[k]; !ok {
 			added = append(added, f)
 		}
@@ -222,16 +225,20 @@ func (a *agent) LocalPorts(_ context.Context) ([]api.IPPort, error) {
 	}
 
 	for _, f := range tcpParsed {
-		switch f.Kind {
-		case procnettcp.TCP, procnettcp.TCP6:
-		default:
-			continue
-		}
-		if f.State == procnettcp.TCPListen {
+		switch {
+		case (f.Kind == procnettcp.TCP || f.Kind == procnettcp.TCP6) && f.State == procnettcp.TCPListen:
 			res = append(res,
 				api.IPPort{
-					IP:   f.IP,
-					Port: int(f.Port),
+					Protocol: api.TCP,
+					IP:       f.IP,
+					Port:     int(f.Port),
+				})
+		case (f.Kind == procnettcp.UDP || f.Kind == procnettcp.UDP6) && f.State == procnettcp.UDPUnconnected:
+			res = append(res,
+				api.IPPort{
+					Protocol: api.UDP,
+					IP:       f.IP,
+					Port:     int(f.Port),
 				})
 		}
 	}
@@ -257,27 +264,33 @@ func (a *agent) LocalPorts(_ context.Context) ([]api.IPPort, error) {
 	}
 
 	for _, ipt := range ipts {
+		proto := api.TCP
+		if ipt.UDP {
+			proto = api.UDP
+		}
 		// Make sure the port isn't already listed from procnettcp
 		found := false
 		for _, re := range res {
-			if re.Port == ipt.Port {
+			if re.Port == ipt.Port && re.Protocol == proto && isIPv4(re.IP) == isIPv4(ipt.IP) {
 				found = true
 			}
 		}
 		if !found {
 			res = append(res,
 				api.IPPort{
-					IP:   ipt.IP,
-					Port: ipt.Port,
+					Protocol: proto,
+					IP:       ipt.IP,
+					Port:     ipt.Port,
 				})
 		}
 	}
 
 	kubernetesEntries := a.kubernetesServiceWatcher.GetPorts()
 	for _, entry := range kubernetesEntries {
+		proto := strings.ToLower(string(entry.Protocol))
 		found := false
 		for _, re := range res {
-			if re.Port == int(entry.Port) {
+			if re.Port == int(entry.Port) && re.Protocol == proto {
 				found = true
 			}
 		}
@@ -285,8 +298,9 @@ func (a *agent) LocalPorts(_ context.Context) ([]api.IPPort, error) {
 		if !found {
 			res = append(res,
 				api.IPPort{
-					IP:   entry.IP,
-					Port: int(entry.Port),
+					Protocol: proto,
+					IP:       entry.IP,
+					Port:     int(entry.Port),
 				})
 		}
 	}
@@ -294,6 +308,10 @@ func (a *agent) LocalPorts(_ context.Context) ([]api.IPPort, error) {
 	return res, nil
 }
 
+func isIPv4(ip net.IP) bool {
+	return ip.To4() != nil
+}
+
 func (a *agent) Info(ctx context.Context) (*api.Info, error) {
 	var (
 		info api.Info
@@ -306,6 +324,17 @@ func (a *agent) Info(ctx context.Context) (*api.Info, error) {
 	return &info, nil
 }
 
+func (a *agent) MemInfo(_ context.Context) (*api.MemInfo, error) {
+	entry, err := meminfo.ParseFile()
+	if err != nil {
+		return nil, err
+	}
+	return &api.MemInfo{
+		Total:     entry.Total,
+		Available: entry.Available,
+	}, nil
+}
+
 const deltaLimit = 2 * time.Second
 
 func (a *agent) fixSystemTimeSkew() {
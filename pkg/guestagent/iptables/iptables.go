@@ -9,10 +9,13 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 type Entry struct {
 	TCP  bool
+	UDP  bool
 	IP   net.IP
 	Port int
 }
@@ -31,16 +34,32 @@ type Entry struct {
 // ipv4 IP address. We need to detect this IP.
 // --dport is the destination port. We need to detect this port
 // -j DNAT this tells us it's the line doing the port forwarding.
-var findPortRegex = regexp.MustCompile(`-A\s+CNI-DN-\w*\s+(?:-d ((?:\b25[0-5]|\b2[0-4][0-9]|\b[01]?[0-9][0-9]?)(?:\.(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)){3}))?(?:/32\s+)?-p (tcp)?.*--dport (\d+) -j DNAT`)
+var findPortRegex = regexp.MustCompile(`-A\s+CNI-DN-\w*\s+(?:-d ((?:\b25[0-5]|\b2[0-4][0-9]|\b[01]?[0-9][0-9]?)(?:\.(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)){3}))?(?:/32\s+)?-p (tcp|udp)?.*--dport (\d+) -j DNAT`)
+
+// findPortRegex6 is the IPv6 counterpart of findPortRegex, for the lines in the ip6tables, e.g.,
+//
+//	-A CNI-DN-2e2f8d5b91929ef9fc152 -d ::1/128 -p tcp -m tcp --dport 8081 -j DNAT --to-destination [fd00::7]:80
+var findPortRegex6 = regexp.MustCompile(`-A\s+CNI-DN-\w*\s+(?:-d ([0-9a-fA-F:]+)/128\s+)?-p (tcp|udp)?.*--dport (\d+) -j DNAT`)
 
 func GetPorts() ([]Entry, error) {
-	// TODO: add support for ipv6
+	pts, err := getPorts("iptables", parsePortsFromRules)
+	if err != nil {
+		return nil, err
+	}
+	pts6, err := getPorts("ip6tables", parsePortsFromRules6)
+	if err != nil {
+		// e.g., the kernel lacks the IPv6 NAT support
+		logrus.WithError(err).Debug("failed to get the ports from ip6tables")
+	}
+	return checkPortsOpen(append(pts, pts6...))
+}
 
+func getPorts(name string, parse func([]string) ([]Entry, error)) ([]Entry, error) {
 	// Detect the location of iptables. If it is not installed skip the lookup
 	// and return no results. The lookup is performed on each run so that the
 	// agent does not need to be started to detect if iptables was installed
 	// after the agent is already running.
-	pth, err := exec.LookPath("iptables")
+	pth, err := exec.LookPath(name)
 	if err != nil {
 		if errors.Is(err, exec.ErrNotFound) {
 			return nil, nil
@@ -54,38 +73,44 @@ func GetPorts() ([]Entry, error) {
 		return nil, err
 	}
 
-	pts, err := parsePortsFromRules(res)
-	if err != nil {
-		return nil, err
-	}
-
-	return checkPortsOpen(pts)
+	return parse(res)
 }
 
 func parsePortsFromRules(rules []string) ([]Entry, error) {
+	return parsePorts(rules, findPortRegex, "0.0.0.0")
+}
+
+func parsePortsFromRules6(rules []string) ([]Entry, error) {
+	return parsePorts(rules, findPortRegex6, "::")
+}
+
+func parsePorts(rules []string, re *regexp.Regexp, unspecifiedIP string) ([]Entry, error) {
 	var entries []Entry
 	for _, rule := range rules {
-		if found := findPortRegex.FindStringSubmatch(rule); found != nil {
+		if found := re.FindStringSubmatch(rule); found != nil {
 			if len(found) == 4 {
 				port, err := strconv.Atoi(found[3])
 				if err != nil {
 					return nil, err
 				}
 
-				istcp := false
-				if found[2] == "tcp" {
-					istcp = true
-				}
+				istcp := found[2] == "tcp"
+				isudp := found[2] == "udp"
 
 				// When no IP is present the rule applies to all interfaces.
 				ip := found[1]
 				if ip == "" {
-					ip = "0.0.0.0"
+					ip = unspecifiedIP
+				}
+				parsedIP := net.ParseIP(ip)
+				if parsedIP == nil {
+					continue
 				}
 				ent := Entry{
-					IP:   net.ParseIP(ip),
+					IP:   parsedIP,
 					Port: port,
 					TCP:  istcp,
+					UDP:  isudp,
 				}
 				entries = append(entries, ent)
 			}
@@ -91,3 +91,25 @@ func TestParsePortsFromRules(t *testing.T) {
 		t.Errorf("expected port 8081 on IP 127.0.0.1 with TCP true but go port %d on IP %s with TCP %t", res[1].Port, res[1].IP.String(), res[1].TCP)
 	}
 }
+
+func TestParsePortsFromRules6(t *testing.T) {
+	data6 := `-P PREROUTING ACCEPT
+-A CNI-DN-04579c7bb67f4c3f6cca0 -s fd00::/64 -p tcp -m tcp --dport 8082 -j CNI-HOSTPORT-SETMARK
+-A CNI-DN-04579c7bb67f4c3f6cca0 -p tcp -m tcp --dport 8082 -j DNAT --to-destination [fd00::a]:80
+-A CNI-DN-2e2f8d5b91929ef9fc152 -d ::1/128 -p udp -m udp --dport 8081 -j DNAT --to-destination [fd00::7]:80
+`
+	rules := strings.Split(strings.TrimSuffix(data6, "\n"), "\n")
+	res, err := parsePortsFromRules6(rules)
+	if err != nil {
+		t.Fatalf("parsing ip6tables ports failed with error: %s", err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected 2 ports parsed from ip6tables but parsed %d", len(res))
+	}
+	if res[0].IP.String() != "::" || res[0].Port != 8082 || !res[0].TCP {
+		t.Errorf("expected TCP port 8082 on IP :: but got %+v", res[0])
+	}
+	if res[1].IP.String() != "::1" || res[1].Port != 8081 || !res[1].UDP {
+		t.Errorf("expected UDP port 8081 on IP ::1 but got %+v", res[1])
+	}
+}
@@ -22,8 +22,9 @@ import (
 type Protocol string
 
 const (
-	// UDP/SCTP when lima port forwarding works on those protocols
+	// SCTP when lima port forwarding works on that protocol
 	TCP Protocol = "TCP"
+	UDP Protocol = "UDP"
 )
 
 type Entry struct {
@@ -128,8 +129,8 @@ func (s *ServiceWatcher) GetPorts() []Entry {
 		}
 
 		for _, portEntry := range service.Spec.Ports {
-			if portEntry.Protocol != corev1.ProtocolTCP {
-				// currently only TCP port can be forwarded
+			if portEntry.Protocol != corev1.ProtocolTCP && portEntry.Protocol != corev1.ProtocolUDP {
+				// currently only TCP and UDP ports can be forwarded
 				continue
 			}
 
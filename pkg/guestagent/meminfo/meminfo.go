@@ -0,0 +1,58 @@
+// Package meminfo parses /proc/meminfo.
+package meminfo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Entry is the memory statistics in bytes.
+type Entry struct {
+	Total     int64
+	Available int64
+}
+
+// Parse parses /proc/meminfo.
+// "MemAvailable" is required (Linux 3.14 and later).
+func Parse(r io.Reader) (*Entry, error) {
+	var (
+		res                    Entry
+		hasTotal, hasAvailable bool
+	)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		// "MemTotal:        4005216 kB"
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		var dst *int64
+		switch k {
+		case "MemTotal":
+			dst, hasTotal = &res.Total, true
+		case "MemAvailable":
+			dst, hasAvailable = &res.Available, true
+		default:
+			continue
+		}
+		fields := strings.Fields(v)
+		if len(fields) != 2 || fields[1] != "kB" {
+			return nil, fmt.Errorf("unexpected line %q", sc.Text())
+		}
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected line %q: %w", sc.Text(), err)
+		}
+		*dst = n << 10
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !hasTotal || !hasAvailable {
+		return nil, fmt.Errorf("MemTotal or MemAvailable is missing")
+	}
+	return &res, nil
+}
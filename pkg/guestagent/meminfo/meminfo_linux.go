@@ -0,0 +1,13 @@
+package meminfo
+
+import "os"
+
+// ParseFile parses /proc/meminfo
+func ParseFile() (*Entry, error) {
+	r, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return Parse(r)
+}
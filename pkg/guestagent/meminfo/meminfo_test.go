@@ -0,0 +1,24 @@
+package meminfo
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParse(t *testing.T) {
+	procMeminfo := `MemTotal:        4005216 kB
+MemFree:          269168 kB
+MemAvailable:    3218024 kB
+Buffers:          112860 kB
+HugePages_Total:       0
+`
+	entry, err := Parse(strings.NewReader(procMeminfo))
+	assert.NilError(t, err)
+	assert.Equal(t, entry.Total, int64(4005216*1024))
+	assert.Equal(t, entry.Available, int64(3218024*1024))
+
+	_, err = Parse(strings.NewReader("MemTotal:        4005216 kB\n"))
+	assert.ErrorContains(t, err, "missing")
+}
@@ -1,5 +1,64 @@
 package api
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+)
+
 type Info struct {
-	SSHLocalPort int `json:"sshLocalPort,omitempty"`
+	SSHLocalPort  int   `json:"sshLocalPort,omitempty"`
+	MemoryBalloon int64 `json:"memoryBalloon,omitempty"` // bytes
+	// PortForwards are the guest ports that are currently forwarded to the host
+	PortForwards ActivePortForwards `json:"portForwards,omitempty"`
+}
+
+// ActivePortForward is a guest port that is currently forwarded to the host.
+type ActivePortForward struct {
+	// Proto is "tcp" or "udp"
+	Proto string `json:"proto"`
+	// Guest is the guest address, e.g. "127.0.0.1:80"
+	Guest string `json:"guest"`
+	// Host is the actual host address, e.g. "127.0.0.1:8080"
+	Host string `json:"host"`
+	// Remapped is true when the host port differs from the rule, due to hostPortFallback
+	Remapped bool `json:"remapped,omitempty"`
+}
+
+// String returns the port forward in the form of "127.0.0.1:8080->127.0.0.1:80/tcp".
+func (f ActivePortForward) String() string {
+	return fmt.Sprintf("%s->%s/%s", f.Host, f.Guest, f.Proto)
+}
+
+type ActivePortForwards []ActivePortForward
+
+// String returns the port forwards separated by ", ".
+func (fs ActivePortForwards) String() string {
+	ss := make([]string, len(fs))
+	for i, f := range fs {
+		ss[i] = f.String()
+	}
+	return strings.Join(ss, ", ")
+}
+
+// PortForward is a port forwarding rule of a running instance.
+type PortForward struct {
+	// ID identifies the rule while the host agent is running.
+	ID int `json:"id"`
+	// Dynamic is true for rules that were added at runtime via the API.
+	// Only dynamic rules can be removed at runtime.
+	Dynamic bool `json:"dynamic,omitempty"`
+	limayaml.PortForward
+}
+
+// Reconfigured is the result of reconfiguring a running instance.
+type Reconfigured struct {
+	// Applied is the list of the YAML names of the fields that were applied to the running instance.
+	Applied []string `json:"applied,omitempty"`
+}
+
+// DiskResize is the request for resizing an additional disk of a running instance.
+type DiskResize struct {
+	Size int64 `json:"size"` // bytes
 }
@@ -4,18 +4,29 @@ package client
 // Apache License 2.0
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/lima-vm/lima/pkg/hostagent/api"
+	"github.com/lima-vm/lima/pkg/hostagent/events"
 	"github.com/lima-vm/lima/pkg/httpclientutil"
+	"github.com/lima-vm/lima/pkg/limayaml"
 )
 
 type HostAgentClient interface {
 	HTTPClient() *http.Client
 	Info(context.Context) (*api.Info, error)
+	Events(context.Context, func(events.Event)) error
+	PortForwards(context.Context) ([]api.PortForward, error)
+	AddPortForward(context.Context, limayaml.PortForward) (*api.PortForward, error)
+	RemovePortForward(context.Context, int) error
+	Suspend(context.Context) error
+	Reconfigure(context.Context) (*api.Reconfigured, error)
+	ResizeDisk(ctx context.Context, name string, size int64) error
 }
 
 // NewHostAgentClient creates a client.
@@ -62,3 +73,100 @@ func (c *client) Info(ctx context.Context) (*api.Info, error) {
 	}
 	return &info, nil
 }
+
+func (c *client) Events(ctx context.Context, onEvent func(events.Event)) error {
+	u := fmt.Sprintf("http://%s/%s/events", c.dummyHost, c.version)
+	resp, err := httpclientutil.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev events.Event
+		if err := dec.Decode(&ev); err != nil {
+			return err
+		}
+		onEvent(ev)
+	}
+}
+
+func (c *client) PortForwards(ctx context.Context) ([]api.PortForward, error) {
+	u := fmt.Sprintf("http://%s/%s/portforwards", c.dummyHost, c.version)
+	resp, err := httpclientutil.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var rules []api.PortForward
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (c *client) AddPortForward(ctx context.Context, rule limayaml.PortForward) (*api.PortForward, error) {
+	u := fmt.Sprintf("http://%s/%s/portforwards", c.dummyHost, c.version)
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpclientutil.Post(ctx, c.HTTPClient(), u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var added api.PortForward
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&added); err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+func (c *client) RemovePortForward(ctx context.Context, id int) error {
+	u := fmt.Sprintf("http://%s/%s/portforwards/%d", c.dummyHost, c.version, id)
+	resp, err := httpclientutil.Delete(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *client) Suspend(ctx context.Context) error {
+	u := fmt.Sprintf("http://%s/%s/suspend", c.dummyHost, c.version)
+	resp, err := httpclientutil.Post(ctx, c.HTTPClient(), u, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *client) Reconfigure(ctx context.Context) (*api.Reconfigured, error) {
+	u := fmt.Sprintf("http://%s/%s/reconfigure", c.dummyHost, c.version)
+	resp, err := httpclientutil.Post(ctx, c.HTTPClient(), u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res api.Reconfigured
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *client) ResizeDisk(ctx context.Context, name string, size int64) error {
+	u := fmt.Sprintf("http://%s/%s/disks/%s/resize", c.dummyHost, c.version, url.PathEscape(name))
+	b, err := json.Marshal(api.DiskResize{Size: size})
+	if err != nil {
+		return err
+	}
+	resp, err := httpclientutil.Post(ctx, c.HTTPClient(), u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -3,11 +3,18 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/lima-vm/lima/pkg/hostagent"
+	"github.com/lima-vm/lima/pkg/hostagent/api"
+	"github.com/lima-vm/lima/pkg/hostagent/events"
 	"github.com/lima-vm/lima/pkg/httputil"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/portfwdpolicy"
+	"github.com/sirupsen/logrus"
 )
 
 type Backend struct {
@@ -46,7 +53,157 @@ func (b *Backend) GetInfo(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(m)
 }
 
+// GetEvents is the handler for GET /v{N}/events.
+func (b *Backend) GetEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		panic("http.ResponseWriter has to implement http.Flusher")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := make(chan events.Event)
+	go b.Agent.Events(ctx, ch)
+
+	enc := json.NewEncoder(w)
+	for ev := range ch {
+		if err := enc.Encode(ev); err != nil {
+			logrus.Warn(err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (b *Backend) writeJSON(w http.ResponseWriter, v interface{}, ec int) {
+	m, err := json.Marshal(v)
+	if err != nil {
+		b.onError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ec)
+	_, _ = w.Write(m)
+}
+
+// GetPortForwards is the handler for GET /v{N}/portforwards
+func (b *Backend) GetPortForwards(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rules, err := b.Agent.PortForwards(ctx)
+	if err != nil {
+		b.onError(w, err, http.StatusInternalServerError)
+		return
+	}
+	b.writeJSON(w, rules, http.StatusOK)
+}
+
+// PostPortForward is the handler for POST /v{N}/portforwards
+func (b *Backend) PostPortForward(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var rule limayaml.PortForward
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		b.onError(w, err, http.StatusBadRequest)
+		return
+	}
+	added, err := b.Agent.AddPortForward(ctx, rule)
+	if err != nil {
+		ec := http.StatusBadRequest
+		if errors.Is(err, portfwdpolicy.ErrViolation) {
+			ec = http.StatusForbidden
+		}
+		b.onError(w, err, ec)
+		return
+	}
+	b.writeJSON(w, added, http.StatusCreated)
+}
+
+// DeletePortForward is the handler for DELETE /v{N}/portforwards/{id}
+func (b *Backend) DeletePortForward(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		b.onError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := b.Agent.RemovePortForward(ctx, id); err != nil {
+		ec := http.StatusBadRequest
+		if errors.Is(err, hostagent.ErrPortForwardNotFound) {
+			ec = http.StatusNotFound
+		}
+		b.onError(w, err, ec)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PostSuspend is the handler for POST /v{N}/suspend
+func (b *Backend) PostSuspend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := b.Agent.Suspend(ctx); err != nil {
+		b.onError(w, err, http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// PostReconfigure is the handler for POST /v{N}/reconfigure
+func (b *Backend) PostReconfigure(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	applied, err := b.Agent.Reconfigure(ctx)
+	if err != nil {
+		b.onError(w, err, http.StatusBadRequest)
+		return
+	}
+	b.writeJSON(w, api.Reconfigured{Applied: applied}, http.StatusOK)
+}
+
+// PostDiskResize is the handler for POST /v{N}/disks/{name}/resize
+func (b *Backend) PostDiskResize(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var req api.DiskResize
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		b.onError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := b.Agent.ResizeAdditionalDisk(ctx, mux.Vars(r)["name"], req.Size); err != nil {
+		b.onError(w, err, http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func AddRoutes(r *mux.Router, b *Backend) {
 	v1 := r.PathPrefix("/v1").Subrouter()
 	v1.Path("/info").Methods("GET").HandlerFunc(b.GetInfo)
+	v1.Path("/events").Methods("GET").HandlerFunc(b.GetEvents)
+	v1.Path("/portforwards").Methods("GET").HandlerFunc(b.GetPortForwards)
+	v1.Path("/portforwards").Methods("POST").HandlerFunc(b.PostPortForward)
+	v1.Path("/portforwards/{id}").Methods("DELETE").HandlerFunc(b.DeletePortForward)
+	v1.Path("/suspend").Methods("POST").HandlerFunc(b.PostSuspend)
+	v1.Path("/reconfigure").Methods("POST").HandlerFunc(b.PostReconfigure)
+	v1.Path("/disks/{name}/resize").Methods("POST").HandlerFunc(b.PostDiskResize)
 }
@@ -0,0 +1,110 @@
+package hostagent
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/go-units"
+	guestagentclient "github.com/lima-vm/lima/pkg/guestagent/api/client"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// balloonPollInterval is the interval of checking the guest memory.
+	// Inflating the balloon is also limited by memoryBalloon.reclaimInterval.
+	balloonPollInterval = 10 * time.Second
+
+	// balloonMinHeadroom is the minimum amount of the memory to be kept available in the guest.
+	balloonMinHeadroom = 512 << 20
+
+	// balloonMinStep is the minimum change of the balloon size, to avoid resizing the balloon too frequently.
+	balloonMinStep = 64 << 20
+)
+
+// balloonTarget returns the memory size of the guest that keeps 25% of the used memory
+// (at least balloonMinHeadroom) available in the guest.
+//
+// actual is the current memory size of the guest, available is MemAvailable of the guest.
+func balloonTarget(actual, available, minMemory, maxMemory int64) int64 {
+	used := actual - available
+	headroom := used / 4
+	if headroom < balloonMinHeadroom {
+		headroom = balloonMinHeadroom
+	}
+	target := used + headroom
+	target = target &^ (1<<20 - 1) // align to MiB
+	if target < minMemory {
+		target = minMemory
+	}
+	if target > maxMemory {
+		target = maxMemory
+	}
+	if diff := target - actual; diff > -balloonMinStep && diff < balloonMinStep && target != minMemory && target != maxMemory {
+		return actual
+	}
+	return target
+}
+
+// balloonLimits returns the lower and the upper limits of the guest memory for balloonTarget,
+// capped by the current memory size of the guest, which may be changed by hotplugging the memory.
+func balloonLimits(memory, balloonMin, balloonMax int64) (int64, int64) {
+	if balloonMin > memory {
+		balloonMin = memory
+	}
+	if balloonMax > memory {
+		balloonMax = memory
+	}
+	return balloonMin, balloonMax
+}
+
+// watchMemoryBalloon resizes the memory balloon periodically, according to the free memory reported by the guest agent.
+func (a *HostAgent) watchMemoryBalloon(ctx context.Context) {
+	// Validated in limayaml.Validate
+	balloonMin, _ := units.RAMInBytes(*a.y.MemoryBalloon.Min)
+	balloonMax, _ := units.RAMInBytes(*a.y.MemoryBalloon.Max)
+	reclaimInterval, _ := time.ParseDuration(*a.y.MemoryBalloon.ReclaimInterval)
+
+	client, err := guestagentclient.NewGuestAgentClient(a.guestAgentAddr(), a.guestAgentProto, a.instName)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to create the guest agent client, the memory balloon is disabled")
+		return
+	}
+	lastInflated := time.Now()
+	ticker := time.NewTicker(balloonPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		actual, err := a.driver.MemoryBalloon(ctx)
+		if err != nil {
+			logrus.WithError(err).Debug("failed to get the memory balloon size")
+			continue
+		}
+		// Read on every iteration, as the memory may be hot-plugged with `limactl edit`
+		memory := a.memory.Load()
+		a.memoryBalloon.Store(memory - actual)
+		memInfo, err := client.MemInfo(ctx)
+		if err != nil {
+			// The guest agent may not be ready yet
+			logrus.WithError(err).Debug("failed to get the memory info from the guest agent")
+			continue
+		}
+		minMemory, maxMemory := balloonLimits(memory, balloonMin, balloonMax)
+		target := balloonTarget(actual, memInfo.Available, minMemory, maxMemory)
+		if target == actual || (target < actual && time.Since(lastInflated) < reclaimInterval) {
+			continue
+		}
+		logrus.Debugf("Resizing the memory balloon: guest memory %s -> %s (available: %s)",
+			units.BytesSize(float64(actual)), units.BytesSize(float64(target)), units.BytesSize(float64(memInfo.Available)))
+		if err := a.driver.SetMemoryBalloon(ctx, target); err != nil {
+			logrus.WithError(err).Warn("failed to resize the memory balloon")
+			continue
+		}
+		if target < actual {
+			lastInflated = time.Now()
+		}
+	}
+}
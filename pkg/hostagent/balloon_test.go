@@ -0,0 +1,43 @@
+package hostagent
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestBalloonTarget(t *testing.T) {
+	const (
+		MiB = int64(1 << 20)
+		GiB = int64(1 << 30)
+	)
+	testCases := []struct {
+		name      string
+		actual    int64
+		available int64
+		expected  int64
+	}{
+		{"idle guest is shrunk to min", 4 * GiB, 3900 * MiB, 1 * GiB},
+		{"busy guest keeps 25% of used memory available", 4 * GiB, 1 * GiB, 3*GiB + 768*MiB},
+		{"guest under pressure is grown", 2 * GiB, 10 * MiB, 2550 * MiB},
+		{"guest is not grown beyond max", 4 * GiB, 10 * MiB, 4 * GiB},
+		{"small changes are ignored", 3 * GiB, 608 * MiB, 3 * GiB},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, balloonTarget(tc.actual, tc.available, 1*GiB, 4*GiB), tc.expected)
+		})
+	}
+}
+
+func TestBalloonLimits(t *testing.T) {
+	const GiB = int64(1 << 30)
+	minMemory, maxMemory := balloonLimits(4*GiB, 1*GiB, 8*GiB)
+	assert.Equal(t, minMemory, 1*GiB)
+	assert.Equal(t, maxMemory, 4*GiB)
+
+	// after hotplugging the memory
+	minMemory, maxMemory = balloonLimits(12*GiB, 1*GiB, 8*GiB)
+	assert.Equal(t, minMemory, 1*GiB)
+	assert.Equal(t, maxMemory, 8*GiB)
+}
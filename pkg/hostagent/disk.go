@@ -0,0 +1,47 @@
+package hostagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/sshocker/pkg/ssh"
+	"github.com/sirupsen/logrus"
+)
+
+// growDisksScript executes the boot script for the additional disks again, so that the partitions and
+// the filesystems of the resized disks are grown. The mounted disks are not mounted again.
+const growDisksScript = `#!/bin/bash
+set -eux -o pipefail
+sudo bash -c 'while read -r line; do [ -n "$line" ] && export "$line"; done </mnt/lima-cidata/lima.env
+LIMA_CIDATA_MNT=/mnt/lima-cidata PATH="/mnt/lima-cidata/util:$PATH" /mnt/lima-cidata/boot/05-lima-disks.sh'
+`
+
+// ResizeAdditionalDisk grows the additional disk name of the running instance to size bytes,
+// including the partition and the filesystem in the guest.
+func (a *HostAgent) ResizeAdditionalDisk(ctx context.Context, name string, size int64) error {
+	disk, err := store.InspectDisk(name)
+	if err != nil {
+		return err
+	}
+	if disk.Instance != a.instName {
+		return fmt.Errorf("disk %q is not attached to instance %q", name, a.instName)
+	}
+	if size < disk.Size {
+		return errors.New("shrinking the disk of a running instance is not supported (hint: stop the instance first)")
+	}
+	if size == disk.Size {
+		return nil
+	}
+	if err := a.driver.ResizeAdditionalDisk(ctx, name, size); err != nil {
+		return err
+	}
+	const desc = "growing the additional disks"
+	stdout, stderr, err := ssh.ExecuteScript(a.instSSHAddress, a.sshLocalPort, a.sshConfig, growDisksScript, desc)
+	logrus.Debugf("stdout=%q, stderr=%q, err=%v", stdout, stderr, err)
+	if err != nil {
+		return fmt.Errorf("failed to run the script for %s: stdout=%q, stderr=%q: %w", desc, stdout, stderr, err)
+	}
+	return nil
+}
@@ -16,7 +16,83 @@ type Status struct {
 	SSHLocalPort int `json:"sshLocalPort,omitempty"`
 }
 
+type PortForwardEventType = string
+
+const (
+	PortForwardAdded   PortForwardEventType = "added"
+	PortForwardRemoved PortForwardEventType = "removed"
+	// PortForwardViolation is emitted when a port forward or a client is rejected by the port forwarding policy
+	PortForwardViolation PortForwardEventType = "violation"
+	// PortForwardConflict is emitted when the host address of a port forward is already in use.
+	// When the port forward is remapped by hostPortFallback, PortForwardAdded follows with the actual host address.
+	PortForwardConflict PortForwardEventType = "conflict"
+)
+
+type PortForwardEvent struct {
+	Type PortForwardEventType `json:"type"`
+	// Proto is "tcp" or "udp"
+	Proto string `json:"proto,omitempty"`
+	// Guest is the guest address, e.g. "127.0.0.1:80"
+	Guest string `json:"guest"`
+	// Host is the host address, e.g. "127.0.0.1:8080", or the path of the host socket
+	Host string `json:"host"`
+	// Client is the address of the rejected client, for PortForwardViolation
+	Client string `json:"client,omitempty"`
+	// Error is set for PortForwardViolation and PortForwardConflict
+	Error string `json:"error,omitempty"`
+}
+
+type RequirementState = string
+
+const (
+	RequirementPending   RequirementState = "pending"
+	RequirementRunning   RequirementState = "running"
+	RequirementSatisfied RequirementState = "satisfied"
+	RequirementFailed    RequirementState = "failed"
+	// RequirementSkipped is emitted for the requirements that are not checked after a fatal failure
+	RequirementSkipped RequirementState = "skipped"
+)
+
+type RequirementEvent struct {
+	// Label is "essential", "optional", or "final"
+	Label string `json:"label"`
+	// Name is a short identifier of the requirement, e.g. "ssh", "guestagent", "probe-0"
+	Name string `json:"name"`
+	// Index starts with 1
+	Index       int              `json:"index"`
+	Total       int              `json:"total"`
+	Description string           `json:"description"`
+	State       RequirementState `json:"state"`
+	// Attempt starts with 1, and is 0 while the state is pending
+	Attempt int `json:"attempt,omitempty"`
+	// Stderr is the stderr of the last attempt
+	Stderr string `json:"stderr,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type MountState = string
+
+const (
+	MountMounted   MountState = "mounted"
+	MountUnmounted MountState = "unmounted"
+	MountFailed    MountState = "failed"
+)
+
+type MountEvent struct {
+	Location   string     `json:"location"`
+	MountPoint string     `json:"mountPoint"`
+	State      MountState `json:"state"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Event is emitted by the host agent.
+// Events that report the status of the host agent only have Status.
+// Other kinds of events have an empty Status, and set the corresponding field.
 type Event struct {
 	Time   time.Time `json:"time,omitempty"`
 	Status Status    `json:"status,omitempty"`
+
+	PortForward *PortForwardEvent `json:"portForward,omitempty"`
+	Requirement *RequirementEvent `json:"requirement,omitempty"`
+	Mount       *MountEvent       `json:"mount,omitempty"`
 }
@@ -14,11 +14,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/lima-vm/lima/pkg/driver"
 	"github.com/lima-vm/lima/pkg/driverutil"
 	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/lima-vm/lima/pkg/networks/usernet"
 
 	"github.com/lima-vm/lima/pkg/cidata"
 	guestagentapi "github.com/lima-vm/lima/pkg/guestagent/api"
@@ -27,12 +30,14 @@ import (
 	"github.com/lima-vm/lima/pkg/hostagent/dns"
 	"github.com/lima-vm/lima/pkg/hostagent/events"
 	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/portfwdpolicy"
 	"github.com/lima-vm/lima/pkg/sshutil"
 	"github.com/lima-vm/lima/pkg/store"
 	"github.com/lima-vm/lima/pkg/store/filenames"
 	"github.com/lima-vm/sshocker/pkg/ssh"
 	"github.com/sethvargo/go-password/password"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 )
 
 type HostAgent struct {
@@ -45,16 +50,29 @@ type HostAgent struct {
 	instSSHAddress  string
 	sshConfig       *ssh.SSHConfig
 	portForwarder   *portForwarder
-	onClose         []func() error // LIFO
+	// runRequirement checks a requirement in the guest, and returns the stderr along with the error
+	runRequirement  func(requirement) (string, error)
+	onCloseMu       sync.Mutex
+	onClose         []func() error // LIFO, protected by onCloseMu
 	guestAgentProto guestagentclient.Proto
 
-	driver   driver.Driver
-	sigintCh chan os.Signal
+	driver    driver.Driver
+	sigintCh  chan os.Signal
+	suspendCh chan struct{}
+	resuming  bool
 
 	eventEnc   *json.Encoder
 	eventEncMu sync.Mutex
+	// eventSubs and lastStatusEvent are protected by eventEncMu
+	eventSubs       map[chan events.Event]struct{}
+	lastStatusEvent *events.Event
 
 	vSockPort int
+
+	// memory is the current memory size of the guest in bytes, including the hot-plugged memory
+	memory atomic.Int64
+	// memoryBalloon is the current size of the memory balloon in bytes
+	memoryBalloon atomic.Int64
 }
 
 type options struct {
@@ -99,8 +117,15 @@ func New(instName string, stdout io.Writer, sigintCh chan os.Signal, opts ...Opt
 		sshLocalPort = inst.SSHLocalPort
 	}
 
+	resumeState, err := loadSuspendState(inst.Dir)
+	if err != nil {
+		return nil, err
+	}
+
 	var udpDNSLocalPort, tcpDNSLocalPort int
-	if *y.HostResolver.Enabled {
+	if resumeState != nil {
+		udpDNSLocalPort, tcpDNSLocalPort = resumeState.UDPDNSLocalPort, resumeState.TCPDNSLocalPort
+	} else if *y.HostResolver.Enabled {
 		udpDNSLocalPort, err = findFreeUDPLocalPort()
 		if err != nil {
 			return nil, err
@@ -112,21 +137,31 @@ func New(instName string, stdout io.Writer, sigintCh chan os.Signal, opts ...Opt
 	}
 
 	guestAgentProto := guestagentclient.UNIX
-	if *y.VMType == limayaml.WSL2 {
+	switch *y.VMType {
+	case limayaml.WSL2:
 		guestAgentProto = guestagentclient.VSOCK
+	case limayaml.CloudHypervisor:
+		guestAgentProto = guestagentclient.HybridVSOCK
 	}
 
 	vSockPort := 0
-	if guestAgentProto == guestagentclient.VSOCK {
+	switch guestAgentProto {
+	case guestagentclient.VSOCK:
 		port, err := getFreeVSockPort()
 		if err != nil {
 			logrus.WithError(err).Error("failed to get free VSock port")
 		}
 		vSockPort = port
+	case guestagentclient.HybridVSOCK:
+		// The vsock ports are not shared with other VMs
+		vSockPort = hybridVSockPort
 	}
 
-	if err := cidata.GenerateISO9660(inst.Dir, instName, y, udpDNSLocalPort, tcpDNSLocalPort, o.nerdctlArchive, vSockPort); err != nil {
-		return nil, err
+	// The resumed guest keeps using the cidata of the initial boot
+	if resumeState == nil {
+		if err := cidata.GenerateISO9660(inst.Dir, instName, y, udpDNSLocalPort, tcpDNSLocalPort, o.nerdctlArchive, vSockPort); err != nil {
+			return nil, err
+		}
 	}
 
 	sshOpts, err := sshutil.SSHOpts(inst.Dir, *y.SSH.LoadDotSSHPubKeys, *y.SSH.ForwardAgent, *y.SSH.ForwardX11, *y.SSH.ForwardX11Trusted)
@@ -140,19 +175,25 @@ func New(instName string, stdout io.Writer, sigintCh chan os.Signal, opts ...Opt
 		AdditionalArgs: sshutil.SSHArgsFromOpts(sshOpts),
 	}
 
-	rules := make([]limayaml.PortForward, 0, 3+len(y.PortForwards))
+	reservedRules := make([]limayaml.PortForward, 0, 2)
 	// Block ports 22 and sshLocalPort on all IPs
 	for _, port := range []int{sshGuestPort, sshLocalPort} {
 		rule := limayaml.PortForward{GuestIP: net.IPv4zero, GuestPort: port, Ignore: true}
 		limayaml.FillPortForwardDefaults(&rule, inst.Dir)
-		rules = append(rules, rule)
+		reservedRules = append(reservedRules, rule)
 	}
+	rules := make([]limayaml.PortForward, 0, 1+len(y.PortForwards))
 	rules = append(rules, y.PortForwards...)
 	// Default forwards for all non-privileged ports from "127.0.0.1" and "::1"
 	rule := limayaml.PortForward{GuestIP: guestagentapi.IPv4loopback1}
 	limayaml.FillPortForwardDefaults(&rule, inst.Dir)
 	rules = append(rules, rule)
 
+	policy, err := portfwdpolicy.Load()
+	if err != nil {
+		return nil, err
+	}
+
 	limaDriver := driverutil.CreateTargetDriverInstance(&driver.BaseDriver{
 		Instance:     inst,
 		Yaml:         y,
@@ -168,13 +209,30 @@ func New(instName string, stdout io.Writer, sigintCh chan os.Signal, opts ...Opt
 		instName:        instName,
 		instSSHAddress:  inst.SSHAddress,
 		sshConfig:       sshConfig,
-		portForwarder:   newPortForwarder(sshConfig, sshLocalPort, rules, inst.VMType),
+		portForwarder:   newPortForwarder(sshConfig, sshLocalPort, reservedRules, rules, inst.VMType),
 		driver:          limaDriver,
 		sigintCh:        sigintCh,
+		suspendCh:       make(chan struct{}, 1),
+		resuming:        resumeState != nil,
 		eventEnc:        json.NewEncoder(stdout),
+		eventSubs:       make(map[chan events.Event]struct{}),
 		vSockPort:       vSockPort,
 		guestAgentProto: guestAgentProto,
 	}
+	memory, err := units.RAMInBytes(*y.Memory)
+	if err != nil {
+		return nil, err
+	}
+	a.memory.Store(memory)
+	a.runRequirement = a.waitForRequirement
+	a.portForwarder.emitEvent = a.emitEvent
+	a.portForwarder.dialUDP = a.dialGuestUDP
+	a.portForwarder.policy = policy
+	if usernetIndex := limayaml.FirstUsernetIndex(y); usernetIndex != -1 {
+		if client := usernet.NewClientByName(y.Networks[usernetIndex].Lima); client != nil {
+			a.portForwarder.usernet = &usernetPortExposer{client: client, macAddress: limayaml.MACAddress(inst.Dir)}
+		}
+	}
 	return a, nil
 }
 
@@ -273,6 +331,52 @@ func (a *HostAgent) emitEvent(_ context.Context, ev events.Event) {
 	if err := a.eventEnc.Encode(ev); err != nil {
 		logrus.WithField("event", ev).WithError(err).Error("failed to emit an event")
 	}
+	if ev.PortForward == nil && ev.Requirement == nil && ev.Mount == nil {
+		a.lastStatusEvent = &ev
+	}
+	for ch := range a.eventSubs {
+		select {
+		case ch <- ev:
+		default:
+			logrus.WithField("event", ev).Warn("dropping an event for a slow subscriber")
+		}
+	}
+}
+
+// eventSubBufferSize is the number of events that can be queued for a subscriber.
+const eventSubBufferSize = 64
+
+// hybridVSockPort is the vsock port of the guest agent for the hybrid vsock device of cloud-hypervisor.
+const hybridVSockPort = 10240
+
+// Events sends the events to ch until ctx is done, and closes ch.
+// The first event is the latest status event, if any.
+func (a *HostAgent) Events(ctx context.Context, ch chan events.Event) {
+	defer close(ch)
+	sub := make(chan events.Event, eventSubBufferSize)
+	a.eventEncMu.Lock()
+	if a.lastStatusEvent != nil {
+		sub <- *a.lastStatusEvent
+	}
+	a.eventSubs[sub] = struct{}{}
+	a.eventEncMu.Unlock()
+	defer func() {
+		a.eventEncMu.Lock()
+		delete(a.eventSubs, sub)
+		a.eventEncMu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-sub:
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
 }
 
 func generatePassword(length int) (string, error) {
@@ -311,10 +415,24 @@ func (a *HostAgent) Run(ctx context.Context) error {
 		defer dnsServer.Shutdown()
 	}
 
+	if closer, ok := a.driver.(io.Closer); ok {
+		// Not using a.onClose, as the hooks are called before stopping the driver.
+		// The external driver exits when the connection is closed.
+		defer func() {
+			if err := closer.Close(); err != nil {
+				logrus.WithError(err).Warn("failed to close the driver")
+			}
+		}()
+	}
 	errCh, err := a.driver.Start(ctx)
 	if err != nil {
 		return err
 	}
+	if a.resuming {
+		if err := os.RemoveAll(filepath.Join(a.instDir, filenames.VMStateJSON)); err != nil {
+			logrus.WithError(err).Warn("failed to remove the host agent state of the suspended instance")
+		}
+	}
 
 	// WSL instance SSH address isn't known until after VM start
 	if *a.y.VMType == limayaml.WSL2 {
@@ -415,19 +533,65 @@ func (a *HostAgent) startRoutinesAndWait(ctx context.Context, errCh chan error)
 			}
 			err := a.driver.Stop(ctx)
 			return err
+		case <-a.suspendCh:
+			logrus.Info("Received a suspend request, suspending the instance")
+			cancelHA()
+			if closeErr := a.close(); closeErr != nil {
+				logrus.WithError(closeErr).Warn("an error during shutting down the host agent")
+			}
+			return a.suspend(ctx)
 		}
 	}
 }
 
 func (a *HostAgent) Info(_ context.Context) (*hostagentapi.Info, error) {
 	info := &hostagentapi.Info{
-		SSHLocalPort: a.sshLocalPort,
+		SSHLocalPort:  a.sshLocalPort,
+		MemoryBalloon: a.memoryBalloon.Load(),
+		PortForwards:  a.portForwarder.ActiveForwards(),
 	}
 	return info, nil
 }
 
+// PortForwards returns the port forwarding rules in the order of evaluation.
+func (a *HostAgent) PortForwards(_ context.Context) ([]hostagentapi.PortForward, error) {
+	return a.portForwarder.Rules(), nil
+}
+
+// AddPortForward adds a port forwarding rule at runtime.
+// The rule takes precedence over the rules in lima.yaml, but is lost when the host agent exits.
+func (a *HostAgent) AddPortForward(ctx context.Context, rule limayaml.PortForward) (*hostagentapi.PortForward, error) {
+	limayaml.FillPortForwardDefaults(&rule, a.instDir)
+	return a.portForwarder.AddRule(ctx, rule)
+}
+
+// RemovePortForward removes a port forwarding rule that was added by AddPortForward.
+func (a *HostAgent) RemovePortForward(ctx context.Context, id int) error {
+	return a.portForwarder.RemoveRule(ctx, id)
+}
+
+// Reconfigure applies the changes of lima.yaml to the running instance, where the driver supports it.
+// It returns the YAML names of the applied fields.
+func (a *HostAgent) Reconfigure(ctx context.Context) ([]string, error) {
+	inst, err := store.Inspect(a.instName)
+	if err != nil {
+		return nil, err
+	}
+	y, err := inst.LoadYAML()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := a.driver.Reconfigure(ctx, y)
+	if slices.Contains(applied, "memory") {
+		// Validated in limayaml.Validate
+		memory, _ := units.RAMInBytes(*y.Memory)
+		a.memory.Store(memory)
+	}
+	return applied, err
+}
+
 func (a *HostAgent) startHostAgentRoutines(ctx context.Context) error {
-	a.onClose = append(a.onClose, func() error {
+	a.addOnClose(func() error {
 		logrus.Debugf("shutting down the SSH master")
 		if exitMasterErr := ssh.ExitMaster(a.instSSHAddress, a.sshLocalPort, a.sshConfig); exitMasterErr != nil {
 			logrus.WithError(exitMasterErr).Warn("failed to exit SSH master")
@@ -435,7 +599,7 @@ func (a *HostAgent) startHostAgentRoutines(ctx context.Context) error {
 		return nil
 	})
 	var errs []error
-	if err := a.waitForRequirements("essential", a.essentialRequirements()); err != nil {
+	if err := a.waitForRequirements(ctx, "essential", a.essentialRequirements()); err != nil {
 		errs = append(errs, err)
 	}
 	if *a.y.SSH.ForwardAgent {
@@ -452,11 +616,11 @@ sudo chown -R "${USER}" /run/host-services`
 		}
 	}
 	if *a.y.MountType == limayaml.REVSSHFS {
-		mounts, err := a.setupMounts()
+		mounts, err := a.setupMounts(ctx)
 		if err != nil {
 			errs = append(errs, err)
 		}
-		a.onClose = append(a.onClose, func() error {
+		a.addOnClose(func() error {
 			var unmountErrs []error
 			for _, m := range mounts {
 				if unmountErr := m.close(); unmountErr != nil {
@@ -467,7 +631,7 @@ sudo chown -R "${USER}" /run/host-services`
 		})
 	}
 	if len(a.y.AdditionalDisks) > 0 {
-		a.onClose = append(a.onClose, func() error {
+		a.addOnClose(func() error {
 			var unlockErrs []error
 			for _, d := range a.y.AdditionalDisks {
 				disk, inspectErr := store.InspectDisk(d.Name)
@@ -476,18 +640,29 @@ sudo chown -R "${USER}" /run/host-services`
 					continue
 				}
 				logrus.Infof("Unmounting disk %q", disk.Name)
-				if unlockErr := disk.Unlock(); unlockErr != nil {
+				unlock := disk.Unlock
+				if d.ReadOnly != nil && *d.ReadOnly {
+					unlock = func() error { return disk.UnlockReadOnly(a.instDir) }
+				}
+				if unlockErr := unlock(); unlockErr != nil {
 					unlockErrs = append(unlockErrs, unlockErr)
 				}
 			}
 			return errors.Join(unlockErrs...)
 		})
 	}
+	a.addOnClose(func() error {
+		logrus.Debugf("Stop forwarding ports")
+		return a.portForwarder.Close()
+	})
 	go a.watchGuestAgentEvents(ctx)
-	if err := a.waitForRequirements("optional", a.optionalRequirements()); err != nil {
+	if *a.y.MemoryBalloon.Enabled {
+		go a.watchMemoryBalloon(ctx)
+	}
+	if err := a.waitForRequirements(ctx, "optional", a.optionalRequirements()); err != nil {
 		errs = append(errs, err)
 	}
-	if err := a.waitForRequirements("final", a.finalRequirements()); err != nil {
+	if err := a.waitForRequirements(ctx, "final", a.finalRequirements()); err != nil {
 		errs = append(errs, err)
 	}
 	// Copy all config files _after_ the requirements are done
@@ -496,7 +671,7 @@ sudo chown -R "${USER}" /run/host-services`
 			errs = append(errs, err)
 		}
 	}
-	a.onClose = append(a.onClose, func() error {
+	a.addOnClose(func() error {
 		var rmErrs []error
 		for _, rule := range a.y.CopyToHost {
 			if rule.DeleteOnStop {
@@ -511,11 +686,22 @@ sudo chown -R "${USER}" /run/host-services`
 	return errors.Join(errs...)
 }
 
+// addOnClose registers f to be called on closing the host agent.
+// addOnClose is safe to be called from the goroutines of the host agent.
+func (a *HostAgent) addOnClose(f func() error) {
+	a.onCloseMu.Lock()
+	defer a.onCloseMu.Unlock()
+	a.onClose = append(a.onClose, f)
+}
+
 func (a *HostAgent) close() error {
 	logrus.Infof("Shutting down the host agent")
+	a.onCloseMu.Lock()
+	onClose := a.onClose
+	a.onCloseMu.Unlock()
 	var errs []error
-	for i := len(a.onClose) - 1; i >= 0; i-- {
-		f := a.onClose[i]
+	for i := len(onClose) - 1; i >= 0; i-- {
+		f := onClose[i]
 		if err := f(); err != nil {
 			errs = append(errs, err)
 		}
@@ -540,7 +726,7 @@ func (a *HostAgent) watchGuestAgentEvents(ctx context.Context) {
 	localUnix := filepath.Join(a.instDir, filenames.GuestAgentSock)
 	remoteUnix := "/run/lima-guestagent.sock"
 
-	a.onClose = append(a.onClose, func() error {
+	a.addOnClose(func() error {
 		logrus.Debugf("Stop forwarding unix sockets")
 		var errs []error
 		for _, rule := range a.y.PortForwards {
@@ -558,14 +744,10 @@ func (a *HostAgent) watchGuestAgentEvents(ctx context.Context) {
 		return errors.Join(errs...)
 	})
 
-	guestSocketAddr := localUnix
-	if a.guestAgentProto == guestagentclient.VSOCK {
-		guestSocketAddr = fmt.Sprintf("0.0.0.0:%d", a.vSockPort)
-	}
-
+	guestSocketAddr := a.guestAgentAddr()
 	for {
 		if !isGuestAgentSocketAccessible(ctx, guestSocketAddr, a.guestAgentProto, a.instName) {
-			if a.guestAgentProto != guestagentclient.VSOCK {
+			if a.guestAgentProto == guestagentclient.UNIX {
 				_ = forwardSSH(ctx, a.sshConfig, a.sshLocalPort, localUnix, remoteUnix, verbForward, false)
 			}
 		}
@@ -582,6 +764,27 @@ func (a *HostAgent) watchGuestAgentEvents(ctx context.Context) {
 	}
 }
 
+// guestAgentAddr returns the address of the guest agent for guestagentclient.NewGuestAgentClient.
+func (a *HostAgent) guestAgentAddr() string {
+	switch a.guestAgentProto {
+	case guestagentclient.VSOCK:
+		return fmt.Sprintf("0.0.0.0:%d", a.vSockPort)
+	case guestagentclient.HybridVSOCK:
+		return fmt.Sprintf("%s:%d", filepath.Join(a.instDir, filenames.CHVSockSock), a.vSockPort)
+	default:
+		return filepath.Join(a.instDir, filenames.GuestAgentSock)
+	}
+}
+
+// dialGuestUDP opens a tunnel to the UDP address in the guest via the guest agent.
+func (a *HostAgent) dialGuestUDP(ctx context.Context, guestAddr string) (io.ReadWriteCloser, error) {
+	client, err := guestagentclient.NewGuestAgentClient(a.guestAgentAddr(), a.guestAgentProto, a.instName)
+	if err != nil {
+		return nil, err
+	}
+	return client.UDPTunnel(ctx, guestAddr)
+}
+
 func isGuestAgentSocketAccessible(ctx context.Context, localUnix string, proto guestagentclient.Proto, instanceName string) bool {
 	client, err := guestagentclient.NewGuestAgentClient(localUnix, proto, instanceName)
 	if err != nil {
@@ -1,10 +1,12 @@
 package hostagent
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 
+	"github.com/lima-vm/lima/pkg/hostagent/events"
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/lima-vm/lima/pkg/localpathutil"
 	"github.com/lima-vm/sshocker/pkg/reversesshfs"
@@ -15,14 +17,15 @@ type mount struct {
 	close func() error
 }
 
-func (a *HostAgent) setupMounts() ([]*mount, error) {
+func (a *HostAgent) setupMounts(ctx context.Context) ([]*mount, error) {
 	var (
 		res  []*mount
 		errs []error
 	)
 	for _, f := range a.y.Mounts {
-		m, err := a.setupMount(f)
+		m, err := a.setupMount(ctx, f)
 		if err != nil {
+			a.emitMountEvent(ctx, f.Location, f.MountPoint, events.MountFailed, err)
 			errs = append(errs, err)
 			continue
 		}
@@ -31,7 +34,19 @@ func (a *HostAgent) setupMounts() ([]*mount, error) {
 	return res, errors.Join(errs...)
 }
 
-func (a *HostAgent) setupMount(m limayaml.Mount) (*mount, error) {
+func (a *HostAgent) emitMountEvent(ctx context.Context, location, mountPoint string, state events.MountState, err error) {
+	ev := &events.MountEvent{
+		Location:   location,
+		MountPoint: mountPoint,
+		State:      state,
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	a.emitEvent(ctx, events.Event{Mount: ev})
+}
+
+func (a *HostAgent) setupMount(ctx context.Context, m limayaml.Mount) (*mount, error) {
 	location, err := localpathutil.Expand(m.Location)
 	if err != nil {
 		return nil, err
@@ -76,12 +91,16 @@ func (a *HostAgent) setupMount(m limayaml.Mount) (*mount, error) {
 		}
 	}
 
+	a.emitMountEvent(ctx, location, mountPoint, events.MountMounted, nil)
+
 	res := &mount{
 		close: func() error {
 			logrus.Infof("Unmounting %q", location)
 			if closeErr := rsf.Close(); closeErr != nil {
 				return fmt.Errorf("failed to unmount reverse sshfs for %q on %q: %w", location, mountPoint, err)
 			}
+			// using context.Background() because ctx has already been cancelled
+			a.emitMountEvent(context.Background(), location, mountPoint, events.MountUnmounted, nil)
 			return nil
 		},
 	}
@@ -2,30 +2,136 @@ package hostagent
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/lima-vm/lima/pkg/guestagent/api"
+	hostagentapi "github.com/lima-vm/lima/pkg/hostagent/api"
+	"github.com/lima-vm/lima/pkg/hostagent/events"
 	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/portfwdpolicy"
 	"github.com/lima-vm/sshocker/pkg/ssh"
 	"github.com/sirupsen/logrus"
 )
 
+// ErrPortForwardNotFound is returned when a port forwarding rule does not exist.
+var ErrPortForwardNotFound = errors.New("port forwarding rule not found")
+
 type portForwarder struct {
 	sshConfig   *ssh.SSHConfig
 	sshHostPort int
-	rules       []limayaml.PortForward
 	vmType      limayaml.VMType
+
+	mu sync.Mutex
+	// reservedRules block the SSH ports, and always take precedence
+	reservedRules []hostagentapi.PortForward
+	// dynamicRules are added at runtime, and take precedence over rules
+	dynamicRules []hostagentapi.PortForward
+	rules        []hostagentapi.PortForward
+	lastID       int
+	localUnixIP  net.IP
+	// guestPorts contains the ports that are currently listened in the guest
+	guestPorts map[string]api.IPPort
+	// forwards contains the active forwards, keyed by api.IPPort.Key() of the guest address.
+	// A guest address may be forwarded to multiple host addresses, see hostAddresses.
+	forwards map[string][]forward
+	// emitEvent may be nil
+	emitEvent func(context.Context, events.Event)
+	// dialUDP opens a tunnel to the UDP address in the guest, see guestagentclient.GuestAgentClient.UDPTunnel.
+	// dialUDP may be nil, in which case UDP ports are not forwarded.
+	dialUDP func(ctx context.Context, guestAddr string) (io.ReadWriteCloser, error)
+	// usernet forwards the ports without SSH, when the instance is attached to a user-v2 network.
+	// usernet may be nil.
+	usernet usernetExposer
+	// policy is the host-wide port forwarding policy. policy may be nil.
+	policy *portfwdpolicy.Policy
+	// innerDir contains the sockets of the inner forwards behind the proxies, see innerLocal.
+	// innerDir is created on the first use, and removed on Close.
+	innerDir     string
+	lastInnerSeq int
+	// sshOps are the SSH commands queued with pf.mu held, see runSSHOps.
+	sshOps     []sshOp
+	lastSSHSeq int
+	// sshMu serializes runSSHOps, as forwardTCP is not thread-safe.
+	// sshMu must not be acquired with pf.mu held.
+	sshMu sync.Mutex
+
+	// violationsMu protects violations, without pf.mu, as the clients are checked outside pf.mu
+	violationsMu sync.Mutex
+	// violations contains the last time of the reported violations, see reportViolation
+	violations map[string]time.Time
 }
 
+// usernetExposer forwards the host ports to the guest via the gvproxy API of the user-v2 network.
+// The gvproxy connects to the IP address of the guest, so only the ports listened on the
+// unspecified address in the guest can be forwarded this way.
+type usernetExposer interface {
+	Expose(local string, guestPort int, proto string) error
+	Unexpose(local, proto string) error
+}
+
+type forward struct {
+	local  string
+	remote string
+	// ruleLocal is the host address by the rule.
+	// local differs from ruleLocal when the host port is remapped by hostPortFallback.
+	ruleLocal string
+	// sshSeq is set for the TCP forwards via SSH, to identify the forward in sshOp
+	sshSeq int
+	// udp is set for the UDP forwards via the guest agent
+	udp *udpForwarder
+	// usernet is set for the forwards via usernetExposer
+	usernet bool
+	// proxy is set for the TCP forwards that check the clients with the policy.
+	// The proxy relays the allowed connections to inner, which forwards a host socket to the guest,
+	// see innerLocal.
+	proxy *tcpProxy
+	inner *forward
+}
+
+// sshOp is an SSH command for forwarding local to remote (verbForward), or cancelling it (verbCancel).
+type sshOp struct {
+	verb   string
+	local  string
+	remote string
+	// guest and seq identify the forward in pf.forwards, for verbForward
+	guest api.IPPort
+	seq   int
+}
+
+// violationReportInterval is the interval for reporting the same violation again.
+const violationReportInterval = time.Minute
+
 const sshGuestPort = 22
 
-func newPortForwarder(sshConfig *ssh.SSHConfig, sshHostPort int, rules []limayaml.PortForward, vmType limayaml.VMType) *portForwarder {
-	return &portForwarder{
+func newPortForwarder(sshConfig *ssh.SSHConfig, sshHostPort int, reservedRules, rules []limayaml.PortForward, vmType limayaml.VMType) *portForwarder {
+	pf := &portForwarder{
 		sshConfig:   sshConfig,
 		sshHostPort: sshHostPort,
-		rules:       rules,
 		vmType:      vmType,
+		guestPorts:  make(map[string]api.IPPort),
+		forwards:    make(map[string][]forward),
+		violations:  make(map[string]time.Time),
+	}
+	for _, rule := range reservedRules {
+		pf.lastID++
+		pf.reservedRules = append(pf.reservedRules, hostagentapi.PortForward{ID: pf.lastID, PortForward: rule})
 	}
+	for _, rule := range rules {
+		pf.lastID++
+		pf.rules = append(pf.rules, hostagentapi.PortForward{ID: pf.lastID, PortForward: rule})
+	}
+	return pf
 }
 
 func hostAddress(rule limayaml.PortForward, guest api.IPPort) string {
@@ -42,17 +148,44 @@ func hostAddress(rule limayaml.PortForward, guest api.IPPort) string {
 	return host.String()
 }
 
+// ruleHostAddress returns the host address of the rule, e.g., "127.0.0.1:8080-8090".
+func ruleHostAddress(rule limayaml.PortForward) string {
+	return net.JoinHostPort(rule.HostIP.String(), limayaml.PortRangeString(rule.HostPortRange))
+}
+
+// ruleGuestAddress returns the guest address of the rule, e.g., "127.0.0.1:80-90".
+func ruleGuestAddress(rule limayaml.PortForward) string {
+	return net.JoinHostPort(rule.GuestIP.String(), limayaml.PortRangeString(rule.GuestPortRange))
+}
+
+// allRules returns the rules in the order of evaluation.
+func (pf *portForwarder) allRules() []hostagentapi.PortForward {
+	rules := make([]hostagentapi.PortForward, 0, len(pf.reservedRules)+len(pf.dynamicRules)+len(pf.rules))
+	rules = append(rules, pf.reservedRules...)
+	rules = append(rules, pf.dynamicRules...)
+	rules = append(rules, pf.rules...)
+	return rules
+}
+
 func (pf *portForwarder) forwardingAddresses(guest api.IPPort, localUnixIP net.IP) (string, string) {
-	if pf.vmType == limayaml.WSL2 {
+	local, remote, _ := pf.forwardingRule(guest, localUnixIP)
+	return local, remote
+}
+
+// forwardingRule returns the host address and the guest address for forwarding the guest address,
+// and the hostPortFallback of the matching rule.
+func (pf *portForwarder) forwardingRule(guest api.IPPort, localUnixIP net.IP) (string, string, limayaml.HostPortFallback) {
+	if pf.vmType == limayaml.WSL2 && guest.Proto() == api.TCP {
 		guest.IP = localUnixIP
 		host := api.IPPort{
 			IP:   net.ParseIP("127.0.0.1"),
 			Port: guest.Port,
 		}
-		return host.String(), guest.String()
+		return host.String(), guest.String(), limayaml.HostPortFallbackNone
 	}
-	for _, rule := range pf.rules {
-		if rule.GuestSocket != "" {
+	for _, r := range pf.allRules() {
+		rule := r.PortForward
+		if rule.GuestSocket != "" || rule.Proto != guest.Proto() {
 			continue
 		}
 		if guest.Port < rule.GuestPortRange[0] || guest.Port > rule.GuestPortRange[1] {
@@ -74,33 +207,610 @@ func (pf *portForwarder) forwardingAddresses(guest api.IPPort, localUnixIP net.I
 			}
 			break
 		}
-		return hostAddress(rule, guest), guest.String()
+		return hostAddress(rule, guest), guest.String(), rule.HostPortFallback
 	}
-	return "", guest.String()
+	return "", guest.String(), limayaml.HostPortFallbackNone
 }
 
 func (pf *portForwarder) OnEvent(ctx context.Context, ev api.Event, instSSHAddress string) {
-	localUnixIP := net.ParseIP(instSSHAddress)
+	defer pf.runSSHOps(ctx)
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	pf.localUnixIP = net.ParseIP(instSSHAddress)
 
 	for _, f := range ev.LocalPortsRemoved {
-		local, remote := pf.forwardingAddresses(f, localUnixIP)
-		if local == "" {
+		delete(pf.guestPorts, f.Key())
+		pf.stopForwarding(ctx, f)
+	}
+	for _, f := range ev.LocalPortsAdded {
+		pf.guestPorts[f.Key()] = f
+		pf.startForwarding(ctx, f)
+	}
+}
+
+// hostAddresses returns the host addresses and the guest address for forwarding the guest address.
+// The guest addresses with an IPv6 address (e.g., "::" and "::1") that are forwarded to 127.0.0.1
+// are forwarded to ::1 too.
+func (pf *portForwarder) hostAddresses(guest api.IPPort) ([]string, string, limayaml.HostPortFallback) {
+	local, remote, fallback := pf.forwardingRule(guest, pf.localUnixIP)
+	if local == "" {
+		return nil, remote, fallback
+	}
+	locals := []string{local}
+	if guest.IP.To4() == nil {
+		if host, port, err := net.SplitHostPort(local); err == nil && net.ParseIP(host).Equal(api.IPv4loopback1) {
+			locals = append(locals, net.JoinHostPort(net.IPv6loopback.String(), port))
+		}
+	}
+	return locals, remote, fallback
+}
+
+// startForwarding must be called with pf.mu held.
+func (pf *portForwarder) startForwarding(ctx context.Context, guest api.IPPort) {
+	proto := strings.ToUpper(guest.Proto())
+	locals, remote, fallback := pf.hostAddresses(guest)
+	if len(locals) == 0 {
+		logrus.Infof("Not forwarding %s %s", proto, remote)
+		return
+	}
+	for _, local := range locals {
+		f := forward{local: local, remote: remote, ruleLocal: local}
+		if err := pf.policy.CheckHostAddress(local); err != nil {
+			logrus.WithError(err).Warnf("Not forwarding %s %s to %s", proto, remote, local)
+			pf.reportViolation(ctx, guest.Proto(), local, remote, "", err)
 			continue
 		}
-		logrus.Infof("Stopping forwarding TCP from %s to %s", remote, local)
-		if err := forwardTCP(ctx, pf.sshConfig, pf.sshHostPort, local, remote, verbCancel); err != nil {
-			logrus.WithError(err).Warnf("failed to stop forwarding tcp port %d", f.Port)
+		if pf.alreadyAccessible(local, guest) {
+			logrus.Infof("Not forwarding %s %s, as the guest shares the network with the host", proto, remote)
+		} else {
+			if pf.isForwarded(guest.Proto(), local) {
+				logrus.Infof("Not forwarding %s %s, as %s is already forwarded from another guest address", proto, remote, local)
+				continue
+			}
+			if err := checkHostAddressAvailable(guest.Proto(), local); err != nil {
+				f.local = pf.resolveConflict(ctx, guest, local, remote, fallback, err)
+				if f.local == "" {
+					continue
+				}
+			}
+			logrus.Infof("Forwarding %s from %s to %s", proto, remote, f.local)
+			if err := pf.forward(ctx, &f, guest); err != nil {
+				logrus.WithError(err).Warnf("failed to set up forwarding %s port %d to %s", guest.Proto(), guest.Port, f.local)
+				continue
+			}
+		}
+		pf.forwards[guest.Key()] = append(pf.forwards[guest.Key()], f)
+		if f.sshSeq != 0 || (f.inner != nil && f.inner.sshSeq != 0) {
+			// PortForwardAdded is emitted by runSSHOps, after setting up the SSH forward
+			continue
 		}
+		pf.emitPortForwardEvent(ctx, events.PortForwardAdded, guest.Proto(), f.local, remote)
 	}
-	for _, f := range ev.LocalPortsAdded {
-		local, remote := pf.forwardingAddresses(f, localUnixIP)
-		if local == "" {
-			logrus.Infof("Not forwarding TCP %s", remote)
+}
+
+// checkHostAddressAvailable returns an error if the host address cannot be bound.
+func checkHostAddressAvailable(proto, local string) error {
+	if proto == api.UDP {
+		conn, err := net.ListenPacket("udp", local)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	ln, err := net.Listen("tcp", local)
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
+
+// isForwarded returns true if the host address is already forwarded from another guest address,
+// e.g., the guest listens on both "0.0.0.0:80" and ":::80".
+// The host address of the rule is matched too, so that a forward remapped by the fallback
+// is not remapped again for another guest address.
+// isForwarded must be called with pf.mu held.
+func (pf *portForwarder) isForwarded(proto, local string) bool {
+	for key, forwards := range pf.forwards {
+		if guest := pf.guestPorts[key]; guest.Proto() != proto {
+			continue
+		}
+		for _, f := range forwards {
+			if f.local == local || f.ruleLocal == local {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveConflict reports that the host address local cannot be bound, and returns the host address
+// to forward to instead, according to fallback. An empty string is returned when the guest address
+// should not be forwarded.
+// resolveConflict must be called with pf.mu held.
+func (pf *portForwarder) resolveConflict(ctx context.Context, guest api.IPPort, local, remote string, fallback limayaml.HostPortFallback, bindErr error) string {
+	proto := strings.ToUpper(guest.Proto())
+	if !isAddrInUse(bindErr) {
+		// e.g., a privileged port, or the host does not support IPv6.
+		// Leave the error to the forwarding, as before the conflict detection.
+		return local
+	}
+	err := fmt.Errorf("host address %s is already in use", local)
+	var alt string
+	if fallback == limayaml.HostPortFallbackNextFree {
+		alt = pf.nextFreeHostAddress(guest.Proto(), local)
+	}
+	if alt == "" {
+		logrus.WithError(err).Warnf("Not forwarding %s %s", proto, remote)
+	} else {
+		logrus.WithError(err).Warnf("Forwarding %s %s to %s instead of %s", proto, remote, alt, local)
+	}
+	if pf.emitEvent != nil {
+		pf.emitEvent(ctx, events.Event{
+			PortForward: &events.PortForwardEvent{
+				Type:  events.PortForwardConflict,
+				Proto: guest.Proto(),
+				Guest: remote,
+				Host:  local,
+				Error: err.Error(),
+			},
+		})
+	}
+	return alt
+}
+
+// maxNextFreeHostPorts is the maximum number of the host ports scanned by nextFreeHostAddress.
+const maxNextFreeHostPorts = 100
+
+// nextFreeHostAddress returns the host address with the next free port after the port of local,
+// that is allowed by the policy. At most maxNextFreeHostPorts ports are scanned, and the scan stops
+// at the end of the host port range of the policy.
+// An empty string is returned when no port is free.
+// nextFreeHostAddress must be called with pf.mu held.
+func (pf *portForwarder) nextFreeHostAddress(proto, local string) string {
+	host, portStr, err := net.SplitHostPort(local)
+	if err != nil {
+		return ""
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return ""
+	}
+	last := port + maxNextFreeHostPorts
+	if last > 65535 {
+		last = 65535
+	}
+	if pf.policy != nil && pf.policy.HostPortRange != [2]int{0, 0} && last > pf.policy.HostPortRange[1] {
+		last = pf.policy.HostPortRange[1]
+	}
+	for p := port + 1; p <= last; p++ {
+		candidate := net.JoinHostPort(host, strconv.Itoa(p))
+		if pf.policy.CheckHostAddress(candidate) != nil || pf.isForwarded(proto, candidate) {
 			continue
 		}
-		logrus.Infof("Forwarding TCP from %s to %s", remote, local)
-		if err := forwardTCP(ctx, pf.sshConfig, pf.sshHostPort, local, remote, verbForward); err != nil {
-			logrus.WithError(err).Warnf("failed to set up forwarding tcp port %d (negligible if already forwarded)", f.Port)
+		if err := checkHostAddressAvailable(proto, candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// forward sets up forwarding f.local to f.remote.
+// When the policy checks the clients, the TCP connections are relayed via a proxy.
+// The host sockets are not subject to the policy, and are forwarded directly.
+// forward must be called with pf.mu held.
+func (pf *portForwarder) forward(ctx context.Context, f *forward, guest api.IPPort) error {
+	if guest.Proto() == api.UDP || !pf.policy.FiltersClients() || strings.HasPrefix(f.local, "/") {
+		return pf.forwardDirect(ctx, f, guest)
+	}
+	innerLocal, err := pf.innerLocal()
+	if err != nil {
+		return err
+	}
+	inner := &forward{local: innerLocal, remote: f.remote}
+	if err := pf.forwardDirect(ctx, inner, guest); err != nil {
+		return err
+	}
+	local, remote := f.local, f.remote
+	proxy, err := newTCPProxy(local, inner.local, func(client *net.TCPAddr) bool {
+		return pf.allowClient(ctx, api.TCP, local, remote, client.IP, client.String())
+	})
+	if err != nil {
+		if inner.sshSeq != 0 {
+			pf.dequeueSSHForward(inner.sshSeq)
+		}
+		_ = pf.release(*inner, guest)
+		return err
+	}
+	go proxy.Serve()
+	f.proxy, f.inner = proxy, inner
+	return nil
+}
+
+// innerLocal returns a new host address for the inner forward behind a proxy.
+//
+// The inner forward is a Unix socket in a private directory, so that the other users of the host
+// cannot connect to the guest port without being checked by the proxy.
+// On Windows, where SSH cannot forward Unix sockets, the inner forward is a free loopback TCP port.
+// Note that any local process can connect to the loopback port without being checked by the policy,
+// and that the port may be taken by another process between finding it and binding it.
+// innerLocal must be called with pf.mu held.
+func (pf *portForwarder) innerLocal() (string, error) {
+	if runtime.GOOS == "windows" {
+		port, err := findFreeTCPLocalPort()
+		if err != nil {
+			return "", err
+		}
+		return net.JoinHostPort(api.IPv4loopback1.String(), strconv.Itoa(port)), nil
+	}
+	if pf.innerDir == "" {
+		// Not in the instance directory, to keep the socket paths short
+		dir, err := os.MkdirTemp("/tmp", "lima-pf-")
+		if err != nil {
+			return "", err
+		}
+		pf.innerDir = dir
+	}
+	pf.lastInnerSeq++
+	return filepath.Join(pf.innerDir, strconv.Itoa(pf.lastInnerSeq)+".sock"), nil
+}
+
+// forwardDirect sets up forwarding f.local to f.remote without a proxy.
+// The forwards via usernetExposer are preferred, and fall back to the guest agent (UDP) or SSH (TCP).
+// The SSH forwards are queued, see runSSHOps.
+// forwardDirect must be called with pf.mu held.
+func (pf *portForwarder) forwardDirect(ctx context.Context, f *forward, guest api.IPPort) error {
+	// The clients of the UDP forwards via usernetExposer cannot be checked
+	useUsernet := guest.Proto() == api.TCP || !pf.policy.FiltersClients()
+	if pf.usernet != nil && guest.IP.IsUnspecified() && useUsernet {
+		err := pf.usernet.Expose(f.local, guest.Port, guest.Proto())
+		if err == nil {
+			f.usernet = true
+			return nil
+		}
+		logrus.WithError(err).Debugf("failed to forward %s port %d to %s via usernet, falling back", guest.Proto(), guest.Port, f.local)
+	}
+	if guest.Proto() == api.UDP {
+		var err error
+		f.udp, err = pf.forwardUDP(ctx, f.local, f.remote)
+		return err
+	}
+	pf.lastSSHSeq++
+	f.sshSeq = pf.lastSSHSeq
+	pf.sshOps = append(pf.sshOps, sshOp{verb: verbForward, local: f.local, remote: f.remote, guest: guest, seq: f.sshSeq})
+	return nil
+}
+
+// forwardUDP starts relaying the datagrams received on the host address local to the guest address remote.
+func (pf *portForwarder) forwardUDP(ctx context.Context, local, remote string) (*udpForwarder, error) {
+	if pf.dialUDP == nil {
+		return nil, errors.New("UDP forwarding is not available")
+	}
+	f, err := newUDPForwarder(local, func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return pf.dialUDP(ctx, remote)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pf.policy.FiltersClients() {
+		f.allow = func(src *net.UDPAddr) bool {
+			return pf.allowClient(ctx, api.UDP, local, remote, src.IP, src.String())
+		}
+	}
+	go f.Serve(ctx)
+	return f, nil
+}
+
+// stopForwarding must be called with pf.mu held.
+func (pf *portForwarder) stopForwarding(ctx context.Context, guest api.IPPort) {
+	forwards := pf.forwards[guest.Key()]
+	delete(pf.forwards, guest.Key())
+	proto := strings.ToUpper(guest.Proto())
+	for _, f := range forwards {
+		if !pf.alreadyAccessible(f.local, guest) {
+			logrus.Infof("Stopping forwarding %s from %s to %s", proto, f.remote, f.local)
+			if err := pf.unforward(ctx, f, guest); err != nil {
+				logrus.WithError(err).Warnf("failed to stop forwarding %s port %d", guest.Proto(), guest.Port)
+			}
+		}
+		pf.emitPortForwardEvent(ctx, events.PortForwardRemoved, guest.Proto(), f.local, f.remote)
+	}
+}
+
+// unforward tears down f. The SSH forwards are cancelled by the queued commands, see runSSHOps.
+// unforward must be called with pf.mu held.
+func (pf *portForwarder) unforward(ctx context.Context, f forward, guest api.IPPort) error {
+	switch {
+	case f.proxy != nil:
+		return errors.Join(f.proxy.Close(), pf.unforward(ctx, *f.inner, guest))
+	case f.usernet || f.udp != nil:
+		return pf.release(f, guest)
+	}
+	if !pf.dequeueSSHForward(f.sshSeq) {
+		pf.sshOps = append(pf.sshOps, sshOp{verb: verbCancel, local: f.local, remote: f.remote})
+	}
+	return nil
+}
+
+// dequeueSSHForward removes the queued SSH forward with seq, and returns true if it was queued.
+// dequeueSSHForward must be called with pf.mu held.
+func (pf *portForwarder) dequeueSSHForward(seq int) bool {
+	for i, op := range pf.sshOps {
+		if op.verb == verbForward && op.seq == seq {
+			pf.sshOps = append(pf.sshOps[:i], pf.sshOps[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// runSSHOps runs the SSH commands queued with pf.mu held, in the queued order.
+// The SSH commands are run without pf.mu held, as they may take long, e.g., when the SSH master is not responding.
+// A forward is removed when the SSH command for setting it up fails.
+// runSSHOps must be called without pf.mu held.
+func (pf *portForwarder) runSSHOps(ctx context.Context) {
+	pf.sshMu.Lock()
+	defer pf.sshMu.Unlock()
+	for {
+		pf.mu.Lock()
+		ops := pf.sshOps
+		pf.sshOps = nil
+		pf.mu.Unlock()
+		if len(ops) == 0 {
+			return
+		}
+		for _, op := range ops {
+			err := forwardTCP(ctx, pf.sshConfig, pf.sshHostPort, op.local, op.remote, op.verb)
+			if op.verb == verbCancel {
+				if err != nil {
+					logrus.WithError(err).Warnf("failed to stop forwarding %s to %s", op.remote, op.local)
+				}
+				continue
+			}
+			pf.onSSHForward(ctx, op, err)
+		}
+	}
+}
+
+// onSSHForward emits PortForwardAdded for the forward set up by op, or removes the forward if err is not nil.
+func (pf *portForwarder) onSSHForward(ctx context.Context, op sshOp, err error) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	key := op.guest.Key()
+	for i, f := range pf.forwards[key] {
+		if f.sshSeq != op.seq && (f.inner == nil || f.inner.sshSeq != op.seq) {
+			continue
+		}
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to set up forwarding %s port %d to %s", op.guest.Proto(), op.guest.Port, f.local)
+			if releaseErr := pf.release(f, op.guest); releaseErr != nil {
+				logrus.WithError(releaseErr).Warnf("failed to stop forwarding %s port %d to %s", op.guest.Proto(), op.guest.Port, f.local)
+			}
+			pf.forwards[key] = append(pf.forwards[key][:i], pf.forwards[key][i+1:]...)
+			if len(pf.forwards[key]) == 0 {
+				delete(pf.forwards, key)
+			}
+			return
+		}
+		pf.emitPortForwardEvent(ctx, events.PortForwardAdded, op.guest.Proto(), f.local, f.remote)
+		return
+	}
+}
+
+// release tears down the parts of f that are not torn down together with the SSH master,
+// i.e., the forwards via usernetExposer, the UDP forwards, and the proxies.
+// The SSH forwards behind the proxies are not cancelled.
+func (pf *portForwarder) release(f forward, guest api.IPPort) error {
+	switch {
+	case f.proxy != nil:
+		return errors.Join(f.proxy.Close(), pf.release(*f.inner, guest))
+	case f.usernet:
+		return pf.usernet.Unexpose(f.local, guest.Proto())
+	case f.udp != nil:
+		return f.udp.Close()
+	}
+	return nil
+}
+
+// Close releases the forwards, see release.
+func (pf *portForwarder) Close() error {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	var errs []error
+	for key, forwards := range pf.forwards {
+		guest := pf.guestPorts[key]
+		for _, f := range forwards {
+			if err := pf.release(f, guest); err != nil {
+				errs = append(errs, fmt.Errorf("failed to stop forwarding %s: %w", f.local, err))
+			}
+		}
+		delete(pf.forwards, key)
+	}
+	// The SSH forwards are torn down together with the SSH master
+	pf.sshOps = nil
+	if pf.innerDir != "" {
+		if err := os.RemoveAll(pf.innerDir); err != nil {
+			errs = append(errs, err)
+		}
+		pf.innerDir = ""
+	}
+	return errors.Join(errs...)
+}
+
+// alreadyAccessible returns true if the guest port is accessible on the host without forwarding,
+// i.e., the guest shares the network namespace with the host (the fake driver), and the host port is the same.
+func (pf *portForwarder) alreadyAccessible(local string, guest api.IPPort) bool {
+	if pf.vmType != limayaml.Fake {
+		return false
+	}
+	_, port, err := net.SplitHostPort(local)
+	return err == nil && port == strconv.Itoa(guest.Port)
+}
+
+// allowClient checks the client of the forward from remote to local with the policy,
+// and reports the violation.
+func (pf *portForwarder) allowClient(ctx context.Context, proto, local, remote string, ip net.IP, client string) bool {
+	err := pf.policy.CheckClient(ip)
+	if err == nil {
+		return true
+	}
+	pf.reportViolation(ctx, proto, local, remote, client, err)
+	return false
+}
+
+// reportViolation logs the violation and emits an event.
+// The same violation is not reported again within violationReportInterval,
+// so that a flood of the rejected clients does not flood the events.
+func (pf *portForwarder) reportViolation(ctx context.Context, proto, local, remote, client string, err error) {
+	clientIP := client
+	if host, _, splitErr := net.SplitHostPort(client); splitErr == nil {
+		clientIP = host
+	}
+	key := strings.Join([]string{proto, local, clientIP}, "|")
+	now := time.Now()
+	pf.violationsMu.Lock()
+	if last, ok := pf.violations[key]; ok && now.Sub(last) < violationReportInterval {
+		pf.violationsMu.Unlock()
+		return
+	}
+	for k, t := range pf.violations {
+		if now.Sub(t) >= violationReportInterval {
+			delete(pf.violations, k)
+		}
+	}
+	pf.violations[key] = now
+	pf.violationsMu.Unlock()
+	if client != "" {
+		logrus.WithError(err).Warnf("Rejected %s client %s of %s", strings.ToUpper(proto), client, local)
+	}
+	if pf.emitEvent == nil {
+		return
+	}
+	pf.emitEvent(ctx, events.Event{
+		PortForward: &events.PortForwardEvent{
+			Type:   events.PortForwardViolation,
+			Proto:  proto,
+			Guest:  remote,
+			Host:   local,
+			Client: client,
+			Error:  err.Error(),
+		},
+	})
+}
+
+func (pf *portForwarder) emitPortForwardEvent(ctx context.Context, typ events.PortForwardEventType, proto, local, remote string) {
+	if pf.emitEvent == nil {
+		return
+	}
+	pf.emitEvent(ctx, events.Event{
+		PortForward: &events.PortForwardEvent{
+			Type:  typ,
+			Proto: proto,
+			Guest: remote,
+			Host:  local,
+		},
+	})
+}
+
+// reconcile applies the current rules to the ports that are already listened in the guest.
+// reconcile must be called with pf.mu held.
+func (pf *portForwarder) reconcile(ctx context.Context) {
+	for key, guest := range pf.guestPorts {
+		locals, _, _ := pf.hostAddresses(guest)
+		forwards := pf.forwards[key]
+		if len(forwards) == len(locals) {
+			unchanged := true
+			for i, f := range forwards {
+				if f.ruleLocal != locals[i] {
+					unchanged = false
+				}
+			}
+			if unchanged {
+				continue
+			}
+		}
+		pf.stopForwarding(ctx, guest)
+		pf.startForwarding(ctx, guest)
+	}
+}
+
+// ActiveForwards returns the guest ports that are currently forwarded to the host, sorted by the guest address.
+func (pf *portForwarder) ActiveForwards() hostagentapi.ActivePortForwards {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	var res hostagentapi.ActivePortForwards
+	for key, forwards := range pf.forwards {
+		guest := pf.guestPorts[key]
+		for _, f := range forwards {
+			res = append(res, hostagentapi.ActivePortForward{
+				Proto:    guest.Proto(),
+				Guest:    f.remote,
+				Host:     f.local,
+				Remapped: f.local != f.ruleLocal,
+			})
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Guest != res[j].Guest {
+			return res[i].Guest < res[j].Guest
+		}
+		if res[i].Proto != res[j].Proto {
+			return res[i].Proto < res[j].Proto
+		}
+		return res[i].Host < res[j].Host
+	})
+	return res
+}
+
+// Rules returns the port forwarding rules in the order of evaluation.
+func (pf *portForwarder) Rules() []hostagentapi.PortForward {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	return pf.allRules()
+}
+
+// AddRule adds a dynamic rule, and applies it to the ports that are already listened in the guest.
+// The rule must be filled with defaults.
+func (pf *portForwarder) AddRule(ctx context.Context, rule limayaml.PortForward) (*hostagentapi.PortForward, error) {
+	if rule.GuestSocket != "" || rule.HostSocket != "" {
+		return nil, errors.New("socket forwarding rules cannot be added at runtime")
+	}
+	if err := limayaml.ValidatePortForward("portForward", rule); err != nil {
+		return nil, err
+	}
+	if err := pf.policy.CheckRule(rule); err != nil {
+		pf.reportViolation(ctx, rule.Proto, ruleHostAddress(rule), ruleGuestAddress(rule), "", err)
+		return nil, err
+	}
+	defer pf.runSSHOps(ctx)
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	pf.lastID++
+	r := hostagentapi.PortForward{
+		ID:          pf.lastID,
+		Dynamic:     true,
+		PortForward: rule,
+	}
+	pf.dynamicRules = append(pf.dynamicRules, r)
+	pf.reconcile(ctx)
+	return &r, nil
+}
+
+// RemoveRule removes a dynamic rule, and stops the forwards that no longer match any rule.
+func (pf *portForwarder) RemoveRule(ctx context.Context, id int) error {
+	defer pf.runSSHOps(ctx)
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	for i, r := range pf.dynamicRules {
+		if r.ID == id {
+			pf.dynamicRules = append(pf.dynamicRules[:i], pf.dynamicRules[i+1:]...)
+			pf.reconcile(ctx)
+			return nil
+		}
+	}
+	for _, r := range pf.allRules() {
+		if r.ID == id {
+			return fmt.Errorf("port forwarding rule %d is not dynamic, and can only be removed by editing the instance", id)
 		}
 	}
+	return fmt.Errorf("%w: %d", ErrPortForwardNotFound, id)
 }
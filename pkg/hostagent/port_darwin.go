@@ -2,12 +2,14 @@ package hostagent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/lima-vm/lima/pkg/bicopy"
 	"github.com/lima-vm/lima/pkg/guestagent/api"
@@ -160,3 +162,8 @@ func (plf *pseudoLoopbackForwarder) Close() error {
 func getFreeVSockPort() (int, error) {
 	return 0, nil
 }
+
+// isAddrInUse returns true if err is EADDRINUSE.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
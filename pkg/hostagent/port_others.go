@@ -5,6 +5,8 @@ package hostagent
 
 import (
 	"context"
+	"errors"
+	"syscall"
 
 	"github.com/lima-vm/sshocker/pkg/ssh"
 )
@@ -16,3 +18,8 @@ func forwardTCP(ctx context.Context, sshConfig *ssh.SSHConfig, port int, local,
 func getFreeVSockPort() (int, error) {
 	return 0, nil
 }
+
+// isAddrInUse returns true if err is EADDRINUSE.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE)
+}
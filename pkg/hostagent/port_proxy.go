@@ -0,0 +1,64 @@
+package hostagent
+
+import (
+	"net"
+	"strings"
+
+	"github.com/lima-vm/lima/pkg/bicopy"
+	"github.com/sirupsen/logrus"
+)
+
+// tcpProxy relays the TCP connections accepted on a host address to the backend address,
+// after checking the client with allow.
+// The backend address is either a TCP address or the path of a Unix socket.
+type tcpProxy struct {
+	ln      net.Listener
+	backend string
+	allow   func(client *net.TCPAddr) bool
+}
+
+func newTCPProxy(local, backend string, allow func(client *net.TCPAddr) bool) (*tcpProxy, error) {
+	ln, err := net.Listen("tcp", local)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpProxy{
+		ln:      ln,
+		backend: backend,
+		allow:   allow,
+	}, nil
+}
+
+// Serve accepts the connections until p is closed.
+func (p *tcpProxy) Serve() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *tcpProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	if client, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !p.allow(client) {
+		return
+	}
+	network := "tcp"
+	if strings.HasPrefix(p.backend, "/") {
+		network = "unix"
+	}
+	backendConn, err := net.Dial(network, p.backend)
+	if err != nil {
+		logrus.WithError(err).Warnf("failed to connect to %s", p.backend)
+		return
+	}
+	defer backendConn.Close()
+	bicopy.Bicopy(conn, backendConn, nil)
+}
+
+// Close stops accepting the connections. The established connections are not closed.
+func (p *tcpProxy) Close() error {
+	return p.ln.Close()
+}
@@ -0,0 +1,633 @@
+package hostagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/guestagent/api"
+	"github.com/lima-vm/lima/pkg/hostagent/events"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/portfwdpolicy"
+	"github.com/lima-vm/sshocker/pkg/ssh"
+	"gotest.tools/v3/assert"
+)
+
+func newTestPortForwarder(t *testing.T) *portForwarder {
+	t.Helper()
+	reserved := limayaml.PortForward{GuestIP: net.IPv4zero, GuestPort: 60022, Ignore: true}
+	limayaml.FillPortForwardDefaults(&reserved, t.TempDir())
+	rule := limayaml.PortForward{GuestIP: api.IPv4loopback1}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	return newPortForwarder(nil, 0, []limayaml.PortForward{reserved}, []limayaml.PortForward{rule}, limayaml.QEMU)
+}
+
+func TestPortForwarderDynamicRules(t *testing.T) {
+	ctx := context.Background()
+	pf := newTestPortForwarder(t)
+	guest := api.IPPort{IP: api.IPv4loopback1, Port: 80}
+
+	local, _ := pf.forwardingAddresses(guest, nil)
+	assert.Equal(t, local, "127.0.0.1:80")
+
+	rule := limayaml.PortForward{GuestPort: 80, HostPort: 8080}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	added, err := pf.AddRule(ctx, rule)
+	assert.NilError(t, err)
+	assert.Assert(t, added.Dynamic)
+	assert.Equal(t, len(pf.Rules()), 3)
+
+	// dynamic rules take precedence over the static rules
+	local, _ = pf.forwardingAddresses(guest, nil)
+	assert.Equal(t, local, "127.0.0.1:8080")
+
+	// but not over the reserved rules
+	sshRule := limayaml.PortForward{GuestPort: 60022, HostPort: 2222}
+	limayaml.FillPortForwardDefaults(&sshRule, t.TempDir())
+	_, err = pf.AddRule(ctx, sshRule)
+	assert.NilError(t, err)
+	local, _ = pf.forwardingAddresses(api.IPPort{IP: api.IPv4loopback1, Port: 60022}, nil)
+	assert.Equal(t, local, "")
+
+	err = pf.RemoveRule(ctx, pf.reservedRules[0].ID)
+	assert.ErrorContains(t, err, "not dynamic")
+	err = pf.RemoveRule(ctx, 42)
+	assert.Assert(t, errors.Is(err, ErrPortForwardNotFound))
+
+	assert.NilError(t, pf.RemoveRule(ctx, added.ID))
+	local, _ = pf.forwardingAddresses(guest, nil)
+	assert.Equal(t, local, "127.0.0.1:80")
+}
+
+func TestPortForwarderAddRuleValidation(t *testing.T) {
+	pf := newTestPortForwarder(t)
+	rule := limayaml.PortForward{GuestPortRange: [2]int{80, 81}, HostPort: 8080}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	_, err := pf.AddRule(context.Background(), rule)
+	assert.ErrorContains(t, err, "hostPortRange")
+
+	rule = limayaml.PortForward{GuestSocket: "/run/foo.sock", HostSocket: "foo.sock"}
+	_, err = pf.AddRule(context.Background(), rule)
+	assert.ErrorContains(t, err, "socket")
+}
+
+func TestPortForwarderAlreadyAccessible(t *testing.T) {
+	guest := api.IPPort{IP: net.IPv4zero, Port: 80}
+	pf := newTestPortForwarder(t)
+	assert.Assert(t, !pf.alreadyAccessible("127.0.0.1:80", guest))
+
+	pf.vmType = limayaml.Fake
+	assert.Assert(t, pf.alreadyAccessible("127.0.0.1:80", guest))
+	assert.Assert(t, !pf.alreadyAccessible("127.0.0.1:8080", guest))
+}
+
+func TestPortForwarderUDPRules(t *testing.T) {
+	pf := newTestPortForwarder(t)
+	guest := api.IPPort{Protocol: api.UDP, IP: api.IPv4loopback1, Port: 5353}
+
+	// the default rule only forwards TCP
+	local, _ := pf.forwardingAddresses(guest, nil)
+	assert.Equal(t, local, "")
+
+	rule := limayaml.PortForward{GuestPort: 5353, HostPort: 15353, Proto: limayaml.UDP}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	_, err := pf.AddRule(context.Background(), rule)
+	assert.NilError(t, err)
+	local, _ = pf.forwardingAddresses(guest, nil)
+	assert.Equal(t, local, "127.0.0.1:15353")
+
+	// the UDP rule does not match TCP
+	local, _ = pf.forwardingAddresses(api.IPPort{IP: api.IPv4loopback1, Port: 5353}, nil)
+	assert.Equal(t, local, "127.0.0.1:5353")
+}
+
+func TestUDPForwarder(t *testing.T) {
+	// echoes the datagrams, like a UDP echo server in the guest
+	dial := func(context.Context) (io.ReadWriteCloser, error) {
+		c1, c2 := net.Pipe()
+		go func() {
+			defer c2.Close()
+			buf := make([]byte, api.MaxDatagramSize)
+			for {
+				n, err := api.ReadDatagram(c2, buf)
+				if err != nil {
+					return
+				}
+				if err := api.WriteDatagram(c2, buf[:n]); err != nil {
+					return
+				}
+			}
+		}()
+		return c1, nil
+	}
+	f, err := newUDPForwarder("127.0.0.1:0", dial)
+	assert.NilError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Serve(ctx)
+
+	conn, err := net.DialUDP("udp", nil, f.conn.LocalAddr().(*net.UDPAddr))
+	assert.NilError(t, err)
+	defer conn.Close()
+	assert.NilError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 64)
+	for _, msg := range []string{"hello", "world"} {
+		_, err = conn.Write([]byte(msg))
+		assert.NilError(t, err)
+		n, err := conn.Read(buf)
+		assert.NilError(t, err)
+		assert.Equal(t, string(buf[:n]), msg)
+	}
+
+	assert.NilError(t, f.Close())
+	f.mu.Lock()
+	assert.Equal(t, len(f.sessions), 0)
+	f.mu.Unlock()
+}
+
+func TestUDPForwarderSlowDial(t *testing.T) {
+	// the first dial blocks until released, the others return immediately
+	release := make(chan struct{})
+	var dials atomic.Int32
+	dial := func(context.Context) (io.ReadWriteCloser, error) {
+		if dials.Add(1) == 1 {
+			<-release
+		}
+		c1, c2 := net.Pipe()
+		go func() {
+			defer c2.Close()
+			buf := make([]byte, api.MaxDatagramSize)
+			for {
+				n, err := api.ReadDatagram(c2, buf)
+				if err != nil {
+					return
+				}
+				if err := api.WriteDatagram(c2, buf[:n]); err != nil {
+					return
+				}
+			}
+		}()
+		return c1, nil
+	}
+	f, err := newUDPForwarder("127.0.0.1:0", dial)
+	assert.NilError(t, err)
+	defer f.Close()
+	go f.Serve(context.Background())
+
+	newClient := func() *net.UDPConn {
+		conn, err := net.DialUDP("udp", nil, f.conn.LocalAddr().(*net.UDPAddr))
+		assert.NilError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		assert.NilError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+		return conn
+	}
+	buf := make([]byte, 64)
+	slow := newClient()
+	_, err = slow.Write([]byte("slow"))
+	assert.NilError(t, err)
+	assert.Assert(t, waitFor(func() bool { return dials.Load() == 1 }))
+
+	// the other clients are not blocked by the pending dial
+	fast := newClient()
+	_, err = fast.Write([]byte("fast"))
+	assert.NilError(t, err)
+	n, err := fast.Read(buf)
+	assert.NilError(t, err)
+	assert.Equal(t, string(buf[:n]), "fast")
+
+	// the datagram queued during the dial is delivered
+	close(release)
+	n, err = slow.Read(buf)
+	assert.NilError(t, err)
+	assert.Equal(t, string(buf[:n]), "slow")
+}
+
+func TestPortForwarderIPv6(t *testing.T) {
+	pf := newTestPortForwarder(t)
+
+	locals, remote, _ := pf.hostAddresses(api.IPPort{IP: net.IPv4zero, Port: 80})
+	assert.DeepEqual(t, locals, []string{"127.0.0.1:80"})
+	assert.Equal(t, remote, "0.0.0.0:80")
+
+	// IPv6 listeners are forwarded to ::1 too
+	locals, remote, _ = pf.hostAddresses(api.IPPort{IP: net.IPv6zero, Port: 80})
+	assert.DeepEqual(t, locals, []string{"127.0.0.1:80", "[::1]:80"})
+	assert.Equal(t, remote, "[::]:80")
+	locals, _, _ = pf.hostAddresses(api.IPPort{IP: net.IPv6loopback, Port: 80})
+	assert.DeepEqual(t, locals, []string{"127.0.0.1:80", "[::1]:80"})
+
+	// the host IP defaults to ::1 for the guest IP ::1
+	rule := limayaml.PortForward{GuestIP: net.IPv6loopback, GuestPort: 80, HostPort: 8080}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	assert.Assert(t, rule.HostIP.Equal(net.IPv6loopback))
+	_, err := pf.AddRule(context.Background(), rule)
+	assert.NilError(t, err)
+	locals, _, _ = pf.hostAddresses(api.IPPort{IP: net.IPv6loopback, Port: 80})
+	assert.DeepEqual(t, locals, []string{"[::1]:8080"})
+	// the rule does not match 127.0.0.1
+	locals, _, _ = pf.hostAddresses(api.IPPort{IP: api.IPv4loopback1, Port: 80})
+	assert.DeepEqual(t, locals, []string{"127.0.0.1:80"})
+	// but matches ::
+	locals, _, _ = pf.hostAddresses(api.IPPort{IP: net.IPv6zero, Port: 80})
+	assert.DeepEqual(t, locals, []string{"[::1]:8080"})
+
+	// guestIPMustBeZero accepts ::
+	rule = limayaml.PortForward{GuestIP: net.IPv6zero, GuestIPMustBeZero: true, GuestPort: 443, HostIP: net.IPv6zero}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	assert.NilError(t, limayaml.ValidatePortForward("portForward", rule))
+}
+
+type fakeUsernetExposer struct {
+	exposed map[string]string
+	err     error
+}
+
+func (e *fakeUsernetExposer) Expose(local string, guestPort int, proto string) error {
+	if e.err != nil {
+		return e.err
+	}
+	e.exposed[local] = fmt.Sprintf("%s/%d", proto, guestPort)
+	return nil
+}
+
+func (e *fakeUsernetExposer) Unexpose(local, _ string) error {
+	delete(e.exposed, local)
+	return nil
+}
+
+func TestPortForwarderUsernet(t *testing.T) {
+	ctx := context.Background()
+	pf := newTestPortForwarder(t)
+	exposer := &fakeUsernetExposer{exposed: make(map[string]string)}
+	pf.usernet = exposer
+
+	port, err := findFreeTCPLocalPort()
+	assert.NilError(t, err)
+	guest := api.IPPort{IP: net.IPv4zero, Port: port}
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{guest}}, "127.0.0.1")
+	assert.DeepEqual(t, exposer.exposed, map[string]string{fmt.Sprintf("127.0.0.1:%d", port): fmt.Sprintf("tcp/%d", port)})
+	assert.Assert(t, pf.forwards[guest.Key()][0].usernet)
+
+	pf.OnEvent(ctx, api.Event{LocalPortsRemoved: []api.IPPort{guest}}, "127.0.0.1")
+	assert.Equal(t, len(exposer.exposed), 0)
+	assert.Equal(t, len(pf.forwards), 0)
+
+	// the forwards are unexposed on closing
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{guest}}, "127.0.0.1")
+	assert.NilError(t, pf.Close())
+	assert.Equal(t, len(exposer.exposed), 0)
+
+	// falls back to the guest agent, which is not available here
+	rule := limayaml.PortForward{GuestPort: 5353, Proto: limayaml.UDP}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	_, err = pf.AddRule(ctx, rule)
+	assert.NilError(t, err)
+	exposer.err = errors.New("proxy already running")
+	udpGuest := api.IPPort{Protocol: api.UDP, IP: net.IPv4zero, Port: 5353}
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{udpGuest}}, "127.0.0.1")
+	assert.Equal(t, len(pf.forwards[udpGuest.Key()]), 0)
+}
+
+func TestPortForwarderPolicy(t *testing.T) {
+	ctx := context.Background()
+	pf := newTestPortForwarder(t)
+	pf.usernet = &fakeUsernetExposer{exposed: make(map[string]string)}
+	var err error
+	pf.policy, err = portfwdpolicy.Parse([]byte(`
+hostIPs: ["127.0.0.1"]
+hostPortRange: [1024, 65535]
+`))
+	assert.NilError(t, err)
+	evCh := make(chan events.Event, 10)
+	pf.emitEvent = func(_ context.Context, ev events.Event) { evCh <- ev }
+
+	port, err := findFreeTCPLocalPort()
+	assert.NilError(t, err)
+	privileged := api.IPPort{IP: net.IPv4zero, Port: 80}
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{privileged, {IP: net.IPv4zero, Port: port}}}, "127.0.0.1")
+	ev := <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardViolation)
+	assert.Equal(t, ev.PortForward.Host, "127.0.0.1:80")
+	assert.ErrorContains(t, errors.New(ev.PortForward.Error), "host port 80")
+	ev = <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardAdded)
+	assert.Equal(t, ev.PortForward.Host, fmt.Sprintf("127.0.0.1:%d", port))
+
+	// the same violation is not reported again within violationReportInterval
+	pf.OnEvent(ctx, api.Event{LocalPortsRemoved: []api.IPPort{privileged}, LocalPortsAdded: []api.IPPort{privileged}}, "127.0.0.1")
+	assert.Equal(t, len(evCh), 0)
+
+	rule := limayaml.PortForward{GuestPort: 8888, HostIP: net.IPv4zero}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	_, err = pf.AddRule(ctx, rule)
+	assert.Assert(t, errors.Is(err, portfwdpolicy.ErrViolation))
+	ev = <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardViolation)
+	assert.Equal(t, ev.PortForward.Host, "0.0.0.0:8888")
+	assert.Equal(t, len(pf.Rules()), 2)
+}
+
+func TestPortForwarderPolicyClients(t *testing.T) {
+	ctx := context.Background()
+	pf := newTestPortForwarder(t)
+	exposer := &fakeUsernetExposer{exposed: make(map[string]string)}
+	pf.usernet = exposer
+	var err error
+	pf.policy, err = portfwdpolicy.Parse([]byte(`allowFrom: ["10.0.0.0/8"]`))
+	assert.NilError(t, err)
+	evCh := make(chan events.Event, 10)
+	pf.emitEvent = func(_ context.Context, ev events.Event) { evCh <- ev }
+
+	port, err := findFreeTCPLocalPort()
+	assert.NilError(t, err)
+	guest := api.IPPort{IP: net.IPv4zero, Port: port}
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{guest}}, "127.0.0.1")
+	ev := <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardAdded)
+	f := pf.forwards[guest.Key()][0]
+	assert.Assert(t, f.proxy != nil)
+	// the guest port is exposed on a private socket behind the proxy
+	assert.Assert(t, f.inner.usernet)
+	assert.Equal(t, len(exposer.exposed), 1)
+	innerDir := pf.innerDir
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, filepath.Dir(f.inner.local), innerDir)
+		st, err := os.Stat(innerDir)
+		assert.NilError(t, err)
+		assert.Equal(t, st.Mode().Perm(), os.FileMode(0o700))
+	}
+
+	conn, err := net.Dial("tcp", f.local)
+	assert.NilError(t, err)
+	defer conn.Close()
+	assert.NilError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Assert(t, errors.Is(err, io.EOF))
+	ev = <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardViolation)
+	assert.Equal(t, ev.PortForward.Host, f.local)
+	assert.ErrorContains(t, errors.New(ev.PortForward.Error), "client 127.0.0.1 is not allowed")
+
+	assert.NilError(t, pf.Close())
+	assert.Equal(t, len(exposer.exposed), 0)
+	if innerDir != "" {
+		_, err = os.Stat(innerDir)
+		assert.Assert(t, os.IsNotExist(err))
+	}
+}
+
+func TestPortForwarderPolicyHostSocket(t *testing.T) {
+	ctx := context.Background()
+	hostSocket := filepath.Join(t.TempDir(), "foo.sock")
+	rule := limayaml.PortForward{GuestPort: 8080, HostSocket: hostSocket}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	pf := newPortForwarder(nil, 0, nil, []limayaml.PortForward{rule}, limayaml.QEMU)
+	exposer := &fakeUsernetExposer{exposed: make(map[string]string)}
+	pf.usernet = exposer
+	var err error
+	pf.policy, err = portfwdpolicy.Parse([]byte(`
+hostIPs: ["127.0.0.1"]
+hostPortRange: [1024, 65535]
+allowFrom: ["10.0.0.0/8"]
+`))
+	assert.NilError(t, err)
+	evCh := make(chan events.Event, 10)
+	pf.emitEvent = func(_ context.Context, ev events.Event) { evCh <- ev }
+
+	// the host socket is neither restricted by the policy, nor proxied
+	guest := api.IPPort{IP: net.IPv4zero, Port: 8080}
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{guest}}, "127.0.0.1")
+	ev := <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardAdded)
+	assert.Equal(t, ev.PortForward.Host, hostSocket)
+	f := pf.forwards[guest.Key()][0]
+	assert.Assert(t, f.proxy == nil)
+	assert.Assert(t, f.usernet)
+	assert.NilError(t, pf.Close())
+}
+
+func TestTCPProxy(t *testing.T) {
+	backends := map[string]string{
+		"tcp":  "127.0.0.1:0",
+		"unix": filepath.Join(t.TempDir(), "backend.sock"),
+	}
+	for network, addr := range backends {
+		t.Run(network, func(t *testing.T) {
+			if network == "unix" && runtime.GOOS == "windows" {
+				t.Skip("the inner forwards are not Unix sockets on Windows")
+			}
+			backend, err := net.Listen(network, addr)
+			assert.NilError(t, err)
+			defer backend.Close()
+			go func() {
+				conn, err := backend.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+
+			p, err := newTCPProxy("127.0.0.1:0", backend.Addr().String(), func(*net.TCPAddr) bool { return true })
+			assert.NilError(t, err)
+			defer p.Close()
+			go p.Serve()
+
+			conn, err := net.Dial("tcp", p.ln.Addr().String())
+			assert.NilError(t, err)
+			defer conn.Close()
+			assert.NilError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+			_, err = conn.Write([]byte("hello"))
+			assert.NilError(t, err)
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(conn, buf)
+			assert.NilError(t, err)
+			assert.Equal(t, string(buf), "hello")
+		})
+	}
+}
+
+func TestPortForwarderConflict(t *testing.T) {
+	ctx := context.Background()
+	pf := newTestPortForwarder(t)
+	pf.usernet = &fakeUsernetExposer{exposed: make(map[string]string)}
+	evCh := make(chan events.Event, 10)
+	pf.emitEvent = func(_ context.Context, ev events.Event) { evCh <- ev }
+
+	// another process (e.g., another instance) uses the host port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer ln.Close()
+	local := ln.Addr().String()
+	port := ln.Addr().(*net.TCPAddr).Port
+	guest := api.IPPort{IP: net.IPv4zero, Port: port}
+
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{guest}}, "127.0.0.1")
+	ev := <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardConflict)
+	assert.Equal(t, ev.PortForward.Host, local)
+	assert.Equal(t, len(pf.ActiveForwards()), 0)
+
+	rule := limayaml.PortForward{GuestPort: port, HostPortFallback: limayaml.HostPortFallbackNextFree}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	_, err = pf.AddRule(ctx, rule)
+	assert.NilError(t, err)
+	ev = <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardConflict)
+	ev = <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardAdded)
+	assert.Assert(t, ev.PortForward.Host != local)
+
+	active := pf.ActiveForwards()
+	assert.Equal(t, len(active), 1)
+	assert.Equal(t, active[0].Host, ev.PortForward.Host)
+	assert.Equal(t, active[0].Guest, guest.String())
+	assert.Assert(t, active[0].Remapped)
+	remapped, err := net.ResolveTCPAddr("tcp", active[0].Host)
+	assert.NilError(t, err)
+	assert.Assert(t, remapped.Port > port)
+	assert.Equal(t, active[0].String(), fmt.Sprintf("%s->%s/tcp", active[0].Host, guest.String()))
+
+	// the remapped forward is kept on reconciling
+	pf.mu.Lock()
+	pf.reconcile(ctx)
+	pf.mu.Unlock()
+	assert.Equal(t, len(evCh), 0)
+}
+
+func TestPortForwarderAlreadyForwarded(t *testing.T) {
+	ctx := context.Background()
+	pf := newTestPortForwarder(t)
+	pf.usernet = &fakeUsernetExposer{exposed: make(map[string]string)}
+	port, err := findFreeTCPLocalPort()
+	assert.NilError(t, err)
+
+	// the guest listens on both 0.0.0.0 and ::
+	guest4 := api.IPPort{IP: net.IPv4zero, Port: port}
+	guest6 := api.IPPort{IP: net.IPv6zero, Port: port}
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{guest4, guest6}}, "127.0.0.1")
+	assert.Equal(t, len(pf.forwards[guest4.Key()]), 1)
+	// 127.0.0.1 is already forwarded from 0.0.0.0, and is not treated as a conflict
+	assert.Equal(t, len(pf.forwards[guest6.Key()]), 1)
+	assert.Equal(t, pf.forwards[guest6.Key()][0].local, fmt.Sprintf("[::1]:%d", port))
+}
+
+func TestPortForwarderConflictIPv6(t *testing.T) {
+	ctx := context.Background()
+	pf := newTestPortForwarder(t)
+	pf.usernet = &fakeUsernetExposer{exposed: make(map[string]string)}
+	pf.emitEvent = func(context.Context, events.Event) {}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	rule := limayaml.PortForward{GuestPort: port, HostPortFallback: limayaml.HostPortFallbackNextFree}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	_, err = pf.AddRule(ctx, rule)
+	assert.NilError(t, err)
+
+	// the guest listens on both 0.0.0.0 and ::
+	guest4 := api.IPPort{IP: net.IPv4zero, Port: port}
+	guest6 := api.IPPort{IP: net.IPv6zero, Port: port}
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{guest4, guest6}}, "127.0.0.1")
+	assert.Equal(t, len(pf.forwards[guest4.Key()]), 1)
+	assert.Assert(t, pf.forwards[guest4.Key()][0].local != ln.Addr().String())
+	// 127.0.0.1 is not remapped again for ::
+	assert.Equal(t, len(pf.forwards[guest6.Key()]), 1)
+	assert.Equal(t, pf.forwards[guest6.Key()][0].local, fmt.Sprintf("[::1]:%d", port))
+	var remapped int
+	for _, f := range pf.ActiveForwards() {
+		if f.Remapped {
+			remapped++
+		}
+	}
+	assert.Equal(t, remapped, 1)
+}
+
+func TestNextFreeHostAddress(t *testing.T) {
+	pf := newTestPortForwarder(t)
+	port, err := findFreeTCPLocalPort()
+	assert.NilError(t, err)
+	if port+maxNextFreeHostPorts+1 > 65535 {
+		t.Skipf("port %d is too large", port)
+	}
+	local := fmt.Sprintf("127.0.0.1:%d", port)
+	beyond := fmt.Sprintf("127.0.0.1:%d", port+maxNextFreeHostPorts+1)
+	if err := checkHostAddressAvailable(api.TCP, beyond); err != nil {
+		t.Skipf("%s is not available: %v", beyond, err)
+	}
+
+	// the ports after local are already forwarded, up to maxNextFreeHostPorts
+	guest := api.IPPort{IP: net.IPv4zero, Port: 1}
+	pf.guestPorts[guest.Key()] = guest
+	for p := port + 1; p <= port+maxNextFreeHostPorts; p++ {
+		addr := fmt.Sprintf("127.0.0.1:%d", p)
+		pf.forwards[guest.Key()] = append(pf.forwards[guest.Key()], forward{local: addr, ruleLocal: addr})
+	}
+	assert.Equal(t, pf.nextFreeHostAddress(api.TCP, local), "")
+
+	delete(pf.forwards, guest.Key())
+	assert.Equal(t, pf.nextFreeHostAddress(api.TCP, local), fmt.Sprintf("127.0.0.1:%d", port+1))
+
+	// the scan stops at the end of the host port range of the policy
+	pf.policy, err = portfwdpolicy.Parse([]byte(fmt.Sprintf("hostPortRange: [1024, %d]", port)))
+	assert.NilError(t, err)
+	assert.Equal(t, pf.nextFreeHostAddress(api.TCP, local), "")
+}
+
+func TestPortForwarderSSHOps(t *testing.T) {
+	ctx := context.Background()
+	pf := newTestPortForwarder(t)
+	evCh := make(chan events.Event, 10)
+	pf.emitEvent = func(_ context.Context, ev events.Event) { evCh <- ev }
+	port, err := findFreeTCPLocalPort()
+	assert.NilError(t, err)
+	guest := api.IPPort{IP: net.IPv4zero, Port: port}
+
+	// the SSH forwards are queued with pf.mu held
+	pf.mu.Lock()
+	pf.guestPorts[guest.Key()] = guest
+	pf.startForwarding(ctx, guest)
+	assert.Equal(t, len(pf.sshOps), 1)
+	assert.Equal(t, pf.sshOps[0].verb, verbForward)
+	assert.Equal(t, len(pf.forwards[guest.Key()]), 1)
+	// PortForwardAdded is not emitted until the SSH forward is set up
+	assert.Equal(t, len(evCh), 0)
+	// the queued SSH forward is dropped, instead of being cancelled after setting it up
+	pf.stopForwarding(ctx, guest)
+	assert.Equal(t, len(pf.sshOps), 0)
+	ev := <-evCh
+	assert.Equal(t, ev.PortForward.Type, events.PortForwardRemoved)
+	pf.mu.Unlock()
+
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not installed")
+	}
+	// the forward is removed when the SSH command fails, as there is no SSH master
+	pf.sshConfig = &ssh.SSHConfig{}
+	pf.OnEvent(ctx, api.Event{LocalPortsAdded: []api.IPPort{guest}}, "127.0.0.1")
+	assert.Equal(t, len(pf.ActiveForwards()), 0)
+	assert.Equal(t, len(pf.sshOps), 0)
+	assert.Equal(t, len(evCh), 0)
+}
+
+// waitFor polls cond for up to 1 second.
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
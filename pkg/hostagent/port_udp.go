@@ -0,0 +1,248 @@
+package hostagent
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/guestagent/api"
+	"github.com/sirupsen/logrus"
+)
+
+// udpSessionIdleTimeout is the duration after which the tunnel of an idle UDP client is closed.
+const udpSessionIdleTimeout = 2 * time.Minute
+
+// udpSessionQueueSize is the number of the datagrams queued for a client while its tunnel is being opened
+// or written. Further datagrams are dropped, as UDP is unreliable anyway.
+const udpSessionQueueSize = 64
+
+// udpForwarder relays the UDP datagrams received on a host address to the guest.
+// Each UDP client on the host gets its own tunnel, so that the replies from the guest
+// can be sent back to the client.
+type udpForwarder struct {
+	conn *net.UDPConn
+	// dial opens a tunnel to the guest address.
+	// The datagrams are read and written with api.ReadDatagram and api.WriteDatagram.
+	dial func(ctx context.Context) (io.ReadWriteCloser, error)
+	// allow checks the client of the datagram. allow may be nil.
+	allow func(src *net.UDPAddr) bool
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+	closed   bool
+	done     chan struct{}
+}
+
+// udpSession is the tunnel of a UDP client.
+// The tunnel is opened and written by the goroutine of the session, so that a slow dial
+// does not block the other clients.
+type udpSession struct {
+	queue      chan []byte
+	lastActive atomic.Int64 // UnixNano
+
+	mu     sync.Mutex
+	tunnel io.ReadWriteCloser // nil until dialed
+	closed chan struct{}
+}
+
+func newUDPSession() *udpSession {
+	sess := &udpSession{
+		queue:  make(chan []byte, udpSessionQueueSize),
+		closed: make(chan struct{}),
+	}
+	sess.touch()
+	return sess
+}
+
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// setTunnel sets the tunnel of the session. setTunnel closes the tunnel and returns false if the session is closed.
+func (s *udpSession) setTunnel(tunnel io.ReadWriteCloser) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+		_ = tunnel.Close()
+		return false
+	default:
+	}
+	s.tunnel = tunnel
+	return true
+}
+
+func (s *udpSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+	close(s.closed)
+	if s.tunnel != nil {
+		_ = s.tunnel.Close()
+	}
+}
+
+func newUDPForwarder(local string, dial func(ctx context.Context) (io.ReadWriteCloser, error)) (*udpForwarder, error) {
+	addr, err := net.ResolveUDPAddr("udp", local)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpForwarder{
+		conn:     conn,
+		dial:     dial,
+		sessions: make(map[string]*udpSession),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Serve relays the datagrams until f is closed or ctx is cancelled.
+func (f *udpForwarder) Serve(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(udpSessionIdleTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				f.Close()
+				return
+			case <-f.done:
+				return
+			case <-ticker.C:
+				f.closeIdleSessions()
+			}
+		}
+	}()
+	buf := make([]byte, api.MaxDatagramSize)
+	for {
+		n, src, err := f.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-f.done:
+			default:
+				logrus.WithError(err).Warnf("failed to read from %s", f.conn.LocalAddr())
+			}
+			return
+		}
+		if f.allow != nil && !f.allow(src) {
+			continue
+		}
+		sess, ok := f.session(ctx, src)
+		if !ok {
+			return
+		}
+		sess.touch()
+		select {
+		case sess.queue <- append([]byte(nil), buf[:n]...):
+		default:
+			logrus.Debugf("dropping a datagram from %s, the tunnel is busy", src)
+		}
+	}
+}
+
+// session returns the session of src, creating it if it does not exist.
+// session returns false if f is closed.
+func (f *udpForwarder) session(ctx context.Context, src *net.UDPAddr) (*udpSession, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, false
+	}
+	key := src.String()
+	if sess, ok := f.sessions[key]; ok {
+		return sess, true
+	}
+	sess := newUDPSession()
+	f.sessions[key] = sess
+	go f.run(ctx, src, sess)
+	return sess, true
+}
+
+// run opens the tunnel of the session, and sends the queued datagrams from src to the guest.
+func (f *udpForwarder) run(ctx context.Context, src *net.UDPAddr, sess *udpSession) {
+	defer f.closeSession(src.String(), sess)
+	tunnel, err := f.dial(ctx)
+	if err != nil {
+		logrus.WithError(err).Warnf("failed to open a UDP tunnel for %s", src)
+		return
+	}
+	if !sess.setTunnel(tunnel) {
+		return
+	}
+	go f.receive(src, sess, tunnel)
+	for {
+		select {
+		case <-sess.closed:
+			return
+		case b := <-sess.queue:
+			if err := api.WriteDatagram(tunnel, b); err != nil {
+				logrus.WithError(err).Debugf("failed to send a datagram from %s", src)
+				return
+			}
+		}
+	}
+}
+
+// receive sends the datagrams from the guest back to src.
+func (f *udpForwarder) receive(src *net.UDPAddr, sess *udpSession, tunnel io.Reader) {
+	defer f.closeSession(src.String(), sess)
+	buf := make([]byte, api.MaxDatagramSize)
+	for {
+		n, err := api.ReadDatagram(tunnel, buf)
+		if err != nil {
+			return
+		}
+		sess.touch()
+		if _, err := f.conn.WriteToUDP(buf[:n], src); err != nil {
+			logrus.WithError(err).Debugf("failed to send a datagram to %s", src)
+		}
+	}
+}
+
+func (f *udpForwarder) closeSession(key string, sess *udpSession) {
+	f.mu.Lock()
+	if f.sessions[key] == sess {
+		delete(f.sessions, key)
+	}
+	f.mu.Unlock()
+	sess.close()
+}
+
+func (f *udpForwarder) closeIdleSessions() {
+	deadline := time.Now().Add(-udpSessionIdleTimeout).UnixNano()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, sess := range f.sessions {
+		if sess.lastActive.Load() < deadline {
+			delete(f.sessions, key)
+			sess.close()
+		}
+	}
+}
+
+// Close stops listening on the host address, and closes all the tunnels.
+// The tunnels that are still being opened are closed as soon as they are opened.
+func (f *udpForwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	close(f.done)
+	for key, sess := range f.sessions {
+		delete(f.sessions, key)
+		sess.close()
+	}
+	return f.conn.Close()
+}
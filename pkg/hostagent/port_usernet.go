@@ -0,0 +1,57 @@
+package hostagent
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lima-vm/lima/pkg/networks/usernet"
+)
+
+// usernetPortExposer implements usernetExposer with usernet.Client.
+type usernetPortExposer struct {
+	client     *usernet.Client
+	macAddress string
+
+	mu sync.Mutex
+	// guestIP is resolved from the DHCP leases on the first use
+	guestIP string
+}
+
+func (e *usernetPortExposer) resolveGuestIP() (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.guestIP == "" {
+		ip, err := e.client.ResolveIPAddress(e.macAddress)
+		if err != nil {
+			return "", err
+		}
+		e.guestIP = ip
+	}
+	return e.guestIP, nil
+}
+
+// Expose forwards the host address local to the guest port.
+// When local is the path of a Unix socket, the socket is forwarded to the TCP guest port.
+func (e *usernetPortExposer) Expose(local string, guestPort int, proto string) error {
+	guestIP, err := e.resolveGuestIP()
+	if err != nil {
+		return err
+	}
+	remote := net.JoinHostPort(guestIP, strconv.Itoa(guestPort))
+	if strings.HasPrefix(local, "/") {
+		return e.client.Expose(local, "tcp://"+remote, usernetUnixProto)
+	}
+	return e.client.Expose(local, remote, proto)
+}
+
+func (e *usernetPortExposer) Unexpose(local, proto string) error {
+	if strings.HasPrefix(local, "/") {
+		proto = usernetUnixProto
+	}
+	return e.client.Unexpose(local, proto)
+}
+
+// usernetUnixProto is the gvproxy protocol for forwarding Unix sockets.
+const usernetUnixProto = "unix"
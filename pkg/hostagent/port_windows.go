@@ -5,9 +5,11 @@ package hostagent
 
 import (
 	"context"
+	"errors"
 
 	"github.com/lima-vm/lima/pkg/windows"
 	"github.com/lima-vm/sshocker/pkg/ssh"
+	winsys "golang.org/x/sys/windows"
 )
 
 func forwardTCP(ctx context.Context, sshConfig *ssh.SSHConfig, port int, local, remote string, verb string) error {
@@ -17,3 +19,8 @@ func forwardTCP(ctx context.Context, sshConfig *ssh.SSHConfig, port int, local,
 func getFreeVSockPort() (int, error) {
 	return windows.GetRandomFreeVSockPort(0, 2147483647)
 }
+
+// isAddrInUse returns true if err is WSAEADDRINUSE.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, winsys.WSAEADDRINUSE)
+}
@@ -1,38 +1,70 @@
 package hostagent
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
 	guestagentclient "github.com/lima-vm/lima/pkg/guestagent/api/client"
+	"github.com/lima-vm/lima/pkg/hostagent/events"
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/lima-vm/sshocker/pkg/ssh"
 	"github.com/sirupsen/logrus"
 )
 
-func (a *HostAgent) waitForRequirements(label string, requirements []requirement) error {
+func (a *HostAgent) waitForRequirements(ctx context.Context, label string, requirements []requirement) error {
 	const (
 		retries       = 60
 		sleepDuration = 10 * time.Second
 	)
 	var errs []error
 
+	progress := make([]events.RequirementEvent, len(requirements))
 	for i, req := range requirements {
+		progress[i] = events.RequirementEvent{
+			Label:       label,
+			Name:        req.name,
+			Index:       i + 1,
+			Total:       len(requirements),
+			Description: req.description,
+			State:       events.RequirementPending,
+		}
+		a.emitRequirementEvent(ctx, progress[i])
+	}
+
+	for i, req := range requirements {
+		ev := progress[i]
 	retryLoop:
 		for j := 0; j < retries; j++ {
 			logrus.Infof("Waiting for the %s requirement %d of %d: %q", label, i+1, len(requirements), req.description)
-			err := a.waitForRequirement(req)
+			ev.State = events.RequirementRunning
+			ev.Attempt = j + 1
+			a.emitRequirementEvent(ctx, ev)
+			stderr, err := a.runRequirement(req)
+			ev.Stderr = stderr
 			if err == nil {
 				logrus.Infof("The %s requirement %d of %d is satisfied", label, i+1, len(requirements))
+				ev.State = events.RequirementSatisfied
+				a.emitRequirementEvent(ctx, ev)
 				break retryLoop
 			}
 			if req.fatal {
 				logrus.Infof("No further %s requirements will be checked", label)
+				ev.State = events.RequirementFailed
+				ev.Error = err.Error()
+				a.emitRequirementEvent(ctx, ev)
 				errs = append(errs, fmt.Errorf("failed to satisfy the %s requirement %d of %d %q: %s; skipping further checks: %w", label, i+1, len(requirements), req.description, req.debugHint, err))
+				for _, skipped := range progress[i+1:] {
+					skipped.State = events.RequirementSkipped
+					a.emitRequirementEvent(ctx, skipped)
+				}
 				return errors.Join(errs...)
 			}
 			if j == retries-1 {
+				ev.State = events.RequirementFailed
+				ev.Error = err.Error()
+				a.emitRequirementEvent(ctx, ev)
 				errs = append(errs, fmt.Errorf("failed to satisfy the %s requirement %d of %d %q: %s: %w", label, i+1, len(requirements), req.description, req.debugHint, err))
 				break retryLoop
 			}
@@ -42,17 +74,24 @@ func (a *HostAgent) waitForRequirements(label string, requirements []requirement
 	return errors.Join(errs...)
 }
 
-func (a *HostAgent) waitForRequirement(r requirement) error {
+func (a *HostAgent) emitRequirementEvent(ctx context.Context, ev events.RequirementEvent) {
+	a.emitEvent(ctx, events.Event{Requirement: &ev})
+}
+
+// waitForRequirement returns the stderr of the script along with the error.
+func (a *HostAgent) waitForRequirement(r requirement) (string, error) {
 	logrus.Debugf("executing script %q", r.description)
 	stdout, stderr, err := ssh.ExecuteScript(a.instSSHAddress, a.sshLocalPort, a.sshConfig, r.script, r.description)
 	logrus.Debugf("stdout=%q, stderr=%q, err=%v", stdout, stderr, err)
 	if err != nil {
-		return fmt.Errorf("stdout=%q, stderr=%q: %w", stdout, stderr, err)
+		return stderr, fmt.Errorf("stdout=%q, stderr=%q: %w", stdout, stderr, err)
 	}
-	return nil
+	return stderr, nil
 }
 
 type requirement struct {
+	// name is a short identifier of the requirement, used in events
+	name        string
 	description string
 	script      string
 	debugHint   string
@@ -63,6 +102,7 @@ func (a *HostAgent) essentialRequirements() []requirement {
 	req := make([]requirement, 0)
 	req = append(req,
 		requirement{
+			name:        "ssh",
 			description: "ssh",
 			script: `#!/bin/bash
 true
@@ -73,6 +113,7 @@ If any private key under ~/.ssh is protected with a passphrase, you need to have
 `,
 		},
 		requirement{
+			name:        "user-session",
 			description: "user session is ready for ssh",
 			script: `#!/bin/bash
 set -eux -o pipefail
@@ -90,6 +131,7 @@ it must not be created until the session reset is done.
 
 	if *a.y.MountType == limayaml.REVSSHFS && len(a.y.Mounts) > 0 {
 		req = append(req, requirement{
+			name:        "sshfs",
 			description: "sshfs binary to be installed",
 			script: `#!/bin/bash
 set -eux -o pipefail
@@ -105,6 +147,7 @@ A possible workaround is to run "apt-get install sshfs" in the guest.
 `,
 		})
 		req = append(req, requirement{
+			name:        "fuse-conf",
 			description: "/etc/fuse.conf (/etc/fuse3.conf) to contain \"user_allow_other\"",
 			script: `#!/bin/bash
 set -eux -o pipefail
@@ -117,8 +160,9 @@ fi
 		})
 
 	}
-	if a.guestAgentProto == guestagentclient.VSOCK {
+	if a.guestAgentProto == guestagentclient.VSOCK || a.guestAgentProto == guestagentclient.HybridVSOCK {
 		req = append(req, requirement{
+			name:        "guestagent",
 			description: "the guest agent to be running",
 			script: fmt.Sprintf(`#!/bin/bash
 set -eux -o pipefail
@@ -135,6 +179,7 @@ A possible workaround is to run "lima-guestagent install-systemd" in the guest.
 		})
 	} else {
 		req = append(req, requirement{
+			name:        "guestagent",
 			description: "the guest agent to be running",
 			script: `#!/bin/bash
 set -eux -o pipefail
@@ -159,6 +204,7 @@ func (a *HostAgent) optionalRequirements() []requirement {
 	if *a.y.Containerd.System || *a.y.Containerd.User {
 		req = append(req,
 			requirement{
+				name:        "systemd",
 				description: "systemd must be available",
 				fatal:       true,
 				script: `#!/bin/bash
@@ -175,6 +221,7 @@ are set to 'false' in the config file.
 `,
 			},
 			requirement{
+				name:        "containerd",
 				description: "containerd binaries to be installed",
 				script: `#!/bin/bash
 set -eux -o pipefail
@@ -189,9 +236,10 @@ Also see "/var/log/cloud-init-output.log" in the guest.
 `,
 			})
 	}
-	for _, probe := range a.y.Probes {
+	for i, probe := range a.y.Probes {
 		if probe.Mode == limayaml.ProbeModeReadiness {
 			req = append(req, requirement{
+				name:        fmt.Sprintf("probe-%d", i),
 				description: probe.Description,
 				script:      probe.Script,
 				debugHint:   probe.Hint,
@@ -205,6 +253,7 @@ func (a *HostAgent) finalRequirements() []requirement {
 	req := make([]requirement, 0)
 	req = append(req,
 		requirement{
+			name:        "boot-scripts",
 			description: "boot scripts must have finished",
 			script: `#!/bin/bash
 set -eux -o pipefail
@@ -0,0 +1,51 @@
+package hostagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/hostagent/events"
+	"gotest.tools/v3/assert"
+)
+
+func TestWaitForRequirementsSkipped(t *testing.T) {
+	var buf bytes.Buffer
+	a := &HostAgent{
+		eventEnc:  json.NewEncoder(&buf),
+		eventSubs: make(map[chan events.Event]struct{}),
+		runRequirement: func(r requirement) (string, error) {
+			if r.name == "ssh" {
+				return "", errors.New("connection refused")
+			}
+			return "", nil
+		},
+	}
+	requirements := []requirement{
+		{name: "ssh", description: "ssh", fatal: true},
+		{name: "user session", description: "user session is ready"},
+		{name: "sshfs", description: "sshfs binary"},
+	}
+	err := a.waitForRequirements(context.Background(), "essential", requirements)
+	assert.ErrorContains(t, err, "skipping further checks")
+
+	last := make(map[string]events.RequirementState)
+	dec := json.NewDecoder(&buf)
+	for {
+		var ev events.Event
+		if err := dec.Decode(&ev); errors.Is(err, io.EOF) {
+			break
+		} else {
+			assert.NilError(t, err)
+		}
+		last[ev.Requirement.Name] = ev.Requirement.State
+	}
+	assert.DeepEqual(t, last, map[string]events.RequirementState{
+		"ssh":          events.RequirementFailed,
+		"user session": events.RequirementSkipped,
+		"sshfs":        events.RequirementSkipped,
+	})
+}
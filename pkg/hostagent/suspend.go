@@ -0,0 +1,81 @@
+package hostagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+)
+
+// suspendState is saved to filenames.VMStateJSON on suspend.
+// The resumed guest keeps using the host ports that were configured in cidata on the initial boot.
+type suspendState struct {
+	UDPDNSLocalPort int `json:"udpDNSLocalPort,omitempty"`
+	TCPDNSLocalPort int `json:"tcpDNSLocalPort,omitempty"`
+}
+
+// loadSuspendState returns nil if the instance is not suspended.
+func loadSuspendState(instDir string) (*suspendState, error) {
+	if _, err := os.Stat(filepath.Join(instDir, filenames.VMState)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(instDir, filenames.VMStateJSON))
+	if err != nil {
+		return nil, err
+	}
+	var st suspendState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", filenames.VMStateJSON, err)
+	}
+	return &st, nil
+}
+
+func (a *HostAgent) saveSuspendState() error {
+	st := suspendState{
+		UDPDNSLocalPort: a.udpDNSLocalPort,
+		TCPDNSLocalPort: a.tcpDNSLocalPort,
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(a.instDir, filenames.VMStateJSON), b, 0o644)
+}
+
+// Suspend requests the host agent to save the VM state and to exit.
+// The request is processed asynchronously; the host agent emits the "exiting" event when it is done.
+func (a *HostAgent) Suspend(_ context.Context) error {
+	if *a.y.MountType != limayaml.REVSSHFS && len(a.y.Mounts) > 0 {
+		return fmt.Errorf("suspending an instance with %q mounts is not supported", *a.y.MountType)
+	}
+	select {
+	case a.suspendCh <- struct{}{}:
+		return nil
+	default:
+		return errors.New("the instance is already being suspended")
+	}
+}
+
+// suspend is called by startRoutinesAndWait after shutting down the host agent routines.
+func (a *HostAgent) suspend(ctx context.Context) error {
+	if err := a.saveSuspendState(); err != nil {
+		logrus.WithError(err).Error("failed to save the host agent state, shutting down the instance")
+		return errors.Join(err, a.driver.Stop(ctx))
+	}
+	if err := a.driver.Suspend(ctx); err != nil {
+		logrus.WithError(err).Error("failed to suspend the instance, shutting down the instance")
+		_ = os.RemoveAll(filepath.Join(a.instDir, filenames.VMStateJSON))
+		return errors.Join(err, a.driver.Stop(ctx))
+	}
+	logrus.Info("The instance has been suspended")
+	return nil
+}
@@ -0,0 +1,29 @@
+package hostagent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"gotest.tools/v3/assert"
+)
+
+func TestSuspendState(t *testing.T) {
+	instDir := t.TempDir()
+	st, err := loadSuspendState(instDir)
+	assert.NilError(t, err)
+	assert.Assert(t, st == nil)
+
+	a := &HostAgent{instDir: instDir, udpDNSLocalPort: 40001, tcpDNSLocalPort: 40002}
+	assert.NilError(t, a.saveSuspendState())
+	// vmstate.json is ignored without vmstate
+	st, err = loadSuspendState(instDir)
+	assert.NilError(t, err)
+	assert.Assert(t, st == nil)
+
+	assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.VMState), nil, 0o644))
+	st, err = loadSuspendState(instDir)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, *st, suspendState{UDPDNSLocalPort: 40001, TCPDNSLocalPort: 40002})
+}
@@ -0,0 +1,56 @@
+package httpclientutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DialHybridVSock connects to the vsock port of the guest via the host-side UNIX socket of
+// the "hybrid vsock" device of cloud-hypervisor and firecracker.
+//
+// See https://github.com/firecracker-microvm/firecracker/blob/main/docs/vsock.md
+func DialHybridVSock(ctx context.Context, socketPath string, port int) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Read the reply byte by byte, so that no payload is consumed
+	var reply strings.Builder
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to read the reply of CONNECT %d from %q: %w", port, socketPath, err)
+		}
+		if b[0] == '\n' {
+			break
+		}
+		reply.WriteByte(b[0])
+	}
+	if !strings.HasPrefix(reply.String(), "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected reply of CONNECT %d from %q: %q", port, socketPath, reply.String())
+	}
+	return conn, nil
+}
+
+// NewHTTPClientWithHybridVSock creates a client.
+// socketPath is the host-side UNIX socket of the hybrid vsock device, and port is the vsock port in the guest.
+func NewHTTPClientWithHybridVSock(socketPath string, port int) *http.Client {
+	hc := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return DialHybridVSock(ctx, socketPath, port)
+			},
+		},
+	}
+	return hc
+}
@@ -0,0 +1,97 @@
+// Package imagebuild builds a reusable base image from an instance.
+package imagebuild
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lima-vm/lima/pkg/iso9660util"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/qemu/imgutil"
+	"github.com/lima-vm/lima/pkg/sshutil"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/lima-vm/sshocker/pkg/ssh"
+	"github.com/sirupsen/logrus"
+)
+
+// generalizeScript resets the guest state that is specific to the instance, so that the image
+// can be booted as a new machine. The Lima-specific files are installed again from cidata on boot.
+const generalizeScript = `#!/bin/bash
+set -eux -o pipefail
+LIMA_USER="$(id -un)"
+if command -v cloud-init >/dev/null 2>&1; then
+	sudo cloud-init clean --logs
+fi
+# machine-id is regenerated on the first boot
+if [ -e /etc/machine-id ]; then
+	sudo truncate -s 0 /etc/machine-id
+fi
+sudo rm -f /var/lib/dbus/machine-id
+# SSH host keys are regenerated by cloud-init
+sudo rm -f /etc/ssh/ssh_host_*
+sudo rm -f /usr/local/bin/lima-guestagent /etc/systemd/system/lima-guestagent.service /etc/sudoers.d/90-cloud-init-users
+# userdel has to be the last step, as sudo no longer works after deleting the user
+sudo sh -c "userdel -r -f '${LIMA_USER}'; sync; fstrim -av || true"
+`
+
+// Generalize removes the cloud-init state, the machine-id, the SSH host keys, and the Lima user
+// (including the home directory) from the running instance.
+// The instance should be stopped after calling Generalize.
+//
+// Generalize is destructive, so it should be called for a clone of the instance of the user (see pkg/clone).
+func Generalize(_ context.Context, inst *store.Instance) error {
+	if inst.Status != store.StatusRunning {
+		return fmt.Errorf("expected status %q, got %q", store.StatusRunning, inst.Status)
+	}
+	if inst.VMType != limayaml.QEMU {
+		return fmt.Errorf("building an image is not supported for vmType %q", inst.VMType)
+	}
+	y, err := inst.LoadYAML()
+	if err != nil {
+		return err
+	}
+	sshOpts, err := sshutil.SSHOpts(inst.Dir, *y.SSH.LoadDotSSHPubKeys, false, false, false)
+	if err != nil {
+		return err
+	}
+	sshConfig := &ssh.SSHConfig{
+		AdditionalArgs: sshutil.SSHArgsFromOpts(sshOpts),
+	}
+	const desc = "generalizing the guest"
+	logrus.Infof("Removing the cloud-init state, the machine-id, the SSH host keys, and the user from the guest")
+	stdout, stderr, err := ssh.ExecuteScript(inst.SSHAddress, inst.SSHLocalPort, sshConfig, generalizeScript, desc)
+	logrus.Debugf("stdout=%q, stderr=%q, err=%v", stdout, stderr, err)
+	if err != nil {
+		return fmt.Errorf("failed to run the script for %s: stdout=%q, stderr=%q: %w", desc, stdout, stderr, err)
+	}
+	return nil
+}
+
+// Compact writes the disk of the stopped instance into output as a standalone qcow2 image.
+// The base disk is merged, and the unallocated and discarded blocks are omitted.
+func Compact(inst *store.Instance, output string) error {
+	if inst.Status != store.StatusStopped {
+		return fmt.Errorf("expected status %q, got %q", store.StatusStopped, inst.Status)
+	}
+	if _, err := os.Stat(output); !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("output %q already exists", output)
+	}
+	isBaseDiskISO, err := iso9660util.IsISO9660(filepath.Join(inst.Dir, filenames.BaseDisk))
+	if err != nil {
+		return err
+	}
+	if isBaseDiskISO {
+		return errors.New("building an image from an instance booted from an ISO9660 image is not supported")
+	}
+	tmp := output + ".tmp"
+	logrus.Infof("Writing the disk of %q into %q", inst.Name, output)
+	if err := imgutil.Convert(filepath.Join(inst.Dir, filenames.DiffDisk), tmp, "qcow2"); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	return os.Rename(tmp, output)
+}
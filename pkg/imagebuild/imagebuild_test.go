@@ -0,0 +1,66 @@
+package imagebuild
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/iso9660util"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"gotest.tools/v3/assert"
+)
+
+func TestGeneralizeScript(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	cmd := exec.Command(bash, "-n")
+	cmd.Stdin = strings.NewReader(generalizeScript)
+	out, err := cmd.CombinedOutput()
+	assert.NilError(t, err, string(out))
+	// sudo no longer works after deleting the user
+	lines := strings.Split(strings.TrimSpace(generalizeScript), "\n")
+	assert.Assert(t, strings.Contains(lines[len(lines)-1], "userdel"))
+}
+
+func TestGeneralize(t *testing.T) {
+	ctx := context.Background()
+	inst := &store.Instance{Name: "foo", Status: store.StatusStopped, VMType: limayaml.QEMU}
+	assert.ErrorContains(t, Generalize(ctx, inst), "expected status")
+
+	inst = &store.Instance{Name: "foo", Status: store.StatusRunning, VMType: limayaml.VZ}
+	assert.ErrorContains(t, Generalize(ctx, inst), "not supported")
+}
+
+func TestCompact(t *testing.T) {
+	instDir := t.TempDir()
+	inst := &store.Instance{Name: "foo", Dir: instDir, Status: store.StatusRunning}
+	output := filepath.Join(t.TempDir(), "foo.qcow2")
+	assert.ErrorContains(t, Compact(inst, output), "expected status")
+
+	inst.Status = store.StatusStopped
+	assert.NilError(t, os.WriteFile(output, nil, 0o644))
+	assert.ErrorContains(t, Compact(inst, output), "already exists")
+	assert.NilError(t, os.Remove(output))
+
+	assert.NilError(t, iso9660util.Write(filepath.Join(instDir, filenames.BaseDisk), "foo", nil))
+	assert.ErrorContains(t, Compact(inst, output), "ISO9660")
+
+	if _, err := exec.LookPath("qemu-img"); err != nil {
+		t.Skip("qemu-img is not installed")
+	}
+	baseDisk := filepath.Join(instDir, filenames.BaseDisk)
+	assert.NilError(t, exec.Command("qemu-img", "create", "-f", "qcow2", baseDisk, "64M").Run())
+	assert.NilError(t, exec.Command("qemu-img", "create", "-f", "qcow2", "-F", "qcow2", "-b", baseDisk,
+		filepath.Join(instDir, filenames.DiffDisk)).Run())
+	assert.NilError(t, Compact(inst, output))
+	out, err := exec.Command("qemu-img", "info", output).CombinedOutput()
+	assert.NilError(t, err, string(out))
+	assert.Assert(t, !strings.Contains(string(out), "backing file"), string(out))
+}
@@ -0,0 +1,528 @@
+// Package instarchive exports and imports instances as tar archives.
+//
+// The archive contains lima.yaml and the disks of the instance, and optionally the
+// snapshot metadata and the additional disks (as "_disks/<NAME>/datadisk").
+// Host-specific files such as sockets, PID files, logs, ssh.config, and cidata.iso are
+// not included, as they are regenerated on the next start.
+//
+// The files with holes, such as raw disk images, are archived as PAX sparse files.
+// On import, ssh.localPort and the explicit MAC addresses of lima.yaml are reset, as for clones.
+package instarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/lima-vm/lima/pkg/clone"
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/lima-vm/lima/pkg/nativeimgutil"
+	"github.com/lima-vm/lima/pkg/qemu/imgutil"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+)
+
+// instanceFiles are the files of the instance directory that are included in the archive.
+var instanceFiles = []string{
+	filenames.LimaYAML,
+	filenames.BaseDisk,
+	filenames.DiffDisk,
+	filenames.Kernel,
+	filenames.KernelCmdline,
+	filenames.Initrd,
+	filenames.VzEfi,
+}
+
+// archiveExts maps the supported archive extensions to the compression extensions.
+var archiveExts = map[string]string{
+	".tar":     "",
+	".tar.gz":  ".gz",
+	".tgz":     ".gz",
+	".tar.bz2": ".bz2",
+	".tar.xz":  ".xz",
+	".tar.zst": ".zst",
+}
+
+// splitExt splits the archive path into the base name and the compression extension.
+func splitExt(archive string) (base, compression string, err error) {
+	for ext, compression := range archiveExts {
+		if strings.HasSuffix(archive, ext) {
+			return strings.TrimSuffix(filepath.Base(archive), ext), compression, nil
+		}
+	}
+	return "", "", fmt.Errorf("unsupported archive %q, the file name must end with one of %v",
+		archive, []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tar.xz", ".tar.zst"})
+}
+
+// DefaultInstanceName returns the instance name derived from the archive file name,
+// e.g., "foo" for "/path/to/foo.tar.zst".
+func DefaultInstanceName(archive string) (string, error) {
+	base, _, err := splitExt(archive)
+	return base, err
+}
+
+type ExportOptions struct {
+	// Snapshots includes the snapshots of the diff disk and the snapshot metadata.
+	Snapshots bool
+	// AdditionalDisks includes the additional disks attached to the instance.
+	AdditionalDisks bool
+}
+
+// Export exports the stopped instance into the archive.
+// The archive is compressed according to the extension of the file name.
+func Export(ctx context.Context, inst *store.Instance, archive string, opts ExportOptions) (retErr error) {
+	if inst.Status != store.StatusStopped {
+		return fmt.Errorf("expected status %q, got %q (hint: stop the instance before exporting)", store.StatusStopped, inst.Status)
+	}
+	_, compression, err := splitExt(archive)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(archive)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var w io.WriteCloser
+	defer func() {
+		if retErr != nil {
+			if w != nil {
+				// Kill the compressor, and wait for it to exit
+				cancel()
+				_ = w.Close()
+			}
+			_ = f.Close()
+			_ = os.RemoveAll(archive)
+		}
+	}()
+	w, err = compress(ctx, f, compression)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	for _, name := range instanceFiles {
+		p := filepath.Join(inst.Dir, name)
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if name == filenames.DiffDisk {
+			tmp, err := diffDiskForExport(inst.Dir, opts.Snapshots)
+			if err != nil {
+				return err
+			}
+			if tmp != "" {
+				defer os.RemoveAll(tmp)
+				p = tmp
+			}
+		}
+		if err := addFile(w, tw, name, p); err != nil {
+			return err
+		}
+	}
+	if opts.Snapshots {
+		p := filepath.Join(inst.Dir, filenames.SnapshotsJSON)
+		if _, err := os.Stat(p); err == nil {
+			if err := addFile(w, tw, filenames.SnapshotsJSON, p); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.AdditionalDisks {
+		for _, d := range inst.AdditionalDisks {
+			disk, err := store.InspectDisk(d.Name)
+			if err != nil {
+				return fmt.Errorf("failed to inspect the additional disk %q: %w", d.Name, err)
+			}
+			name := path.Join(filenames.DisksDir, disk.Name, filenames.DataDisk)
+			if err := addFile(w, tw, name, filepath.Join(disk.Dir, filenames.DataDisk)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// diffDiskForExport returns the path of the temporary copy of the diff disk to be exported,
+// or an empty string when the diff disk can be exported as is.
+// The diff disk of a cloned instance, which is backed by a frozen disk in $LIMA_HOME/_frozen,
+// is converted to be backed by the base disk. The snapshots are removed unless keepSnapshots is true.
+func diffDiskForExport(instDir string, keepSnapshots bool) (string, error) {
+	diffDisk := filepath.Join(instDir, filenames.DiffDisk)
+	baseDisk := filepath.Join(instDir, filenames.BaseDisk)
+	info, err := imgutil.GetInfo(diffDisk)
+	if err != nil {
+		return "", err
+	}
+	tmp := diffDisk + ".export.tmp"
+	backedByBaseDisk, err := isBackedBy(info, baseDisk)
+	if err != nil {
+		return "", err
+	}
+	if !backedByBaseDisk {
+		if keepSnapshots && len(info.Snapshots) > 0 {
+			return "", errors.New("exporting the snapshots of a cloned instance is not supported")
+		}
+		baseInfo, err := imgutil.GetInfo(baseDisk)
+		if err != nil {
+			return "", err
+		}
+		if err := imgutil.ConvertOverlay(diffDisk, tmp, baseDisk, baseInfo.Format); err != nil {
+			_ = os.RemoveAll(tmp)
+			return "", err
+		}
+		return tmp, nil
+	}
+	if keepSnapshots || len(info.Snapshots) == 0 {
+		return "", nil
+	}
+	// continuity attempts clonefile
+	if err := fs.CopyFile(tmp, diffDisk); err != nil {
+		return "", err
+	}
+	for _, s := range info.Snapshots {
+		if err := imgutil.DeleteSnapshot(tmp, s.Name); err != nil {
+			_ = os.RemoveAll(tmp)
+			return "", err
+		}
+	}
+	return tmp, nil
+}
+
+// isBackedBy returns true if the image has no backing file, or the backing file is baseDisk.
+func isBackedBy(info *imgutil.Info, baseDisk string) (bool, error) {
+	backingFile := info.FullBackingFilename
+	if backingFile == "" {
+		backingFile = info.BackingFilename
+	}
+	if backingFile == "" {
+		return true, nil
+	}
+	backingFi, err := os.Stat(backingFile)
+	if err != nil {
+		return false, err
+	}
+	baseFi, err := os.Stat(baseDisk)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(backingFi, baseFi), nil
+}
+
+// addFile adds the file p to the archive as name.
+// The sparse files are added as PAX sparse files. tw is the tar.Writer that writes to w.
+func addFile(w io.Writer, tw *tar.Writer, name, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Exporting %q", name)
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(st.Mode().Perm()),
+		Size:     st.Size(),
+		ModTime:  st.ModTime(),
+	}
+	segments, err := dataSegments(f, st.Size())
+	if err != nil {
+		return err
+	}
+	if isSparse(segments, st.Size()) {
+		return writeSparseFile(w, tw, hdr, f, segments)
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Import imports the archive as a new instance.
+func Import(ctx context.Context, archive, instName string) (_ *store.Instance, retErr error) {
+	_, compression, err := splitExt(archive)
+	if err != nil {
+		return nil, err
+	}
+	instDir, err := store.InstanceDir(instName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(instDir); !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("instance %q already exists (%q)", instName, instDir)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompress(ctx, f, compression)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(instDir, 0o700); err != nil {
+		return nil, err
+	}
+	var diskDirs []string
+	defer func() {
+		if retErr != nil {
+			_ = os.RemoveAll(instDir)
+			for _, d := range diskDirs {
+				_ = os.RemoveAll(d)
+			}
+		}
+	}()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		dest, diskDir, err := destination(instDir, hdr)
+		if err != nil {
+			return nil, err
+		}
+		if dest == "" {
+			logrus.Warnf("Ignoring an unexpected file %q in the archive", hdr.Name)
+			continue
+		}
+		if diskDir != "" {
+			if _, err := os.Stat(diskDir); !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("disk %q already exists (%q)", filepath.Base(diskDir), diskDir)
+			}
+			if err := os.MkdirAll(diskDir, 0o755); err != nil {
+				return nil, err
+			}
+			diskDirs = append(diskDirs, diskDir)
+		}
+		logrus.Infof("Importing %q", hdr.Name)
+		if err := extractFile(dest, tr, os.FileMode(hdr.Mode).Perm()); err != nil {
+			return nil, err
+		}
+	}
+	// Drain the trailing data so that the decompressor can exit
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(instDir, filenames.LimaYAML)); err != nil {
+		return nil, fmt.Errorf("the archive does not contain %q: %w", filenames.LimaYAML, err)
+	}
+	if err := rebaseDiffDisk(instDir); err != nil {
+		return nil, err
+	}
+	if err := resetHostSpecificFields(instDir); err != nil {
+		return nil, err
+	}
+	inst, err := store.Inspect(instName)
+	if err != nil {
+		return nil, err
+	}
+	if len(inst.Errors) > 0 {
+		return nil, fmt.Errorf("errors inspecting the imported instance %q: %+v", instName, inst.Errors)
+	}
+	return inst, nil
+}
+
+// destination returns the destination path of the archive entry.
+// An empty path is returned for unexpected entries.
+// diskDir is set for the entries of the additional disks.
+func destination(instDir string, hdr *tar.Header) (dest, diskDir string, err error) {
+	if hdr.Typeflag != tar.TypeReg {
+		return "", "", nil
+	}
+	name := path.Clean(hdr.Name)
+	for _, f := range append(instanceFiles, filenames.SnapshotsJSON) {
+		if name == f {
+			return filepath.Join(instDir, f), "", nil
+		}
+	}
+	// "_disks/<NAME>/datadisk"
+	if split := strings.Split(name, "/"); len(split) == 3 && split[0] == filenames.DisksDir && split[2] == filenames.DataDisk {
+		diskDir, err := store.DiskDir(split[1])
+		if err != nil {
+			return "", "", err
+		}
+		return filepath.Join(diskDir, filenames.DataDisk), diskDir, nil
+	}
+	return "", "", nil
+}
+
+func extractFile(dest string, r io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := nativeimgutil.CopySparse(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rebaseDiffDisk updates the backing file of the diff disk, which is recorded as an absolute path
+// of the base disk of the exported instance.
+func rebaseDiffDisk(instDir string) error {
+	diffDisk := filepath.Join(instDir, filenames.DiffDisk)
+	baseDisk := filepath.Join(instDir, filenames.BaseDisk)
+	if _, err := os.Stat(diffDisk); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	info, err := imgutil.GetInfo(diffDisk)
+	if err != nil {
+		return err
+	}
+	if info.BackingFilename == "" || info.BackingFilename == baseDisk {
+		return nil
+	}
+	baseInfo, err := imgutil.GetInfo(baseDisk)
+	if err != nil {
+		return err
+	}
+	return imgutil.Rebase(diffDisk, baseDisk, baseInfo.Format)
+}
+
+// resetHostSpecificFields resets ssh.localPort and the explicit MAC addresses in lima.yaml,
+// as they may be used by the exported instance on the same host.
+func resetHostSpecificFields(instDir string) error {
+	p := filepath.Join(instDir, filenames.LimaYAML)
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	reset, err := clone.ResetHostSpecificFields(b, p)
+	if err != nil {
+		return fmt.Errorf("failed to reset the host-specific fields of %q: %w", p, err)
+	}
+	if bytes.Equal(reset, b) {
+		return nil
+	}
+	return os.WriteFile(p, reset, 0o644)
+}
+
+// compress returns a writer that compresses the data with the external program for the extension.
+func compress(ctx context.Context, w io.Writer, ext string) (io.WriteCloser, error) {
+	if ext == "" {
+		return nopWriteCloser{w}, nil
+	}
+	decompressor, ok := downloader.Decompressor(ext)
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q", ext)
+	}
+	// "gzip -c", "bzip2 -c", "xz -c", "zstd -c"
+	cmd := exec.CommandContext(ctx, decompressor[0], "-c")
+	cmd.Stdout = w
+	return startCommand(cmd)
+}
+
+// decompress returns a reader that decompresses the data with the external program for the extension.
+func decompress(ctx context.Context, r io.Reader, ext string) (io.ReadCloser, error) {
+	if ext == "" {
+		return io.NopCloser(r), nil
+	}
+	decompressor, ok := downloader.Decompressor(ext)
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %q", ext)
+	}
+	cmd := exec.CommandContext(ctx, decompressor[0], decompressor[1:]...)
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandReader{ReadCloser: stdout, cmd: cmd, stderr: &stderr}, nil
+}
+
+func startCommand(cmd *exec.Cmd) (io.WriteCloser, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandWriter{WriteCloser: stdin, cmd: cmd, stderr: &stderr}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// commandWriter waits for the command to exit on Close.
+type commandWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (w *commandWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	if err := w.cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to run %v: stderr=%q: %w", w.cmd.Args, w.stderr.String(), err)
+	}
+	return nil
+}
+
+// commandReader waits for the command to exit on Close.
+// Closing the reader before reading all the data makes the command fail.
+type commandReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	closed bool
+}
+
+func (r *commandReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.ReadCloser.Close(); err != nil {
+		return err
+	}
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to run %v: stderr=%q: %w", r.cmd.Args, r.stderr.String(), err)
+	}
+	return nil
+}
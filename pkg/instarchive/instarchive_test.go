@@ -0,0 +1,97 @@
+package instarchive
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/qemu/imgutil"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"gotest.tools/v3/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("LIMA_HOME", t.TempDir())
+	instDir, err := store.InstanceDir("foo")
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(instDir, 0o700))
+	yaml := `images:
+- location: "/dev/null"
+ssh:
+  localPort: 60123
+`
+	assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.LimaYAML), []byte(yaml), 0o644))
+	// A sparse disk with a trailing hole
+	baseDisk := make([]byte, 64<<20)
+	copy(baseDisk[2<<20:], "data")
+	f, err := os.Create(filepath.Join(instDir, filenames.BaseDisk))
+	assert.NilError(t, err)
+	_, err = f.WriteAt([]byte("data"), 2<<20)
+	assert.NilError(t, err)
+	assert.NilError(t, f.Truncate(int64(len(baseDisk))))
+	assert.NilError(t, f.Close())
+	assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.SSHConfig), []byte("Host lima-foo\n"), 0o644))
+	inst, err := store.Inspect("foo")
+	assert.NilError(t, err)
+
+	archive := filepath.Join(t.TempDir(), "bar.tar")
+	_, err = Import(ctx, archive, "bar")
+	assert.ErrorContains(t, err, "no such file")
+	assert.NilError(t, Export(ctx, inst, archive, ExportOptions{}))
+	if runtime.GOOS != "windows" {
+		st, err := os.Stat(archive)
+		assert.NilError(t, err)
+		assert.Assert(t, st.Size() < 1<<20, "the holes must not be expanded in the archive (size: %d)", st.Size())
+	}
+
+	name, err := DefaultInstanceName(archive)
+	assert.NilError(t, err)
+	assert.Equal(t, name, "bar")
+	_, err = Import(ctx, archive, "foo")
+	assert.ErrorContains(t, err, "already exists")
+	imported, err := Import(ctx, archive, name)
+	assert.NilError(t, err)
+	assert.Equal(t, imported.Status, store.StatusStopped)
+
+	b, err := os.ReadFile(filepath.Join(imported.Dir, filenames.BaseDisk))
+	assert.NilError(t, err)
+	assert.Assert(t, bytes.Equal(b, baseDisk))
+	assert.Equal(t, *imported.Config.SSH.LocalPort, 0)
+	_, err = os.Stat(filepath.Join(imported.Dir, filenames.SSHConfig))
+	assert.Assert(t, os.IsNotExist(err))
+
+	_, err = DefaultInstanceName("foo.zip")
+	assert.ErrorContains(t, err, "unsupported archive")
+}
+
+func TestIsBackedBy(t *testing.T) {
+	dir := t.TempDir()
+	baseDisk := filepath.Join(dir, filenames.BaseDisk)
+	assert.NilError(t, os.WriteFile(baseDisk, nil, 0o644))
+	frozen := filepath.Join(dir, "frozen")
+	assert.NilError(t, os.WriteFile(frozen, nil, 0o644))
+
+	backed, err := isBackedBy(&imgutil.Info{}, baseDisk)
+	assert.NilError(t, err)
+	assert.Assert(t, backed)
+	backed, err = isBackedBy(&imgutil.Info{BackingFilename: baseDisk}, baseDisk)
+	assert.NilError(t, err)
+	assert.Assert(t, backed)
+	// the diff disk of a cloned instance
+	backed, err = isBackedBy(&imgutil.Info{BackingFilename: frozen}, baseDisk)
+	assert.NilError(t, err)
+	assert.Assert(t, !backed)
+	if runtime.GOOS != "windows" {
+		// the backing file is recorded via a symlink of $LIMA_HOME
+		link := filepath.Join(t.TempDir(), "link")
+		assert.NilError(t, os.Symlink(dir, link))
+		backed, err = isBackedBy(&imgutil.Info{FullBackingFilename: filepath.Join(link, filenames.BaseDisk)}, baseDisk)
+		assert.NilError(t, err)
+		assert.Assert(t, backed)
+	}
+}
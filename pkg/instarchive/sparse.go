@@ -0,0 +1,130 @@
+package instarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+const blockSize = 512
+
+// segment is a data segment of a sparse file.
+type segment struct {
+	offset, length int64
+}
+
+// isSparse returns true if the segments do not cover the whole file.
+func isSparse(segments []segment, size int64) bool {
+	var n int64
+	for _, s := range segments {
+		n += s.length
+	}
+	return n < size
+}
+
+// writeSparseFile writes the data segments of f as a PAX 1.0 sparse file (as in GNU tar),
+// so that the holes of the disk images are not expanded in the archive.
+// The holes are read as zeros by tar.Reader.
+//
+// tw is the tar.Writer that writes to w.
+func writeSparseFile(w io.Writer, tw *tar.Writer, hdr *tar.Header, f *os.File, segments []segment) error {
+	var sparseMap bytes.Buffer
+	entries := segments
+	// GNU tar terminates the map with an empty segment at the end of the file, for the trailing hole
+	if len(entries) == 0 || entries[len(entries)-1].offset+entries[len(entries)-1].length < hdr.Size {
+		entries = append(entries[:len(entries):len(entries)], segment{offset: hdr.Size})
+	}
+	fmt.Fprintf(&sparseMap, "%d\n", len(entries))
+	var dataSize int64
+	for _, s := range entries {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", s.offset, s.length)
+		dataSize += s.length
+	}
+	sparseMap.Write(make([]byte, padding(int64(sparseMap.Len()))))
+
+	var records bytes.Buffer
+	records.WriteString(paxRecord("GNU.sparse.major", "1"))
+	records.WriteString(paxRecord("GNU.sparse.minor", "0"))
+	records.WriteString(paxRecord("GNU.sparse.name", hdr.Name))
+	records.WriteString(paxRecord("GNU.sparse.realsize", strconv.FormatInt(hdr.Size, 10)))
+	paxHdr, err := paxHeaderBlock(path.Join(path.Dir(hdr.Name), "PaxHeaders.0", path.Base(hdr.Name)), int64(records.Len()))
+	if err != nil {
+		return err
+	}
+	records.Write(make([]byte, padding(int64(records.Len()))))
+
+	// Pad the previous entry before writing the PAX header directly
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if _, err := w.Write(paxHdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(records.Bytes()); err != nil {
+		return err
+	}
+	sparseHdr := *hdr
+	sparseHdr.Name = path.Join(path.Dir(hdr.Name), "GNUSparseFile.0", path.Base(hdr.Name))
+	sparseHdr.Size = int64(sparseMap.Len()) + dataSize
+	// Another PAX header must not be written between the PAX header above and this header
+	sparseHdr.Format = tar.FormatUSTAR
+	if err := tw.WriteHeader(&sparseHdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(sparseMap.Bytes()); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if _, err := io.Copy(tw, io.NewSectionReader(f, s.offset, s.length)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paxHeaderBlock returns the header block of a PAX extended header with the records of size bytes.
+// tar.Writer refuses to write the GNU.sparse.* records, so the header block is encoded as a regular file
+// and then the type flag is replaced.
+func paxHeaderBlock(name string, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Format:   tar.FormatUSTAR,
+	}
+	if err := tar.NewWriter(&buf).WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	blk := buf.Bytes()[:blockSize]
+	blk[156] = tar.TypeXHeader
+	// The checksum is computed with the checksum field filled with spaces
+	copy(blk[148:156], "        ")
+	var chksum int64
+	for _, b := range blk {
+		chksum += int64(b)
+	}
+	copy(blk[148:156], fmt.Sprintf("%06o\x00 ", chksum))
+	return blk, nil
+}
+
+// paxRecord formats a PAX record, "<LENGTH> <KEY>=<VALUE>\n", where LENGTH includes itself.
+func paxRecord(k, v string) string {
+	size := len(k) + len(v) + len(" =\n")
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	if len(record) != size {
+		// The length of LENGTH has been changed by adding itself
+		size = len(record)
+		record = strconv.Itoa(size) + " " + k + "=" + v + "\n"
+	}
+	return record
+}
+
+func padding(n int64) int64 {
+	return -n & (blockSize - 1)
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package instarchive
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataSegments returns the data segments of f, using SEEK_DATA and SEEK_HOLE.
+// The whole file is returned as a single segment if the file system does not support them.
+func dataSegments(f *os.File, size int64) ([]segment, error) {
+	var segments []segment
+	var offset int64
+	for offset < size {
+		data, err := f.Seek(offset, unix.SEEK_DATA)
+		if err != nil {
+			if errors.Is(err, unix.ENXIO) {
+				// No data after offset
+				break
+			}
+			if errors.Is(err, unix.EINVAL) && offset == 0 {
+				return []segment{{offset: 0, length: size}}, nil
+			}
+			return nil, err
+		}
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+		segments = append(segments, segment{offset: data, length: hole - data})
+		offset = hole
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
@@ -0,0 +1,52 @@
+package limayaml
+
+import (
+	"reflect"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// HotplugFields are the YAML names of the top-level fields that may be changed on running instances.
+var HotplugFields = []string{"cpus", "memory"}
+
+// ChangedFields returns the YAML names of the top-level fields that differ between x and y.
+func ChangedFields(x, y *LimaYAML) []string {
+	var res []string
+	vx, vy := reflect.ValueOf(x).Elem(), reflect.ValueOf(y).Elem()
+	t := vx.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(vx.Field(i).Interface(), vy.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		res = append(res, name)
+	}
+	return res
+}
+
+// ChangedNonHotplugFields returns the YAML names of the top-level fields that differ between x and y,
+// excluding HotplugFields.
+func ChangedNonHotplugFields(x, y *LimaYAML) []string {
+	var res []string
+	for _, f := range ChangedFields(x, y) {
+		if !slices.Contains(HotplugFields, f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// ChangedNonHotplugFieldsYAML is like ChangedNonHotplugFields, but compares the YAML documents as written,
+// before the defaults are filled. Otherwise the fields derived from the hot-pluggable fields
+// (e.g., maxCPUs from cpus) would be reported as changed, although the user did not touch them.
+func ChangedNonHotplugFieldsYAML(xBytes, yBytes []byte) ([]string, error) {
+	var x, y LimaYAML
+	if err := unmarshalYAML(xBytes, &x, "the current YAML"); err != nil {
+		return nil, err
+	}
+	if err := unmarshalYAML(yBytes, &y, "the edited YAML"); err != nil {
+		return nil, err
+	}
+	return ChangedNonHotplugFields(&x, &y), nil
+}
@@ -0,0 +1,63 @@
+package limayaml
+
+import (
+	"testing"
+
+	"github.com/xorcare/pointer"
+	"gotest.tools/v3/assert"
+)
+
+func TestChangedFields(t *testing.T) {
+	x := LimaYAML{
+		CPUs:   pointer.Int(4),
+		Memory: pointer.String("4GiB"),
+		Mounts: []Mount{{Location: "/tmp"}},
+	}
+	y := x
+	assert.Assert(t, len(ChangedFields(&x, &y)) == 0)
+
+	y.CPUs = pointer.Int(4)
+	assert.Assert(t, len(ChangedFields(&x, &y)) == 0)
+
+	y.CPUs = pointer.Int(8)
+	y.Mounts = []Mount{{Location: "/tmp", Writable: pointer.Bool(true)}}
+	assert.DeepEqual(t, ChangedFields(&x, &y), []string{"cpus", "mounts"})
+}
+
+func TestChangedNonHotplugFields(t *testing.T) {
+	x := LimaYAML{
+		CPUs:   pointer.Int(4),
+		Memory: pointer.String("4GiB"),
+		Mounts: []Mount{{Location: "/tmp"}},
+	}
+	y := x
+	y.CPUs = pointer.Int(8)
+	y.Memory = pointer.String("8GiB")
+	assert.Assert(t, len(ChangedNonHotplugFields(&x, &y)) == 0)
+
+	y.Mounts = []Mount{{Location: "/tmp", Writable: pointer.Bool(true)}}
+	assert.DeepEqual(t, ChangedNonHotplugFields(&x, &y), []string{"mounts"})
+}
+
+func TestChangedNonHotplugFieldsYAML(t *testing.T) {
+	current := []byte(`
+cpus: 2
+memory: 2GiB
+mounts:
+- location: /tmp
+`)
+	// the derived defaults (maxCPUs, maxMemory, memoryBalloon) follow cpus and memory after filling defaults,
+	// but they are not changed in the YAML
+	for _, edited := range []string{
+		"cpus: 4\nmemory: 2GiB\nmounts:\n- location: /tmp\n",
+		"cpus: 2\nmemory: 4GiB\nmounts:\n- location: /tmp\n",
+	} {
+		fields, err := ChangedNonHotplugFieldsYAML(current, []byte(edited))
+		assert.NilError(t, err)
+		assert.Equal(t, len(fields), 0, edited)
+	}
+
+	fields, err := ChangedNonHotplugFieldsYAML(current, []byte("cpus: 4\nmemory: 2GiB\nmounts:\n- location: /tmp\n  writable: true\n"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, fields, []string{"mounts"})
+}
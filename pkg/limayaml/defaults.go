@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"text/template"
 
 	"github.com/docker/go-units"
@@ -99,6 +100,15 @@ func defaultMemory() uint64 {
 	return x
 }
 
+// defaultMemoryBalloonMin returns min("1GiB", memory).
+func defaultMemoryBalloonMin(memory string) string {
+	const x = "1GiB"
+	if memBytes, err := units.RAMInBytes(memory); err == nil && memBytes < 1<<30 {
+		return memory
+	}
+	return x
+}
+
 func defaultMemoryAsString() string {
 	return units.BytesSize(float64(defaultMemory()))
 }
@@ -231,6 +241,66 @@ func FillDefault(y, d, o *LimaYAML, filePath string) {
 		y.Memory = pointer.String(defaultMemoryAsString())
 	}
 
+	if y.MaxCPUs == nil {
+		y.MaxCPUs = d.MaxCPUs
+	}
+	if o.MaxCPUs != nil {
+		y.MaxCPUs = o.MaxCPUs
+	}
+	if y.MaxCPUs == nil || *y.MaxCPUs == 0 {
+		y.MaxCPUs = pointer.Int(*y.CPUs)
+	}
+
+	if y.MaxMemory == nil {
+		y.MaxMemory = d.MaxMemory
+	}
+	if o.MaxMemory != nil {
+		y.MaxMemory = o.MaxMemory
+	}
+	if y.MaxMemory == nil || *y.MaxMemory == "" {
+		y.MaxMemory = pointer.String(*y.Memory)
+	}
+
+	if y.MemoryBalloon.Enabled == nil {
+		y.MemoryBalloon.Enabled = d.MemoryBalloon.Enabled
+	}
+	if o.MemoryBalloon.Enabled != nil {
+		y.MemoryBalloon.Enabled = o.MemoryBalloon.Enabled
+	}
+	if y.MemoryBalloon.Enabled == nil {
+		y.MemoryBalloon.Enabled = pointer.Bool(false)
+	}
+
+	if y.MemoryBalloon.Min == nil {
+		y.MemoryBalloon.Min = d.MemoryBalloon.Min
+	}
+	if o.MemoryBalloon.Min != nil {
+		y.MemoryBalloon.Min = o.MemoryBalloon.Min
+	}
+	if y.MemoryBalloon.Min == nil || *y.MemoryBalloon.Min == "" {
+		y.MemoryBalloon.Min = pointer.String(defaultMemoryBalloonMin(*y.Memory))
+	}
+
+	if y.MemoryBalloon.Max == nil {
+		y.MemoryBalloon.Max = d.MemoryBalloon.Max
+	}
+	if o.MemoryBalloon.Max != nil {
+		y.MemoryBalloon.Max = o.MemoryBalloon.Max
+	}
+	if y.MemoryBalloon.Max == nil || *y.MemoryBalloon.Max == "" {
+		y.MemoryBalloon.Max = pointer.String(*y.MaxMemory)
+	}
+
+	if y.MemoryBalloon.ReclaimInterval == nil {
+		y.MemoryBalloon.ReclaimInterval = d.MemoryBalloon.ReclaimInterval
+	}
+	if o.MemoryBalloon.ReclaimInterval != nil {
+		y.MemoryBalloon.ReclaimInterval = o.MemoryBalloon.ReclaimInterval
+	}
+	if y.MemoryBalloon.ReclaimInterval == nil || *y.MemoryBalloon.ReclaimInterval == "" {
+		y.MemoryBalloon.ReclaimInterval = pointer.String("1m")
+	}
+
 	if y.Disk == nil {
 		y.Disk = d.Disk
 	}
@@ -518,7 +588,7 @@ func FillDefault(y, d, o *LimaYAML, filePath string) {
 		y.MountType = o.MountType
 	}
 	if y.MountType == nil || *y.MountType == "" {
-		if *y.VMType == VZ {
+		if *y.VMType == VZ || *y.VMType == CloudHypervisor {
 			y.MountType = pointer.String(VIRTIOFS)
 		} else {
 			y.MountType = pointer.String(REVSSHFS)
@@ -712,6 +782,9 @@ func FillPortForwardDefaults(rule *PortForward, instDir string) {
 	if rule.Proto == "" {
 		rule.Proto = TCP
 	}
+	if rule.HostPortFallback == "" {
+		rule.HostPortFallback = HostPortFallbackNone
+	}
 	if rule.GuestIP == nil {
 		if rule.GuestIPMustBeZero {
 			rule.GuestIP = net.IPv4zero
@@ -720,7 +793,11 @@ func FillPortForwardDefaults(rule *PortForward, instDir string) {
 		}
 	}
 	if rule.HostIP == nil {
-		rule.HostIP = api.IPv4loopback1
+		if rule.GuestIP.Equal(net.IPv6loopback) {
+			rule.HostIP = net.IPv6loopback
+		} else {
+			rule.HostIP = api.IPv4loopback1
+		}
 	}
 	if rule.GuestPortRange[0] == 0 && rule.GuestPortRange[1] == 0 {
 		if rule.GuestPort == 0 {
@@ -758,6 +835,14 @@ func FillPortForwardDefaults(rule *PortForward, instDir string) {
 	}
 }
 
+// PortRangeString returns the port range as "80-90", or "80" for a single port.
+func PortRangeString(r [2]int) string {
+	if r[0] == r[1] {
+		return strconv.Itoa(r[0])
+	}
+	return fmt.Sprintf("%d-%d", r[0], r[1])
+}
+
 func FillCopyToHostDefaults(rule *CopyToHost, instDir string) {
 	if rule.GuestFile != "" {
 		if out, err := executeGuestTemplate(rule.GuestFile); err == nil {
@@ -830,7 +915,14 @@ func NewVMType(driver string) VMType {
 		return QEMU
 	case "wsl2":
 		return WSL2
+	case "cloud-hypervisor":
+		return CloudHypervisor
+	case "fake":
+		return Fake
 	default:
+		if strings.HasPrefix(driver, ExtVMTypePrefix) {
+			return driver
+		}
 		logrus.Warnf("Unknown driver: %s", driver)
 		return driver
 	}
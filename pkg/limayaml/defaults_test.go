@@ -69,8 +69,16 @@ func TestFillDefault(t *testing.T) {
 			X8664:   "qemu64",
 			RISCV64: "rv64",
 		},
-		CPUs:               pointer.Int(defaultCPUs()),
-		Memory:             pointer.String(defaultMemoryAsString()),
+		CPUs:      pointer.Int(defaultCPUs()),
+		Memory:    pointer.String(defaultMemoryAsString()),
+		MaxCPUs:   pointer.Int(defaultCPUs()),
+		MaxMemory: pointer.String(defaultMemoryAsString()),
+		MemoryBalloon: MemoryBalloon{
+			Enabled:         pointer.Bool(false),
+			Min:             pointer.String(defaultMemoryBalloonMin(defaultMemoryAsString())),
+			Max:             pointer.String(defaultMemoryAsString()),
+			ReclaimInterval: pointer.String("1m"),
+		},
 		Disk:               pointer.String(defaultDiskSizeAsString()),
 		GuestInstallPrefix: pointer.String(defaultGuestInstallPrefix()),
 		Containerd: Containerd{
@@ -121,12 +129,13 @@ func TestFillDefault(t *testing.T) {
 	}
 
 	defaultPortForward := PortForward{
-		GuestIP:        api.IPv4loopback1,
-		GuestPortRange: [2]int{1, 65535},
-		HostIP:         api.IPv4loopback1,
-		HostPortRange:  [2]int{1, 65535},
-		Proto:          TCP,
-		Reverse:        false,
+		GuestIP:          api.IPv4loopback1,
+		GuestPortRange:   [2]int{1, 65535},
+		HostIP:           api.IPv4loopback1,
+		HostPortRange:    [2]int{1, 65535},
+		Proto:            TCP,
+		Reverse:          false,
+		HostPortFallback: HostPortFallbackNone,
 	}
 
 	// ------------------------------------------------------------------------------------
@@ -275,9 +284,17 @@ func TestFillDefault(t *testing.T) {
 			X8664:   "amd64",
 			RISCV64: "riscv64",
 		},
-		CPUs:   pointer.Int(7),
-		Memory: pointer.String("5GiB"),
-		Disk:   pointer.String("105GiB"),
+		CPUs:      pointer.Int(7),
+		Memory:    pointer.String("5GiB"),
+		MaxCPUs:   pointer.Int(14),
+		MaxMemory: pointer.String("10GiB"),
+		MemoryBalloon: MemoryBalloon{
+			Enabled:         pointer.Bool(true),
+			Min:             pointer.String("2GiB"),
+			Max:             pointer.String("4GiB"),
+			ReclaimInterval: pointer.String("5m"),
+		},
+		Disk: pointer.String("105GiB"),
 		AdditionalDisks: []Disk{
 			{Name: "data"},
 		},
@@ -348,13 +365,14 @@ func TestFillDefault(t *testing.T) {
 			net.ParseIP("1.1.1.1"),
 		},
 		PortForwards: []PortForward{{
-			GuestIP:        api.IPv4loopback1,
-			GuestPort:      80,
-			GuestPortRange: [2]int{80, 80},
-			HostIP:         api.IPv4loopback1,
-			HostPort:       80,
-			HostPortRange:  [2]int{80, 80},
-			Proto:          TCP,
+			GuestIP:          api.IPv4loopback1,
+			GuestPort:        80,
+			GuestPortRange:   [2]int{80, 80},
+			HostIP:           api.IPv4loopback1,
+			HostPort:         80,
+			HostPortRange:    [2]int{80, 80},
+			Proto:            TCP,
+			HostPortFallback: HostPortFallbackNone,
 		}},
 		CopyToHost: []CopyToHost{{}},
 		Env: map[string]string{
@@ -453,9 +471,17 @@ func TestFillDefault(t *testing.T) {
 			X8664:   "pentium",
 			RISCV64: "sifive-u54",
 		},
-		CPUs:   pointer.Int(12),
-		Memory: pointer.String("7GiB"),
-		Disk:   pointer.String("117GiB"),
+		CPUs:      pointer.Int(12),
+		Memory:    pointer.String("7GiB"),
+		MaxCPUs:   pointer.Int(24),
+		MaxMemory: pointer.String("14GiB"),
+		MemoryBalloon: MemoryBalloon{
+			Enabled:         pointer.Bool(false),
+			Min:             pointer.String("3GiB"),
+			Max:             pointer.String("6GiB"),
+			ReclaimInterval: pointer.String("30s"),
+		},
+		Disk: pointer.String("117GiB"),
 		AdditionalDisks: []Disk{
 			{Name: "test"},
 		},
@@ -546,13 +572,14 @@ func TestFillDefault(t *testing.T) {
 			net.ParseIP("2.2.2.2"),
 		},
 		PortForwards: []PortForward{{
-			GuestIP:        api.IPv4loopback1,
-			GuestPort:      88,
-			GuestPortRange: [2]int{88, 88},
-			HostIP:         api.IPv4loopback1,
-			HostPort:       8080,
-			HostPortRange:  [2]int{8080, 8080},
-			Proto:          TCP,
+			GuestIP:          api.IPv4loopback1,
+			GuestPort:        88,
+			GuestPortRange:   [2]int{88, 88},
+			HostIP:           api.IPv4loopback1,
+			HostPort:         8080,
+			HostPortRange:    [2]int{8080, 8080},
+			Proto:            TCP,
+			HostPortFallback: HostPortFallbackNone,
 		}},
 		CopyToHost: []CopyToHost{{}},
 		Env: map[string]string{
@@ -14,7 +14,10 @@ type LimaYAML struct {
 	CPUType            map[Arch]string `yaml:"cpuType,omitempty" json:"cpuType,omitempty"`
 	CPUs               *int            `yaml:"cpus,omitempty" json:"cpus,omitempty"`
 	Memory             *string         `yaml:"memory,omitempty" json:"memory,omitempty"` // go-units.RAMInBytes
-	Disk               *string         `yaml:"disk,omitempty" json:"disk,omitempty"`     // go-units.RAMInBytes
+	MaxCPUs            *int            `yaml:"maxCPUs,omitempty" json:"maxCPUs,omitempty"`
+	MaxMemory          *string         `yaml:"maxMemory,omitempty" json:"maxMemory,omitempty"` // go-units.RAMInBytes
+	MemoryBalloon      MemoryBalloon   `yaml:"memoryBalloon,omitempty" json:"memoryBalloon,omitempty"`
+	Disk               *string         `yaml:"disk,omitempty" json:"disk,omitempty"` // go-units.RAMInBytes
 	AdditionalDisks    []Disk          `yaml:"additionalDisks,omitempty" json:"additionalDisks,omitempty"`
 	Mounts             []Mount         `yaml:"mounts,omitempty" json:"mounts,omitempty"`
 	MountType          *MountType      `yaml:"mountType,omitempty" json:"mountType,omitempty"`
@@ -61,6 +64,14 @@ const (
 	QEMU VMType = "qemu"
 	VZ   VMType = "vz"
 	WSL2 VMType = "wsl2"
+	// CloudHypervisor is supported only on Linux hosts.
+	CloudHypervisor VMType = "cloud-hypervisor"
+	// Fake runs the guest in a chroot on Linux hosts, without a hypervisor. Only for testing.
+	Fake VMType = "fake"
+
+	// ExtVMTypePrefix is the prefix of the vmTypes of external drivers, e.g. "ext:firecracker".
+	// See pkg/driver/external.
+	ExtVMTypePrefix = "ext:"
 )
 
 type Rosetta struct {
@@ -86,10 +97,11 @@ type Image struct {
 }
 
 type Disk struct {
-	Name   string   `yaml:"name" json:"name"` // REQUIRED
-	Format *bool    `yaml:"format,omitempty" json:"format,omitempty"`
-	FSType *string  `yaml:"fsType,omitempty" json:"fsType,omitempty"`
-	FSArgs []string `yaml:"fsArgs,omitempty" json:"fsArgs,omitempty"`
+	Name     string   `yaml:"name" json:"name"` // REQUIRED
+	Format   *bool    `yaml:"format,omitempty" json:"format,omitempty"`
+	FSType   *string  `yaml:"fsType,omitempty" json:"fsType,omitempty"`
+	FSArgs   []string `yaml:"fsArgs,omitempty" json:"fsArgs,omitempty"`
+	ReadOnly *bool    `yaml:"readOnly,omitempty" json:"readOnly,omitempty"`
 }
 
 type Mount struct {
@@ -194,6 +206,16 @@ type Proto = string
 
 const (
 	TCP Proto = "tcp"
+	UDP Proto = "udp"
+)
+
+type HostPortFallback = string
+
+const (
+	// HostPortFallbackNone does not forward the guest port when the host port is already in use
+	HostPortFallbackNone HostPortFallback = "none"
+	// HostPortFallbackNextFree forwards the guest port to the next free host port
+	HostPortFallbackNextFree HostPortFallback = "next-free"
 )
 
 type PortForward struct {
@@ -209,6 +231,8 @@ type PortForward struct {
 	Proto             Proto  `yaml:"proto,omitempty" json:"proto,omitempty"`
 	Reverse           bool   `yaml:"reverse,omitempty" json:"reverse,omitempty"`
 	Ignore            bool   `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+	// HostPortFallback is applied when the host port is already in use
+	HostPortFallback HostPortFallback `yaml:"hostPortFallback,omitempty" json:"hostPortFallback,omitempty"`
 }
 
 type CopyToHost struct {
@@ -234,6 +258,13 @@ type Network struct {
 	Interface            string `yaml:"interface,omitempty" json:"interface,omitempty"`
 }
 
+type MemoryBalloon struct {
+	Enabled         *bool   `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	Min             *string `yaml:"min,omitempty" json:"min,omitempty"`                         // go-units.RAMInBytes
+	Max             *string `yaml:"max,omitempty" json:"max,omitempty"`                         // go-units.RAMInBytes
+	ReclaimInterval *string `yaml:"reclaimInterval,omitempty" json:"reclaimInterval,omitempty"` // time.ParseDuration
+}
+
 type HostResolver struct {
 	Enabled *bool             `yaml:"enabled,omitempty" json:"enabled,omitempty"`
 	IPv6    *bool             `yaml:"ipv6,omitempty" json:"ipv6,omitempty"`
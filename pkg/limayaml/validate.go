@@ -6,8 +6,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	"errors"
 
@@ -18,6 +20,10 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// extDriverNameRegexp matches the names of external drivers.
+// The name is a part of the binary name, so it must not contain path separators.
+var extDriverNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 func validateFileObject(f File, fieldName string) error {
 	if !strings.Contains(f.Location, "://") {
 		if _, err := localpathutil.Expand(f.Location); err != nil {
@@ -62,8 +68,23 @@ func Validate(y LimaYAML, warn bool) error {
 		if !IsNativeArch(*y.Arch) {
 			return fmt.Errorf("field `arch` must be %q for VZ; got %q", NewArch(runtime.GOARCH), *y.Arch)
 		}
+	case CloudHypervisor:
+		if !IsNativeArch(*y.Arch) {
+			return fmt.Errorf("field `arch` must be %q for cloud-hypervisor; got %q", NewArch(runtime.GOARCH), *y.Arch)
+		}
+	case Fake:
+		if !IsNativeArch(*y.Arch) {
+			return fmt.Errorf("field `arch` must be %q for the fake driver; got %q", NewArch(runtime.GOARCH), *y.Arch)
+		}
 	default:
-		return fmt.Errorf("field `vmType` must be %q, %q, %q; got %q", QEMU, VZ, WSL2, *y.VMType)
+		if name, ok := strings.CutPrefix(*y.VMType, ExtVMTypePrefix); ok {
+			if !extDriverNameRegexp.MatchString(name) {
+				return fmt.Errorf("field `vmType` must be %q followed by the driver name matching %q; got %q",
+					ExtVMTypePrefix, extDriverNameRegexp.String(), *y.VMType)
+			}
+			break
+		}
+		return fmt.Errorf("field `vmType` must be %q, %q, %q, %q, or %q; got %q", QEMU, VZ, WSL2, CloudHypervisor, ExtVMTypePrefix+"<NAME>", *y.VMType)
 	}
 
 	if len(y.Images) == 0 {
@@ -109,10 +130,34 @@ func Validate(y LimaYAML, warn bool) error {
 		return errors.New("field `cpus` must be set")
 	}
 
-	if _, err := units.RAMInBytes(*y.Memory); err != nil {
+	memory, err := units.RAMInBytes(*y.Memory)
+	if err != nil {
 		return fmt.Errorf("field `memory` has an invalid value: %w", err)
 	}
 
+	maxMemory, err := units.RAMInBytes(*y.MaxMemory)
+	if err != nil {
+		return fmt.Errorf("field `maxMemory` has an invalid value: %w", err)
+	}
+	if *y.VMType != QEMU {
+		// Ignored, so that a template with maxCPUs and maxMemory can be used for other vmTypes too
+		if warn && (*y.MaxCPUs != *y.CPUs || maxMemory != memory) {
+			logrus.Warnf("Ignoring fields `maxCPUs` and `maxMemory`, as they are only supported for vmType %q", QEMU)
+		}
+		maxMemory = memory
+	} else {
+		if *y.MaxCPUs < *y.CPUs {
+			return fmt.Errorf("field `maxCPUs` must be greater than or equal to `cpus` (%d), got %d", *y.CPUs, *y.MaxCPUs)
+		}
+		if maxMemory < memory {
+			return fmt.Errorf("field `maxMemory` must be greater than or equal to `memory` (%q), got %q", *y.Memory, *y.MaxMemory)
+		}
+	}
+
+	if err := validateMemoryBalloon(y.MemoryBalloon, memory, maxMemory, *y.VMType); err != nil {
+		return err
+	}
+
 	if _, err := units.RAMInBytes(*y.Disk); err != nil {
 		return fmt.Errorf("field `memory` has an invalid value: %w", err)
 	}
@@ -202,83 +247,9 @@ func Validate(y LimaYAML, warn bool) error {
 		}
 	}
 	for i, rule := range y.PortForwards {
-		field := fmt.Sprintf("portForwards[%d]", i)
-		if rule.GuestIPMustBeZero && !rule.GuestIP.Equal(net.IPv4zero) {
-			return fmt.Errorf("field `%s.guestIPMustBeZero` can only be true when field `%s.guestIP` is 0.0.0.0", field, field)
-		}
-		if rule.GuestPort != 0 {
-			if rule.GuestSocket != "" {
-				return fmt.Errorf("field `%s.guestPort` must be 0 when field `%s.guestSocket` is set", field, field)
-			}
-			if rule.GuestPort != rule.GuestPortRange[0] {
-				return fmt.Errorf("field `%s.guestPort` must match field `%s.guestPortRange[0]`", field, field)
-			}
-			// redundant validation to make sure the error contains the correct field name
-			if err := validatePort(field+".guestPort", rule.GuestPort); err != nil {
-				return err
-			}
-		}
-		if rule.HostPort != 0 {
-			if rule.HostSocket != "" {
-				return fmt.Errorf("field `%s.hostPort` must be 0 when field `%s.hostSocket` is set", field, field)
-			}
-			if rule.HostPort != rule.HostPortRange[0] {
-				return fmt.Errorf("field `%s.hostPort` must match field `%s.hostPortRange[0]`", field, field)
-			}
-			// redundant validation to make sure the error contains the correct field name
-			if err := validatePort(field+".hostPort", rule.HostPort); err != nil {
-				return err
-			}
-		}
-		for j := 0; j < 2; j++ {
-			if err := validatePort(fmt.Sprintf("%s.guestPortRange[%d]", field, j), rule.GuestPortRange[j]); err != nil {
-				return err
-			}
-			if err := validatePort(fmt.Sprintf("%s.hostPortRange[%d]", field, j), rule.HostPortRange[j]); err != nil {
-				return err
-			}
-		}
-		if rule.GuestPortRange[0] > rule.GuestPortRange[1] {
-			return fmt.Errorf("field `%s.guestPortRange[1]` must be greater than or equal to field `%s.guestPortRange[0]`", field, field)
-		}
-		if rule.HostPortRange[0] > rule.HostPortRange[1] {
-			return fmt.Errorf("field `%s.hostPortRange[1]` must be greater than or equal to field `%s.hostPortRange[0]`", field, field)
-		}
-		if rule.GuestPortRange[1]-rule.GuestPortRange[0] != rule.HostPortRange[1]-rule.HostPortRange[0] {
-			return fmt.Errorf("field `%s.hostPortRange` must specify the same number of ports as field `%s.guestPortRange`", field, field)
-		}
-		if rule.GuestSocket != "" {
-			if !path.IsAbs(rule.GuestSocket) {
-				return fmt.Errorf("field `%s.guestSocket` must be an absolute path", field)
-			}
-			if rule.HostSocket == "" && rule.HostPortRange[1]-rule.HostPortRange[0] > 0 {
-				return fmt.Errorf("field `%s.guestSocket` can only be mapped to a single port or socket. not a range", field)
-			}
-		}
-		if rule.HostSocket != "" {
-			if !filepath.IsAbs(rule.HostSocket) {
-				// should be unreachable because FillDefault() will prepend the instance directory to relative names
-				return fmt.Errorf("field `%s.hostSocket` must be an absolute path, but is %q", field, rule.HostSocket)
-			}
-			if rule.GuestSocket == "" && rule.GuestPortRange[1]-rule.GuestPortRange[0] > 0 {
-				return fmt.Errorf("field `%s.hostSocket` can only be mapped from a single port or socket. not a range", field)
-			}
-		}
-		if len(rule.HostSocket) >= osutil.UnixPathMax {
-			return fmt.Errorf("field `%s.hostSocket` must be less than UNIX_PATH_MAX=%d characters, but is %d",
-				field, osutil.UnixPathMax, len(rule.HostSocket))
-		}
-		if rule.Proto != TCP {
-			return fmt.Errorf("field `%s.proto` must be %q", field, TCP)
-		}
-		if rule.Reverse && rule.GuestSocket == "" {
-			return fmt.Errorf("field `%s.reverse` must be %t", field, false)
-		}
-		if rule.Reverse && rule.HostSocket == "" {
-			return fmt.Errorf("field `%s.reverse` must be %t", field, false)
+		if err := ValidatePortForward(fmt.Sprintf("portForwards[%d]", i), rule); err != nil {
+			return err
 		}
-		// Not validating that the various GuestPortRanges and HostPortRanges are not overlapping. Rules will be
-		// processed sequentially and the first matching rule for a guest port determines forwarding behavior.
 	}
 	for i, rule := range y.CopyToHost {
 		field := fmt.Sprintf("CopyToHost[%d]", i)
@@ -307,6 +278,103 @@ func Validate(y LimaYAML, warn bool) error {
 	return nil
 }
 
+// ValidatePortForward validates a port forwarding rule that has already been filled with defaults.
+// field is used as the field name in error messages.
+func ValidatePortForward(field string, rule PortForward) error {
+	if rule.GuestIPMustBeZero && !rule.GuestIP.IsUnspecified() {
+		return fmt.Errorf("field `%s.guestIPMustBeZero` can only be true when field `%s.guestIP` is 0.0.0.0 or ::", field, field)
+	}
+	if rule.GuestPort != 0 {
+		if rule.GuestSocket != "" {
+			return fmt.Errorf("field `%s.guestPort` must be 0 when field `%s.guestSocket` is set", field, field)
+		}
+		if rule.GuestPort != rule.GuestPortRange[0] {
+			return fmt.Errorf("field `%s.guestPort` must match field `%s.guestPortRange[0]`", field, field)
+		}
+		// redundant validation to make sure the error contains the correct field name
+		if err := validatePort(field+".guestPort", rule.GuestPort); err != nil {
+			return err
+		}
+	}
+	if rule.HostPort != 0 {
+		if rule.HostSocket != "" {
+			return fmt.Errorf("field `%s.hostPort` must be 0 when field `%s.hostSocket` is set", field, field)
+		}
+		if rule.HostPort != rule.HostPortRange[0] {
+			return fmt.Errorf("field `%s.hostPort` must match field `%s.hostPortRange[0]`", field, field)
+		}
+		// redundant validation to make sure the error contains the correct field name
+		if err := validatePort(field+".hostPort", rule.HostPort); err != nil {
+			return err
+		}
+	}
+	for j := 0; j < 2; j++ {
+		if err := validatePort(fmt.Sprintf("%s.guestPortRange[%d]", field, j), rule.GuestPortRange[j]); err != nil {
+			return err
+		}
+		if err := validatePort(fmt.Sprintf("%s.hostPortRange[%d]", field, j), rule.HostPortRange[j]); err != nil {
+			return err
+		}
+	}
+	if rule.GuestPortRange[0] > rule.GuestPortRange[1] {
+		return fmt.Errorf("field `%s.guestPortRange[1]` must be greater than or equal to field `%s.guestPortRange[0]`", field, field)
+	}
+	if rule.HostPortRange[0] > rule.HostPortRange[1] {
+		return fmt.Errorf("field `%s.hostPortRange[1]` must be greater than or equal to field `%s.hostPortRange[0]`", field, field)
+	}
+	if rule.GuestPortRange[1]-rule.GuestPortRange[0] != rule.HostPortRange[1]-rule.HostPortRange[0] {
+		return fmt.Errorf("field `%s.hostPortRange` must specify the same number of ports as field `%s.guestPortRange`", field, field)
+	}
+	if rule.GuestSocket != "" {
+		if !path.IsAbs(rule.GuestSocket) {
+			return fmt.Errorf("field `%s.guestSocket` must be an absolute path", field)
+		}
+		if rule.HostSocket == "" && rule.HostPortRange[1]-rule.HostPortRange[0] > 0 {
+			return fmt.Errorf("field `%s.guestSocket` can only be mapped to a single port or socket. not a range", field)
+		}
+	}
+	if rule.HostSocket != "" {
+		if !filepath.IsAbs(rule.HostSocket) {
+			// should be unreachable because FillDefault() will prepend the instance directory to relative names
+			return fmt.Errorf("field `%s.hostSocket` must be an absolute path, but is %q", field, rule.HostSocket)
+		}
+		if rule.GuestSocket == "" && rule.GuestPortRange[1]-rule.GuestPortRange[0] > 0 {
+			return fmt.Errorf("field `%s.hostSocket` can only be mapped from a single port or socket. not a range", field)
+		}
+	}
+	if len(rule.HostSocket) >= osutil.UnixPathMax {
+		return fmt.Errorf("field `%s.hostSocket` must be less than UNIX_PATH_MAX=%d characters, but is %d",
+			field, osutil.UnixPathMax, len(rule.HostSocket))
+	}
+	switch rule.Proto {
+	case TCP:
+	case UDP:
+		if rule.GuestSocket != "" || rule.HostSocket != "" {
+			return fmt.Errorf("field `%s.proto` must be %q when forwarding sockets", field, TCP)
+		}
+	default:
+		return fmt.Errorf("field `%s.proto` must be %q or %q", field, TCP, UDP)
+	}
+	switch rule.HostPortFallback {
+	case HostPortFallbackNone:
+	case HostPortFallbackNextFree:
+		if rule.HostSocket != "" {
+			return fmt.Errorf("field `%s.hostPortFallback` must be %q when field `%s.hostSocket` is set", field, HostPortFallbackNone, field)
+		}
+	default:
+		return fmt.Errorf("field `%s.hostPortFallback` must be %q or %q", field, HostPortFallbackNone, HostPortFallbackNextFree)
+	}
+	if rule.Reverse && rule.GuestSocket == "" {
+		return fmt.Errorf("field `%s.reverse` must be %t", field, false)
+	}
+	if rule.Reverse && rule.HostSocket == "" {
+		return fmt.Errorf("field `%s.reverse` must be %t", field, false)
+	}
+	// Not validating that the various GuestPortRanges and HostPortRanges are not overlapping. Rules will be
+	// processed sequentially and the first matching rule for a guest port determines forwarding behavior.
+	return nil
+}
+
 func validateNetwork(y LimaYAML, warn bool) error {
 	interfaceName := make(map[string]int)
 	for i, nw := range y.Networks {
@@ -473,3 +541,38 @@ func warnExperimental(y LimaYAML) {
 		logrus.Warn("`audio.device` is experimental")
 	}
 }
+
+func validateMemoryBalloon(b MemoryBalloon, memory, maxMemory int64, vmType VMType) error {
+	balloonMin, err := units.RAMInBytes(*b.Min)
+	if err != nil {
+		return fmt.Errorf("field `memoryBalloon.min` has an invalid value: %w", err)
+	}
+	balloonMax, err := units.RAMInBytes(*b.Max)
+	if err != nil {
+		return fmt.Errorf("field `memoryBalloon.max` has an invalid value: %w", err)
+	}
+	interval, err := time.ParseDuration(*b.ReclaimInterval)
+	if err != nil {
+		return fmt.Errorf("field `memoryBalloon.reclaimInterval` has an invalid value: %w", err)
+	}
+	if !*b.Enabled {
+		return nil
+	}
+	if vmType != QEMU {
+		return fmt.Errorf("field `memoryBalloon.enabled` is only supported for vmType %q", QEMU)
+	}
+	if balloonMin <= 0 || balloonMin > balloonMax {
+		return fmt.Errorf("field `memoryBalloon.min` must be positive and less than or equal to `memoryBalloon.max` (%q), got %q", *b.Max, *b.Min)
+	}
+	if balloonMin > memory {
+		return fmt.Errorf("field `memoryBalloon.min` must be less than or equal to `memory`, got %q", *b.Min)
+	}
+	// The memory may be increased up to maxMemory on running instances
+	if balloonMax > maxMemory {
+		return fmt.Errorf("field `memoryBalloon.max` must be less than or equal to `maxMemory`, got %q", *b.Max)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("field `memoryBalloon.reclaimInterval` must be positive, got %q", *b.ReclaimInterval)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package limayaml
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/xorcare/pointer"
+	"gotest.tools/v3/assert"
+)
+
+func TestValidateMaxCPUsAndMaxMemory(t *testing.T) {
+	newYAML := func(vmType VMType) LimaYAML {
+		y := LimaYAML{
+			VMType:    pointer.String(vmType),
+			Images:    []Image{{File: File{Location: "https://example.com/ubuntu.img", Arch: NewArch(runtime.GOARCH)}}},
+			CPUs:      pointer.Int(2),
+			Memory:    pointer.String("2GiB"),
+			MaxCPUs:   pointer.Int(4),
+			MaxMemory: pointer.String("4GiB"),
+		}
+		FillDefault(&y, &LimaYAML{}, &LimaYAML{}, "/tmp/lima/lima.yaml")
+		return y
+	}
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	assert.NilError(t, Validate(newYAML(QEMU), false))
+
+	// ignored for other vmTypes
+	assert.NilError(t, Validate(newYAML(Fake), true))
+	var warned bool
+	for _, e := range hook.AllEntries() {
+		if e.Level == logrus.WarnLevel && strings.HasPrefix(e.Message, "Ignoring fields `maxCPUs` and `maxMemory`") {
+			warned = true
+		}
+	}
+	assert.Assert(t, warned)
+
+	y := newYAML(QEMU)
+	y.MaxCPUs = pointer.Int(1)
+	assert.ErrorContains(t, Validate(y, false), "field `maxCPUs` must be greater than or equal to `cpus`")
+	y = newYAML(QEMU)
+	y.MaxMemory = pointer.String("1GiB")
+	assert.ErrorContains(t, Validate(y, false), "field `maxMemory` must be greater than or equal to `memory`")
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/containerd/continuity/fs"
 	"github.com/docker/go-units"
 	"github.com/lima-vm/go-qcow2reader"
+	"github.com/lima-vm/go-qcow2reader/image"
 	"github.com/lima-vm/go-qcow2reader/image/qcow2"
 	"github.com/lima-vm/go-qcow2reader/image/raw"
 	"github.com/lima-vm/lima/pkg/osutil"
@@ -28,32 +29,19 @@ func ConvertToRaw(source, dest string, size *int64, allowSourceWithBackingFile b
 		return err
 	}
 	defer srcF.Close()
-	srcImg, err := qcow2reader.Open(srcF)
+	srcImg, err := openImage(source, srcF, allowSourceWithBackingFile)
 	if err != nil {
-		return fmt.Errorf("failed to detect the format of %q: %w", source, err)
+		return err
 	}
 	if size != nil && *size < srcImg.Size() {
 		return fmt.Errorf("specified size %d is smaller than the original image size (%d) of %q", *size, srcImg.Size(), source)
 	}
 	logrus.Infof("Converting %q (%s) to a raw disk %q", source, srcImg.Type(), dest)
-	switch t := srcImg.Type(); t {
-	case raw.Type:
+	if srcImg.Type() == raw.Type {
 		if err = srcF.Close(); err != nil {
 			return err
 		}
 		return convertRawToRaw(source, dest, size)
-	case qcow2.Type:
-		if !allowSourceWithBackingFile {
-			q, ok := srcImg.(*qcow2.Qcow2)
-			if !ok {
-				return fmt.Errorf("unexpected qcow2 image %T", srcImg)
-			}
-			if q.BackingFile != "" {
-				return fmt.Errorf("qcow2 image %q has an unexpected backing file: %q", source, q.BackingFile)
-			}
-		}
-	default:
-		logrus.Warnf("image %q has an unexpected format: %q", source, t)
 	}
 	if err = srcImg.Readable(); err != nil {
 		return fmt.Errorf("image %q is not readable: %w", source, err)
@@ -88,6 +76,9 @@ func ConvertToRaw(source, dest string, size *int64, allowSourceWithBackingFile b
 		if err = MakeSparse(destTmpF, *size); err != nil {
 			return err
 		}
+	} else if err = MakeSparse(destTmpF, copied); err != nil {
+		// copySparse does not extend the file for the trailing zero blocks
+		return err
 	}
 	if err = destTmpF.Close(); err != nil {
 		return err
@@ -100,6 +91,30 @@ func ConvertToRaw(source, dest string, size *int64, allowSourceWithBackingFile b
 	return os.Rename(destTmp, dest)
 }
 
+// openImage opens the image of srcF, and validates its format.
+func openImage(source string, srcF *os.File, allowSourceWithBackingFile bool) (image.Image, error) {
+	srcImg, err := qcow2reader.Open(srcF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect the format of %q: %w", source, err)
+	}
+	switch t := srcImg.Type(); t {
+	case raw.Type:
+	case qcow2.Type:
+		if !allowSourceWithBackingFile {
+			q, ok := srcImg.(*qcow2.Qcow2)
+			if !ok {
+				return nil, fmt.Errorf("unexpected qcow2 image %T", srcImg)
+			}
+			if q.BackingFile != "" {
+				return nil, fmt.Errorf("qcow2 image %q has an unexpected backing file: %q", source, q.BackingFile)
+			}
+		}
+	default:
+		logrus.Warnf("image %q has an unexpected format: %q", source, t)
+	}
+	return srcImg, nil
+}
+
 func convertRawToRaw(source, dest string, size *int64) error {
 	if source != dest {
 		// continuity attempts clonefile
@@ -122,6 +137,17 @@ func convertRawToRaw(source, dest string, size *int64) error {
 	return nil
 }
 
+// CopySparse copies r into w, leaving holes in w for the zero blocks of r.
+// w is truncated to the number of the copied bytes.
+func CopySparse(w *os.File, r io.Reader) (int64, error) {
+	const bufSize = 1024 * 1024
+	n, err := copySparse(w, r, bufSize)
+	if err != nil {
+		return n, fmt.Errorf("failed to call copySparse(), bufSize=%d, copied=%d: %w", bufSize, n, err)
+	}
+	return n, MakeSparse(w, n)
+}
+
 func copySparse(w *os.File, r io.Reader, bufSize int64) (int64, error) {
 	var n int64
 	zeroBuf := make([]byte, bufSize)
@@ -136,14 +162,14 @@ func copySparse(w *os.File, r io.Reader, bufSize int64) (int64, error) {
 			}
 		}
 		// TODO: qcow2reader should have a method to notify whether buf is zero
-		if bytes.Equal(buf, zeroBuf) {
+		if bytes.Equal(buf[:rN], zeroBuf[:rN]) {
 			if _, sErr := w.Seek(int64(rN), io.SeekCurrent); sErr != nil {
 				return n, fmt.Errorf("failed seek: %w", sErr)
 			}
 			// no need to ftruncate here
 			n += int64(rN)
 		} else {
-			wN, wErr := w.Write(buf)
+			wN, wErr := w.Write(buf[:rN])
 			if wN > 0 {
 				n += int64(wN)
 			}
@@ -0,0 +1,113 @@
+package nativeimgutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/lima-vm/go-qcow2reader"
+	"github.com/lima-vm/go-qcow2reader/image"
+	"github.com/lima-vm/go-qcow2reader/image/qcow2"
+	"gotest.tools/v3/assert"
+)
+
+func TestConvertToQcow2(t *testing.T) {
+	dir := t.TempDir()
+	rawDisk := filepath.Join(dir, "disk.raw")
+	// not aligned to the cluster size, and spans two L2 tables
+	data := make([]byte, 512<<20+3<<10)
+	copy(data[0:], "head")
+	copy(data[100<<20:], "middle")
+	copy(data[len(data)-64<<10:], "tail")
+	assert.NilError(t, os.WriteFile(rawDisk, data, 0o644))
+
+	qcow2Disk := filepath.Join(dir, "disk.qcow2")
+	assert.NilError(t, ConvertToQcow2(rawDisk, qcow2Disk, false))
+	st, err := os.Stat(qcow2Disk)
+	assert.NilError(t, err)
+	assert.Assert(t, st.Size() < 1<<20, "zero clusters must not be allocated, got %d bytes", st.Size())
+
+	f, err := os.Open(qcow2Disk)
+	assert.NilError(t, err)
+	defer f.Close()
+	img, err := qcow2reader.Open(f)
+	assert.NilError(t, err)
+	assert.Equal(t, img.Type(), image.Type(qcow2.Type))
+	assert.Equal(t, img.Size(), int64(len(data)))
+	assert.NilError(t, img.Readable())
+
+	// cross-check the metadata with qemu-img, when available
+	if qemuImg, err := exec.LookPath("qemu-img"); err != nil {
+		t.Log("qemu-img not found, skipping qemu-img check")
+	} else {
+		out, err := exec.Command(qemuImg, "check", qcow2Disk).CombinedOutput()
+		assert.NilError(t, err, string(out))
+	}
+
+	// convert back
+	rawDisk2 := filepath.Join(dir, "disk2.raw")
+	assert.NilError(t, ConvertToRaw(qcow2Disk, rawDisk2, nil, false))
+	b, err := os.ReadFile(rawDisk2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(b), len(data))
+	assert.Assert(t, bytes.Equal(b, data))
+}
+
+func TestSnapshotUsage(t *testing.T) {
+	dir := t.TempDir()
+	rawDisk := filepath.Join(dir, "disk.raw")
+	data := make([]byte, 4<<20)
+	copy(data[1<<20:], "data")
+	assert.NilError(t, os.WriteFile(rawDisk, data, 0o644))
+	usage, err := SnapshotUsage(rawDisk)
+	assert.NilError(t, err)
+	assert.Equal(t, usage, int64(0))
+
+	qcow2Disk := filepath.Join(dir, "disk.qcow2")
+	assert.NilError(t, ConvertToQcow2(rawDisk, qcow2Disk, false))
+	usage, err = SnapshotUsage(qcow2Disk)
+	assert.NilError(t, err)
+	assert.Equal(t, usage, int64(0))
+
+	// Append a snapshot that has a data cluster and an L2 table of its own,
+	// and shares the L2 table of the active image.
+	f, err := os.OpenFile(qcow2Disk, os.O_RDWR, 0o644)
+	assert.NilError(t, err)
+	defer f.Close()
+	st, err := f.Stat()
+	assert.NilError(t, err)
+	dataOffset := st.Size()
+	l2Offset := dataOffset + qcow2ClusterSize
+	l1Offset := l2Offset + qcow2ClusterSize
+	snapshotsOffset := l1Offset + qcow2ClusterSize
+	_, err = f.WriteAt([]byte("snapshot"), dataOffset)
+	assert.NilError(t, err)
+	l2Table := make([]uint64, qcow2ClusterSize/8)
+	l2Table[0] = uint64(dataOffset) | qcow2OflagCopied
+	assert.NilError(t, writeAt(f, l2Table, l2Offset))
+	// writeQcow2 lays out the header, the data cluster, the L2 table, and the L1 table in this order
+	var activeL1 [1]uint64
+	assert.NilError(t, binary.Read(io.NewSectionReader(f, qcow2ClusterSize*3, 8), binary.BigEndian, &activeL1))
+	assert.NilError(t, writeAt(f, []uint64{uint64(l2Offset), activeL1[0] &^ qcow2OflagCopied}, l1Offset))
+	entry := make([]byte, qcow2SnapshotHeaderSize+8)
+	binary.BigEndian.PutUint64(entry[0:], uint64(l1Offset))
+	binary.BigEndian.PutUint32(entry[8:], 2)  // l1_size
+	binary.BigEndian.PutUint16(entry[12:], 1) // id_str_size
+	binary.BigEndian.PutUint16(entry[14:], 4) // name_size
+	copy(entry[qcow2SnapshotHeaderSize:], "1snap")
+	_, err = f.WriteAt(entry, snapshotsOffset)
+	assert.NilError(t, err)
+	hdr := make([]byte, 12)
+	binary.BigEndian.PutUint32(hdr[0:], 1) // nb_snapshots
+	binary.BigEndian.PutUint64(hdr[4:], uint64(snapshotsOffset))
+	_, err = f.WriteAt(hdr, 60)
+	assert.NilError(t, err)
+
+	usage, err = SnapshotUsage(qcow2Disk)
+	assert.NilError(t, err)
+	assert.Equal(t, usage, int64(2*qcow2ClusterSize))
+}
@@ -0,0 +1,220 @@
+package nativeimgutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/lima-vm/lima/pkg/progressbar"
+	"github.com/sirupsen/logrus"
+)
+
+// The qcow2 images are written with the fixed parameters below, which are the defaults of `qemu-img create -f qcow2`.
+const (
+	qcow2ClusterBits   = 16
+	qcow2ClusterSize   = 1 << qcow2ClusterBits
+	qcow2RefcountOrder = 4 // 16-bit refcounts
+	qcow2HeaderLength  = 104
+	qcow2OflagCopied   = uint64(1) << 63
+)
+
+// qcow2Header is the version 3 header of a qcow2 image, without the header extensions.
+// https://gitlab.com/qemu-project/qemu/-/blob/v8.2.0/docs/interop/qcow2.txt
+type qcow2Header struct {
+	Magic                 [4]byte
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+	IncompatibleFeatures  uint64
+	CompatibleFeatures    uint64
+	AutoclearFeatures     uint64
+	RefcountOrder         uint32
+	HeaderLength          uint32
+}
+
+func divRoundUp(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// writeQcow2 writes size bytes read from r into w as a qcow2 image.
+// The zero clusters are not allocated.
+//
+// The data clusters are written in the order of r, followed by the L2 tables, the L1 table,
+// the refcount table, and the refcount blocks, so r is read only once.
+func writeQcow2(w io.WriterAt, r io.Reader, size int64) error {
+	const l2Entries = qcow2ClusterSize / 8
+	nClusters := divRoundUp(size, qcow2ClusterSize)
+	l1Size := divRoundUp(nClusters, l2Entries)
+	l2Tables := make([][]uint64, l1Size)
+
+	// The cluster 0 is the header
+	offset := int64(qcow2ClusterSize)
+	buf := make([]byte, qcow2ClusterSize)
+	zeroBuf := make([]byte, qcow2ClusterSize)
+	for i := int64(0); i < nClusters; i++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil {
+			if !errors.Is(err, io.ErrUnexpectedEOF) || i != nClusters-1 {
+				return fmt.Errorf("failed to read cluster %d: %w", i, err)
+			}
+			// the last cluster is padded with zeros
+			copy(buf[n:], zeroBuf)
+		}
+		if bytes.Equal(buf, zeroBuf) {
+			continue
+		}
+		if _, err := w.WriteAt(buf, offset); err != nil {
+			return err
+		}
+		l1Index, l2Index := i/l2Entries, i%l2Entries
+		if l2Tables[l1Index] == nil {
+			l2Tables[l1Index] = make([]uint64, l2Entries)
+		}
+		l2Tables[l1Index][l2Index] = uint64(offset) | qcow2OflagCopied
+		offset += qcow2ClusterSize
+	}
+
+	l1Table := make([]uint64, l1Size)
+	for i, l2Table := range l2Tables {
+		if l2Table == nil {
+			continue
+		}
+		if err := writeAt(w, l2Table, offset); err != nil {
+			return err
+		}
+		l1Table[i] = uint64(offset) | qcow2OflagCopied
+		offset += qcow2ClusterSize
+	}
+
+	l1TableOffset := offset
+	if err := writeAt(w, l1Table, l1TableOffset); err != nil {
+		return err
+	}
+	l1TableClusters := divRoundUp(l1Size*8, qcow2ClusterSize)
+	if l1TableClusters == 0 {
+		l1TableClusters = 1
+	}
+	offset += l1TableClusters * qcow2ClusterSize
+
+	// The refcount structures have to cover themselves too
+	const refcountBlockEntries = qcow2ClusterSize * 8 / (1 << qcow2RefcountOrder)
+	usedClusters := offset / qcow2ClusterSize
+	var refcountBlocks, refcountTableClusters int64
+	for {
+		total := usedClusters + refcountBlocks + refcountTableClusters
+		newRefcountBlocks := divRoundUp(total, refcountBlockEntries)
+		newRefcountTableClusters := divRoundUp(newRefcountBlocks*8, qcow2ClusterSize)
+		if newRefcountBlocks == refcountBlocks && newRefcountTableClusters == refcountTableClusters {
+			break
+		}
+		refcountBlocks, refcountTableClusters = newRefcountBlocks, newRefcountTableClusters
+	}
+	totalClusters := usedClusters + refcountBlocks + refcountTableClusters
+
+	refcountTableOffset := offset
+	refcountTable := make([]uint64, refcountBlocks)
+	offset += refcountTableClusters * qcow2ClusterSize
+	refcountBlock := make([]uint16, refcountBlockEntries)
+	for i := int64(0); i < refcountBlocks; i++ {
+		refcountTable[i] = uint64(offset)
+		for j := range refcountBlock {
+			refcountBlock[j] = 0
+			if i*refcountBlockEntries+int64(j) < totalClusters {
+				refcountBlock[j] = 1
+			}
+		}
+		if err := writeAt(w, refcountBlock, offset); err != nil {
+			return err
+		}
+		offset += qcow2ClusterSize
+	}
+	if err := writeAt(w, refcountTable, refcountTableOffset); err != nil {
+		return err
+	}
+
+	header := qcow2Header{
+		Magic:                 [4]byte{'Q', 'F', 'I', 0xfb},
+		Version:               3,
+		ClusterBits:           qcow2ClusterBits,
+		Size:                  uint64(size),
+		L1Size:                uint32(l1Size),
+		L1TableOffset:         uint64(l1TableOffset),
+		RefcountTableOffset:   uint64(refcountTableOffset),
+		RefcountTableClusters: uint32(refcountTableClusters),
+		RefcountOrder:         qcow2RefcountOrder,
+		HeaderLength:          qcow2HeaderLength,
+	}
+	// The header extension area is terminated by the zero-filled end-of-extensions marker
+	return writeAt(w, header, 0)
+}
+
+func writeAt(w io.WriterAt, data any, offset int64) error {
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.BigEndian, data); err != nil {
+		return err
+	}
+	_, err := w.WriteAt(b.Bytes(), offset)
+	return err
+}
+
+// ConvertToQcow2 converts a source disk into a qcow2 disk without a backing file.
+// The zero clusters of the source disk are not allocated in the dest disk.
+// source and dest may be same.
+func ConvertToQcow2(source, dest string, allowSourceWithBackingFile bool) error {
+	srcF, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer srcF.Close()
+	srcImg, err := openImage(source, srcF, allowSourceWithBackingFile)
+	if err != nil {
+		return err
+	}
+	if err = srcImg.Readable(); err != nil {
+		return fmt.Errorf("image %q is not readable: %w", source, err)
+	}
+	logrus.Infof("Converting %q (%s) to a qcow2 disk %q", source, srcImg.Type(), dest)
+
+	// Create a tmp file because source and dest can be same.
+	destTmpF, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".lima-*.tmp")
+	if err != nil {
+		return err
+	}
+	destTmp := destTmpF.Name()
+	defer os.RemoveAll(destTmp)
+	defer destTmpF.Close()
+
+	srcImgR := io.NewSectionReader(srcImg, 0, srcImg.Size())
+	bar, err := progressbar.New(srcImg.Size())
+	if err != nil {
+		return err
+	}
+	bar.Start()
+	err = writeQcow2(destTmpF, bar.NewProxyReader(srcImgR), srcImg.Size())
+	bar.Finish()
+	if err != nil {
+		return fmt.Errorf("failed to write a qcow2 image: %w", err)
+	}
+	if err = destTmpF.Close(); err != nil {
+		return err
+	}
+
+	// Rename destTmp into dest
+	if err = os.RemoveAll(dest); err != nil {
+		return err
+	}
+	return os.Rename(destTmp, dest)
+}
@@ -0,0 +1,114 @@
+package nativeimgutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/lima-vm/go-qcow2reader"
+	"github.com/lima-vm/go-qcow2reader/image/qcow2"
+)
+
+const (
+	qcow2OffsetMask      = 0x00fffffffffffe00
+	qcow2OflagCompressed = uint64(1) << 62
+	// qcow2SnapshotHeaderSize is the size of the fixed part of a snapshot table entry
+	qcow2SnapshotHeaderSize = 40
+)
+
+// SnapshotUsage returns the bytes of the qcow2 image f that are only used by the internal snapshots,
+// i.e., the bytes that would be reclaimed by deleting all the snapshots.
+// The VM states of the snapshots are included.
+// SnapshotUsage returns 0 for the images that are not qcow2.
+func SnapshotUsage(f string) (int64, error) {
+	r, err := os.Open(f)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	img, err := qcow2reader.Open(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to detect the format of %q: %w", f, err)
+	}
+	q, ok := img.(*qcow2.Qcow2)
+	if !ok || q.NbSnapshots == 0 {
+		return 0, nil
+	}
+	clusterSize := int64(1) << q.ClusterBits
+
+	active, err := qcow2Clusters(r, clusterSize, q.L1TableOffset, q.L1Size)
+	if err != nil {
+		return 0, err
+	}
+	var snapshotClusters []uint64
+	offset := int64(q.SnapshotsOffset)
+	hdr := make([]byte, qcow2SnapshotHeaderSize)
+	for i := uint32(0); i < q.NbSnapshots; i++ {
+		if _, err := r.ReadAt(hdr, offset); err != nil {
+			return 0, fmt.Errorf("failed to read snapshot %d of %q: %w", i, f, err)
+		}
+		l1TableOffset := binary.BigEndian.Uint64(hdr[0:8])
+		l1Size := binary.BigEndian.Uint32(hdr[8:12])
+		idStrSize := binary.BigEndian.Uint16(hdr[12:14])
+		nameSize := binary.BigEndian.Uint16(hdr[14:16])
+		extraDataSize := binary.BigEndian.Uint32(hdr[36:40])
+		clusters, err := qcow2Clusters(r, clusterSize, l1TableOffset, l1Size)
+		if err != nil {
+			return 0, err
+		}
+		snapshotClusters = append(snapshotClusters, clusters...)
+		// the entries are aligned to 8 bytes
+		entrySize := int64(qcow2SnapshotHeaderSize) + int64(extraDataSize) + int64(idStrSize) + int64(nameSize)
+		offset += divRoundUp(entrySize, 8) * 8
+	}
+	sortUint64s(snapshotClusters)
+
+	var n int64
+	for i, c := range snapshotClusters {
+		if i > 0 && snapshotClusters[i-1] == c {
+			continue
+		}
+		j := sort.Search(len(active), func(j int) bool { return active[j] >= c })
+		if j == len(active) || active[j] != c {
+			n++
+		}
+	}
+	return n * clusterSize, nil
+}
+
+// qcow2Clusters returns the sorted host offsets of the L2 tables and the data clusters referenced by the L1 table.
+// The compressed clusters are ignored.
+func qcow2Clusters(r io.ReaderAt, clusterSize int64, l1TableOffset uint64, l1Size uint32) ([]uint64, error) {
+	l1Table := make([]uint64, l1Size)
+	if err := binary.Read(io.NewSectionReader(r, int64(l1TableOffset), int64(l1Size)*8), binary.BigEndian, l1Table); err != nil {
+		return nil, fmt.Errorf("failed to read the L1 table at %d: %w", l1TableOffset, err)
+	}
+	var clusters []uint64
+	l2Table := make([]uint64, clusterSize/8)
+	for _, l1Entry := range l1Table {
+		l2TableOffset := l1Entry & qcow2OffsetMask
+		if l2TableOffset == 0 {
+			continue
+		}
+		clusters = append(clusters, l2TableOffset)
+		if err := binary.Read(io.NewSectionReader(r, int64(l2TableOffset), clusterSize), binary.BigEndian, l2Table); err != nil {
+			return nil, fmt.Errorf("failed to read the L2 table at %d: %w", l2TableOffset, err)
+		}
+		for _, l2Entry := range l2Table {
+			if l2Entry&qcow2OflagCompressed != 0 {
+				continue
+			}
+			if off := l2Entry & qcow2OffsetMask; off != 0 {
+				clusters = append(clusters, off)
+			}
+		}
+	}
+	sortUint64s(clusters)
+	return clusters, nil
+}
+
+func sortUint64s(x []uint64) {
+	sort.Slice(x, func(i, j int) bool { return x[i] < x[j] })
+}
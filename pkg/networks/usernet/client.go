@@ -48,6 +48,24 @@ func (c *Client) UnExposeSSH(sshPort int) error {
 	})
 }
 
+// Expose forwards the host address local to the guest address remote, e.g., "192.168.104.2:80".
+// protocol is "tcp" or "udp".
+func (c *Client) Expose(local, remote, protocol string) error {
+	return c.delegate.Expose(&types.ExposeRequest{
+		Local:    local,
+		Remote:   remote,
+		Protocol: types.TransportProtocol(protocol),
+	})
+}
+
+// Unexpose stops forwarding the host address local.
+func (c *Client) Unexpose(local, protocol string) error {
+	return c.delegate.Unexpose(&types.UnexposeRequest{
+		Local:    local,
+		Protocol: types.TransportProtocol(protocol),
+	})
+}
+
 func (c *Client) AddDNSHosts(hosts map[string]string) error {
 	hosts["host.lima.internal"] = GatewayIP(c.subnet)
 	zones := dnshosts.ExtractZones(hosts)
@@ -2,8 +2,21 @@
 
 package osutil
 
-import "golang.org/x/sys/unix"
+import (
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
 
 func Ftruncate(fd int, length int64) (err error) {
 	return unix.Ftruncate(fd, length)
 }
+
+// AllocatedSize returns the bytes allocated on the filesystem for the file, excluding the holes of a sparse file.
+func AllocatedSize(fi fs.FileInfo) int64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512
+	}
+	return fi.Size()
+}
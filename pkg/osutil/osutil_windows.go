@@ -36,3 +36,8 @@ func SysKill(pid int, _ Signal) error {
 func Ftruncate(_ int, _ int64) (err error) {
 	return fmt.Errorf("unimplemented")
 }
+
+// AllocatedSize returns the size of the file, as the holes of a sparse file are not inspected on Windows.
+func AllocatedSize(fi fs.FileInfo) int64 {
+	return fi.Size()
+}
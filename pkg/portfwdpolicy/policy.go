@@ -0,0 +1,204 @@
+// Package portfwdpolicy implements the host-wide port forwarding policy
+// that is loaded from the _config/port-forward-policy.yaml file.
+//
+// The policy caps the host addresses and the host ports that the port forwards of
+// any instance may bind, and filters the clients that may connect to the forwarded ports.
+package portfwdpolicy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store/dirnames"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+)
+
+// ErrViolation is returned when a port forward violates the policy.
+var ErrViolation = errors.New("port forwarding policy violation")
+
+// Policy is the content of the _config/port-forward-policy.yaml file.
+//
+// A nil *Policy allows everything.
+type Policy struct {
+	// HostIPs are the host addresses (IPs or CIDRs) that the port forwards may bind.
+	// Empty means any address.
+	HostIPs []string `yaml:"hostIPs,omitempty" json:"hostIPs,omitempty"`
+	// HostPortRange is the range of the host ports that the port forwards may bind.
+	// [0, 0] means any port.
+	HostPortRange [2]int `yaml:"hostPortRange,omitempty" json:"hostPortRange,omitempty"`
+	// AllowFrom are the CIDRs of the clients that may connect to the forwarded ports.
+	// Empty means any client.
+	AllowFrom []string `yaml:"allowFrom,omitempty" json:"allowFrom,omitempty"`
+	// DenyFrom are the CIDRs of the clients that may not connect to the forwarded ports.
+	// DenyFrom takes precedence over AllowFrom.
+	DenyFrom []string `yaml:"denyFrom,omitempty" json:"denyFrom,omitempty"`
+
+	hostIPs   []*net.IPNet
+	allowFrom []*net.IPNet
+	denyFrom  []*net.IPNet
+}
+
+// ConfigFile returns the path of the _config/port-forward-policy.yaml file.
+func ConfigFile() (string, error) {
+	configDir, err := dirnames.LimaConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, filenames.PortForwardPolicy), nil
+}
+
+// Load loads the _config/port-forward-policy.yaml file.
+// Load returns nil without an error when the file does not exist.
+func Load() (*Policy, error) {
+	configFile, err := ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", configFile, err)
+	}
+	return p, nil
+}
+
+// Parse parses and validates the policy.
+func Parse(b []byte) (*Policy, error) {
+	var p Policy
+	if err := yaml.UnmarshalWithOptions(b, &p, yaml.Strict()); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.hostIPs, err = parseCIDRs("hostIPs", p.HostIPs); err != nil {
+		return nil, err
+	}
+	if p.allowFrom, err = parseCIDRs("allowFrom", p.AllowFrom); err != nil {
+		return nil, err
+	}
+	if p.denyFrom, err = parseCIDRs("denyFrom", p.DenyFrom); err != nil {
+		return nil, err
+	}
+	if p.HostPortRange != [2]int{0, 0} {
+		for i, port := range p.HostPortRange {
+			if port < 1 || port > 65535 {
+				return nil, fmt.Errorf("field `hostPortRange[%d]` must be between 1 and 65535", i)
+			}
+		}
+		if p.HostPortRange[0] > p.HostPortRange[1] {
+			return nil, errors.New("field `hostPortRange[1]` must be greater than or equal to field `hostPortRange[0]`")
+		}
+	}
+	return &p, nil
+}
+
+// parseCIDRs parses the CIDRs. A plain IP address is parsed as a single-address CIDR.
+func parseCIDRs(field string, ss []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(ss))
+	for i, s := range ss {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("field `%s[%d]` must be an IP address or a CIDR: %q", field, i, s)
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) checkHostIP(ip net.IP) error {
+	if len(p.hostIPs) > 0 && !containsIP(p.hostIPs, ip) {
+		return fmt.Errorf("%w: host IP %s is not allowed", ErrViolation, ip)
+	}
+	return nil
+}
+
+func (p *Policy) checkHostPorts(first, last int) error {
+	if p.HostPortRange == [2]int{0, 0} {
+		return nil
+	}
+	if first < p.HostPortRange[0] || last > p.HostPortRange[1] {
+		return fmt.Errorf("%w: host port %s is out of the allowed range %d-%d",
+			ErrViolation, limayaml.PortRangeString([2]int{first, last}), p.HostPortRange[0], p.HostPortRange[1])
+	}
+	return nil
+}
+
+// CheckHostAddress checks whether a port forward may bind the host address, e.g., "127.0.0.1:8080".
+// The host sockets, e.g., "/tmp/foo.sock", are not subject to the policy, as in CheckRule.
+func (p *Policy) CheckHostAddress(hostAddr string) error {
+	if p == nil || strings.HasPrefix(hostAddr, "/") {
+		return nil
+	}
+	host, portStr, err := net.SplitHostPort(hostAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	if err := p.checkHostIP(net.ParseIP(host)); err != nil {
+		return err
+	}
+	return p.checkHostPorts(port, port)
+}
+
+// CheckRule checks whether all the host addresses of the rule may be bound.
+// The rule must be filled with defaults. The host sockets are not subject to the policy.
+func (p *Policy) CheckRule(rule limayaml.PortForward) error {
+	if p == nil || rule.HostSocket != "" || rule.Ignore {
+		return nil
+	}
+	if err := p.checkHostIP(rule.HostIP); err != nil {
+		return err
+	}
+	return p.checkHostPorts(rule.HostPortRange[0], rule.HostPortRange[1])
+}
+
+// FiltersClients returns true if the policy restricts the clients that may connect to the forwarded ports.
+func (p *Policy) FiltersClients() bool {
+	return p != nil && (len(p.allowFrom) > 0 || len(p.denyFrom) > 0)
+}
+
+// CheckClient checks whether the client may connect to the forwarded ports.
+func (p *Policy) CheckClient(ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+	if containsIP(p.denyFrom, ip) {
+		return fmt.Errorf("%w: client %s is denied", ErrViolation, ip)
+	}
+	if len(p.allowFrom) > 0 && !containsIP(p.allowFrom, ip) {
+		return fmt.Errorf("%w: client %s is not allowed", ErrViolation, ip)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package portfwdpolicy
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"gotest.tools/v3/assert"
+)
+
+func TestParse(t *testing.T) {
+	_, err := Parse([]byte("hostIPs: [localhost]"))
+	assert.ErrorContains(t, err, "hostIPs[0]")
+
+	_, err = Parse([]byte("hostPortRange: [2000, 1000]"))
+	assert.ErrorContains(t, err, "hostPortRange[1]")
+
+	_, err = Parse([]byte("unknown: true"))
+	assert.ErrorContains(t, err, "unknown")
+}
+
+func TestCheckHostAddress(t *testing.T) {
+	var nilPolicy *Policy
+	assert.NilError(t, nilPolicy.CheckHostAddress("0.0.0.0:80"))
+
+	p, err := Parse([]byte(`
+hostIPs: ["127.0.0.1", "::1", "192.168.5.0/24"]
+hostPortRange: [1024, 65535]
+`))
+	assert.NilError(t, err)
+	assert.NilError(t, p.CheckHostAddress("127.0.0.1:8080"))
+	assert.NilError(t, p.CheckHostAddress("[::1]:8080"))
+	assert.NilError(t, p.CheckHostAddress("192.168.5.2:8080"))
+
+	// host sockets are not restricted
+	assert.NilError(t, p.CheckHostAddress("/tmp/foo.sock"))
+
+	err = p.CheckHostAddress("0.0.0.0:8080")
+	assert.Assert(t, errors.Is(err, ErrViolation))
+	assert.ErrorContains(t, err, "host IP 0.0.0.0")
+
+	err = p.CheckHostAddress("127.0.0.1:80")
+	assert.Assert(t, errors.Is(err, ErrViolation))
+	assert.ErrorContains(t, err, "host port 80")
+}
+
+func TestCheckRule(t *testing.T) {
+	p, err := Parse([]byte(`
+hostIPs: ["127.0.0.1"]
+hostPortRange: [1024, 65535]
+`))
+	assert.NilError(t, err)
+
+	rule := limayaml.PortForward{GuestPortRange: [2]int{8000, 8010}}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	assert.NilError(t, p.CheckRule(rule))
+
+	rule = limayaml.PortForward{GuestPortRange: [2]int{1000, 1100}}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	assert.ErrorContains(t, p.CheckRule(rule), "host port 1000-1100")
+
+	rule = limayaml.PortForward{GuestPort: 8080, HostIP: net.IPv4zero}
+	limayaml.FillPortForwardDefaults(&rule, t.TempDir())
+	assert.ErrorContains(t, p.CheckRule(rule), "host IP 0.0.0.0")
+}
+
+func TestCheckClient(t *testing.T) {
+	p, err := Parse([]byte(`
+allowFrom: ["127.0.0.0/8", "192.168.1.0/24"]
+denyFrom: ["192.168.1.13"]
+`))
+	assert.NilError(t, err)
+	assert.Assert(t, p.FiltersClients())
+	assert.NilError(t, p.CheckClient(net.ParseIP("127.0.0.1")))
+	assert.NilError(t, p.CheckClient(net.ParseIP("192.168.1.12")))
+	// IPv4-mapped IPv6 addresses, as seen on dual-stack sockets
+	assert.NilError(t, p.CheckClient(net.ParseIP("::ffff:192.168.1.12")))
+	assert.ErrorContains(t, p.CheckClient(net.ParseIP("192.168.1.13")), "denied")
+	assert.ErrorContains(t, p.CheckClient(net.ParseIP("10.0.0.1")), "not allowed")
+
+	p, err = Parse([]byte(`hostPortRange: [1024, 65535]`))
+	assert.NilError(t, err)
+	assert.Assert(t, !p.FiltersClients())
+}
@@ -64,6 +64,18 @@ type InfoFormatSpecificDataVmdkExtent struct {
 	ClusterSize int    `json:"cluster-size,omitempty"` // since QEMU 1.7
 }
 
+// InfoSnapshot corresponds to an element of the "snapshots" array of `qemu-img info --output=json FILE`
+type InfoSnapshot struct {
+	ID          string `json:"id,omitempty"`            // since QEMU 1.3
+	Name        string `json:"name,omitempty"`          // since QEMU 1.3
+	VMStateSize int64  `json:"vm-state-size,omitempty"` // since QEMU 1.3
+	DateSec     int64  `json:"date-sec,omitempty"`      // since QEMU 1.3
+	DateNsec    int64  `json:"date-nsec,omitempty"`     // since QEMU 1.3
+	VMClockSec  int64  `json:"vm-clock-sec,omitempty"`  // since QEMU 1.3
+	VMClockNsec int64  `json:"vm-clock-nsec,omitempty"` // since QEMU 1.3
+	ICount      int64  `json:"icount,omitempty"`        // since QEMU 5.2
+}
+
 // Info corresponds to the output of `qemu-img info --output=json FILE`
 type Info struct {
 	Filename              string              `json:"filename,omitempty"`                // since QEMU 1.3
@@ -77,6 +89,7 @@ type Info struct {
 	BackingFilenameFormat string              `json:"backing-filename-format,omitempty"` // since QEMU 1.3
 	FormatSpecific        *InfoFormatSpecific `json:"format-specific,omitempty"`         // since QEMU 1.7
 	Children              []InfoChild         `json:"children,omitempty"`                // since QEMU 8.0
+	Snapshots             []InfoSnapshot      `json:"snapshots,omitempty"`               // since QEMU 1.3
 }
 
 func ConvertToRaw(source string, dest string) error {
@@ -150,3 +163,136 @@ func AcceptableAsBasedisk(info *Info) error {
 	}
 	return nil
 }
+
+// Rebase changes the backing file of the image, without modifying the data (`qemu-img rebase -u`).
+func Rebase(f, backingFile, backingFormat string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("qemu-img", "rebase", "-u", "-F", backingFormat, "-b", backingFile, f)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %v: stdout=%q, stderr=%q: %w",
+			cmd.Args, stdout.String(), stderr.String(), err)
+	}
+	return nil
+}
+
+// DeleteSnapshot deletes the internal snapshot of the image (`qemu-img snapshot -d`).
+func DeleteSnapshot(f, tag string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("qemu-img", "snapshot", "-d", tag, f)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %v: stdout=%q, stderr=%q: %w",
+			cmd.Args, stdout.String(), stderr.String(), err)
+	}
+	return nil
+}
+
+// Convert converts the image into the format, merging the backing files (`qemu-img convert`).
+// The snapshots of the image are not copied.
+func Convert(source, dest, format string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("qemu-img", "convert", "-O", format, source, dest)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %v: stdout=%q, stderr=%q: %w",
+			cmd.Args, stdout.String(), stderr.String(), err)
+	}
+	return nil
+}
+
+// CreateOverlay creates the qcow2 overlay image f of the backing file.
+func CreateOverlay(f, backingFile, backingFormat string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("qemu-img", "create", "-f", "qcow2", "-F", backingFormat, "-b", backingFile, f)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %v: stdout=%q, stderr=%q: %w",
+			cmd.Args, stdout.String(), stderr.String(), err)
+	}
+	return nil
+}
+
+// ConvertOverlay converts the qcow2 overlay image source into dest, keeping the backing file.
+// Only the clusters that differ from the backing file are written, and the zero clusters are omitted.
+func ConvertOverlay(source, dest, backingFile, backingFormat string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("qemu-img", "convert", "-O", "qcow2", "-B", backingFile, "-F", backingFormat, source, dest)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run %v: stdout=%q, stderr=%q: %w",
+			cmd.Args, stdout.String(), stderr.String(), err)
+	}
+	return nil
+}
+
+// MapEntry corresponds to an element of the output of `qemu-img map --output=json FILE`
+type MapEntry struct {
+	Start   int64 `json:"start"`
+	Length  int64 `json:"length"`
+	Depth   int   `json:"depth"`
+	Present bool  `json:"present"`
+	Zero    bool  `json:"zero"`
+	Data    bool  `json:"data"`
+}
+
+// UsedSize returns the end offset of the last block that contains data.
+// Truncating the image to UsedSize does not lose data.
+func UsedSize(f string) (int64, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("qemu-img", "map", "--output=json", "--force-share", f)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("failed to run %v: stdout=%q, stderr=%q: %w",
+			cmd.Args, stdout.String(), stderr.String(), err)
+	}
+	return parseUsedSize(stdout.Bytes())
+}
+
+// IsEmptyOverlay returns true if the overlay image f has no clusters allocated in itself,
+// i.e., all the data is read from the backing files.
+func IsEmptyOverlay(f string) (bool, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("qemu-img", "map", "--output=json", "--force-share", f)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("failed to run %v: stdout=%q, stderr=%q: %w",
+			cmd.Args, stdout.String(), stderr.String(), err)
+	}
+	return parseIsEmptyOverlay(stdout.Bytes())
+}
+
+func parseIsEmptyOverlay(b []byte) (bool, error) {
+	var entries []MapEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		// the depth of the clusters allocated in the overlay itself is 0
+		if e.Depth == 0 && (e.Present || e.Data || e.Zero) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func parseUsedSize(b []byte) (int64, error) {
+	var entries []MapEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return 0, err
+	}
+	var used int64
+	for _, e := range entries {
+		if e.Data && !e.Zero && e.Start+e.Length > used {
+			used = e.Start + e.Length
+		}
+	}
+	return used, nil
+}
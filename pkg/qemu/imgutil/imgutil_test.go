@@ -111,6 +111,38 @@ func TestParseInfo(t *testing.T) {
 			assert.Check(t, qcow2 != nil)
 			assert.Equal(t, qcow2.Compat, "1.1")
 		})
+
+		t.Run("snapshots", func(t *testing.T) {
+			// qemu-img info --output=json diffdisk, after `savevm snap1`
+			// (QEMU 8.0, trimmed)
+			const s = `{
+    "snapshots": [
+        {
+            "icount": 0,
+            "vm-clock-nsec": 123456789,
+            "name": "snap1",
+            "date-sec": 1700000000,
+            "date-nsec": 500000000,
+            "vm-clock-sec": 42,
+            "id": "1",
+            "vm-state-size": 268435456
+        }
+    ],
+    "virtual-size": 107374182400,
+    "filename": "diffdisk",
+    "cluster-size": 65536,
+    "format": "qcow2",
+    "actual-size": 1073741824,
+    "dirty-flag": false
+}`
+			info, err := ParseInfo([]byte(s))
+			assert.NilError(t, err)
+			assert.Equal(t, 1, len(info.Snapshots))
+			assert.Equal(t, "snap1", info.Snapshots[0].Name)
+			assert.Equal(t, int64(268435456), info.Snapshots[0].VMStateSize)
+			assert.Equal(t, int64(1700000000), info.Snapshots[0].DateSec)
+			assert.Equal(t, int64(42), info.Snapshots[0].VMClockSec)
+		})
 	})
 	t.Run("vmdk", func(t *testing.T) {
 		t.Run("twoGbMaxExtentSparse", func(t *testing.T) {
@@ -210,3 +242,34 @@ func TestParseInfo(t *testing.T) {
 		})
 	})
 }
+
+func TestParseUsedSize(t *testing.T) {
+	// qemu-img map --output=json datadisk
+	// (QEMU 8.0, a qcow2 disk partitioned with sfdisk)
+	const s = `[{ "start": 0, "length": 65536, "depth": 0, "present": true, "zero": false, "data": true, "offset": 327680},
+{ "start": 65536, "length": 4294836224, "depth": 0, "present": false, "zero": true, "data": false},
+{ "start": 4294901760, "length": 65536, "depth": 0, "present": true, "zero": false, "data": true, "offset": 393216}]`
+	used, err := parseUsedSize([]byte(s))
+	assert.NilError(t, err)
+	assert.Equal(t, used, int64(4294967296))
+
+	used, err = parseUsedSize([]byte(`[{ "start": 0, "length": 4294967296, "depth": 0, "present": false, "zero": true, "data": false}]`))
+	assert.NilError(t, err)
+	assert.Equal(t, used, int64(0))
+}
+
+func TestParseIsEmptyOverlay(t *testing.T) {
+	// qemu-img map --output=json diffdisk
+	// (QEMU 8.0, an overlay created with `qemu-img create -b`)
+	empty, err := parseIsEmptyOverlay([]byte(`[{ "start": 0, "length": 1048576, "depth": 1, "present": true, "zero": false, "data": true, "offset": 327680},
+{ "start": 1048576, "length": 4293918720, "depth": 1, "present": false, "zero": true, "data": false}]`))
+	assert.NilError(t, err)
+	assert.Assert(t, empty)
+
+	// (written in the guest)
+	empty, err = parseIsEmptyOverlay([]byte(`[{ "start": 0, "length": 65536, "depth": 0, "present": true, "zero": false, "data": true, "offset": 327680},
+{ "start": 65536, "length": 983040, "depth": 1, "present": true, "zero": false, "data": true, "offset": 393216},
+{ "start": 1048576, "length": 4293918720, "depth": 1, "present": false, "zero": true, "data": false}]`))
+	assert.NilError(t, err)
+	assert.Assert(t, !empty)
+}
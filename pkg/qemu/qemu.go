@@ -12,20 +12,24 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/lima-vm/lima/pkg/networks/usernet"
 
+	"github.com/alessio/shellescape"
 	"github.com/coreos/go-semver/semver"
 	"github.com/digitalocean/go-qemu/qmp"
 	"github.com/digitalocean/go-qemu/qmp/raw"
 	"github.com/docker/go-units"
+	"github.com/lima-vm/lima/pkg/driver"
 	"github.com/lima-vm/lima/pkg/fileutils"
 	"github.com/lima-vm/lima/pkg/iso9660util"
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/lima-vm/lima/pkg/localpathutil"
+	"github.com/lima-vm/lima/pkg/nativeimgutil"
 	"github.com/lima-vm/lima/pkg/networks"
 	"github.com/lima-vm/lima/pkg/qemu/imgutil"
 	"github.com/lima-vm/lima/pkg/store"
@@ -44,6 +48,9 @@ type Config struct {
 // MinimumQemuVersion is the minimum supported QEMU version
 const MinimumQemuVersion = "4.0.0"
 
+// virtioMemID is the ID of the virtio-mem device for memory hotplug.
+const virtioMemID = "vmem0"
+
 // EnsureDisk also ensures the kernel and the initrd
 func EnsureDisk(cfg Config) error {
 	diffDisk := filepath.Join(cfg.InstanceDir, filenames.DiffDisk)
@@ -134,6 +141,52 @@ func CreateDataDisk(dir, format string, size int) error {
 	return nil
 }
 
+// ResizeDataDisk resizes the datadisk in dir, which must not be in use.
+// Shrinking the disk below the end of the last block that contains data is refused.
+func ResizeDataDisk(dir string, size int64) error {
+	dataDisk := filepath.Join(dir, filenames.DataDisk)
+	info, err := imgutil.GetInfo(dataDisk)
+	if err != nil {
+		return err
+	}
+	args := []string{"resize", "-f", info.Format}
+	switch {
+	case size == info.VSize:
+		return nil
+	case size < info.VSize:
+		used, err := imgutil.UsedSize(dataDisk)
+		if err != nil {
+			return err
+		}
+		if size < used {
+			return fmt.Errorf("cannot shrink the disk to %s, as the disk contains data up to %s",
+				units.BytesSize(float64(size)), units.BytesSize(float64(used)))
+		}
+		args = append(args, "--shrink")
+	case info.Format == "raw":
+		f, err := os.OpenFile(dataDisk, os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		if err = nativeimgutil.MakeSparse(f, size); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	args = append(args, dataDisk, strconv.FormatInt(size, 10))
+	cmd := exec.Command("qemu-img", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run %v: %q: %w", cmd.Args, string(out), err)
+	}
+	return nil
+}
+
+// additionalDiskID returns the QEMU drive ID of the additional disk.
+func additionalDiskID(name string) string {
+	return "lima-disk-" + name
+}
+
 func newQmpClient(cfg Config) (*qmp.SocketMonitor, error) {
 	qmpSock := filepath.Join(cfg.InstanceDir, filenames.QMPSock)
 	qmpClient, err := qmp.NewSocketMonitor("unix", qmpSock, 5*time.Second)
@@ -212,25 +265,27 @@ func Load(cfg Config, run bool, tag string) error {
 	return err
 }
 
-// List returns a space-separated list of all snapshots, with header and newlines
-func List(cfg Config, run bool) (string, error) {
-	if run {
-		out, err := sendHmpCommand(cfg, "info", "snapshots")
-		if err == nil {
-			out = strings.ReplaceAll(out, "\r", "")
-			out = strings.Replace(out, "List of snapshots present on all disks:\n", "", 1)
-			out = strings.Replace(out, "There is no snapshot available.\n", "", 1)
-		}
-		return out, err
-	}
-	// -l  lists all snapshots
-	args := []string{"snapshot", "-l"}
-	out, err := execImgCommand(cfg, args...)
-	if err == nil {
-		// remove the redundant heading, result is not machine-parseable
-		out = strings.Replace(out, "Snapshot list:\n", "", 1)
+// List returns the snapshots of the diff disk, sorted by the creation time.
+// The disk is opened with --force-share, so List works for running instances too.
+func List(cfg Config) ([]driver.Snapshot, error) {
+	info, err := imgutil.GetInfo(filepath.Join(cfg.InstanceDir, filenames.DiffDisk))
+	if err != nil {
+		return nil, err
 	}
-	return out, err
+	res := make([]driver.Snapshot, 0, len(info.Snapshots))
+	for _, s := range info.Snapshots {
+		res = append(res, driver.Snapshot{
+			Tag:         s.Name,
+			CreatedAt:   time.Unix(s.DateSec, s.DateNsec),
+			VMClock:     time.Duration(s.VMClockSec)*time.Second + time.Duration(s.VMClockNsec),
+			VMStateSize: s.VMStateSize,
+			VMState:     s.VMStateSize > 0,
+		})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res, nil
 }
 
 func argValue(args []string, key string) (string, bool) {
@@ -502,13 +557,34 @@ func Cmdline(cfg Config) (string, []string, error) {
 		return "", nil, err
 	}
 	memBytes = adjustMemBytesDarwinARM64HVF(memBytes, accel, features)
-	args = appendArgsIfNoConflict(args, "-m", strconv.Itoa(int(memBytes>>20)))
+	maxMemBytes, err := units.RAMInBytes(*y.MaxMemory)
+	if err != nil {
+		return "", nil, err
+	}
+	if maxMemBytes > memBytes {
+		// The memory between `memory` and `maxMemory` is hot-plugged with virtio-mem (see Reconfigure)
+		args = appendArgsIfNoConflict(args, "-m", fmt.Sprintf("%d,maxmem=%d", memBytes>>20, maxMemBytes>>20))
+	} else {
+		args = appendArgsIfNoConflict(args, "-m", strconv.Itoa(int(memBytes>>20)))
+	}
 
 	if *y.MountType == limayaml.VIRTIOFS {
 		args = appendArgsIfNoConflict(args, "-object",
 			fmt.Sprintf("memory-backend-file,id=virtiofs-shm,size=%s,mem-path=/dev/shm,share=on", strconv.Itoa(int(memBytes))))
 		args = appendArgsIfNoConflict(args, "-numa", "node,memdev=virtiofs-shm")
 	}
+	if maxMemBytes > memBytes {
+		vmemSize := strconv.Itoa(int(maxMemBytes - memBytes))
+		vmemDevice := "virtio-mem-pci,id=" + virtioMemID + ",memdev=mem-hotplug,requested-size=0"
+		if *y.MountType == limayaml.VIRTIOFS {
+			// virtiofs needs all the guest memory to be shared
+			args = append(args, "-object", "memory-backend-file,id=mem-hotplug,size="+vmemSize+",mem-path=/dev/shm,share=on")
+			vmemDevice += ",node=0"
+		} else {
+			args = append(args, "-object", "memory-backend-ram,id=mem-hotplug,size="+vmemSize)
+		}
+		args = append(args, "-device", vmemDevice)
+	}
 
 	// CPU
 	cpu := y.CPUType[*y.Arch]
@@ -552,7 +628,7 @@ func Cmdline(cfg Config) (string, []string, error) {
 		// https://github.com/lima-vm/lima/issues/680
 		// https://github.com/lima-vm/lima/pull/24
 		// But when the memory size is <= 3 GiB, we can always set highmem=off.
-		if !features.VersionGEQ7 || memBytes <= 3*1024*1024*1024 {
+		if !features.VersionGEQ7 || maxMemBytes <= 3*1024*1024*1024 {
 			machine += ",highmem=off"
 		}
 		args = appendArgsIfNoConflict(args, "-machine", machine)
@@ -565,8 +641,14 @@ func Cmdline(cfg Config) (string, []string, error) {
 	}
 
 	// SMP
-	args = appendArgsIfNoConflict(args, "-smp",
-		fmt.Sprintf("%d,sockets=1,cores=%d,threads=1", *y.CPUs, *y.CPUs))
+	if *y.MaxCPUs > *y.CPUs {
+		// The CPUs between `cpus` and `maxCPUs` are hot-plugged with device_add (see Reconfigure)
+		args = appendArgsIfNoConflict(args, "-smp",
+			fmt.Sprintf("%d,maxcpus=%d,sockets=1,cores=%d,threads=1", *y.CPUs, *y.MaxCPUs, *y.MaxCPUs))
+	} else {
+		args = appendArgsIfNoConflict(args, "-smp",
+			fmt.Sprintf("%d,sockets=1,cores=%d,threads=1", *y.CPUs, *y.CPUs))
+	}
 
 	// Firmware
 	legacyBIOS := *y.Firmware.LegacyBIOS
@@ -585,7 +667,8 @@ func Cmdline(cfg Config) (string, []string, error) {
 	// Disk
 	baseDisk := filepath.Join(cfg.InstanceDir, filenames.BaseDisk)
 	diffDisk := filepath.Join(cfg.InstanceDir, filenames.DiffDisk)
-	extraDisks := []string{}
+	extraDisks := []*store.Disk{}
+	readOnlyDisks := map[string]bool{}
 	if len(y.AdditionalDisks) > 0 {
 		for _, d := range y.AdditionalDisks {
 			diskName := d.Name
@@ -600,13 +683,17 @@ func Cmdline(cfg Config) (string, []string, error) {
 				return "", nil, err
 			}
 			logrus.Infof("Mounting disk %q on %q", diskName, disk.MountPoint)
-			err = disk.Lock(cfg.InstanceDir)
+			if d.ReadOnly != nil && *d.ReadOnly {
+				err = disk.LockReadOnly(cfg.InstanceDir)
+				readOnlyDisks[diskName] = true
+			} else {
+				err = disk.Lock(cfg.InstanceDir)
+			}
 			if err != nil {
 				logrus.Errorf("could not lock disk %q: %q", diskName, err)
 				return "", nil, err
 			}
-			dataDisk := filepath.Join(disk.Dir, filenames.DataDisk)
-			extraDisks = append(extraDisks, dataDisk)
+			extraDisks = append(extraDisks, disk)
 		}
 	}
 
@@ -636,7 +723,12 @@ func Cmdline(cfg Config) (string, []string, error) {
 		args = append(args, "-drive", fmt.Sprintf("file=%s,format=%s,if=virtio,discard=on", baseDisk, baseDiskInfo.Format))
 	}
 	for _, extraDisk := range extraDisks {
-		args = append(args, "-drive", fmt.Sprintf("file=%s,if=virtio,discard=on", extraDisk))
+		dataDisk := filepath.Join(extraDisk.Dir, filenames.DataDisk)
+		driveOpts := fmt.Sprintf("id=%s,file=%s,if=virtio,discard=on", additionalDiskID(extraDisk.Name), dataDisk)
+		if readOnlyDisks[extraDisk.Name] {
+			driveOpts += ",readonly=on"
+		}
+		args = append(args, "-drive", driveOpts)
 	}
 
 	// cloud-init
@@ -762,6 +854,11 @@ func Cmdline(cfg Config) (string, []string, error) {
 	// virtio-rng-pci accelerates starting up the OS, according to https://wiki.gentoo.org/wiki/QEMU/Options
 	args = append(args, "-device", "virtio-rng-pci")
 
+	// Memory balloon, resized by the host agent (see LimaQemuDriver.SetMemoryBalloon)
+	if *y.MemoryBalloon.Enabled {
+		args = append(args, "-device", "virtio-balloon-pci,id=balloon0,deflate-on-oom=on")
+	}
+
 	// Sound
 	if *y.Audio.Device != "" {
 		id := "default"
@@ -900,6 +997,12 @@ func Cmdline(cfg Config) (string, []string, error) {
 	args = append(args, "-chardev", fmt.Sprintf("socket,id=%s,path=%s,server=on,wait=off", qmpChardev, qmpSock))
 	args = append(args, "-qmp", "chardev:"+qmpChardev)
 
+	// Resume the VM suspended by LimaQemuDriver.Suspend
+	vmState := filepath.Join(cfg.InstanceDir, filenames.VMState)
+	if _, err := os.Stat(vmState); err == nil {
+		args = append(args, "-incoming", "exec:cat "+shellescape.Quote(vmState))
+	}
+
 	// QEMU process
 	args = append(args, "-name", "lima-"+cfg.Name)
 	args = append(args, "-pidfile", filepath.Join(cfg.InstanceDir, filenames.PIDFile(*y.VMType)))
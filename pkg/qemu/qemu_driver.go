@@ -4,22 +4,27 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
+	"github.com/alessio/shellescape"
 	"github.com/digitalocean/go-qemu/qmp"
 	"github.com/digitalocean/go-qemu/qmp/raw"
+	"github.com/docker/go-units"
 	"github.com/lima-vm/lima/pkg/driver"
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/lima-vm/lima/pkg/networks/usernet"
@@ -34,6 +39,10 @@ type LimaQemuDriver struct {
 	qWaitCh chan error
 
 	vhostCmds []*exec.Cmd
+
+	// bootCPUs and bootMemory are the values at boot; Reconfigure cannot go below them
+	bootCPUs   int
+	bootMemory int64
 }
 
 func New(driver *driver.BaseDriver) *LimaQemuDriver {
@@ -77,6 +86,11 @@ func (l *LimaQemuDriver) Start(ctx context.Context) (chan error, error) {
 	if err != nil {
 		return nil, err
 	}
+	l.bootCPUs = *l.Yaml.CPUs
+	l.bootMemory, err = units.RAMInBytes(*l.Yaml.Memory)
+	if err != nil {
+		return nil, err
+	}
 
 	var vhostCmds []*exec.Cmd
 	if *l.Yaml.MountType == limayaml.VIRTIOFS {
@@ -197,6 +211,12 @@ func (l *LimaQemuDriver) Start(ctx context.Context) (chan error, error) {
 			}
 		}
 	}()
+	if _, err := os.Stat(filepath.Join(l.Instance.Dir, filenames.VMState)); err == nil {
+		if err := l.waitForResume(ctx); err != nil {
+			_ = qCmd.Process.Kill()
+			return nil, err
+		}
+	}
 	return l.qWaitCh, nil
 }
 
@@ -204,6 +224,372 @@ func (l *LimaQemuDriver) Stop(ctx context.Context) error {
 	return l.shutdownQEMU(ctx, 3*time.Minute, l.qCmd, l.qWaitCh)
 }
 
+// Suspend saves the state of the VM with the QMP "migrate" command, and quits QEMU.
+// The next Start resumes the VM with the "-incoming" flag.
+func (l *LimaQemuDriver) Suspend(ctx context.Context) error {
+	if runtime.GOOS == "windows" {
+		// "exec:" migration URIs are not supported on Windows
+		return errors.New("suspend is not supported for QEMU on Windows hosts")
+	}
+	if l.qCmd == nil {
+		return errors.New("QEMU is not running")
+	}
+	qmpSockPath := filepath.Join(l.Instance.Dir, filenames.QMPSock)
+	qmpClient, err := qmp.NewSocketMonitor("unix", qmpSockPath, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	if err := qmpClient.Connect(); err != nil {
+		return err
+	}
+	defer func() { _ = qmpClient.Disconnect() }()
+	rawClient := raw.NewMonitor(qmpClient)
+	logrus.Info("Sending QMP stop command")
+	if err := rawClient.Stop(); err != nil {
+		return err
+	}
+	vmState := filepath.Join(l.Instance.Dir, filenames.VMState)
+	vmStateTmp := vmState + ".tmp"
+	logrus.Infof("Saving the VM state to %q", vmState)
+	if err := saveVMState(ctx, qmpClient, rawClient, vmStateTmp); err != nil {
+		_ = os.RemoveAll(vmStateTmp)
+		logrus.Info("Sending QMP cont command")
+		return errors.Join(err, rawClient.Cont())
+	}
+	if err := os.Rename(vmStateTmp, vmState); err != nil {
+		return errors.Join(err, rawClient.Cont())
+	}
+	if usernetIndex := limayaml.FirstUsernetIndex(l.Yaml); usernetIndex != -1 {
+		client := usernet.NewClientByName(l.Yaml.Networks[usernetIndex].Lima)
+		if err := client.UnExposeSSH(l.SSHLocalPort); err != nil {
+			logrus.Warnf("Failed to remove SSH binding for port %d", l.SSHLocalPort)
+		}
+	}
+	logrus.Info("Sending QMP quit command")
+	if err := rawClient.Quit(); err != nil {
+		// QEMU may close the connection before replying
+		logrus.WithError(err).Debug("failed to send quit command via the QMP socket")
+	}
+	const timeout = 30 * time.Second
+	select {
+	case qWaitErr := <-l.qWaitCh:
+		logrus.WithError(qWaitErr).Info("QEMU has exited")
+		l.removeVNCFiles()
+		return l.killVhosts()
+	case <-time.After(timeout):
+		logrus.Warnf("QEMU did not exit in %v, forcibly killing QEMU", timeout)
+		return l.killQEMU(ctx, timeout, l.qCmd, l.qWaitCh)
+	}
+}
+
+func saveVMState(ctx context.Context, qmpClient *qmp.SocketMonitor, rawClient *raw.Monitor, path string) error {
+	// The default bandwidth limit (128 MiB/s) makes no sense when the destination is a local file.
+	// The command is not sent with the raw client, as the raw MigrateSetParameters struct cannot omit the TLS fields.
+	if err := qmpExecute(qmpClient, "migrate-set-parameters", map[string]any{"max-bandwidth": int64(math.MaxInt64)}, nil); err != nil {
+		logrus.WithError(err).Warn("failed to unlimit the migration bandwidth")
+	}
+	if err := rawClient.Migrate("exec:cat > "+shellescape.Quote(path), nil, nil, nil); err != nil {
+		return err
+	}
+	for {
+		info, err := rawClient.QueryMigrate()
+		if err != nil {
+			return err
+		}
+		if info.Status != nil {
+			switch *info.Status {
+			case raw.MigrationStatusCompleted:
+				return nil
+			case raw.MigrationStatusFailed, raw.MigrationStatusCancelled:
+				desc := "unknown error"
+				if info.ErrorDesc != nil {
+					desc = *info.ErrorDesc
+				}
+				return fmt.Errorf("failed to save the VM state: %s", desc)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// waitForResume waits for QEMU to load the state saved by Suspend, and continues the VM.
+func (l *LimaQemuDriver) waitForResume(ctx context.Context) error {
+	vmState := filepath.Join(l.Instance.Dir, filenames.VMState)
+	logrus.Infof("Resuming the VM from %q", vmState)
+	qmpSockPath := filepath.Join(l.Instance.Dir, filenames.QMPSock)
+	if err := waitFileExists(qmpSockPath, 30*time.Second); err != nil {
+		return err
+	}
+	qmpClient, err := qmp.NewSocketMonitor("unix", qmpSockPath, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	if err := qmpClient.Connect(); err != nil {
+		return err
+	}
+	defer func() { _ = qmpClient.Disconnect() }()
+	rawClient := raw.NewMonitor(qmpClient)
+	for {
+		status, err := rawClient.QueryStatus()
+		if err != nil {
+			return fmt.Errorf("failed to load the VM state from %q (hint: run `limactl stop -f` to discard the state): %w", vmState, err)
+		}
+		if status.Status != raw.RunStateInmigrate {
+			// The VM stays paused after loading the state, as it was paused when the state was saved
+			if !status.Running {
+				logrus.Info("Sending QMP cont command")
+				if err := rawClient.Cont(); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		select {
+		case qWaitErr := <-l.qWaitCh:
+			return fmt.Errorf("QEMU exited while loading the VM state from %q (hint: run `limactl stop -f` to discard the state): %w", vmState, qWaitErr)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	logrus.Info("The VM has been resumed")
+	return os.RemoveAll(vmState)
+}
+
+// Reconfigure hot-plugs CPUs with device_add, and memory with virtio-mem.
+// The room for hotplug has to be reserved at boot with `maxCPUs` and `maxMemory`.
+func (l *LimaQemuDriver) Reconfigure(_ context.Context, y *limayaml.LimaYAML) ([]string, error) {
+	if l.qCmd == nil {
+		return nil, errors.New("QEMU is not running")
+	}
+	curMemory, err := units.RAMInBytes(*l.Yaml.Memory)
+	if err != nil {
+		return nil, err
+	}
+	newMemory, err := units.RAMInBytes(*y.Memory)
+	if err != nil {
+		return nil, err
+	}
+	maxMemory, err := units.RAMInBytes(*l.Yaml.MaxMemory)
+	if err != nil {
+		return nil, err
+	}
+	cpusChanged := *y.CPUs != *l.Yaml.CPUs
+	memoryChanged := newMemory != curMemory
+	if !cpusChanged && !memoryChanged {
+		return nil, nil
+	}
+
+	// Validate all the changes before applying any of them
+	var errs []error
+	if cpusChanged {
+		switch {
+		case *l.Yaml.Arch != limayaml.X8664:
+			errs = append(errs, fmt.Errorf("field `cpus`: CPU hotplug is not supported for arch %q", *l.Yaml.Arch))
+		case *y.CPUs < l.bootCPUs:
+			errs = append(errs, fmt.Errorf("field `cpus`: cannot be less than the value at boot (%d)", l.bootCPUs))
+		case *y.CPUs > *l.Yaml.MaxCPUs:
+			errs = append(errs, fmt.Errorf("field `cpus`: cannot be greater than `maxCPUs` at boot (%d)", *l.Yaml.MaxCPUs))
+		}
+	}
+	if memoryChanged {
+		switch {
+		case newMemory < l.bootMemory:
+			errs = append(errs, fmt.Errorf("field `memory`: cannot be less than the value at boot (%s)", units.BytesSize(float64(l.bootMemory))))
+		case newMemory > maxMemory:
+			errs = append(errs, fmt.Errorf("field `memory`: cannot be greater than `maxMemory` at boot (%q)", *l.Yaml.MaxMemory))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	qmpSockPath := filepath.Join(l.Instance.Dir, filenames.QMPSock)
+	qmpClient, err := qmp.NewSocketMonitor("unix", qmpSockPath, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if err := qmpClient.Connect(); err != nil {
+		return nil, err
+	}
+	defer func() { _ = qmpClient.Disconnect() }()
+
+	var applied []string
+	if cpusChanged {
+		if err := setCPUs(qmpClient, *y.CPUs); err != nil {
+			return applied, err
+		}
+		l.Yaml.CPUs = y.CPUs
+		applied = append(applied, "cpus")
+	}
+	if memoryChanged {
+		if err := setVirtioMemSize(qmpClient, newMemory-l.bootMemory); err != nil {
+			return applied, err
+		}
+		l.Yaml.Memory = y.Memory
+		applied = append(applied, "memory")
+	}
+	return applied, nil
+}
+
+type hotpluggableCPU struct {
+	Type    string         `json:"type"`
+	Props   map[string]any `json:"props"`
+	QOMPath string         `json:"qom-path,omitempty"` // empty if not plugged
+}
+
+func (c *hotpluggableCPU) coreID() int {
+	id, _ := c.Props["core-id"].(float64)
+	return int(id)
+}
+
+// setCPUs plugs or unplugs the CPUs with the highest core IDs.
+// Only the CPUs that were hot-plugged can be unplugged.
+func setCPUs(qmpClient *qmp.SocketMonitor, cpus int) error {
+	var hotpluggable []hotpluggableCPU
+	if err := qmpExecute(qmpClient, "query-hotpluggable-cpus", nil, &hotpluggable); err != nil {
+		return err
+	}
+	sort.Slice(hotpluggable, func(i, j int) bool {
+		return hotpluggable[i].coreID() < hotpluggable[j].coreID()
+	})
+	var plugged int
+	for _, c := range hotpluggable {
+		if c.QOMPath != "" {
+			plugged++
+		}
+	}
+	for i := 0; i < len(hotpluggable) && plugged < cpus; i++ {
+		c := hotpluggable[i]
+		if c.QOMPath != "" {
+			continue
+		}
+		args := map[string]any{
+			"driver": c.Type,
+			"id":     fmt.Sprintf("cpu-%d", c.coreID()),
+		}
+		for k, v := range c.Props {
+			args[k] = v
+		}
+		logrus.Infof("Plugging CPU %d", c.coreID())
+		if err := qmpExecute(qmpClient, "device_add", args, nil); err != nil {
+			return err
+		}
+		plugged++
+	}
+	for i := len(hotpluggable) - 1; i >= 0 && plugged > cpus; i-- {
+		c := hotpluggable[i]
+		// The CPUs plugged at boot are not under /machine/peripheral
+		if !strings.HasPrefix(c.QOMPath, "/machine/peripheral/") {
+			continue
+		}
+		logrus.Infof("Unplugging CPU %d", c.coreID())
+		if err := qmpExecute(qmpClient, "device_del", map[string]any{"id": filepath.Base(c.QOMPath)}, nil); err != nil {
+			return err
+		}
+		plugged--
+	}
+	if plugged != cpus {
+		return fmt.Errorf("failed to change the number of CPUs to %d (%d plugged)", cpus, plugged)
+	}
+	return nil
+}
+
+// setVirtioMemSize sets the size of the hot-plugged memory.
+// The guest kernel needs to support virtio-mem.
+func setVirtioMemSize(qmpClient *qmp.SocketMonitor, size int64) error {
+	var blockSize int64
+	if err := qmpExecute(qmpClient, "qom-get", map[string]any{"path": virtioMemID, "property": "block-size"}, &blockSize); err != nil {
+		return fmt.Errorf("failed to get the block size of the virtio-mem device: %w", err)
+	}
+	if blockSize > 0 && size%blockSize != 0 {
+		size += blockSize - size%blockSize
+	}
+	logrus.Infof("Setting the size of the hot-plugged memory to %s", units.BytesSize(float64(size)))
+	return qmpExecute(qmpClient, "qom-set", map[string]any{"path": virtioMemID, "property": "requested-size", "value": size}, nil)
+}
+
+// qmpExecute executes a QMP command, and decodes the return value into ret, unless ret is nil.
+func qmpExecute(qmpClient *qmp.SocketMonitor, command string, args, ret any) error {
+	cmd := struct {
+		Execute   string `json:"execute"`
+		Arguments any    `json:"arguments,omitempty"`
+	}{
+		Execute:   command,
+		Arguments: args,
+	}
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	resB, err := qmpClient.Run(b)
+	if err != nil {
+		return err
+	}
+	if ret == nil {
+		return nil
+	}
+	var res struct {
+		Return json.RawMessage `json:"return"`
+	}
+	if err := json.Unmarshal(resB, &res); err != nil {
+		return err
+	}
+	return json.Unmarshal(res.Return, ret)
+}
+
+func (l *LimaQemuDriver) MemoryBalloon(_ context.Context) (int64, error) {
+	qmpSockPath := filepath.Join(l.Instance.Dir, filenames.QMPSock)
+	qmpClient, err := qmp.NewSocketMonitor("unix", qmpSockPath, 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	if err := qmpClient.Connect(); err != nil {
+		return 0, err
+	}
+	defer func() { _ = qmpClient.Disconnect() }()
+	rawClient := raw.NewMonitor(qmpClient)
+	info, err := rawClient.QueryBalloon()
+	if err != nil {
+		return 0, err
+	}
+	return info.Actual, nil
+}
+
+func (l *LimaQemuDriver) SetMemoryBalloon(_ context.Context, target int64) error {
+	qmpSockPath := filepath.Join(l.Instance.Dir, filenames.QMPSock)
+	qmpClient, err := qmp.NewSocketMonitor("unix", qmpSockPath, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	if err := qmpClient.Connect(); err != nil {
+		return err
+	}
+	defer func() { _ = qmpClient.Disconnect() }()
+	rawClient := raw.NewMonitor(qmpClient)
+	return rawClient.Balloon(target)
+}
+
+func (l *LimaQemuDriver) ResizeAdditionalDisk(_ context.Context, name string, size int64) error {
+	qmpSockPath := filepath.Join(l.Instance.Dir, filenames.QMPSock)
+	qmpClient, err := qmp.NewSocketMonitor("unix", qmpSockPath, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	if err := qmpClient.Connect(); err != nil {
+		return err
+	}
+	defer func() { _ = qmpClient.Disconnect() }()
+	rawClient := raw.NewMonitor(qmpClient)
+	id := additionalDiskID(name)
+	logrus.Infof("Resizing the additional disk %q to %s", name, units.BytesSize(float64(size)))
+	return rawClient.BlockResize(&id, nil, size)
+}
+
 func (l *LimaQemuDriver) ChangeDisplayPassword(_ context.Context, password string) error {
 	return l.changeVNCPassword(password)
 }
@@ -385,13 +771,13 @@ func (l *LimaQemuDriver) ApplySnapshot(_ context.Context, tag string) error {
 	return Load(qCfg, l.Instance.Status == store.StatusRunning, tag)
 }
 
-func (l *LimaQemuDriver) ListSnapshots(_ context.Context) (string, error) {
+func (l *LimaQemuDriver) ListSnapshots(_ context.Context) ([]driver.Snapshot, error) {
 	qCfg := Config{
 		Name:        l.Instance.Name,
 		InstanceDir: l.Instance.Dir,
 		LimaYAML:    l.Yaml,
 	}
-	return List(qCfg, l.Instance.Status == store.StatusRunning)
+	return List(qCfg)
 }
 
 type qArgTemplateApplier struct {
@@ -0,0 +1,156 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/opencontainers/go-digest"
+)
+
+// Metadata is saved as filenames.SnapshotsJSON in the instance directory.
+//
+// The snapshots themselves are managed by the driver; Metadata only records
+// the information that the driver does not know about.
+type Metadata struct {
+	// Current is the tag of the snapshot that was created or applied most recently,
+	// i.e., the snapshot that the current state of the instance is based on.
+	Current   string `json:"current,omitempty"`
+	Snapshots []Info `json:"snapshots,omitempty"`
+}
+
+// Info is the metadata of a snapshot.
+type Info struct {
+	Tag         string `json:"tag"`
+	Parent      string `json:"parent,omitempty"`
+	Description string `json:"description,omitempty"`
+	// LimaYAMLDigest is the digest of lima.yaml at the creation of the snapshot.
+	LimaYAMLDigest digest.Digest `json:"limaYAMLDigest,omitempty"`
+	CreatedAt      time.Time     `json:"createdAt"`
+}
+
+// Node is a node of the snapshot tree.
+type Node struct {
+	Info
+	Current  bool    `json:"current,omitempty"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+// LoadMetadata loads the snapshot metadata of the instance.
+// An empty Metadata is returned if the metadata does not exist.
+func LoadMetadata(instDir string) (*Metadata, error) {
+	b, err := os.ReadFile(filepath.Join(instDir, filenames.SnapshotsJSON))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &Metadata{}, nil
+		}
+		return nil, err
+	}
+	var m Metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", filenames.SnapshotsJSON, err)
+	}
+	return &m, nil
+}
+
+func (m *Metadata) save(instDir string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(instDir, filenames.SnapshotsJSON), b, 0o644)
+}
+
+func (m *Metadata) find(tag string) *Info {
+	for i := range m.Snapshots {
+		if m.Snapshots[i].Tag == tag {
+			return &m.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+// add adds the snapshot as a child of the current snapshot, and makes it current.
+func (m *Metadata) add(info Info) {
+	m.remove(info.Tag)
+	info.Parent = m.Current
+	m.Snapshots = append(m.Snapshots, info)
+	m.Current = info.Tag
+}
+
+// remove removes the snapshot. The children of the snapshot are reparented to the parent of the snapshot.
+func (m *Metadata) remove(tag string) {
+	info := m.find(tag)
+	if info == nil {
+		return
+	}
+	parent := info.Parent
+	snapshots := make([]Info, 0, len(m.Snapshots))
+	for _, s := range m.Snapshots {
+		if s.Tag == tag {
+			continue
+		}
+		if s.Parent == tag {
+			s.Parent = parent
+		}
+		snapshots = append(snapshots, s)
+	}
+	m.Snapshots = snapshots
+	if m.Current == tag {
+		m.Current = parent
+	}
+}
+
+// descendants returns the tags of the descendants of the snapshot, the leaves first.
+func (m *Metadata) descendants(tag string) []string {
+	var res []string
+	for _, s := range m.Snapshots {
+		if s.Parent == tag && s.Tag != tag {
+			res = append(res, m.descendants(s.Tag)...)
+			res = append(res, s.Tag)
+		}
+	}
+	return res
+}
+
+// buildTree builds the snapshot tree from the snapshots known to the driver.
+// Snapshots without metadata (e.g., created by an older version of Lima) appear as roots.
+func buildTree(m *Metadata, snapshots []driver.Snapshot) []*Node {
+	nodes := make(map[string]*Node, len(snapshots))
+	var ordered []*Node
+	for _, s := range snapshots {
+		info := Info{Tag: s.Tag, CreatedAt: s.CreatedAt}
+		if mi := m.find(s.Tag); mi != nil {
+			info = *mi
+		}
+		n := &Node{Info: info, Current: s.Tag == m.Current}
+		nodes[s.Tag] = n
+		ordered = append(ordered, n)
+	}
+	var roots []*Node
+	for _, n := range ordered {
+		if parent, ok := nodes[n.Parent]; ok && n.Parent != n.Tag {
+			parent.Children = append(parent.Children, n)
+		} else {
+			n.Parent = ""
+			roots = append(roots, n)
+		}
+	}
+	sortNodes(roots)
+	return roots
+}
+
+func sortNodes(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].CreatedAt.Before(nodes[j].CreatedAt)
+	})
+	for _, n := range nodes {
+		sortNodes(n.Children)
+	}
+}
@@ -0,0 +1,57 @@
+package snapshot
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/driver"
+	"gotest.tools/v3/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	instDir := t.TempDir()
+	m, err := LoadMetadata(instDir)
+	assert.NilError(t, err)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	for i, tag := range []string{"clean", "a", "a2"} {
+		m.add(Info{Tag: tag, CreatedAt: t0.Add(time.Duration(i) * time.Minute)})
+	}
+	m.Current = "clean"
+	m.add(Info{Tag: "b", CreatedAt: t0.Add(3 * time.Minute)})
+	assert.NilError(t, m.save(instDir))
+
+	m, err = LoadMetadata(instDir)
+	assert.NilError(t, err)
+	assert.Equal(t, m.Current, "b")
+	assert.Equal(t, m.find("a2").Parent, "a")
+	assert.Equal(t, m.find("b").Parent, "clean")
+	assert.DeepEqual(t, m.descendants("clean"), []string{"a2", "a", "b"})
+
+	var snapshots []driver.Snapshot
+	for _, s := range m.Snapshots {
+		snapshots = append(snapshots, driver.Snapshot{Tag: s.Tag, CreatedAt: s.CreatedAt})
+	}
+	// created without metadata
+	snapshots = append(snapshots, driver.Snapshot{Tag: "legacy", CreatedAt: t0.Add(-time.Hour)})
+	roots := buildTree(m, snapshots)
+	assert.Equal(t, len(roots), 2)
+	assert.Equal(t, roots[0].Tag, "legacy")
+	assert.Equal(t, roots[1].Tag, "clean")
+	assert.Equal(t, len(roots[1].Children), 2)
+	assert.Equal(t, roots[1].Children[1].Current, true)
+
+	var b bytes.Buffer
+	assert.NilError(t, PrintTree(&b, roots[1:], "tree"))
+	assert.Equal(t, b.String(), `clean (2024-01-01 00:00:00)
+├── a (2024-01-01 00:01:00)
+│   └── a2 (2024-01-01 00:02:00)
+└── b (2024-01-01 00:03:00) <- current
+`)
+
+	m.remove("a")
+	assert.Equal(t, m.find("a2").Parent, "clean")
+	m.remove("b")
+	assert.Equal(t, m.Current, "clean")
+}
@@ -2,56 +2,226 @@ package snapshot
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"text/template"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/lima-vm/lima/pkg/driver"
 	"github.com/lima-vm/lima/pkg/driverutil"
 	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/lima-vm/lima/pkg/textutil"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
 )
 
-func Del(ctx context.Context, inst *store.Instance, tag string) error {
+func newDriver(inst *store.Instance) (driver.Driver, error) {
 	y, err := inst.LoadYAML()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	limaDriver := driverutil.CreateTargetDriverInstance(&driver.BaseDriver{
+	return driverutil.CreateTargetDriverInstance(&driver.BaseDriver{
 		Instance: inst,
 		Yaml:     y,
-	})
-	return limaDriver.DeleteSnapshot(ctx, tag)
+	}), nil
 }
 
-func Save(ctx context.Context, inst *store.Instance, tag string) error {
-	y, err := inst.LoadYAML()
+func Del(ctx context.Context, inst *store.Instance, tag string) error {
+	limaDriver, err := newDriver(inst)
 	if err != nil {
 		return err
 	}
-	limaDriver := driverutil.CreateTargetDriverInstance(&driver.BaseDriver{
-		Instance: inst,
-		Yaml:     y,
-	})
-	return limaDriver.CreateSnapshot(ctx, tag)
+	m, err := LoadMetadata(inst.Dir)
+	if err != nil {
+		return err
+	}
+	if err := limaDriver.DeleteSnapshot(ctx, tag); err != nil {
+		return err
+	}
+	m.remove(tag)
+	return m.save(inst.Dir)
 }
 
-func Load(ctx context.Context, inst *store.Instance, tag string) error {
-	y, err := inst.LoadYAML()
+// Save creates a snapshot as a child of the current snapshot.
+func Save(ctx context.Context, inst *store.Instance, tag, description string) error {
+	limaDriver, err := newDriver(inst)
 	if err != nil {
 		return err
 	}
-	limaDriver := driverutil.CreateTargetDriverInstance(&driver.BaseDriver{
-		Instance: inst,
-		Yaml:     y,
+	m, err := LoadMetadata(inst.Dir)
+	if err != nil {
+		return err
+	}
+	snapshots, err := limaDriver.ListSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if s.Tag == tag {
+			return fmt.Errorf("snapshot %q already exists", tag)
+		}
+	}
+	yDigest, err := limaYAMLDigest(inst)
+	if err != nil {
+		return err
+	}
+	createdAt := time.Now()
+	if err := limaDriver.CreateSnapshot(ctx, tag); err != nil {
+		return err
+	}
+	m.add(Info{
+		Tag:            tag,
+		Description:    description,
+		LimaYAMLDigest: yDigest,
+		CreatedAt:      createdAt,
 	})
-	return limaDriver.ApplySnapshot(ctx, tag)
+	return m.save(inst.Dir)
 }
 
-func List(ctx context.Context, inst *store.Instance) (string, error) {
-	y, err := inst.LoadYAML()
+// Load applies the snapshot, and makes it current.
+// If discardNewer is true, the descendants of the snapshot are deleted.
+func Load(ctx context.Context, inst *store.Instance, tag string, discardNewer bool) error {
+	limaDriver, err := newDriver(inst)
+	if err != nil {
+		return err
+	}
+	m, err := LoadMetadata(inst.Dir)
+	if err != nil {
+		return err
+	}
+	if info := m.find(tag); info != nil && info.LimaYAMLDigest != "" {
+		yDigest, err := limaYAMLDigest(inst)
+		if err != nil {
+			return err
+		}
+		if yDigest != info.LimaYAMLDigest {
+			logrus.Warnf("%q has been modified since the creation of the snapshot %q", filenames.LimaYAML, tag)
+		}
+	}
+	if err := limaDriver.ApplySnapshot(ctx, tag); err != nil {
+		return err
+	}
+	m.Current = tag
+	if discardNewer {
+		for _, newer := range m.descendants(tag) {
+			logrus.Infof("Deleting snapshot %q", newer)
+			if err := limaDriver.DeleteSnapshot(ctx, newer); err != nil {
+				return errors.Join(fmt.Errorf("failed to delete snapshot %q: %w", newer, err), m.save(inst.Dir))
+			}
+			m.remove(newer)
+		}
+	}
+	return m.save(inst.Dir)
+}
+
+func limaYAMLDigest(inst *store.Instance) (digest.Digest, error) {
+	b, err := os.ReadFile(filepath.Join(inst.Dir, filenames.LimaYAML))
 	if err != nil {
 		return "", err
 	}
-	limaDriver := driverutil.CreateTargetDriverInstance(&driver.BaseDriver{
-		Instance: inst,
-		Yaml:     y,
-	})
+	return digest.FromBytes(b), nil
+}
+
+func List(ctx context.Context, inst *store.Instance) ([]driver.Snapshot, error) {
+	limaDriver, err := newDriver(inst)
+	if err != nil {
+		return nil, err
+	}
 	return limaDriver.ListSnapshots(ctx)
 }
+
+// Tree returns the roots of the snapshot tree.
+func Tree(ctx context.Context, inst *store.Instance) ([]*Node, error) {
+	m, err := LoadMetadata(inst.Dir)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := List(ctx, inst)
+	if err != nil {
+		return nil, err
+	}
+	return buildTree(m, snapshots), nil
+}
+
+// PrintSnapshots prints the snapshots in the format, one of "json", "yaml", "table", or a go template.
+func PrintSnapshots(w io.Writer, snapshots []driver.Snapshot, format string) error {
+	switch format {
+	case "json":
+		format = "{{json .}}"
+	case "yaml":
+		format = "{{yaml .}}"
+	case "table":
+		w := tabwriter.NewWriter(w, 4, 8, 4, ' ', 0)
+		fmt.Fprintln(w, "TAG\tCREATED\tVM CLOCK\tVM STATE")
+		for _, s := range snapshots {
+			vmState := "-"
+			if s.VMState {
+				vmState = units.BytesSize(float64(s.VMStateSize))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+				s.Tag,
+				s.CreatedAt.Local().Format(time.DateTime),
+				s.VMClock.Truncate(time.Millisecond),
+				vmState,
+			)
+		}
+		return w.Flush()
+	default:
+		// NOP
+	}
+	tmpl, err := template.New("format").Funcs(textutil.TemplateFuncMap).Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid go template: %w", err)
+	}
+	for _, s := range snapshots {
+		if err := tmpl.Execute(w, s); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// PrintTree prints the snapshot tree in the format, one of "json", "yaml", or "tree".
+func PrintTree(w io.Writer, roots []*Node, format string) error {
+	switch format {
+	case "json", "yaml":
+		tmpl := template.Must(template.New("format").Funcs(textutil.TemplateFuncMap).Parse("{{" + format + " .}}"))
+		if err := tmpl.Execute(w, roots); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+		return nil
+	case "tree":
+		for _, n := range roots {
+			printNode(w, n, "", "")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func printNode(w io.Writer, n *Node, prefix, childPrefix string) {
+	line := fmt.Sprintf("%s%s (%s)", prefix, n.Tag, n.CreatedAt.Local().Format(time.DateTime))
+	if n.Description != "" {
+		line += ": " + n.Description
+	}
+	if n.Current {
+		line += " <- current"
+	}
+	fmt.Fprintln(w, line)
+	for i, c := range n.Children {
+		if i == len(n.Children)-1 {
+			printNode(w, c, childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			printNode(w, c, childPrefix+"├── ", childPrefix+"│   ")
+		}
+	}
+}
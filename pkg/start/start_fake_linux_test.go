@@ -0,0 +1,136 @@
+package start
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/hostagent"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/stop"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+	"gotest.tools/v3/assert"
+)
+
+// TestMain runs the test binary as `limactl hostagent` when Start executes it, so that TestStartFake
+// runs the host agent in a separate process, as `limactl start` does.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == "hostagent" {
+		if err := hostagentMain(os.Args[2:]); err != nil {
+			logrus.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// hostagentMain is a minimal `limactl hostagent`, without the API server.
+func hostagentMain(args []string) error {
+	fs := flag.NewFlagSet("hostagent", flag.ContinueOnError)
+	pidfile := fs.String("pidfile", "", "write pid to file")
+	fs.String("socket", "", "hostagent socket")
+	fs.Bool("run-gui", false, "run gui synchronously within hostagent")
+	nerdctlArchive := fs.String("nerdctl-archive", "", "local file path of nerdctl-full-VERSION-GOOS-GOARCH.tar.gz")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected 1 argument, got %v", fs.Args())
+	}
+	if err := os.WriteFile(*pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return err
+	}
+	defer os.RemoveAll(*pidfile)
+	// JSON logs are parsed in pkg/hostagent/events.Watcher()
+	logrus.SetFormatter(new(logrus.JSONFormatter))
+	logrus.SetLevel(logrus.DebugLevel)
+	sigintCh := make(chan os.Signal, 1)
+	signal.Notify(sigintCh, os.Interrupt)
+	var opts []hostagent.Opt
+	if *nerdctlArchive != "" {
+		opts = append(opts, hostagent.WithNerdctlArchive(*nerdctlArchive))
+	}
+	ha, err := hostagent.New(fs.Arg(0), os.Stdout, sigintCh, opts...)
+	if err != nil {
+		return err
+	}
+	return ha.Run(context.Background())
+}
+
+// TestStartFake starts and stops an instance of vmType "fake", as `limactl start` and `limactl stop` do.
+//
+// The fake guest runs the boot scripts as root, on the network, /dev, and /sys of the host.
+// So the test is skipped unless $LIMA_TEST_FAKE_ROOTFS is set to a rootfs tarball that contains sshd,
+// and the test runs in a container (or $LIMA_TEST_FAKE_ALLOW_HOST is set to "1").
+// The guest agent binary is looked up in ../share/lima of the test binary, e.g.:
+//
+//	make _output/share/lima/lima-guestagent.Linux-$(uname -m)
+//	go test -c -o _output/bin/start.test ./pkg/start
+//	docker run --rm --privileged -v $PWD:/lima -v /path/to/rootfs.tar.gz:/rootfs.tar.gz \
+//	  -e LIMA_TEST_FAKE_ROOTFS=/rootfs.tar.gz ubuntu /lima/_output/bin/start.test -test.run TestStartFake -test.v
+func TestStartFake(t *testing.T) {
+	rootfs := os.Getenv("LIMA_TEST_FAKE_ROOTFS")
+	if rootfs == "" || os.Geteuid() != 0 {
+		t.Skip("needs root, and $LIMA_TEST_FAKE_ROOTFS")
+	}
+	if !inContainer() && os.Getenv("LIMA_TEST_FAKE_ALLOW_HOST") != "1" {
+		t.Skip("needs to run in a container, as the guest shares /dev and /sys with the host (set $LIMA_TEST_FAKE_ALLOW_HOST=1 to run on the host)")
+	}
+	t.Setenv("LIMA_HOME", t.TempDir())
+	const instName = "fake"
+	instDir, err := store.InstanceDir(instName)
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(instDir, 0o700))
+	yaml := fmt.Sprintf(`vmType: %q
+images:
+- location: %q
+mountType: %q
+mounts: []
+containerd:
+  system: false
+  user: false
+hostResolver:
+  enabled: false
+`, limayaml.Fake, rootfs, limayaml.REVSSHFS)
+	assert.NilError(t, os.WriteFile(filepath.Join(instDir, filenames.LimaYAML), []byte(yaml), 0o644))
+
+	ctx := WithWatchHostAgentTimeout(context.Background(), 3*time.Minute)
+	inst, err := store.Inspect(instName)
+	assert.NilError(t, err)
+	err = Start(ctx, inst)
+	inst, inspectErr := store.Inspect(instName)
+	assert.NilError(t, inspectErr)
+	if inst.HostAgentPID > 0 {
+		defer func() {
+			// Leave no processes on failures
+			_ = stop.Gracefully(context.Background(), inst)
+		}()
+	}
+	assert.NilError(t, err)
+	assert.Equal(t, inst.Status, store.StatusRunning)
+
+	assert.NilError(t, stop.Gracefully(ctx, inst))
+	inst, err = store.Inspect(instName)
+	assert.NilError(t, err)
+	assert.Equal(t, inst.Status, store.StatusStopped)
+	_, err = os.Stat(filepath.Join(instDir, filenames.PIDFile(limayaml.Fake)))
+	assert.Assert(t, os.IsNotExist(err))
+}
+
+// inContainer returns true if the process seems running in a Docker or Podman container.
+func inContainer() bool {
+	for _, f := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(f); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -2,11 +2,18 @@ package stop
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
 
 	"github.com/lima-vm/lima/pkg/driver"
 	"github.com/lima-vm/lima/pkg/driverutil"
-
+	hostagentevents "github.com/lima-vm/lima/pkg/hostagent/events"
+	"github.com/lima-vm/lima/pkg/osutil"
 	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
 )
 
 func Unregister(ctx context.Context, inst *store.Instance) error {
@@ -22,3 +29,51 @@ func Unregister(ctx context.Context, inst *store.Instance) error {
 
 	return limaDriver.Unregister(ctx)
 }
+
+// Gracefully stops the running instance by sending SIGINT to the host agent,
+// and waits for the host agent and the driver processes to shut down.
+func Gracefully(ctx context.Context, inst *store.Instance) error {
+	if inst.Status != store.StatusRunning {
+		return fmt.Errorf("expected status %q, got %q (maybe use `limactl stop -f`?)", store.StatusRunning, inst.Status)
+	}
+
+	begin := time.Now() // used for logrus propagation
+	logrus.Infof("Sending SIGINT to hostagent process %d", inst.HostAgentPID)
+	if err := osutil.SysKill(inst.HostAgentPID, osutil.SigInt); err != nil {
+		logrus.Error(err)
+	}
+
+	logrus.Info("Waiting for the host agent and the driver processes to shut down")
+	return WaitForHostAgentTermination(ctx, inst, begin)
+}
+
+// WaitForHostAgentTermination waits for the host agent to emit the "exiting" event after begin.
+func WaitForHostAgentTermination(ctx context.Context, inst *store.Instance, begin time.Time) error {
+	ctx2, cancel := context.WithTimeout(ctx, 3*time.Minute)
+	defer cancel()
+
+	var receivedExitingEvent bool
+	onEvent := func(ev hostagentevents.Event) bool {
+		if len(ev.Status.Errors) > 0 {
+			logrus.Errorf("%+v", ev.Status.Errors)
+		}
+		if ev.Status.Exiting {
+			receivedExitingEvent = true
+			return true
+		}
+		return false
+	}
+
+	haStdoutPath := filepath.Join(inst.Dir, filenames.HostAgentStdoutLog)
+	haStderrPath := filepath.Join(inst.Dir, filenames.HostAgentStderrLog)
+
+	if err := hostagentevents.Watch(ctx2, haStdoutPath, haStderrPath, begin, onEvent); err != nil {
+		return err
+	}
+
+	if !receivedExitingEvent {
+		return errors.New("did not receive an event with the \"exiting\" status")
+	}
+
+	return nil
+}
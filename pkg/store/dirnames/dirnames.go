@@ -62,3 +62,12 @@ func LimaDisksDir() (string, error) {
 	}
 	return filepath.Join(limaDir, filenames.DisksDir), nil
 }
+
+// LimaFrozenDir returns the path of the frozen disks directory, $LIMA_HOME/_frozen.
+func LimaFrozenDir() (string, error) {
+	limaDir, err := LimaDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(limaDir, filenames.FrozenDir), nil
+}
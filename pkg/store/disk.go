@@ -3,22 +3,35 @@ package store
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"text/template"
 
+	"github.com/docker/go-units"
 	"github.com/lima-vm/go-qcow2reader"
+	"github.com/lima-vm/lima/pkg/lockutil"
+	"github.com/lima-vm/lima/pkg/osutil"
 	"github.com/lima-vm/lima/pkg/qemu/imgutil"
 	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/lima-vm/lima/pkg/textutil"
 )
 
 type Disk struct {
 	Name        string `json:"name"`
 	Size        int64  `json:"size"`
+	Usage       int64  `json:"usage"` // bytes allocated on the host
 	Dir         string `json:"dir"`
 	Instance    string `json:"instance"`
 	InstanceDir string `json:"instanceDir"`
-	MountPoint  string `json:"mountPoint"`
+	// ReadOnlyInstances are the instances that attach the disk read-only.
+	// A disk can be attached read-only to multiple instances, but it cannot be attached
+	// read-only and writable at the same time.
+	ReadOnlyInstances []string `json:"readOnlyInstances,omitempty"`
+	MountPoint        string   `json:"mountPoint"`
 }
 
 func InspectDisk(diskName string) (*Disk, error) {
@@ -33,23 +46,19 @@ func InspectDisk(diskName string) (*Disk, error) {
 
 	disk.Dir = diskDir
 	dataDisk := filepath.Join(diskDir, filenames.DataDisk)
-	if _, err := os.Stat(dataDisk); err != nil {
+	fi, err := os.Stat(dataDisk)
+	if err != nil {
 		return nil, err
 	}
+	disk.Usage = osutil.AllocatedSize(fi)
 
 	disk.Size, err = inspectDiskSize(dataDisk)
 	if err != nil {
 		return nil, err
 	}
 
-	instDir, err := os.Readlink(filepath.Join(diskDir, filenames.InUseBy))
-	if err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
-			return nil, err
-		}
-	} else {
-		disk.Instance = filepath.Base(instDir)
-		disk.InstanceDir = instDir
+	if err := disk.loadLocks(); err != nil {
+		return nil, err
 	}
 
 	disk.MountPoint = fmt.Sprintf("/mnt/lima-%s", diskName)
@@ -85,12 +94,115 @@ func inspectDiskSizeWithQemuImg(fName string) (int64, error) {
 	return info.VSize, nil
 }
 
+// loadLocks reads the writable and the read-only locks of the disk.
+func (d *Disk) loadLocks() error {
+	d.Instance, d.InstanceDir, d.ReadOnlyInstances = "", "", nil
+	instDir, err := os.Readlink(filepath.Join(d.Dir, filenames.InUseBy))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	} else {
+		d.Instance = filepath.Base(instDir)
+		d.InstanceDir = instDir
+	}
+
+	roEntries, err := os.ReadDir(filepath.Join(d.Dir, filenames.InUseByReadOnly))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, e := range roEntries {
+		d.ReadOnlyInstances = append(d.ReadOnlyInstances, e.Name())
+	}
+	return nil
+}
+
+// Lock locks the disk for the writable attachment to the instance.
+//
+// Lock and LockReadOnly hold a lock on the disk directory while checking and creating the lock,
+// so that a writable and a read-only attachment cannot be made concurrently.
 func (d *Disk) Lock(instanceDir string) error {
-	inUseBy := filepath.Join(d.Dir, filenames.InUseBy)
-	return os.Symlink(instanceDir, inUseBy)
+	return lockutil.WithDirLock(d.Dir, func() error {
+		if err := d.loadLocks(); err != nil {
+			return err
+		}
+		if len(d.ReadOnlyInstances) > 0 {
+			return fmt.Errorf("disk %q is attached read-only to instances %v", d.Name, d.ReadOnlyInstances)
+		}
+		inUseBy := filepath.Join(d.Dir, filenames.InUseBy)
+		return os.Symlink(instanceDir, inUseBy)
+	})
 }
 
 func (d *Disk) Unlock() error {
 	inUseBy := filepath.Join(d.Dir, filenames.InUseBy)
 	return os.Remove(inUseBy)
 }
+
+// LockReadOnly locks the disk for the read-only attachment to the instance.
+// The disk can be locked read-only by multiple instances.
+func (d *Disk) LockReadOnly(instanceDir string) error {
+	return lockutil.WithDirLock(d.Dir, func() error {
+		if err := d.loadLocks(); err != nil {
+			return err
+		}
+		if d.Instance != "" {
+			return fmt.Errorf("disk %q is in use by instance %q", d.Name, d.Instance)
+		}
+		roDir := filepath.Join(d.Dir, filenames.InUseByReadOnly)
+		if err := os.MkdirAll(roDir, 0o755); err != nil {
+			return err
+		}
+		return os.Symlink(instanceDir, filepath.Join(roDir, filepath.Base(instanceDir)))
+	})
+}
+
+// UnlockReadOnly removes the read-only lock of the instance.
+// Only the base name of instanceDir is used, so the instance name can be specified too.
+func (d *Disk) UnlockReadOnly(instanceDir string) error {
+	return os.Remove(filepath.Join(d.Dir, filenames.InUseByReadOnly, filepath.Base(instanceDir)))
+}
+
+// InUse returns true if the disk is attached to any instance, either writable or read-only.
+func (d *Disk) InUse() bool {
+	return d.Instance != "" || len(d.ReadOnlyInstances) > 0
+}
+
+// PrintDisks prints disks in a requested format to a given io.Writer.
+// Supported formats are "json", "yaml", "table", or a go template
+func PrintDisks(w io.Writer, disks []*Disk, format string) error {
+	switch format {
+	case "json":
+		format = "{{json .}}"
+	case "yaml":
+		format = "{{yaml .}}"
+	case "table":
+		w := tabwriter.NewWriter(w, 4, 8, 4, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE\tUSAGE\tDIR\tIN-USE-BY")
+		for _, disk := range disks {
+			inUseBy := disk.Instance
+			if len(disk.ReadOnlyInstances) > 0 {
+				inUseBy = strings.Join(disk.ReadOnlyInstances, ",") + " (read-only)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				disk.Name,
+				units.BytesSize(float64(disk.Size)),
+				units.BytesSize(float64(disk.Usage)),
+				disk.Dir,
+				inUseBy,
+			)
+		}
+		return w.Flush()
+	}
+	tmpl, err := template.New("format").Funcs(textutil.TemplateFuncMap).Parse(format)
+	if err != nil {
+		return fmt.Errorf("invalid go template: %w", err)
+	}
+	for _, disk := range disks {
+		if err := tmpl.Execute(w, disk); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"gotest.tools/v3/assert"
+)
+
+func TestDiskLock(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	diskDir, err := DiskDir("data")
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(diskDir, 0o700))
+	assert.NilError(t, os.WriteFile(filepath.Join(diskDir, filenames.DataDisk), make([]byte, 1<<20), 0o644))
+	inspect := func() *Disk {
+		disk, err := InspectDisk("data")
+		assert.NilError(t, err)
+		return disk
+	}
+
+	disk := inspect()
+	assert.Assert(t, !disk.InUse())
+	assert.NilError(t, disk.LockReadOnly("/lima/foo"))
+	assert.NilError(t, inspect().LockReadOnly("/lima/bar"))
+	disk = inspect()
+	assert.DeepEqual(t, disk.ReadOnlyInstances, []string{"bar", "foo"})
+	assert.ErrorContains(t, disk.Lock("/lima/baz"), "attached read-only")
+
+	assert.NilError(t, disk.UnlockReadOnly("/lima/foo"))
+	assert.NilError(t, disk.UnlockReadOnly("bar"))
+	disk = inspect()
+	assert.Assert(t, !disk.InUse())
+	assert.NilError(t, disk.Lock("/lima/baz"))
+	disk = inspect()
+	assert.Equal(t, disk.Instance, "baz")
+	assert.ErrorContains(t, disk.LockReadOnly("/lima/foo"), "in use by instance")
+	assert.NilError(t, disk.Unlock())
+}
+
+func TestDiskLockConcurrent(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	diskDir, err := DiskDir("data")
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(diskDir, 0o700))
+	assert.NilError(t, os.WriteFile(filepath.Join(diskDir, filenames.DataDisk), make([]byte, 1<<20), 0o644))
+
+	for i := 0; i < 50; i++ {
+		// both disks are inspected before either of them is locked
+		disk1, err := InspectDisk("data")
+		assert.NilError(t, err)
+		disk2, err := InspectDisk("data")
+		assert.NilError(t, err)
+
+		var wg sync.WaitGroup
+		var errLock, errLockReadOnly error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errLock = disk1.Lock("/lima/foo")
+		}()
+		go func() {
+			defer wg.Done()
+			errLockReadOnly = disk2.LockReadOnly("/lima/bar")
+		}()
+		wg.Wait()
+		assert.Assert(t, (errLock == nil) != (errLockReadOnly == nil),
+			"exactly one of the locks must succeed: %v, %v", errLock, errLockReadOnly)
+
+		disk, err := InspectDisk("data")
+		assert.NilError(t, err)
+		if errLock == nil {
+			assert.Equal(t, disk.Instance, "foo")
+			assert.Equal(t, len(disk.ReadOnlyInstances), 0)
+			assert.NilError(t, disk.Unlock())
+		} else {
+			assert.Equal(t, disk.Instance, "")
+			assert.DeepEqual(t, disk.ReadOnlyInstances, []string{"bar"})
+			assert.NilError(t, disk.UnlockReadOnly("bar"))
+		}
+	}
+}
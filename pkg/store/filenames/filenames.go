@@ -4,6 +4,8 @@
 // See docs/internal.md .
 package filenames
 
+import "strings"
+
 // Instance names starting with an underscore are reserved for lima internal usage
 
 const (
@@ -11,6 +13,7 @@ const (
 	CacheDir    = "_cache"    // not yet implemented
 	NetworksDir = "_networks" // network log files are stored here
 	DisksDir    = "_disks"    // disks are stored here
+	FrozenDir   = "_frozen"   // frozen disks shared by cloned instances are stored here
 )
 
 // Filenames used inside the ConfigDir
@@ -21,6 +24,8 @@ const (
 	NetworksConfig = "networks.yaml"
 	Default        = "default.yaml"
 	Override       = "override.yaml"
+
+	PortForwardPolicy = "port-forward-policy.yaml"
 )
 
 // Filenames that may appear under an instance directory
@@ -53,6 +58,13 @@ const (
 	HostAgentStderrLog = "ha.stderr.log"
 	VzIdentifier       = "vz-identifier"
 	VzEfi              = "vz-efi"
+	CHAPISock          = "ch-api.sock"    // cloud-hypervisor API
+	CHVSockSock        = "ch-vsock.sock"  // cloud-hypervisor vsock (hybrid vsock, see the CONNECT command in the cloud-hypervisor docs)
+	PasstSock          = "passt.sock"     // passt (vhost-user)
+	FakeRootFS         = "fake-rootfs"    // the root filesystem of the fake driver
+	VMState            = "vmstate"        // the state of the suspended VM
+	VMStateJSON        = "vmstate.json"   // the host agent state of the suspended VM
+	SnapshotsJSON      = "snapshots.json" // the metadata of the snapshots (parents, descriptions)
 
 	// SocketDir is the default location for forwarded sockets with a relative paths in HostSocket
 	SocketDir = "sock"
@@ -61,8 +73,9 @@ const (
 // Filenames used under a disk directory
 
 const (
-	DataDisk = "datadisk"
-	InUseBy  = "in_use_by"
+	DataDisk        = "datadisk"
+	InUseBy         = "in_use_by"
+	InUseByReadOnly = "in_use_by_readonly" // directory of the symlinks to the instances that attach the disk read-only
 )
 
 // LongestSock is the longest socket name.
@@ -75,6 +88,8 @@ const (
 // https://github.com/openssh/openssh-portable/blob/V_8_7_P1/mux.c#L1271-L1285
 const LongestSock = SSHSock + ".1234567890123456"
 
+// PIDFile returns the name of the PID file of the driver.
+// ":" in the vmType of external drivers (e.g. "ext:firecracker") is replaced with "-".
 func PIDFile(name string) string {
-	return name + ".pid"
+	return strings.ReplaceAll(name, ":", "-") + ".pid"
 }
@@ -17,8 +17,11 @@ import (
 	"time"
 
 	"github.com/docker/go-units"
+	hostagentapi "github.com/lima-vm/lima/pkg/hostagent/api"
 	hostagentclient "github.com/lima-vm/lima/pkg/hostagent/api/client"
 	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/nativeimgutil"
+	"github.com/lima-vm/lima/pkg/osutil"
 	"github.com/lima-vm/lima/pkg/store/dirnames"
 	"github.com/lima-vm/lima/pkg/store/filenames"
 	"github.com/lima-vm/lima/pkg/textutil"
@@ -33,6 +36,7 @@ const (
 	StatusBroken        Status = "Broken"
 	StatusStopped       Status = "Stopped"
 	StatusRunning       Status = "Running"
+	StatusSuspended     Status = "Suspended" // stopped, with the VM state saved to filenames.VMState
 )
 
 type Instance struct {
@@ -43,8 +47,11 @@ type Instance struct {
 	Arch            limayaml.Arch      `json:"arch"`
 	CPUType         string             `json:"cpuType"`
 	CPUs            int                `json:"cpus,omitempty"`
-	Memory          int64              `json:"memory,omitempty"` // bytes
-	Disk            int64              `json:"disk,omitempty"`   // bytes
+	Memory          int64              `json:"memory,omitempty"`        // bytes
+	MemoryBalloon   int64              `json:"memoryBalloon,omitempty"` // bytes reclaimed by the memory balloon
+	Disk            int64              `json:"disk,omitempty"`          // bytes
+	DiskUsage       int64              `json:"diskUsage,omitempty"`     // bytes allocated on the host for basedisk and diffdisk, including SnapshotUsage; see InspectDiskUsage
+	SnapshotUsage   int64              `json:"snapshotUsage,omitempty"` // bytes of diffdisk that are only used by the snapshots; see InspectDiskUsage
 	Message         string             `json:"message,omitempty"`
 	AdditionalDisks []limayaml.Disk    `json:"additionalDisks,omitempty"`
 	Networks        []limayaml.Network `json:"network,omitempty"`
@@ -55,6 +62,8 @@ type Instance struct {
 	Errors          []error            `json:"errors,omitempty"`
 	Config          *limayaml.LimaYAML `json:"config,omitempty"`
 	SSHAddress      string             `json:"sshAddress,omitempty"`
+	// PortForwards are the guest ports that are currently forwarded to the host, while the instance is running
+	PortForwards hostagentapi.ActivePortForwards `json:"portForwards,omitempty"`
 }
 
 func (inst *Instance) LoadYAML() (*limayaml.LimaYAML, error) {
@@ -116,6 +125,8 @@ func Inspect(instName string) (*Instance, error) {
 				inst.Errors = append(inst.Errors, fmt.Errorf("failed to get Info from %q: %w", haSock, err))
 			} else {
 				inst.SSHLocalPort = info.SSHLocalPort
+				inst.MemoryBalloon = info.MemoryBalloon
+				inst.PortForwards = info.PortForwards
 			}
 		}
 	}
@@ -177,6 +188,9 @@ func inspectStatusWithPIDFiles(instDir string, inst *Instance, y *limayaml.LimaY
 			inst.Status = StatusRunning
 		} else if inst.HostAgentPID == 0 && inst.DriverPID == 0 {
 			inst.Status = StatusStopped
+			if _, err := os.Stat(filepath.Join(instDir, filenames.VMState)); err == nil {
+				inst.Status = StatusSuspended
+			}
 		} else if inst.HostAgentPID > 0 && inst.DriverPID == 0 {
 			inst.Errors = append(inst.Errors, errors.New("host agent is running but driver is not"))
 			inst.Status = StatusBroken
@@ -258,6 +272,20 @@ func AddGlobalFields(inst *Instance) (FormatData, error) {
 	return data, nil
 }
 
+// InspectDiskUsage fills DiskUsage and SnapshotUsage.
+// They are not filled by Inspect, as computing SnapshotUsage scans all the clusters of the diff disk.
+// The disks that cannot be inspected are ignored.
+func (inst *Instance) InspectDiskUsage() {
+	inst.DiskUsage = 0
+	for _, f := range []string{filenames.BaseDisk, filenames.DiffDisk} {
+		if fi, err := os.Stat(filepath.Join(inst.Dir, f)); err == nil {
+			inst.DiskUsage += osutil.AllocatedSize(fi)
+		}
+	}
+	// the diff disk may be concurrently modified by the running instance, so the value is approximate
+	inst.SnapshotUsage, _ = nativeimgutil.SnapshotUsage(filepath.Join(inst.Dir, filenames.DiffDisk))
+}
+
 type PrintOptions struct {
 	AllFields     bool
 	TerminalWidth int
@@ -265,12 +293,17 @@ type PrintOptions struct {
 
 // PrintInstances prints instances in a requested format to a given io.Writer.
 // Supported formats are "json", "yaml", "table", or a go template
+//
+// The disk usage fields are filled with InspectDiskUsage, only when the format may print them.
 func PrintInstances(w io.Writer, instances []*Instance, format string, options *PrintOptions) error {
+	diskUsage := strings.Contains(format, "Usage")
 	switch format {
 	case "json":
 		format = "{{json .}}"
+		diskUsage = true
 	case "yaml":
 		format = "{{yaml .}}"
+		diskUsage = true
 	case "table":
 		types := map[string]int{}
 		archs := map[string]int{}
@@ -381,6 +414,9 @@ func PrintInstances(w io.Writer, instances []*Instance, format string, options *
 		return fmt.Errorf("invalid go template: %w", err)
 	}
 	for _, instance := range instances {
+		if diskUsage {
+			instance.InspectDiskUsage()
+		}
 		data, err := AddGlobalFields(instance)
 		if err != nil {
 			return err
@@ -2,13 +2,16 @@ package store
 
 import (
 	"bytes"
+	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store/filenames"
 	"gotest.tools/v3/assert"
 )
 
@@ -144,3 +147,21 @@ func TestPrintInstanceTableTwo(t *testing.T) {
 	PrintInstances(&buf, instances, "table", &options)
 	assert.Equal(t, tableTwo, buf.String())
 }
+
+func TestPrintInstancesDiskUsage(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	inst := instance
+	inst.Dir = t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(inst.Dir, filenames.DiffDisk), make([]byte, 1<<20), 0o644))
+
+	// the disk usage is not computed unless printed
+	var buf bytes.Buffer
+	assert.NilError(t, PrintInstances(&buf, []*Instance{&inst}, "{{.Name}}", nil))
+	assert.Equal(t, buf.String(), "foo\n")
+	assert.Equal(t, inst.DiskUsage, int64(0))
+
+	buf.Reset()
+	assert.NilError(t, PrintInstances(&buf, []*Instance{&inst}, "{{.DiskUsage}}", nil))
+	assert.Assert(t, inst.DiskUsage > 0)
+	assert.Equal(t, buf.String(), strconv.FormatInt(inst.DiskUsage, 10)+"\n")
+}
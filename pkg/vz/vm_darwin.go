@@ -455,18 +455,30 @@ func attachDisks(driver *driver.BaseDriver, vmConfig *vz.VirtualMachineConfigura
 		if disk.Instance != "" {
 			return fmt.Errorf("failed to run attach disk %q, in use by instance %q", diskName, disk.Instance)
 		}
+		readOnly := d.ReadOnly != nil && *d.ReadOnly
+		extraDiskPath := filepath.Join(disk.Dir, filenames.DataDisk)
+		if readOnly && len(disk.ReadOnlyInstances) > 0 {
+			// The disk cannot be converted while it is attached to other instances
+			if err = validateDiskFormat(extraDiskPath); err != nil {
+				return fmt.Errorf("failed to attach read-only disk %q used by %v (hint: stop them, or convert the disk with `limactl disk export --format raw`): %w",
+					diskName, disk.ReadOnlyInstances, err)
+			}
+		}
 		logrus.Infof("Mounting disk %q on %q", diskName, disk.MountPoint)
-		err = disk.Lock(driver.Instance.Dir)
+		if readOnly {
+			err = disk.LockReadOnly(driver.Instance.Dir)
+		} else {
+			err = disk.Lock(driver.Instance.Dir)
+		}
 		if err != nil {
 			return fmt.Errorf("failed to run lock disk %q: %q", diskName, err)
 		}
-		extraDiskPath := filepath.Join(disk.Dir, filenames.DataDisk)
 		// ConvertToRaw is a NOP if no conversion is needed
 		logrus.Debugf("Converting extra disk %q to a raw disk (if it is not a raw)", extraDiskPath)
 		if err = nativeimgutil.ConvertToRaw(extraDiskPath, extraDiskPath, nil, true); err != nil {
 			return fmt.Errorf("failed to convert extra disk %q to a raw disk: %w", extraDiskPath, err)
 		}
-		extraDiskPathAttachment, err := vz.NewDiskImageStorageDeviceAttachmentWithCacheAndSync(extraDiskPath, false, vz.DiskImageCachingModeAutomatic, vz.DiskImageSynchronizationModeFsync)
+		extraDiskPathAttachment, err := vz.NewDiskImageStorageDeviceAttachmentWithCacheAndSync(extraDiskPath, readOnly, vz.DiskImageCachingModeAutomatic, vz.DiskImageSynchronizationModeFsync)
 		if err != nil {
 			return fmt.Errorf("failed to create disk attachment for extra disk %q: %w", extraDiskPath, err)
 		}